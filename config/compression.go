@@ -0,0 +1,27 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/compression"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+)
+
+// MsCompressor is a tuple containing a compressor and its protocol ID.
+type MsCompressor struct {
+	compression.Compressor
+	ID string
+}
+
+func makeCompressionOpts(compressors []MsCompressor) ([]tptu.Option, error) {
+	opts := make([]tptu.Option, 0, len(compressors))
+	seen := make(map[string]struct{}, len(compressors))
+	for _, c := range compressors {
+		if _, ok := seen[c.ID]; ok {
+			return nil, fmt.Errorf("duplicate compressor: %s", c.ID)
+		}
+		seen[c.ID] = struct{}{}
+		opts = append(opts, tptu.WithCompression(c.ID, c.Compressor))
+	}
+	return opts, nil
+}
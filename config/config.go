@@ -73,6 +73,7 @@ type Config struct {
 
 	Transports         []TptC
 	Muxers             []MsMuxC
+	Compressors        []MsCompressor
 	SecurityTransports []MsSecC
 	Insecure           bool
 	PSK                pnet.PSK
@@ -192,6 +193,11 @@ func (cfg *Config) addTransports(h host.Host) error {
 	if cfg.ResourceManager != nil {
 		opts = append(opts, tptu.WithResourceManager(cfg.ResourceManager))
 	}
+	compressionOpts, err := makeCompressionOpts(cfg.Compressors)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, compressionOpts...)
 	upgrader, err := tptu.New(secure, muxer, opts...)
 	if err != nil {
 		return err
@@ -227,6 +233,7 @@ func (cfg *Config) NewNode() (host.Host, error) {
 
 	h, err := bhost.NewHost(swrm, &bhost.HostOpts{
 		ConnManager:         cfg.ConnManager,
+		ConnectionGater:     cfg.ConnectionGater,
 		AddrsFactory:        cfg.AddrsFactory,
 		NATManager:          cfg.NATManager,
 		EnablePing:          !cfg.DisablePing,
@@ -317,6 +324,7 @@ func (cfg *Config) NewNode() (host.Host, error) {
 		autoNatCfg := Config{
 			Transports:         cfg.Transports,
 			Muxers:             cfg.Muxers,
+			Compressors:        cfg.Compressors,
 			SecurityTransports: cfg.SecurityTransports,
 			Insecure:           cfg.Insecure,
 			PSK:                cfg.PSK,
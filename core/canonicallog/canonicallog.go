@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/libp2p/go-libp2p/core/peer"
 
@@ -15,11 +16,63 @@ import (
 
 var log = logging.WithSkip(logging.Logger("canonical-log"), 1)
 
+// MisbehaviorReport describes a misbehaving peer, as logged by LogMisbehavingPeer.
+type MisbehaviorReport struct {
+	Peer peer.ID
+	// Addr is the address of the peer. It is nil if the address couldn't be
+	// converted to a multiaddr.
+	Addr      multiaddr.Multiaddr
+	Component string
+	Err       error
+	Msg       string
+}
+
+var misbehaviorHooks struct {
+	sync.RWMutex
+	next  int
+	hooks map[int]func(MisbehaviorReport)
+}
+
+// AddMisbehaviorHook registers a function that is called with every misbehaving peer logged by
+// LogMisbehavingPeer and LogMisbehavingPeerNetAddr, e.g. to block the peer. Hooks are called
+// synchronously, from the code paths of the protocols logging the peer, so they must not block;
+// expensive work should be handed off to a goroutine. The returned function removes the hook.
+//
+// Hooks are process-wide: reports don't identify the host that logged them, so a hook receives
+// the reports of all hosts in the process.
+func AddMisbehaviorHook(hook func(MisbehaviorReport)) (remove func()) {
+	misbehaviorHooks.Lock()
+	defer misbehaviorHooks.Unlock()
+
+	if misbehaviorHooks.hooks == nil {
+		misbehaviorHooks.hooks = make(map[int]func(MisbehaviorReport))
+	}
+	id := misbehaviorHooks.next
+	misbehaviorHooks.next++
+	misbehaviorHooks.hooks[id] = hook
+
+	return func() {
+		misbehaviorHooks.Lock()
+		defer misbehaviorHooks.Unlock()
+		delete(misbehaviorHooks.hooks, id)
+	}
+}
+
+func runMisbehaviorHooks(r MisbehaviorReport) {
+	misbehaviorHooks.RLock()
+	defer misbehaviorHooks.RUnlock()
+
+	for _, hook := range misbehaviorHooks.hooks {
+		hook(r)
+	}
+}
+
 // LogMisbehavingPeer is the canonical way to log a misbehaving peer.
 // Protocols should use this to identify a misbehaving peer to allow the end
 // user to easily identify these nodes across protocols and libp2p.
 func LogMisbehavingPeer(p peer.ID, peerAddr multiaddr.Multiaddr, component string, err error, msg string) {
 	log.Warnf("CANONICAL_MISBEHAVING_PEER: peer=%s addr=%s component=%s err=%q msg=%q", p, peerAddr.String(), component, err, msg)
+	runMisbehaviorHooks(MisbehaviorReport{Peer: p, Addr: peerAddr, Component: component, Err: err, Msg: msg})
 }
 
 // LogMisbehavingPeerNetAddr is the canonical way to log a misbehaving peer.
@@ -29,6 +82,7 @@ func LogMisbehavingPeerNetAddr(p peer.ID, peerAddr net.Addr, component string, o
 	ma, err := manet.FromNetAddr(peerAddr)
 	if err != nil {
 		log.Warnf("CANONICAL_MISBEHAVING_PEER: peer=%s net_addr=%s component=%s err=%q msg=%q", p, peerAddr.String(), component, originalErr, msg)
+		runMisbehaviorHooks(MisbehaviorReport{Peer: p, Component: component, Err: originalErr, Msg: msg})
 		return
 	}
 
@@ -24,3 +24,27 @@ func TestLogs(t *testing.T) {
 
 	LogPeerStatus(1, test.RandPeerIDFatal(t), multiaddr.StringCast("/ip4/1.2.3.4"), "extra", "info")
 }
+
+func TestMisbehaviorHook(t *testing.T) {
+	var reports []MisbehaviorReport
+	remove := AddMisbehaviorHook(func(r MisbehaviorReport) { reports = append(reports, r) })
+
+	p := test.RandPeerIDFatal(t)
+	LogMisbehavingPeer(p, multiaddr.StringCast("/ip4/1.2.3.4"), "somecomponent", fmt.Errorf("something"), "hi")
+	LogMisbehavingPeerNetAddr(p, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80}, "othercomponent", nil, "hello")
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 reports, got %d", len(reports))
+	}
+	if reports[0].Peer != p || reports[0].Component != "somecomponent" || !reports[0].Addr.Equal(multiaddr.StringCast("/ip4/1.2.3.4")) {
+		t.Fatalf("unexpected report: %+v", reports[0])
+	}
+	if !reports[1].Addr.Equal(multiaddr.StringCast("/ip4/127.0.0.1/tcp/80")) {
+		t.Fatalf("unexpected report: %+v", reports[1])
+	}
+
+	remove()
+	LogMisbehavingPeer(p, multiaddr.StringCast("/ip4/1.2.3.4"), "somecomponent", fmt.Errorf("something"), "hi")
+	if len(reports) != 2 {
+		t.Fatal("didn't expect a report after removing the hook")
+	}
+}
@@ -0,0 +1,16 @@
+// Package compression provides the connection compression interface for libp2p.
+package compression
+
+import "net"
+
+// A Compressor compresses the data sent on a connection, and decompresses the data
+// received on it.
+//
+// Compression is negotiated by the upgrader, after the security handshake and before
+// the stream multiplexer is set up. Compressed data is encrypted by the security
+// protocol, and the stream multiplexer runs on top of the compressed connection.
+type Compressor interface {
+	// NewConn wraps a secured connection.
+	// Every Write on the returned connection must be flushed to c before it returns.
+	NewConn(c net.Conn) (net.Conn, error)
+}
@@ -88,3 +88,10 @@ type ConnectionGater interface {
 	// NOTE: the go-libp2p implementation currently IGNORES the disconnect reason.
 	InterceptUpgraded(network.Conn) (allow bool, reason control.DisconnectReason)
 }
+
+// GaterNotifee is implemented by connection gaters that need to be notified of connection
+// events, e.g. to enforce limits on the number of connected peers. The host registers the
+// notifiee with the network when it is constructed with such a gater.
+type GaterNotifee interface {
+	Notifee() network.Notifiee
+}
@@ -95,3 +95,10 @@ type Bus interface {
 	// no pointer types will be returned.
 	GetAllEventTypes() []reflect.Type
 }
+
+// BusSetter is implemented by components that emit events on the host's event bus,
+// like resource managers, connection managers and connection gaters, but are constructed
+// before the host. The host passes its event bus to them when it is constructed.
+type BusSetter interface {
+	SetEventBus(Bus) error
+}
@@ -0,0 +1,36 @@
+package event
+
+import (
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// GatingStage is the stage of the connection lifecycle at which a connection gater
+// was consulted, i.e. the ConnectionGater method that was called.
+type GatingStage string
+
+const (
+	GatingStagePeerDial GatingStage = "peer_dial"
+	GatingStageAddrDial GatingStage = "addr_dial"
+	GatingStageAccept   GatingStage = "accept"
+	GatingStageSecured  GatingStage = "secured"
+	GatingStageUpgraded GatingStage = "upgraded"
+)
+
+// EvtConnectionGated is emitted when a connection gater denied a connection.
+type EvtConnectionGated struct {
+	// Gater is the name of the gater that denied the connection.
+	Gater string
+	Stage GatingStage
+	// Direction is the direction of the connection. It is DirOutbound for dials.
+	Direction network.Direction
+	// Peer is the remote peer. It is empty for GatingStageAccept.
+	Peer peer.ID
+	// Addr is the remote address. It is nil for GatingStagePeerDial.
+	Addr ma.Multiaddr
+	// Reason is the disconnect reason returned by the gater, for GatingStageUpgraded.
+	Reason control.DisconnectReason
+}
@@ -0,0 +1,33 @@
+package event
+
+import "github.com/libp2p/go-libp2p/core/peer"
+
+// TrimReason is the reason why the connection manager closed the connections to a peer.
+type TrimReason string
+
+const (
+	// TrimReasonLowWatermark means that the peer was among the least valuable peers
+	// when trimming down to the low watermark.
+	TrimReasonLowWatermark TrimReason = "low watermark"
+	// TrimReasonGroupMax means that the peer belonged to a peer group that exceeded its maximum.
+	TrimReasonGroupMax TrimReason = "peer group maximum"
+	// TrimReasonMemoryEmergency means that the connections were closed because the
+	// process was running low on memory.
+	TrimReasonMemoryEmergency TrimReason = "memory emergency"
+)
+
+// EvtPeerTrimmed is emitted when the connection manager closes the connections to a peer
+// to trim its connections.
+type EvtPeerTrimmed struct {
+	Peer   peer.ID
+	Reason TrimReason
+	// Group is the name of the peer group whose maximum was exceeded, for TrimReasonGroupMax.
+	Group string
+	// Value is the value of the peer, i.e. the sum of its tags, when it was trimmed.
+	Value int
+	// Conns is the number of connections that were closed.
+	Conns int
+	// Protected is true if the peer was protected. Protected peers are only trimmed
+	// in a memory emergency.
+	Protected bool
+}
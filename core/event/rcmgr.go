@@ -0,0 +1,47 @@
+package event
+
+import (
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// EvtResourceLimitsUpdated is emitted when the resource manager applied a new limit
+// configuration at runtime.
+type EvtResourceLimitsUpdated struct {
+	// Scopes are the names of the live scopes whose limits changed, in lexicographical order.
+	// Scopes created after the update use the new limits, even if they aren't listed here.
+	Scopes []string
+}
+
+// LimitedResource is a kind of resource that the resource manager limits.
+type LimitedResource string
+
+const (
+	LimitedResourceMemory  LimitedResource = "memory"
+	LimitedResourceStreams LimitedResource = "streams"
+	LimitedResourceConns   LimitedResource = "connections"
+	LimitedResourceFD      LimitedResource = "file descriptors"
+)
+
+// EvtResourceLimitExceeded is emitted when the resource manager blocks a reservation because it
+// would exceed a limit.
+//
+// These events are rate-limited, and dropped if the event bus can't keep up. Suppressed counts
+// the events that were dropped since the previous event was emitted.
+type EvtResourceLimitExceeded struct {
+	// Scope is the name of the scope whose limit was exceeded.
+	Scope string
+	// Resource is the kind of resource that couldn't be reserved.
+	Resource LimitedResource
+	// Direction is the direction of the blocked stream or connection.
+	// It is DirUnknown for memory reservations.
+	Direction network.Direction
+	// Peer is the peer that the reservation was made for. It is empty if not known, e.g.
+	// for connections that weren't attached to a peer yet.
+	Peer peer.ID
+	// Protocol is the protocol of the stream that the reservation was made for, if any.
+	Protocol protocol.ID
+	// Suppressed is the number of events dropped since the previous event was emitted.
+	Suppressed int
+}
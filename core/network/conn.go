@@ -6,6 +6,7 @@ import (
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 
 	ma "github.com/multiformats/go-multiaddr"
 )
@@ -74,3 +75,30 @@ type ConnScoper interface {
 	// Scope returns the user view of this connection's resource scope
 	Scope() ConnScope
 }
+
+// DatagramConn is an optional interface implemented by connections that can send unreliable,
+// unordered datagrams, for example QUIC connections (RFC 9221).
+//
+// Datagrams are sent for a protocol, which has to be negotiated first: NegotiateDatagrams
+// asks the peer whether it's receiving datagrams for the protocol, i.e. whether it called
+// ReceiveDatagram for that protocol. Datagrams for protocols the peer isn't receiving
+// datagrams for are dropped by the peer.
+//
+// Datagrams buffered by the receiver are accounted for in the connection's resource scope.
+// They are dropped if the memory can't be reserved, or if too many datagrams are buffered.
+type DatagramConn interface {
+	// NegotiateDatagrams negotiates sending datagrams for protocol p with the peer.
+	// It returns ErrDatagramProtocolNotNegotiated if the peer isn't receiving datagrams for p,
+	// and ErrDatagramsNotSupported if the peer didn't enable datagram support.
+	NegotiateDatagrams(ctx context.Context, p protocol.ID) error
+
+	// SendDatagram sends a datagram for protocol p.
+	// It returns ErrDatagramProtocolNotNegotiated if p wasn't negotiated with NegotiateDatagrams,
+	// and ErrDatagramsNotSupported if the peer didn't enable datagram support.
+	SendDatagram(p protocol.ID, b []byte) error
+
+	// ReceiveDatagram blocks until a datagram for protocol p is received. Once it has been
+	// called for p, the peer can negotiate sending datagrams for p.
+	// It returns ErrDatagramsNotSupported if the peer didn't enable datagram support.
+	ReceiveDatagram(ctx context.Context, p protocol.ID) ([]byte, error)
+}
@@ -31,3 +31,15 @@ var ErrResourceLimitExceeded = temporaryError("resource limit exceeded")
 // ErrResourceScopeClosed is returned when attemptig to reserve resources in a closed resource
 // scope.
 var ErrResourceScopeClosed = errors.New("resource scope closed")
+
+// ErrDatagramsNotSupported is returned when attempting to send or receive datagrams on a
+// connection that doesn't support them.
+var ErrDatagramsNotSupported = errors.New("datagrams not supported")
+
+// ErrDatagramProtocolNotNegotiated is returned when sending datagrams for a protocol that wasn't
+// negotiated with DatagramConn.NegotiateDatagrams, or when the peer rejected the protocol.
+var ErrDatagramProtocolNotNegotiated = errors.New("datagram protocol not negotiated")
+
+// ErrStreamPriorityNotSupported is returned when setting the priority of a stream whose stream
+// multiplexer doesn't support priorities.
+var ErrStreamPriorityNotSupported = errors.New("stream priorities not supported")
@@ -61,6 +61,23 @@ type MuxedStream interface {
 	SetWriteDeadline(time.Time) error
 }
 
+// PrioritizedStream is an optional interface implemented by streams that allow prioritizing
+// their writes, for example the streams of the yamux and mplex stream multiplexers. They
+// only support priorities if the connection was created by their PriorityTransport.
+//
+// When the connection is the bottleneck, the streams that are sending data share its
+// bandwidth in proportion to their priority plus one: a stream with priority 3 is allowed to
+// send four times as much data as a stream with the default priority 0. This prevents bulk
+// transfers from starving latency-sensitive streams on the same connection.
+//
+// As long as all streams of a connection use the default priority, writes are not
+// scheduled at all.
+type PrioritizedStream interface {
+	// SetPriority sets the priority of the stream's writes.
+	// It returns ErrStreamPriorityNotSupported if the stream multiplexer doesn't support priorities.
+	SetPriority(prio uint8) error
+}
+
 // MuxedConn represents a connection to a remote peer that has been
 // extended to support stream multiplexing.
 //
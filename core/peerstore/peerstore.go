@@ -197,6 +197,85 @@ func GetCertifiedAddrBook(ab AddrBook) (cab CertifiedAddrBook, ok bool) {
 	return cab, ok
 }
 
+// AddrEntry is an address of a peer, together with its TTL.
+type AddrEntry struct {
+	Addr ma.Multiaddr
+
+	// TTL is the TTL the address was added with, e.g. AddressTTL or ConnectedAddrTTL.
+	TTL time.Duration
+	// Expires is the time the address expires at.
+	Expires time.Time
+}
+
+// AddrTTLBook exposes the TTLs of the addresses in an AddrBook, which the
+// AddrBook interface itself doesn't.
+//
+// Like CertifiedAddrBook, this is an optional interface. Callers should use the
+// GetAddrTTLBook helper or type-assert on the AddrTTLBook interface.
+type AddrTTLBook interface {
+	// AddrEntries returns all non-expired addresses of a peer, with their TTLs.
+	AddrEntries(p peer.ID) []AddrEntry
+}
+
+// GetAddrTTLBook is a helper to "upcast" an AddrBook to an AddrTTLBook by
+// using type assertion. Returns (nil, false) if the AddrBook is not an
+// AddrTTLBook.
+func GetAddrTTLBook(ab AddrBook) (atb AddrTTLBook, ok bool) {
+	atb, ok = ab.(AddrTTLBook)
+	return atb, ok
+}
+
+// AddrStats holds the outcome of the dials to a single address of a peer.
+type AddrStats struct {
+	Addr ma.Multiaddr
+
+	// Successes and Failures count the dials to the address that succeeded and failed.
+	Successes uint64
+	Failures  uint64
+
+	// LastRTT is the duration of the last successful dial, including the handshake.
+	LastRTT time.Duration
+	// LastErrorClass classifies the error the last failed dial ended with.
+	LastErrorClass string
+
+	// LastSuccess and LastFailure are the times of the last successful and failed dial.
+	LastSuccess time.Time
+	LastFailure time.Time
+}
+
+// LastDialSucceeded returns whether the most recent dial to the address succeeded.
+func (s AddrStats) LastDialSucceeded() bool {
+	return s.Successes > 0 && !s.LastSuccess.Before(s.LastFailure)
+}
+
+// AddrStatsBook records per-address dial outcomes, so that dialers can prefer
+// addresses that worked before.
+//
+// Like CertifiedAddrBook, this is an optional interface. Callers should use the
+// GetAddrStatsBook helper or type-assert on the AddrStatsBook interface.
+type AddrStatsBook interface {
+	// RecordDialSuccess records a successful dial to addr that took rtt.
+	RecordDialSuccess(p peer.ID, addr ma.Multiaddr, rtt time.Duration)
+
+	// RecordDialFailure records a failed dial to addr. errClass is a short,
+	// stable description of the error, for example "timeout" or "refused".
+	RecordDialFailure(p peer.ID, addr ma.Multiaddr, errClass string)
+
+	// AddrStats returns the stats of all addresses of a peer that were dialed.
+	AddrStats(p peer.ID) []AddrStats
+
+	// ClearAddrStats removes all stats stored for a peer.
+	ClearAddrStats(p peer.ID)
+}
+
+// GetAddrStatsBook is a helper to "upcast" a Peerstore to an AddrStatsBook by
+// using type assertion. Returns (nil, false) if the Peerstore is not an
+// AddrStatsBook.
+func GetAddrStatsBook(ps Peerstore) (asb AddrStatsBook, ok bool) {
+	asb, ok = ps.(AddrStatsBook)
+	return asb, ok
+}
+
 // KeyBook tracks the keys of Peers.
 type KeyBook interface {
 	// PubKey stores the public key of a peer.
@@ -40,3 +40,29 @@ type SecureMuxer interface {
 	// connection due to simultaneous open.
 	SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (SecureConn, bool, error)
 }
+
+type streamMuxersKey struct{}
+
+// WithStreamMuxers returns a context that asks the security transport to negotiate the
+// stream multiplexer as part of the security handshake, choosing one of the given muxers.
+// The muxers are protocol IDs, in order of preference.
+// This saves the round trip of negotiating the stream multiplexer after the handshake.
+// Security transports that don't support this ignore the option.
+func WithStreamMuxers(ctx context.Context, muxers []string) context.Context {
+	return context.WithValue(ctx, streamMuxersKey{}, muxers)
+}
+
+// GetStreamMuxers returns the stream multiplexers set using WithStreamMuxers.
+func GetStreamMuxers(ctx context.Context) []string {
+	muxers, _ := ctx.Value(streamMuxersKey{}).([]string)
+	return muxers
+}
+
+// EarlyMuxerConn is implemented by SecureConns of security transports that can negotiate
+// the stream multiplexer as part of the security handshake.
+type EarlyMuxerConn interface {
+	// NegotiatedMuxer returns the protocol ID of the stream multiplexer negotiated during
+	// the handshake. It returns an empty string if no stream multiplexer was negotiated,
+	// for example because the remote peer doesn't support this.
+	NegotiatedMuxer() string
+}
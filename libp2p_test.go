@@ -3,6 +3,7 @@ package libp2p
 import (
 	"context"
 	"fmt"
+	"io"
 	"regexp"
 	"strings"
 	"testing"
@@ -10,8 +11,10 @@ import (
 	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/compression/zstd"
 	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
 
 	"github.com/stretchr/testify/require"
@@ -85,6 +88,37 @@ func TestInsecure(t *testing.T) {
 	h.Close()
 }
 
+func TestCompression(t *testing.T) {
+	newHost := func() host.Host {
+		h, err := New(
+			Transport(tcp.NewTCPTransport),
+			ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+			Compression(zstd.ID, zstd.DefaultCompressor),
+		)
+		require.NoError(t, err)
+		return h
+	}
+	h1 := newHost()
+	defer h1.Close()
+	h2 := newHost()
+	defer h2.Close()
+
+	h2.SetStreamHandler("/echo", func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	s, err := h1.NewStream(context.Background(), h2.ID(), "/echo")
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = s.Write([]byte("foobar"))
+	require.NoError(t, err)
+	b := make([]byte, 6)
+	_, err = io.ReadFull(s, b)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foobar"), b)
+}
+
 func TestAutoNATService(t *testing.T) {
 	h, err := New(EnableNATService())
 	require.NoError(t, err)
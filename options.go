@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/config"
+	"github.com/libp2p/go-libp2p/core/compression"
 	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/metrics"
@@ -109,6 +110,23 @@ func Muxer(name string, tpt interface{}) Option {
 	}
 }
 
+// Compression configures libp2p to compress connections using the given
+// compression algorithm, for example zstd.DefaultCompressor.
+//
+// Name is the protocol name, for example zstd.ID. If this option is passed
+// multiple times, the compression algorithms are preferred in the order they
+// were passed.
+//
+// Compression is negotiated after the security handshake, and only applies to
+// transports that use the connection upgrader, like TCP and WebSocket. Peers
+// that don't support compression are still able to connect, without compression.
+func Compression(name string, c compression.Compressor) Option {
+	return func(cfg *Config) error {
+		cfg.Compressors = append(cfg.Compressors, config.MsCompressor{Compressor: c, ID: name})
+		return nil
+	}
+}
+
 // Transport configures libp2p to use the given transport (or transport
 // constructor).
 //
@@ -0,0 +1,55 @@
+// Package snappy implements connection compression using the Snappy framing format.
+package snappy
+
+import (
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/compression"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/snappy"
+)
+
+// ID is the protocol ID of Snappy compression.
+const ID = "/snappy/1.0.0"
+
+// DefaultCompressor is the Snappy compressor.
+var DefaultCompressor = &Compressor{}
+
+// Compressor is the Snappy compressor.
+type Compressor struct{}
+
+var _ compression.Compressor = &Compressor{}
+
+func (c *Compressor) NewConn(nc net.Conn) (net.Conn, error) {
+	return &conn{
+		Conn: nc,
+		// Every write is flushed right away, so there's no point in compressing concurrently.
+		w: s2.NewWriter(nc, s2.WriterSnappyCompat(), s2.WriterConcurrency(1)),
+		r: snappy.NewReader(nc),
+	}, nil
+}
+
+type conn struct {
+	net.Conn
+
+	writeMx sync.Mutex
+	w       *snappy.Writer
+	r       *snappy.Reader
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	n, err := c.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
@@ -0,0 +1,95 @@
+// Package zstd implements connection compression using Zstandard.
+package zstd
+
+import (
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/compression"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ID is the protocol ID of zstd compression.
+const ID = "/zstd/1.0.0"
+
+// The window size limits the memory used per connection, both for compressing and for
+// decompressing. Windows larger than this are rejected when decompressing, so a peer
+// can't make us allocate large buffers.
+const windowSize = 1 << 20
+
+// DefaultCompressor compresses at the default compression level.
+var DefaultCompressor = &Compressor{Level: zstd.SpeedDefault}
+
+// Compressor is the zstd compressor.
+type Compressor struct {
+	Level zstd.EncoderLevel
+}
+
+var _ compression.Compressor = &Compressor{}
+
+func (c *Compressor) NewConn(nc net.Conn) (net.Conn, error) {
+	enc, err := zstd.NewWriter(nc,
+		zstd.WithEncoderLevel(c.Level),
+		zstd.WithWindowSize(windowSize),
+		zstd.WithEncoderConcurrency(1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(nc,
+		zstd.WithDecoderMaxWindow(windowSize),
+		zstd.WithDecoderConcurrency(1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: nc, enc: enc, dec: dec}, nil
+}
+
+type conn struct {
+	net.Conn
+
+	writeMx sync.Mutex
+	enc     *zstd.Encoder
+
+	// readMx serializes reads, so that the decoder isn't released during a read.
+	readMx sync.Mutex
+	dec    *zstd.Decoder
+	closed bool
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.readMx.Lock()
+	defer c.readMx.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	return c.dec.Read(b)
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	n, err := c.enc.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, c.enc.Flush()
+}
+
+// Close closes the underlying connection, which unblocks pending reads, and then releases
+// the decoder once they returned.
+func (c *conn) Close() error {
+	err := c.Conn.Close()
+
+	c.readMx.Lock()
+	defer c.readMx.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.dec.Close()
+	}
+	return err
+}
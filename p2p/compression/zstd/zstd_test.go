@@ -0,0 +1,91 @@
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type countingConn struct {
+	net.Conn
+	written int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.written, int64(len(b)))
+	return c.Conn.Write(b)
+}
+
+func tcpPipe(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	c1, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	c2, err := ln.Accept()
+	require.NoError(t, err)
+	return c1, c2
+}
+
+func TestRoundtrip(t *testing.T) {
+	c1, c2 := tcpPipe(t)
+	raw := &countingConn{Conn: c1}
+	client, err := DefaultCompressor.NewConn(raw)
+	require.NoError(t, err)
+	defer client.Close()
+	server, err := DefaultCompressor.NewConn(c2)
+	require.NoError(t, err)
+	defer server.Close()
+
+	// every write is flushed, so small messages arrive without further writes
+	_, err = client.Write([]byte("foobar"))
+	require.NoError(t, err)
+	b := make([]byte, 6)
+	_, err = io.ReadFull(server, b)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foobar"), b)
+
+	_, err = server.Write([]byte("raboof"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(client, b)
+	require.NoError(t, err)
+	require.Equal(t, []byte("raboof"), b)
+
+	data := bytes.Repeat([]byte("compressible "), 100000)
+	written := atomic.LoadInt64(&raw.written)
+	go func() {
+		_, err := client.Write(data)
+		require.NoError(t, err)
+	}()
+	received := make([]byte, len(data))
+	_, err = io.ReadFull(server, received)
+	require.NoError(t, err)
+	require.Equal(t, data, received)
+	require.Less(t, atomic.LoadInt64(&raw.written)-written, int64(len(data)/10))
+}
+
+func TestCloseDuringRead(t *testing.T) {
+	c1, c2 := tcpPipe(t)
+	defer c2.Close()
+	client, err := DefaultCompressor.NewConn(c1)
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Read(make([]byte, 10))
+		done <- err
+	}()
+	// give the reader a chance to block in the decoder
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, client.Close())
+	require.Error(t, <-done)
+
+	_, err = client.Read(make([]byte, 10))
+	require.ErrorIs(t, err, net.ErrClosed)
+}
@@ -130,6 +130,11 @@ type HostOpts struct {
 	// ConnManager is a libp2p connection manager
 	ConnManager connmgr.ConnManager
 
+	// ConnectionGater is the connection gater used by the network. The host only uses it
+	// to pass its event bus to the gater, if it implements event.BusSetter, and to register
+	// its notifiee with the network, if it implements connmgr.GaterNotifee.
+	ConnectionGater connmgr.ConnectionGater
+
 	// EnablePing indicates whether to instantiate the ping service
 	EnablePing bool
 
@@ -193,6 +198,20 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 	}
 	h.Network().Notify(newPeerConnectWatcher(evtPeerConnectednessChanged))
 
+	if rm, ok := n.ResourceManager().(event.BusSetter); ok {
+		if err := rm.SetEventBus(h.eventbus); err != nil {
+			return nil, err
+		}
+	}
+	if g, ok := opts.ConnectionGater.(event.BusSetter); ok {
+		if err := g.SetEventBus(h.eventbus); err != nil {
+			return nil, err
+		}
+	}
+	if g, ok := opts.ConnectionGater.(connmgr.GaterNotifee); ok {
+		n.Notify(g.Notifee())
+	}
+
 	if !h.disableSignedPeerRecord {
 		cab, ok := peerstore.GetCertifiedAddrBook(n.Peerstore())
 		if !ok {
@@ -270,6 +289,11 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 	} else {
 		h.cmgr = opts.ConnManager
 		n.Notify(h.cmgr.Notifee())
+		if cm, ok := h.cmgr.(event.BusSetter); ok {
+			if err := cm.SetEventBus(h.eventbus); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	if opts.EnableRelayService {
@@ -1038,3 +1062,11 @@ func (s *streamWrapper) CloseWrite() error {
 	}
 	return s.Stream.CloseWrite()
 }
+
+func (s *streamWrapper) SetPriority(prio uint8) error {
+	ps, ok := s.Stream.(network.PrioritizedStream)
+	if !ok {
+		return network.ErrStreamPriorityNotSupported
+	}
+	return ps.SetPriority(prio)
+}
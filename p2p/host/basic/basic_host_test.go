@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
@@ -193,6 +194,35 @@ func TestHostAddrsFactory(t *testing.T) {
 	}
 }
 
+type notifeeGater struct {
+	connmgr.ConnectionGater
+	connected chan peer.ID
+}
+
+func (g *notifeeGater) Notifee() network.Notifiee {
+	return &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) { g.connected <- c.RemotePeer() },
+	}
+}
+
+func TestHostRegistersGaterNotifee(t *testing.T) {
+	g := &notifeeGater{connected: make(chan peer.ID, 10)}
+	h1, err := NewHost(swarmt.GenSwarm(t), &HostOpts{ConnectionGater: g})
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	select {
+	case p := <-g.connected:
+		require.Equal(t, h2.ID(), p)
+	case <-time.After(5 * time.Second):
+		t.Fatal("gater wasn't notified")
+	}
+}
+
 func TestLocalIPChangesWhenListenAddrChanges(t *testing.T) {
 	// no listen addrs
 	h, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDialOnly), nil)
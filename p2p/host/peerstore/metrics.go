@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // LatencyEWMASmoothing governs the decay of the EWMA (the speed
@@ -56,3 +58,56 @@ func (m *metrics) RemovePeer(p peer.ID) {
 	delete(m.latmap, p)
 	m.mutex.Unlock()
 }
+
+// EvictionReason is the limit that caused a peer to be evicted from a bounded peerstore.
+type EvictionReason string
+
+const (
+	EvictionReasonMaxPeers EvictionReason = "max_peers"
+	EvictionReasonMaxBytes EvictionReason = "max_bytes"
+)
+
+// EvictionMetrics reports the size of a bounded peerstore, and the peers evicted from it.
+// See pstoremem.WithMaxPeers.
+type EvictionMetrics struct {
+	evictions *prometheus.CounterVec
+	peers     prometheus.Gauge
+	bytes     prometheus.Gauge
+}
+
+// NewEvictionMetrics creates the eviction metrics, and registers them with reg:
+// libp2p_peerstore_evictions_total, labeled by the limit that caused the eviction,
+// libp2p_peerstore_peers and libp2p_peerstore_estimated_bytes.
+func NewEvictionMetrics(reg prometheus.Registerer) (*EvictionMetrics, error) {
+	m := &EvictionMetrics{
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "libp2p_peerstore_evictions_total",
+			Help: "Peers evicted from the peerstore",
+		}, []string{"reason"}),
+		peers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "libp2p_peerstore_peers",
+			Help: "Peers tracked by the bounded peerstore",
+		}),
+		bytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "libp2p_peerstore_estimated_bytes",
+			Help: "Estimated memory used by the bounded peerstore",
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.evictions, m.peers, m.bytes} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// PeerEvicted records the eviction of a peer.
+func (m *EvictionMetrics) PeerEvicted(reason EvictionReason) {
+	m.evictions.WithLabelValues(string(reason)).Inc()
+}
+
+// SetSize records the number of peers in the peerstore, and their estimated size in bytes.
+func (m *EvictionMetrics) SetSize(peers int, bytes int64) {
+	m.peers.Set(float64(peers))
+	m.bytes.Set(float64(bytes))
+}
@@ -164,6 +164,7 @@ func (rc realclock) After(d time.Duration) <-chan time.Time {
 
 var _ pstore.AddrBook = (*dsAddrBook)(nil)
 var _ pstore.CertifiedAddrBook = (*dsAddrBook)(nil)
+var _ pstore.AddrTTLBook = (*dsAddrBook)(nil)
 
 // NewAddrBook initializes a new datastore-backed address book. It serves as a drop-in replacement for pstoremem
 // (memory-backed peerstore), and works with any datastore implementing the ds.Batching interface.
@@ -436,6 +437,24 @@ func (ab *dsAddrBook) Addrs(p peer.ID) []ma.Multiaddr {
 	return addrs
 }
 
+// AddrEntries returns all of the non-expired addresses for a given peer, with their TTLs.
+func (ab *dsAddrBook) AddrEntries(p peer.ID) []pstore.AddrEntry {
+	pr, err := ab.loadRecord(p, true, true)
+	if err != nil {
+		log.Warnf("failed to load peerstore entry for peer %v while querying addrs, err: %v", p, err)
+		return nil
+	}
+
+	pr.RLock()
+	defer pr.RUnlock()
+
+	entries := make([]pstore.AddrEntry, len(pr.Addrs))
+	for i, a := range pr.Addrs {
+		entries[i] = pstore.AddrEntry{Addr: a.Addr, TTL: time.Duration(a.Ttl), Expires: time.Unix(a.Expiry, 0)}
+	}
+	return entries
+}
+
 // Peers returns all of the peer IDs for which the AddrBook has addresses.
 func (ab *dsAddrBook) PeersWithAddrs() peer.IDSlice {
 	ids, err := uniquePeerIds(ab.ds, addrBookBase, func(result query.Result) string {
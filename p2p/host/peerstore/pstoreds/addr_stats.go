@@ -0,0 +1,554 @@
+package pstoreds
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+
+	pool "github.com/libp2p/go-buffer-pool"
+	"github.com/libp2p/go-libp2p/core/peer"
+	pstore "github.com/libp2p/go-libp2p/core/peerstore"
+
+	lru "github.com/hashicorp/golang-lru"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-base32"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Address stats are stored under the following db key pattern:
+// /peers/addrstats/<b32 peer id no padding>/<b32 multiaddr bytes no padding>
+var asBase = ds.NewKey("/peers/addrstats")
+
+// maxPendingAddrStats is the number of buffered address stats that triggers a flush
+// before the flush interval elapses.
+const maxPendingAddrStats = 1024
+
+// maxAddrStatsReads is the number of times AddrStats reads the stats of a peer without
+// the datastore lock, before waiting for the datastore writes it raced with.
+const maxAddrStatsReads = 3
+
+// addrStatsRecord is the serialized form of pstore.AddrStats. The address is
+// stored in the key.
+//
+// Buffered updates use the same type: the counters are added to the stored record,
+// and the last dial outcomes replace the stored ones if they're more recent.
+type addrStatsRecord struct {
+	Successes      uint64
+	Failures       uint64
+	LastRTT        time.Duration
+	LastErrorClass string
+	LastSuccess    time.Time
+	LastFailure    time.Time
+}
+
+func (r *addrStatsRecord) merge(u *addrStatsRecord) {
+	r.Successes += u.Successes
+	r.Failures += u.Failures
+	if u.Successes > 0 && !u.LastSuccess.Before(r.LastSuccess) {
+		r.LastSuccess = u.LastSuccess
+		r.LastRTT = u.LastRTT
+	}
+	if u.Failures > 0 && !u.LastFailure.Before(r.LastFailure) {
+		r.LastFailure = u.LastFailure
+		r.LastErrorClass = u.LastErrorClass
+	}
+}
+
+// mergeRecords merges the updates in u into the records in m.
+func mergeRecords(m, u map[string]*addrStatsRecord) {
+	for addr, ur := range u {
+		r, ok := m[addr]
+		if !ok {
+			r = &addrStatsRecord{}
+			m[addr] = r
+		}
+		r.merge(ur)
+	}
+}
+
+// lastDial returns the time of the most recent dial of the address.
+func (r *addrStatsRecord) lastDial() time.Time {
+	if r.LastFailure.After(r.LastSuccess) {
+		return r.LastFailure
+	}
+	return r.LastSuccess
+}
+
+// dsAddrStatsBook buffers dial outcomes in memory, and writes them to the datastore
+// in batches, so that dials don't wait for the datastore. The stats of recently
+// queried peers are cached, so that AddrStats doesn't wait for the datastore either.
+type dsAddrStatsBook struct {
+	ctx      context.Context
+	cancelFn func()
+	done     sync.WaitGroup
+
+	ds    ds.Batching
+	opts  Options
+	clock clock
+
+	// dsMx serializes datastore writes, so that a flush can't write stats back after
+	// they've been cleared. It's taken before mx.
+	dsMx sync.Mutex
+
+	mx       sync.Mutex
+	pending  map[peer.ID]map[string]*addrStatsRecord
+	npending int
+	// cache maps peers to their stored stats, including the updates being flushed.
+	cache cache
+	// writeSeq is incremented before and after every datastore write, so that reads
+	// can detect that they raced with a write. It's odd while a write is in progress.
+	writeSeq uint64
+	flushCh  chan struct{}
+}
+
+var _ pstore.AddrStatsBook = (*dsAddrStatsBook)(nil)
+
+// NewAddrStatsBook creates an address stats book backed by a persistent db. It uses gob for serialisation.
+//
+// Updates are written every AddrStatsFlushInterval, and stats of addresses that weren't dialed for
+// AddrStatsTTL are removed every GCPurgeInterval. The book must be closed to write the pending updates.
+func NewAddrStatsBook(ctx context.Context, store ds.Batching, opts Options) (*dsAddrStatsBook, error) {
+	if opts.AddrStatsFlushInterval < 0 {
+		return nil, fmt.Errorf("negative address stats flush interval provided: %s", opts.AddrStatsFlushInterval)
+	}
+	if opts.AddrStatsTTL < 0 {
+		return nil, fmt.Errorf("negative address stats TTL provided: %s", opts.AddrStatsTTL)
+	}
+
+	ctx, cancelFn := context.WithCancel(ctx)
+	sb := &dsAddrStatsBook{
+		ctx:      ctx,
+		cancelFn: cancelFn,
+		ds:       store,
+		opts:     opts,
+		clock:    realclock{},
+		pending:  make(map[peer.ID]map[string]*addrStatsRecord),
+		flushCh:  make(chan struct{}, 1),
+	}
+	if opts.Clock != nil {
+		sb.clock = opts.Clock
+	}
+	if opts.CacheSize > 0 {
+		var err error
+		if sb.cache, err = lru.NewARC(int(opts.CacheSize)); err != nil {
+			return nil, err
+		}
+	} else {
+		sb.cache = new(noopCache)
+	}
+
+	if opts.AddrStatsFlushInterval > 0 {
+		sb.done.Add(1)
+		go sb.flushLoop()
+	}
+	if opts.AddrStatsTTL > 0 && opts.GCPurgeInterval > 0 {
+		sb.done.Add(1)
+		go sb.purgeLoop()
+	}
+	return sb, nil
+}
+
+// Close stops the background processes and writes the pending updates.
+func (sb *dsAddrStatsBook) Close() error {
+	sb.cancelFn()
+	sb.done.Wait()
+	return sb.flush()
+}
+
+func (sb *dsAddrStatsBook) RecordDialSuccess(p peer.ID, addr ma.Multiaddr, rtt time.Duration) {
+	sb.update(p, addr, func(r *addrStatsRecord) {
+		r.Successes++
+		r.LastRTT = rtt
+		r.LastSuccess = sb.clock.Now()
+	})
+}
+
+func (sb *dsAddrStatsBook) RecordDialFailure(p peer.ID, addr ma.Multiaddr, errClass string) {
+	sb.update(p, addr, func(r *addrStatsRecord) {
+		r.Failures++
+		r.LastErrorClass = errClass
+		r.LastFailure = sb.clock.Now()
+	})
+}
+
+func (sb *dsAddrStatsBook) update(p peer.ID, addr ma.Multiaddr, f func(*addrStatsRecord)) {
+	sb.mx.Lock()
+	m, ok := sb.pending[p]
+	if !ok {
+		m = make(map[string]*addrStatsRecord)
+		sb.pending[p] = m
+	}
+	r, ok := m[string(addr.Bytes())]
+	if !ok {
+		r = &addrStatsRecord{}
+		m[string(addr.Bytes())] = r
+		sb.npending++
+	}
+	f(r)
+	full := sb.npending >= maxPendingAddrStats
+	sb.mx.Unlock()
+
+	if sb.opts.AddrStatsFlushInterval == 0 {
+		if err := sb.flush(); err != nil {
+			log.Errorw("failed to store address stats", "error", err)
+		}
+		return
+	}
+	if full {
+		select {
+		case sb.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (sb *dsAddrStatsBook) flushLoop() {
+	defer sb.done.Done()
+
+	ticker := time.NewTicker(sb.opts.AddrStatsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-sb.flushCh:
+		case <-sb.ctx.Done():
+			return
+		}
+		if err := sb.flush(); err != nil {
+			log.Errorw("failed to store address stats", "error", err)
+		}
+	}
+}
+
+// flush merges the pending updates into the stored records, in a single batch.
+func (sb *dsAddrStatsBook) flush() error {
+	sb.dsMx.Lock()
+	defer sb.dsMx.Unlock()
+
+	sb.mx.Lock()
+	pending := sb.pending
+	if len(pending) == 0 {
+		sb.mx.Unlock()
+		return nil
+	}
+	sb.pending = make(map[peer.ID]map[string]*addrStatsRecord)
+	sb.npending = 0
+	// The cached stats already include the updates, so that they're not missed while they're written.
+	peers := make([]peer.ID, 0, len(pending))
+	for p, m := range pending {
+		peers = append(peers, p)
+		if cached, ok := sb.cache.Peek(p); ok {
+			mergeRecords(cached.(map[string]*addrStatsRecord), m)
+		}
+	}
+	sb.beginWrite()
+	sb.mx.Unlock()
+	defer sb.endWrite()
+
+	batch, err := sb.ds.Batch(context.TODO())
+	if err != nil {
+		sb.uncache(peers...)
+		return err
+	}
+	for p, m := range pending {
+		for addr, u := range m {
+			key := addrStatsKey(p).ChildString(base32.RawStdEncoding.EncodeToString([]byte(addr)))
+			r, err := sb.load(key)
+			if err != nil {
+				log.Errorw("failed to load address stats", "peer", p, "error", err)
+				sb.uncache(p)
+				continue
+			}
+			r.merge(u)
+
+			var buf pool.Buffer
+			if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+				log.Errorw("failed to encode address stats", "peer", p, "error", err)
+				sb.uncache(p)
+				continue
+			}
+			if err := batch.Put(context.TODO(), key, buf.Bytes()); err != nil {
+				sb.uncache(peers...)
+				return err
+			}
+		}
+	}
+	if err := batch.Commit(context.TODO()); err != nil {
+		sb.uncache(peers...)
+		return err
+	}
+	return nil
+}
+
+// beginWrite marks the start of a datastore write. It must be called with mx held.
+func (sb *dsAddrStatsBook) beginWrite() {
+	sb.writeSeq++
+}
+
+// endWrite marks the end of a datastore write.
+func (sb *dsAddrStatsBook) endWrite() {
+	sb.mx.Lock()
+	sb.writeSeq++
+	sb.mx.Unlock()
+}
+
+// uncache removes the cached stats of peers, e.g. because their stats couldn't be written.
+func (sb *dsAddrStatsBook) uncache(peers ...peer.ID) {
+	sb.mx.Lock()
+	defer sb.mx.Unlock()
+	for _, p := range peers {
+		sb.cache.Remove(p)
+	}
+}
+
+// load returns the stored record for a key, or an empty record if there is none.
+func (sb *dsAddrStatsBook) load(key ds.Key) (*addrStatsRecord, error) {
+	r := &addrStatsRecord{}
+	value, err := sb.ds.Get(context.TODO(), key)
+	switch err {
+	case nil:
+		if err := gob.NewDecoder(bytes.NewReader(value)).Decode(r); err != nil {
+			log.Warnw("failed to decode address stats, resetting them", "key", key, "error", err)
+			return &addrStatsRecord{}, nil
+		}
+		return r, nil
+	case ds.ErrNotFound:
+		return r, nil
+	default:
+		return nil, err
+	}
+}
+
+func (sb *dsAddrStatsBook) purgeLoop() {
+	defer sb.done.Done()
+
+	select {
+	case <-sb.clock.After(sb.opts.GCInitialDelay):
+	case <-sb.ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(sb.opts.GCPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		sb.purge()
+		select {
+		case <-ticker.C:
+		case <-sb.ctx.Done():
+			return
+		}
+	}
+}
+
+// purge removes the stored stats of addresses that weren't dialed for AddrStatsTTL.
+// The stats are scanned without the datastore lock, and the expired ones are checked
+// again before they're removed, in case they were dialed in the meantime.
+func (sb *dsAddrStatsBook) purge() {
+	results, err := sb.ds.Query(context.TODO(), query.Query{Prefix: asBase.String()})
+	if err != nil {
+		log.Warnw("failed to query address stats for GC", "error", err)
+		return
+	}
+
+	var expired []ds.Key
+	for result := range results.Next() {
+		if result.Error != nil {
+			log.Warnw("failed to query address stats for GC", "error", result.Error)
+			results.Close()
+			return
+		}
+		if sb.expired(result.Value) {
+			expired = append(expired, ds.RawKey(result.Key))
+		}
+	}
+	results.Close()
+	if len(expired) == 0 {
+		return
+	}
+
+	sb.dsMx.Lock()
+	defer sb.dsMx.Unlock()
+
+	batch, err := sb.ds.Batch(context.TODO())
+	if err != nil {
+		log.Warnw("failed to create batch for address stats GC", "error", err)
+		return
+	}
+	sb.mx.Lock()
+	sb.beginWrite()
+	for _, key := range expired {
+		if p, err := base32.RawStdEncoding.DecodeString(key.Parent().Name()); err == nil {
+			sb.cache.Remove(peer.ID(p))
+		}
+	}
+	sb.mx.Unlock()
+	defer sb.endWrite()
+
+	for _, key := range expired {
+		value, err := sb.ds.Get(context.TODO(), key)
+		if err != nil || !sb.expired(value) {
+			continue
+		}
+		if err := batch.Delete(context.TODO(), key); err != nil {
+			log.Warnw("failed to delete expired address stats", "key", key, "error", err)
+			return
+		}
+	}
+	if err := batch.Commit(context.TODO()); err != nil {
+		log.Warnw("failed to commit address stats GC", "error", err)
+	}
+}
+
+// expired reports whether a stored record wasn't dialed for AddrStatsTTL, or can't be decoded.
+func (sb *dsAddrStatsBook) expired(value []byte) bool {
+	var r addrStatsRecord
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&r); err != nil {
+		return true
+	}
+	return sb.clock.Now().Sub(r.lastDial()) >= sb.opts.AddrStatsTTL
+}
+
+// AddrStats returns the stats of the addresses of p. It doesn't wait for datastore writes,
+// unless the stats of p aren't cached, and reading them raced with writes repeatedly.
+func (sb *dsAddrStatsBook) AddrStats(p peer.ID) []pstore.AddrStats {
+	records, ok := sb.cachedStats(p)
+	for i := 0; !ok && i < maxAddrStatsReads; i++ {
+		records, ok = sb.readStats(p, false)
+	}
+	if !ok {
+		records, _ = sb.readStats(p, true)
+	}
+
+	var res []pstore.AddrStats
+	for addrBytes, r := range records {
+		addr, err := ma.NewMultiaddrBytes([]byte(addrBytes))
+		if err != nil {
+			continue
+		}
+		res = append(res, pstore.AddrStats{
+			Addr:           addr,
+			Successes:      r.Successes,
+			Failures:       r.Failures,
+			LastRTT:        r.LastRTT,
+			LastErrorClass: r.LastErrorClass,
+			LastSuccess:    r.LastSuccess,
+			LastFailure:    r.LastFailure,
+		})
+	}
+	return res
+}
+
+// cachedStats returns the cached stats of p, merged with the pending updates.
+func (sb *dsAddrStatsBook) cachedStats(p peer.ID) (map[string]*addrStatsRecord, bool) {
+	sb.mx.Lock()
+	defer sb.mx.Unlock()
+	return sb.cachedStatsLocked(p)
+}
+
+func (sb *dsAddrStatsBook) cachedStatsLocked(p peer.ID) (map[string]*addrStatsRecord, bool) {
+	cached, ok := sb.cache.Get(p)
+	if !ok {
+		return nil, false
+	}
+	records := make(map[string]*addrStatsRecord)
+	mergeRecords(records, cached.(map[string]*addrStatsRecord))
+	mergeRecords(records, sb.pending[p])
+	return records, true
+}
+
+// readStats reads the stored stats of p from the datastore, caches them, and returns them
+// merged with the pending updates. Unless locked is true, the datastore is read without
+// the datastore lock, and false is returned if the read raced with a write.
+func (sb *dsAddrStatsBook) readStats(p peer.ID, locked bool) (map[string]*addrStatsRecord, bool) {
+	if locked {
+		sb.dsMx.Lock()
+		defer sb.dsMx.Unlock()
+	}
+
+	sb.mx.Lock()
+	seq := sb.writeSeq
+	sb.mx.Unlock()
+	if seq%2 == 1 {
+		return nil, false
+	}
+
+	stored, err := sb.query(p)
+	if err != nil {
+		log.Errorw("failed to query address stats", "peer", p, "error", err)
+		return nil, true
+	}
+
+	sb.mx.Lock()
+	defer sb.mx.Unlock()
+	// The stats might have been cached in the meantime, including updates that weren't stored yet.
+	if records, ok := sb.cachedStatsLocked(p); ok {
+		return records, true
+	}
+	if sb.writeSeq != seq {
+		return nil, false
+	}
+	sb.cache.Add(p, stored)
+	records := make(map[string]*addrStatsRecord)
+	mergeRecords(records, stored)
+	mergeRecords(records, sb.pending[p])
+	return records, true
+}
+
+// query returns the stored stats of p.
+func (sb *dsAddrStatsBook) query(p peer.ID) (map[string]*addrStatsRecord, error) {
+	results, err := sb.ds.Query(context.TODO(), query.Query{Prefix: addrStatsKey(p).String()})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	records := make(map[string]*addrStatsRecord)
+	for result := range results.Next() {
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		addrBytes, err := base32.RawStdEncoding.DecodeString(ds.RawKey(result.Key).Name())
+		if err != nil {
+			continue
+		}
+		var r addrStatsRecord
+		if err := gob.NewDecoder(bytes.NewReader(result.Value)).Decode(&r); err != nil {
+			continue
+		}
+		records[string(addrBytes)] = &r
+	}
+	return records, nil
+}
+
+func (sb *dsAddrStatsBook) ClearAddrStats(p peer.ID) {
+	sb.dsMx.Lock()
+	defer sb.dsMx.Unlock()
+
+	sb.mx.Lock()
+	sb.npending -= len(sb.pending[p])
+	delete(sb.pending, p)
+	sb.cache.Remove(p)
+	sb.beginWrite()
+	sb.mx.Unlock()
+	defer sb.endWrite()
+
+	results, err := sb.ds.Query(context.TODO(), query.Query{Prefix: addrStatsKey(p).String(), KeysOnly: true})
+	if err != nil {
+		log.Warnw("querying datastore when clearing address stats failed", "peer", p, "error", err)
+		return
+	}
+	defer results.Close()
+	for entry := range results.Next() {
+		sb.ds.Delete(context.TODO(), ds.RawKey(entry.Key))
+	}
+}
+
+func addrStatsKey(p peer.ID) ds.Key {
+	return asBase.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
+}
@@ -7,12 +7,15 @@ import (
 	"time"
 
 	pstore "github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/test"
 	pt "github.com/libp2p/go-libp2p/p2p/host/peerstore/test"
 
 	mockClock "github.com/benbjohnson/clock"
 	ds "github.com/ipfs/go-datastore"
 	badger "github.com/ipfs/go-ds-badger"
 	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/multiformats/go-base32"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 )
 
@@ -67,6 +70,123 @@ func TestDsAddrBook(t *testing.T) {
 	}
 }
 
+func TestDsAddrStatsBook(t *testing.T) {
+	for name, dsFactory := range dstores {
+		t.Run(name, func(t *testing.T) {
+			pt.TestAddrStatsBook(t, addrStatsBookFactory(t, dsFactory, DefaultOpts()))
+		})
+
+		t.Run(name+" survives restart", func(t *testing.T) {
+			store, closeFn := dsFactory(t)
+			defer closeFn()
+
+			p := test.RandPeerIDFatal(t)
+			addr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+
+			ps, err := NewPeerstore(context.Background(), store, DefaultOpts())
+			require.NoError(t, err)
+			ps.RecordDialSuccess(p, addr, time.Millisecond)
+			require.NoError(t, ps.Close())
+
+			ps, err = NewPeerstore(context.Background(), store, DefaultOpts())
+			require.NoError(t, err)
+			defer ps.Close()
+			stats := ps.AddrStats(p)
+			require.Len(t, stats, 1)
+			require.True(t, stats[0].Addr.Equal(addr))
+			require.Equal(t, uint64(1), stats[0].Successes)
+			require.Equal(t, time.Millisecond, stats[0].LastRTT)
+		})
+
+		t.Run(name+" flush and GC", func(t *testing.T) {
+			store, closeFn := dsFactory(t)
+			defer closeFn()
+
+			opts := DefaultOpts()
+			opts.AddrStatsFlushInterval = time.Hour
+			opts.AddrStatsTTL = time.Hour
+			clk := mockClock.NewMock()
+			opts.Clock = clk
+			sb, err := NewAddrStatsBook(context.Background(), store, opts)
+			require.NoError(t, err)
+			defer sb.Close()
+
+			p := test.RandPeerIDFatal(t)
+			addr1 := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+			addr2 := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+			sb.RecordDialSuccess(p, addr1, time.Millisecond)
+			sb.RecordDialFailure(p, addr2, "timeout")
+
+			// updates are buffered, but visible
+			_, err = store.Get(context.Background(), addrStatsKey(p).ChildString(base32.RawStdEncoding.EncodeToString(addr1.Bytes())))
+			require.ErrorIs(t, err, ds.ErrNotFound)
+			require.Len(t, sb.AddrStats(p), 2)
+
+			// and merged with the stored stats
+			require.NoError(t, sb.flush())
+			sb.RecordDialSuccess(p, addr1, 2*time.Millisecond)
+			stats := sb.AddrStats(p)
+			require.Len(t, stats, 2)
+			for _, s := range stats {
+				if s.Addr.Equal(addr1) {
+					require.Equal(t, uint64(2), s.Successes)
+					require.Equal(t, 2*time.Millisecond, s.LastRTT)
+				}
+			}
+			require.NoError(t, sb.flush())
+
+			// addr2 wasn't dialed for longer than the TTL
+			clk.Add(30 * time.Minute)
+			sb.RecordDialSuccess(p, addr1, time.Millisecond)
+			require.NoError(t, sb.flush())
+			clk.Add(45 * time.Minute)
+			sb.purge()
+			stats = sb.AddrStats(p)
+			require.Len(t, stats, 1)
+			require.True(t, stats[0].Addr.Equal(addr1))
+
+			sb.ClearAddrStats(p)
+			require.Empty(t, sb.AddrStats(p))
+		})
+
+		t.Run(name+" reads don't wait for writes", func(t *testing.T) {
+			store, closeFn := dsFactory(t)
+			defer closeFn()
+
+			opts := DefaultOpts()
+			opts.AddrStatsFlushInterval = time.Hour
+			sb, err := NewAddrStatsBook(context.Background(), store, opts)
+			require.NoError(t, err)
+			defer sb.Close()
+
+			cached := test.RandPeerIDFatal(t)
+			stored := test.RandPeerIDFatal(t)
+			addr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+			sb.RecordDialSuccess(cached, addr, time.Millisecond)
+			sb.RecordDialSuccess(stored, addr, time.Millisecond)
+			require.Len(t, sb.AddrStats(cached), 1)
+			require.NoError(t, sb.flush())
+
+			// a write holds the datastore lock
+			sb.dsMx.Lock()
+			sb.RecordDialSuccess(cached, addr, time.Millisecond)
+			stats := sb.AddrStats(cached)
+			require.Len(t, stats, 1)
+			require.Equal(t, uint64(2), stats[0].Successes)
+			stats = sb.AddrStats(stored)
+			require.Len(t, stats, 1)
+			require.Equal(t, uint64(1), stats[0].Successes)
+			sb.dsMx.Unlock()
+
+			// the cache includes the flushed updates
+			require.NoError(t, sb.flush())
+			stats = sb.AddrStats(cached)
+			require.Len(t, stats, 1)
+			require.Equal(t, uint64(2), stats[0].Successes)
+		})
+	}
+}
+
 func TestDsKeyBook(t *testing.T) {
 	for name, dsFactory := range dstores {
 		t.Run(name, func(t *testing.T) {
@@ -161,6 +281,21 @@ func addressBookFactory(tb testing.TB, storeFactory datastoreFactory, opts Optio
 	}
 }
 
+func addrStatsBookFactory(tb testing.TB, storeFactory datastoreFactory, opts Options) pt.AddrStatsBookFactory {
+	return func() (pstore.AddrStatsBook, func()) {
+		store, storeCloseFn := storeFactory(tb)
+		sb, err := NewAddrStatsBook(context.Background(), store, opts)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		closeFn := func() {
+			sb.Close()
+			storeCloseFn()
+		}
+		return sb, closeFn
+	}
+}
+
 func keyBookFactory(tb testing.TB, storeFactory datastoreFactory, opts Options) pt.KeyBookFactory {
 	return func() (pstore.KeyBook, func()) {
 		store, storeCloseFn := storeFactory(tb)
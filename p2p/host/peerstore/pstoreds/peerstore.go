@@ -17,7 +17,8 @@ import (
 
 // Configuration object for the peerstore.
 type Options struct {
-	// The size of the in-memory cache. A value of 0 or lower disables the cache.
+	// The size of the in-memory caches of addresses and address stats, in peers. A value of 0 or lower
+	// disables the caches.
 	CacheSize uint
 
 	// MaxProtocols is the maximum number of protocols we store for one peer.
@@ -35,6 +36,14 @@ type Options struct {
 	// before starting GC.
 	GCInitialDelay time.Duration
 
+	// Interval to write buffered address stats to the datastore. If this is a zero value, stats are written
+	// on every dial outcome.
+	AddrStatsFlushInterval time.Duration
+
+	// Address stats of addresses that weren't dialed for this long are removed on every GC purge.
+	// If this is a zero value, they're kept until the peer is removed.
+	AddrStatsTTL time.Duration
+
 	Clock clock
 }
 
@@ -45,14 +54,18 @@ type Options struct {
 // * GC purge interval: 2 hours.
 // * GC lookahead interval: disabled.
 // * GC initial delay: 60 seconds.
+// * Address stats flush interval: 10 seconds.
+// * Address stats TTL: 7 days.
 func DefaultOpts() Options {
 	return Options{
-		CacheSize:           1024,
-		MaxProtocols:        1024,
-		GCPurgeInterval:     2 * time.Hour,
-		GCLookaheadInterval: 0,
-		GCInitialDelay:      60 * time.Second,
-		Clock:               realclock{},
+		CacheSize:              1024,
+		MaxProtocols:           1024,
+		GCPurgeInterval:        2 * time.Hour,
+		GCLookaheadInterval:    0,
+		GCInitialDelay:         60 * time.Second,
+		AddrStatsFlushInterval: 10 * time.Second,
+		AddrStatsTTL:           7 * 24 * time.Hour,
+		Clock:                  realclock{},
 	}
 }
 
@@ -63,9 +76,13 @@ type pstoreds struct {
 	*dsAddrBook
 	*dsProtoBook
 	*dsPeerMetadata
+	*dsAddrStatsBook
 }
 
-var _ peerstore.Peerstore = &pstoreds{}
+var (
+	_ peerstore.Peerstore     = &pstoreds{}
+	_ peerstore.AddrStatsBook = &pstoreds{}
+)
 
 // NewPeerstore creates a peerstore backed by the provided persistent datastore.
 // It's the caller's responsibility to call RemovePeer to ensure
@@ -91,12 +108,18 @@ func NewPeerstore(ctx context.Context, store ds.Batching, opts Options) (*pstore
 		return nil, err
 	}
 
+	addrStatsBook, err := NewAddrStatsBook(ctx, store, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	return &pstoreds{
-		Metrics:        pstore.NewMetrics(),
-		dsKeyBook:      keyBook,
-		dsAddrBook:     addrBook,
-		dsPeerMetadata: peerMetadata,
-		dsProtoBook:    protoBook,
+		Metrics:         pstore.NewMetrics(),
+		dsKeyBook:       keyBook,
+		dsAddrBook:      addrBook,
+		dsPeerMetadata:  peerMetadata,
+		dsProtoBook:     protoBook,
+		dsAddrStatsBook: addrStatsBook,
 	}, nil
 }
 
@@ -147,6 +170,7 @@ func (ps *pstoreds) Close() (err error) {
 	weakClose("addressbook", ps.dsAddrBook)
 	weakClose("protobook", ps.dsProtoBook)
 	weakClose("peermetadata", ps.dsPeerMetadata)
+	weakClose("addrstatsbook", ps.dsAddrStatsBook)
 
 	if len(errs) > 0 {
 		return fmt.Errorf("failed while closing peerstore; err(s): %q", errs)
@@ -182,10 +206,12 @@ func (ps *pstoreds) PeerInfo(p peer.ID) peer.AddrInfo {
 // * the ProtoBook
 // * the PeerMetadata
 // * the Metrics
+// * the AddrStatsBook
 // It DOES NOT remove the peer from the AddrBook.
 func (ps *pstoreds) RemovePeer(p peer.ID) {
 	ps.dsKeyBook.RemovePeer(p)
 	ps.dsProtoBook.RemovePeer(p)
 	ps.dsPeerMetadata.RemovePeer(p)
 	ps.Metrics.RemovePeer(p)
+	ps.dsAddrStatsBook.ClearAddrStats(p)
 }
@@ -72,6 +72,7 @@ type memoryAddrBook struct {
 
 var _ pstore.AddrBook = (*memoryAddrBook)(nil)
 var _ pstore.CertifiedAddrBook = (*memoryAddrBook)(nil)
+var _ pstore.AddrTTLBook = (*memoryAddrBook)(nil)
 
 func NewAddrBook() *memoryAddrBook {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -331,6 +332,23 @@ func (mab *memoryAddrBook) Addrs(p peer.ID) []ma.Multiaddr {
 	return validAddrs(mab.clock.Now(), s.addrs[p])
 }
 
+// AddrEntries returns all of the non-expired addresses for a given peer, with their TTLs.
+func (mab *memoryAddrBook) AddrEntries(p peer.ID) []pstore.AddrEntry {
+	s := mab.segments.get(p)
+	s.RLock()
+	defer s.RUnlock()
+
+	now := mab.clock.Now()
+	amap := s.addrs[p]
+	entries := make([]pstore.AddrEntry, 0, len(amap))
+	for _, a := range amap {
+		if !a.ExpiredBy(now) {
+			entries = append(entries, pstore.AddrEntry{Addr: a.Addr, TTL: a.TTL, Expires: a.Expires})
+		}
+	}
+	return entries
+}
+
 func validAddrs(now time.Time, amap map[string]*expiringAddr) []ma.Multiaddr {
 	good := make([]ma.Multiaddr, 0, len(amap))
 	if amap == nil {
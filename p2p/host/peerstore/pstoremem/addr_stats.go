@@ -0,0 +1,99 @@
+package pstoremem
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	pstore "github.com/libp2p/go-libp2p/core/peerstore"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// maxAddrStatsPerPeer is the maximum number of addresses we keep stats for, per peer.
+// When exceeded, the stats of the least recently dialed address are dropped.
+const maxAddrStatsPerPeer = 64
+
+type memoryAddrStatsBook struct {
+	mx    sync.RWMutex
+	stats map[peer.ID]map[string]*pstore.AddrStats
+}
+
+var _ pstore.AddrStatsBook = (*memoryAddrStatsBook)(nil)
+
+func NewAddrStatsBook() *memoryAddrStatsBook {
+	return &memoryAddrStatsBook{
+		stats: make(map[peer.ID]map[string]*pstore.AddrStats),
+	}
+}
+
+func (sb *memoryAddrStatsBook) RecordDialSuccess(p peer.ID, addr ma.Multiaddr, rtt time.Duration) {
+	sb.mx.Lock()
+	defer sb.mx.Unlock()
+
+	s := sb.getOrCreate(p, addr)
+	s.Successes++
+	s.LastRTT = rtt
+	s.LastSuccess = time.Now()
+}
+
+func (sb *memoryAddrStatsBook) RecordDialFailure(p peer.ID, addr ma.Multiaddr, errClass string) {
+	sb.mx.Lock()
+	defer sb.mx.Unlock()
+
+	s := sb.getOrCreate(p, addr)
+	s.Failures++
+	s.LastErrorClass = errClass
+	s.LastFailure = time.Now()
+}
+
+// getOrCreate must be called with the lock held.
+func (sb *memoryAddrStatsBook) getOrCreate(p peer.ID, addr ma.Multiaddr) *pstore.AddrStats {
+	m, ok := sb.stats[p]
+	if !ok {
+		m = make(map[string]*pstore.AddrStats)
+		sb.stats[p] = m
+	}
+	key := string(addr.Bytes())
+	if s, ok := m[key]; ok {
+		return s
+	}
+	if len(m) >= maxAddrStatsPerPeer {
+		var oldestKey string
+		var oldest time.Time
+		for k, s := range m {
+			if last := lastDial(s); oldestKey == "" || last.Before(oldest) {
+				oldestKey, oldest = k, last
+			}
+		}
+		delete(m, oldestKey)
+	}
+	s := &pstore.AddrStats{Addr: addr}
+	m[key] = s
+	return s
+}
+
+func lastDial(s *pstore.AddrStats) time.Time {
+	if s.LastSuccess.After(s.LastFailure) {
+		return s.LastSuccess
+	}
+	return s.LastFailure
+}
+
+func (sb *memoryAddrStatsBook) AddrStats(p peer.ID) []pstore.AddrStats {
+	sb.mx.RLock()
+	defer sb.mx.RUnlock()
+
+	m := sb.stats[p]
+	res := make([]pstore.AddrStats, 0, len(m))
+	for _, s := range m {
+		res = append(res, *s)
+	}
+	return res
+}
+
+func (sb *memoryAddrStatsBook) ClearAddrStats(p peer.ID) {
+	sb.mx.Lock()
+	delete(sb.stats, p)
+	sb.mx.Unlock()
+}
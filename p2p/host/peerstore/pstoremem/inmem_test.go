@@ -59,6 +59,14 @@ func TestInMemoryKeyBook(t *testing.T) {
 	})
 }
 
+func TestInMemoryAddrStatsBook(t *testing.T) {
+	pt.TestAddrStatsBook(t, func() (pstore.AddrStatsBook, func()) {
+		ps, err := NewPeerstore()
+		require.NoError(t, err)
+		return ps, func() { ps.Close() }
+	})
+}
+
 func BenchmarkInMemoryPeerstore(b *testing.B) {
 	pt.BenchmarkPeerstore(b, func() (pstore.Peerstore, func()) {
 		ps, err := NewPeerstore()
@@ -0,0 +1,519 @@
+package pstoremem
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/record"
+	pstore "github.com/libp2p/go-libp2p/p2p/host/peerstore"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Estimated sizes, in bytes, of the entries the books store for a peer,
+// excluding the variable-length data, which is counted separately.
+const (
+	peerEntrySize     = 512 // map entries in all books, the keys, and the LRU entry
+	addrEntrySize     = 96
+	recordEntrySize   = 256
+	protoEntrySize    = 48
+	metadataEntrySize = 64
+	addrStatsSize     = 128
+)
+
+// LimitOption is an option that bounds the size of the peerstore, see WithMaxPeers.
+type LimitOption func(l *peerLimits) error
+
+// WithMaxPeers limits the number of peers stored in the peerstore. When the limit is
+// exceeded, the least recently used peers are evicted from all books. Connected peers,
+// i.e. peers with addresses that have the ConnectedAddrTTL, peers whose private key is
+// known, and peers protected with WithEvictionProtection are never evicted, so the
+// peerstore can exceed the limit if all peers are unevictable.
+func WithMaxPeers(n int) LimitOption {
+	return func(l *peerLimits) error {
+		if n <= 0 {
+			return errors.New("max peers must be positive")
+		}
+		l.maxPeers = n
+		return nil
+	}
+}
+
+// WithMaxBytes limits the estimated memory used by the peerstore, see WithMaxPeers.
+// The estimate covers the addresses, signed peer records, keys, protocols, metadata
+// and address stats of peers, and is approximate.
+func WithMaxBytes(n int64) LimitOption {
+	return func(l *peerLimits) error {
+		if n <= 0 {
+			return errors.New("max bytes must be positive")
+		}
+		l.maxBytes = n
+		return nil
+	}
+}
+
+// WithEvictionProtection sets a function that reports peers that must not be evicted,
+// for example the connection manager's IsProtected with a fixed tag.
+// It must not call into the peerstore. A peer that is no longer protected becomes
+// evictable again once it's updated, or its addresses change.
+func WithEvictionProtection(isProtected func(peer.ID) bool) LimitOption {
+	return func(l *peerLimits) error {
+		l.isProtected = isProtected
+		return nil
+	}
+}
+
+// WithEvictionMetrics reports evictions, and the size of the peerstore, to m.
+func WithEvictionMetrics(m *pstore.EvictionMetrics) LimitOption {
+	return func(l *peerLimits) error {
+		l.metrics = m
+		return nil
+	}
+}
+
+type peerLimits struct {
+	maxPeers    int
+	maxBytes    int64
+	isProtected func(peer.ID) bool
+	metrics     *pstore.EvictionMetrics
+
+	// beforeEvict is called for every peer selected for eviction, before it's evicted. Used in tests.
+	beforeEvict func(peer.ID)
+
+	mx    sync.Mutex
+	lru   *list.List // of *lruEntry, most recently used first
+	peers map[peer.ID]*list.Element
+	// pinned holds the peers that were found to be unevictable. They are kept out of the LRU,
+	// so that they aren't checked on every update while the peerstore exceeds its limits, and
+	// are moved back into the LRU when they're updated, or their addresses change.
+	pinned map[peer.ID]*lruEntry
+	bytes  int64
+}
+
+type lruEntry struct {
+	p    peer.ID
+	size int64
+}
+
+func (l *peerLimits) enabled() bool {
+	return l.maxPeers > 0 || l.maxBytes > 0
+}
+
+// count returns the number of tracked peers. The lock must be held.
+func (l *peerLimits) count() int {
+	return len(l.peers) + len(l.pinned)
+}
+
+// unpinLocked moves a pinned peer back to the front of the LRU. The lock must be held.
+func (l *peerLimits) unpinLocked(p peer.ID) {
+	if entry, ok := l.pinned[p]; ok {
+		delete(l.pinned, p)
+		l.peers[p] = l.lru.PushFront(entry)
+	}
+}
+
+// touch marks a peer as used, if it's tracked.
+func (ps *pstoremem) touch(p peer.ID) {
+	l := ps.limits
+	if l == nil {
+		return
+	}
+	l.mx.Lock()
+	if e, ok := l.peers[p]; ok {
+		l.lru.MoveToFront(e)
+	}
+	l.mx.Unlock()
+}
+
+// unpin marks a peer as used after its addresses were changed in a way that may make
+// it evictable, e.g. when the ConnectedAddrTTL of its addresses is updated after it
+// disconnected.
+func (ps *pstoremem) unpin(p peer.ID) {
+	l := ps.limits
+	if l == nil {
+		return
+	}
+	l.mx.Lock()
+	l.unpinLocked(p)
+	if e, ok := l.peers[p]; ok {
+		l.lru.MoveToFront(e)
+	}
+	l.mx.Unlock()
+}
+
+// updated marks a peer as used after its data was changed, and evicts peers if the peerstore
+// exceeds its limits.
+func (ps *pstoremem) updated(p peer.ID) {
+	l := ps.limits
+	if l == nil {
+		return
+	}
+	var size int64
+	if l.maxBytes > 0 {
+		size = ps.estimateSize(p)
+	}
+
+	l.mx.Lock()
+	l.unpinLocked(p)
+	if e, ok := l.peers[p]; ok {
+		entry := e.Value.(*lruEntry)
+		l.bytes += size - entry.size
+		entry.size = size
+		l.lru.MoveToFront(e)
+	} else {
+		l.peers[p] = l.lru.PushFront(&lruEntry{p: p, size: size})
+		l.bytes += size
+	}
+	candidates := l.lru.Len()
+	l.mx.Unlock()
+
+	// Every peer in the LRU is considered at most once. Unevictable peers are pinned,
+	// to not be considered again until they're updated.
+	for ; candidates > 0; candidates-- {
+		entry, reason := l.nextCandidate()
+		if entry == nil {
+			break
+		}
+		if l.beforeEvict != nil {
+			l.beforeEvict(entry.p)
+		}
+		if !ps.evict(entry) {
+			continue
+		}
+		log.Debugw("evicted peer", "peer", entry.p, "reason", reason)
+		if l.metrics != nil {
+			l.metrics.PeerEvicted(reason)
+		}
+	}
+	l.reportSize()
+}
+
+// nextCandidate removes the least recently used peer from the LRU, if the peerstore exceeds its limits.
+func (l *peerLimits) nextCandidate() (*lruEntry, pstore.EvictionReason) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	var reason pstore.EvictionReason
+	switch {
+	case l.maxPeers > 0 && l.count() > l.maxPeers:
+		reason = pstore.EvictionReasonMaxPeers
+	case l.maxBytes > 0 && l.bytes > l.maxBytes:
+		reason = pstore.EvictionReasonMaxBytes
+	default:
+		return nil, ""
+	}
+	e := l.lru.Back()
+	if e == nil {
+		// all peers are pinned
+		return nil, ""
+	}
+	entry := e.Value.(*lruEntry)
+	l.lru.Remove(e)
+	delete(l.peers, entry.p)
+	l.bytes -= entry.size
+	return entry, reason
+}
+
+func (l *peerLimits) reportSize() {
+	if l.metrics == nil {
+		return
+	}
+	l.mx.Lock()
+	npeers, nbytes := l.count(), l.bytes
+	l.mx.Unlock()
+	l.metrics.SetSize(npeers, nbytes)
+}
+
+// removed updates the size of a peer after some of its data was removed, and stops
+// tracking it if no data is left.
+func (ps *pstoremem) removed(p peer.ID) {
+	l := ps.limits
+	if l == nil {
+		return
+	}
+	empty := !ps.hasData(p)
+	var size int64
+	if !empty && l.maxBytes > 0 {
+		size = ps.estimateSize(p)
+	}
+
+	l.mx.Lock()
+	// removing data, e.g. addresses, may make a pinned peer evictable
+	l.unpinLocked(p)
+	if e, ok := l.peers[p]; ok {
+		entry := e.Value.(*lruEntry)
+		l.bytes -= entry.size
+		if empty {
+			l.lru.Remove(e)
+			delete(l.peers, p)
+		} else {
+			entry.size = size
+			l.bytes += size
+		}
+	}
+	l.mx.Unlock()
+	l.reportSize()
+}
+
+// evictableLocked reports whether a peer may be evicted. The caller must hold the
+// peer's address segment lock.
+func (ps *pstoremem) evictableLocked(s *addrSegment, p peer.ID) bool {
+	if ps.limits.isProtected != nil && ps.limits.isProtected(p) {
+		return false
+	}
+	if ps.memoryKeyBook.PrivKey(p) != nil {
+		return false
+	}
+	for _, a := range s.addrs[p] {
+		if a.TTL == peerstore.ConnectedAddrTTL {
+			return false
+		}
+	}
+	return true
+}
+
+// hasData reports whether any addresses, keys, protocols or metadata are stored for a peer.
+func (ps *pstoremem) hasData(p peer.ID) bool {
+	s := ps.memoryAddrBook.segments.get(p)
+	s.RLock()
+	_, hasAddrs := s.addrs[p]
+	s.RUnlock()
+	if hasAddrs {
+		return true
+	}
+
+	ps.memoryKeyBook.RLock()
+	_, hasPubKey := ps.memoryKeyBook.pks[p]
+	_, hasPrivKey := ps.memoryKeyBook.sks[p]
+	ps.memoryKeyBook.RUnlock()
+	if hasPubKey || hasPrivKey {
+		return true
+	}
+
+	pseg := ps.memoryProtoBook.segments.get(p)
+	pseg.RLock()
+	_, hasProtos := pseg.protocols[p]
+	pseg.RUnlock()
+	if hasProtos {
+		return true
+	}
+
+	ps.memoryPeerMetadata.dslock.RLock()
+	_, hasMetadata := ps.memoryPeerMetadata.ds[p]
+	ps.memoryPeerMetadata.dslock.RUnlock()
+	return hasMetadata
+}
+
+// evict removes all data stored for a peer that was removed from the LRU, and reports whether
+// it did. The peer isn't evicted if it was used since it was removed from the LRU, and it's
+// pinned if it's unevictable.
+//
+// The peer's address segment is locked while it's checked and removed, so that the swarm
+// can't add the addresses of a new connection in between, or change the addresses of a
+// pinned peer before it's pinned. Peers are always locked in the books before the LRU.
+func (ps *pstoremem) evict(entry *lruEntry) bool {
+	p := entry.p
+	s := ps.memoryAddrBook.segments.get(p)
+	s.Lock()
+	defer s.Unlock()
+
+	l := ps.limits
+	l.mx.Lock()
+	_, used := l.peers[p]
+	l.mx.Unlock()
+	if used {
+		return false
+	}
+	if !ps.evictableLocked(s, p) {
+		l.mx.Lock()
+		if _, used := l.peers[p]; !used {
+			l.pinned[p] = entry
+			l.bytes += entry.size
+		}
+		l.mx.Unlock()
+		return false
+	}
+
+	delete(s.addrs, p)
+	delete(s.signedPeerRecords, p)
+	ps.memoryKeyBook.RemovePeer(p)
+	ps.memoryProtoBook.RemovePeer(p)
+	ps.memoryPeerMetadata.RemovePeer(p)
+	ps.memoryAddrStatsBook.ClearAddrStats(p)
+	ps.Metrics.RemovePeer(p)
+	return true
+}
+
+// estimateSize estimates the memory used by the data stored for a peer.
+func (ps *pstoremem) estimateSize(p peer.ID) int64 {
+	size := int64(peerEntrySize + len(p))
+
+	s := ps.memoryAddrBook.segments.get(p)
+	s.RLock()
+	for k := range s.addrs[p] {
+		// the key, and the bytes of the multiaddr
+		size += int64(addrEntrySize + 2*len(k))
+	}
+	if rec, ok := s.signedPeerRecords[p]; ok && rec.Envelope != nil {
+		size += int64(recordEntrySize + len(rec.Envelope.RawPayload) + len(rec.Envelope.PayloadType))
+	}
+	s.RUnlock()
+
+	pseg := ps.memoryProtoBook.segments.get(p)
+	pseg.RLock()
+	// protocol names are interned, so only the map entries are counted
+	size += int64(protoEntrySize * len(pseg.protocols[p]))
+	pseg.RUnlock()
+
+	ps.memoryPeerMetadata.dslock.RLock()
+	for k := range ps.memoryPeerMetadata.ds[p] {
+		size += int64(metadataEntrySize + len(k))
+	}
+	ps.memoryPeerMetadata.dslock.RUnlock()
+
+	ps.memoryAddrStatsBook.mx.RLock()
+	size += int64(addrStatsSize * len(ps.memoryAddrStatsBook.stats[p]))
+	ps.memoryAddrStatsBook.mx.RUnlock()
+
+	return size
+}
+
+// The methods below shadow the methods of the books, to track the use of peers
+// when the peerstore is bounded.
+
+func (ps *pstoremem) AddAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	ps.memoryAddrBook.AddAddr(p, addr, ttl)
+	ps.updated(p)
+}
+
+func (ps *pstoremem) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	ps.memoryAddrBook.AddAddrs(p, addrs, ttl)
+	ps.updated(p)
+}
+
+func (ps *pstoremem) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	ps.memoryAddrBook.SetAddr(p, addr, ttl)
+	ps.updated(p)
+}
+
+func (ps *pstoremem) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	ps.memoryAddrBook.SetAddrs(p, addrs, ttl)
+	ps.updated(p)
+}
+
+func (ps *pstoremem) ConsumePeerRecord(recordEnvelope *record.Envelope, ttl time.Duration) (bool, error) {
+	accepted, err := ps.memoryAddrBook.ConsumePeerRecord(recordEnvelope, ttl)
+	if accepted && ps.limits != nil {
+		// the record was validated by the address book
+		r, _ := recordEnvelope.Record()
+		if rec, ok := r.(*peer.PeerRecord); ok {
+			ps.updated(rec.PeerID)
+		}
+	}
+	return accepted, err
+}
+
+func (ps *pstoremem) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.Duration) {
+	ps.memoryAddrBook.UpdateAddrs(p, oldTTL, newTTL)
+	ps.unpin(p)
+}
+
+func (ps *pstoremem) ClearAddrs(p peer.ID) {
+	ps.memoryAddrBook.ClearAddrs(p)
+	ps.removed(p)
+}
+
+func (ps *pstoremem) Addrs(p peer.ID) []ma.Multiaddr {
+	ps.touch(p)
+	return ps.memoryAddrBook.Addrs(p)
+}
+
+func (ps *pstoremem) GetPeerRecord(p peer.ID) *record.Envelope {
+	ps.touch(p)
+	return ps.memoryAddrBook.GetPeerRecord(p)
+}
+
+func (ps *pstoremem) AddPubKey(p peer.ID, pk ic.PubKey) error {
+	if err := ps.memoryKeyBook.AddPubKey(p, pk); err != nil {
+		return err
+	}
+	ps.updated(p)
+	return nil
+}
+
+func (ps *pstoremem) AddPrivKey(p peer.ID, sk ic.PrivKey) error {
+	if err := ps.memoryKeyBook.AddPrivKey(p, sk); err != nil {
+		return err
+	}
+	ps.updated(p)
+	return nil
+}
+
+func (ps *pstoremem) PubKey(p peer.ID) ic.PubKey {
+	ps.touch(p)
+	return ps.memoryKeyBook.PubKey(p)
+}
+
+func (ps *pstoremem) SetProtocols(p peer.ID, protos ...string) error {
+	if err := ps.memoryProtoBook.SetProtocols(p, protos...); err != nil {
+		return err
+	}
+	ps.updated(p)
+	return nil
+}
+
+func (ps *pstoremem) AddProtocols(p peer.ID, protos ...string) error {
+	if err := ps.memoryProtoBook.AddProtocols(p, protos...); err != nil {
+		return err
+	}
+	ps.updated(p)
+	return nil
+}
+
+func (ps *pstoremem) GetProtocols(p peer.ID) ([]string, error) {
+	ps.touch(p)
+	return ps.memoryProtoBook.GetProtocols(p)
+}
+
+func (ps *pstoremem) SupportsProtocols(p peer.ID, protos ...string) ([]string, error) {
+	ps.touch(p)
+	return ps.memoryProtoBook.SupportsProtocols(p, protos...)
+}
+
+func (ps *pstoremem) FirstSupportedProtocol(p peer.ID, protos ...string) (string, error) {
+	ps.touch(p)
+	return ps.memoryProtoBook.FirstSupportedProtocol(p, protos...)
+}
+
+func (ps *pstoremem) Put(p peer.ID, key string, val interface{}) error {
+	if err := ps.memoryPeerMetadata.Put(p, key, val); err != nil {
+		return err
+	}
+	ps.updated(p)
+	return nil
+}
+
+func (ps *pstoremem) Get(p peer.ID, key string) (interface{}, error) {
+	ps.touch(p)
+	return ps.memoryPeerMetadata.Get(p, key)
+}
+
+func (ps *pstoremem) RecordLatency(p peer.ID, next time.Duration) {
+	ps.Metrics.RecordLatency(p, next)
+	ps.updated(p)
+}
+
+func (ps *pstoremem) RecordDialSuccess(p peer.ID, addr ma.Multiaddr, rtt time.Duration) {
+	ps.memoryAddrStatsBook.RecordDialSuccess(p, addr, rtt)
+	ps.updated(p)
+}
+
+func (ps *pstoremem) RecordDialFailure(p peer.ID, addr ma.Multiaddr, errClass string) {
+	ps.memoryAddrStatsBook.RecordDialFailure(p, addr, errClass)
+	ps.updated(p)
+}
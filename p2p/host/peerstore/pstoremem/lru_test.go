@@ -0,0 +1,208 @@
+package pstoremem
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	pstore "github.com/libp2p/go-libp2p/core/peerstore"
+	hostpstore "github.com/libp2p/go-libp2p/p2p/host/peerstore"
+	pt "github.com/libp2p/go-libp2p/p2p/host/peerstore/test"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxPeers(t *testing.T) {
+	protected := peer.ID("p1")
+	ps, err := NewPeerstore(WithMaxPeers(3), WithEvictionProtection(func(p peer.ID) bool { return p == protected }))
+	require.NoError(t, err)
+	defer ps.Close()
+
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	for _, p := range []peer.ID{"p1", "p2", "p3"} {
+		ps.AddAddr(p, addr, time.Hour)
+	}
+	ps.Addrs("p1")
+	require.NoError(t, ps.Put("p1", "AgentVersion", "test"))
+
+	// p2 is the least recently used peer
+	ps.AddAddr("p4", addr, time.Hour)
+	require.ElementsMatch(t, []peer.ID{"p1", "p3", "p4"}, ps.Peers())
+
+	// connected and protected peers aren't evicted
+	ps.AddAddr("p3", addr, pstore.ConnectedAddrTTL)
+	ps.AddAddr("p4", addr, time.Hour)
+	ps.AddAddr("p5", addr, time.Hour)
+	require.ElementsMatch(t, []peer.ID{"p1", "p3", "p5"}, ps.Peers())
+
+	// evicted peers are removed from all books
+	require.NoError(t, ps.AddProtocols("p6", "/foo"))
+	ps.AddAddr("p6", addr, time.Hour)
+	ps.AddAddr("p7", addr, time.Hour)
+	require.ElementsMatch(t, []peer.ID{"p1", "p3", "p7"}, ps.Peers())
+	protos, err := ps.GetProtocols("p6")
+	require.NoError(t, err)
+	require.Empty(t, protos)
+	_, err = ps.Get("p1", "AgentVersion")
+	require.NoError(t, err)
+
+	// peers that were removed don't count
+	ps.ClearAddrs("p7")
+	ps.AddAddr("p8", addr, time.Hour)
+	require.ElementsMatch(t, []peer.ID{"p1", "p3", "p8"}, ps.Peers())
+}
+
+func TestEvictionRecheck(t *testing.T) {
+	ps, err := NewPeerstore(WithMaxPeers(1))
+	require.NoError(t, err)
+	defer ps.Close()
+
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	ps.AddAddr("p1", addr, time.Hour)
+	require.NoError(t, ps.AddProtocols("p1", "/foo"))
+
+	// p1 connects after it was selected for eviction
+	ps.limits.beforeEvict = func(p peer.ID) {
+		if p == "p1" {
+			ps.memoryAddrBook.AddAddr(p, addr, pstore.ConnectedAddrTTL)
+		}
+	}
+	ps.AddAddr("p2", addr, time.Hour)
+	protos, err := ps.GetProtocols("p1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/foo"}, protos)
+	require.Empty(t, ps.Addrs("p2"))
+
+	// p3 is used after it was selected for eviction
+	ps.ClearAddrs("p1")
+	ps.RemovePeer("p1")
+	ps.AddAddr("p3", addr, time.Hour)
+	ps.limits.beforeEvict = func(p peer.ID) {
+		if p == "p3" {
+			require.NoError(t, ps.Put(p, "AgentVersion", "test"))
+		}
+	}
+	ps.AddAddr("p4", addr, time.Hour)
+	require.NotEmpty(t, ps.Addrs("p3"))
+	require.Empty(t, ps.Addrs("p4"))
+}
+
+func TestEvictionConnectRace(t *testing.T) {
+	ps, err := NewPeerstore(WithMaxPeers(5))
+	require.NoError(t, err)
+	defer ps.Close()
+
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				ps.AddAddr(peer.ID(fmt.Sprintf("filler-%d-%d", g, i)), addr, time.Hour)
+			}
+		}(g)
+	}
+
+	// Peers are added, so that they can be selected for eviction, and connected right away.
+	var connected []peer.ID
+	for i := 0; i < 200; i++ {
+		p := peer.ID(fmt.Sprintf("conn-%d", i))
+		require.NoError(t, ps.AddProtocols(p, "/foo"))
+		ps.AddAddr(p, addr, pstore.ConnectedAddrTTL)
+		connected = append(connected, p)
+	}
+	wg.Wait()
+
+	for _, p := range connected {
+		require.NotEmpty(t, ps.Addrs(p), "connected peer %s was evicted", p)
+	}
+}
+
+func TestEvictionSkipsPinnedPeers(t *testing.T) {
+	ps, err := NewPeerstore(WithMaxPeers(10))
+	require.NoError(t, err)
+	defer ps.Close()
+
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	for i := 0; i < 100; i++ {
+		ps.AddAddr(peer.ID(fmt.Sprintf("conn-%d", i)), addr, pstore.ConnectedAddrTTL)
+	}
+	require.Len(t, ps.Peers(), 100)
+
+	// the peerstore exceeds its limit because of connected peers, which are only checked
+	// again when they're updated
+	var checked int
+	ps.limits.beforeEvict = func(peer.ID) { checked++ }
+	for i := 0; i < 100; i++ {
+		ps.RecordLatency("conn-0", time.Millisecond)
+	}
+	require.LessOrEqual(t, checked, 100)
+
+	// peers become evictable when they disconnect
+	ps.UpdateAddrs("conn-1", pstore.ConnectedAddrTTL, pstore.RecentlyConnectedAddrTTL)
+	checked = 0
+	ps.AddAddr("p1", addr, time.Hour)
+	require.Equal(t, 2, checked)
+	require.Len(t, ps.Peers(), 99)
+	require.Empty(t, ps.Addrs("conn-1"))
+	require.Empty(t, ps.Addrs("p1"))
+}
+
+func TestMaxBytes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := hostpstore.NewEvictionMetrics(reg)
+	require.NoError(t, err)
+	ps, err := NewPeerstore(WithMaxBytes(10000), WithEvictionMetrics(m))
+	require.NoError(t, err)
+	defer ps.Close()
+
+	addrs := make([]ma.Multiaddr, 0, 20)
+	for i := 0; i < cap(addrs); i++ {
+		addrs = append(addrs, ma.StringCast(fmt.Sprintf("/ip4/1.2.3.4/tcp/%d", i)))
+	}
+	for i := 0; i < 10; i++ {
+		ps.AddAddrs(peer.ID(fmt.Sprintf("peer%d", i)), addrs, time.Hour)
+	}
+
+	peers := len(ps.Peers())
+	require.Less(t, peers, 10)
+	require.NotEmpty(t, ps.Addrs("peer9"), "the most recently used peer is kept")
+	require.Empty(t, ps.Addrs("peer0"))
+
+	values := make(map[string]float64)
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	for _, mf := range mfs {
+		for _, metric := range mf.GetMetric() {
+			switch {
+			case metric.GetCounter() != nil:
+				values[mf.GetName()] += metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				values[mf.GetName()] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+	require.Equal(t, float64(10-peers), values["libp2p_peerstore_evictions_total"])
+	require.Equal(t, float64(peers), values["libp2p_peerstore_peers"])
+	require.LessOrEqual(t, values["libp2p_peerstore_estimated_bytes"], 10000.0)
+}
+
+func TestBoundedPeerstore(t *testing.T) {
+	pt.TestPeerstore(t, func() (pstore.Peerstore, func()) {
+		ps, err := NewPeerstore(WithMaxPeers(1000))
+		require.NoError(t, err)
+		return ps, func() { ps.Close() }
+	})
+}
+
+func TestLimitOptions(t *testing.T) {
+	_, err := NewPeerstore(WithMaxPeers(0))
+	require.Error(t, err)
+	_, err = NewPeerstore(WithMaxBytes(-1))
+	require.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package pstoremem
 
 import (
+	"container/list"
 	"fmt"
 	"io"
 
@@ -16,15 +17,22 @@ type pstoremem struct {
 	*memoryAddrBook
 	*memoryProtoBook
 	*memoryPeerMetadata
+	*memoryAddrStatsBook
+
+	limits *peerLimits // nil if the peerstore is unbounded
 }
 
-var _ peerstore.Peerstore = &pstoremem{}
+var (
+	_ peerstore.Peerstore     = &pstoremem{}
+	_ peerstore.AddrStatsBook = &pstoremem{}
+)
 
 type Option interface{}
 
 // NewPeerstore creates an in-memory threadsafe collection of peers.
 // It's the caller's responsibility to call RemovePeer to ensure
-// that memory consumption of the peerstore doesn't grow unboundedly.
+// that memory consumption of the peerstore doesn't grow unboundedly,
+// unless the peerstore is bounded with WithMaxPeers or WithMaxBytes.
 func NewPeerstore(opts ...Option) (ps *pstoremem, err error) {
 	ab := NewAddrBook()
 	defer func() {
@@ -34,12 +42,21 @@ func NewPeerstore(opts ...Option) (ps *pstoremem, err error) {
 	}()
 
 	var protoBookOpts []ProtoBookOption
+	limits := &peerLimits{
+		lru:    list.New(),
+		peers:  make(map[peer.ID]*list.Element),
+		pinned: make(map[peer.ID]*lruEntry),
+	}
 	for _, opt := range opts {
 		switch o := opt.(type) {
 		case ProtoBookOption:
 			protoBookOpts = append(protoBookOpts, o)
 		case AddrBookOption:
 			o(ab)
+		case LimitOption:
+			if err := o(limits); err != nil {
+				return nil, err
+			}
 		default:
 			return nil, fmt.Errorf("unexpected peer store option: %v", o)
 		}
@@ -48,13 +65,18 @@ func NewPeerstore(opts ...Option) (ps *pstoremem, err error) {
 	if err != nil {
 		return nil, err
 	}
-	return &pstoremem{
-		Metrics:            pstore.NewMetrics(),
-		memoryKeyBook:      NewKeyBook(),
-		memoryAddrBook:     ab,
-		memoryProtoBook:    pb,
-		memoryPeerMetadata: NewPeerMetadata(),
-	}, nil
+	ps = &pstoremem{
+		Metrics:             pstore.NewMetrics(),
+		memoryKeyBook:       NewKeyBook(),
+		memoryAddrBook:      ab,
+		memoryProtoBook:     pb,
+		memoryPeerMetadata:  NewPeerMetadata(),
+		memoryAddrStatsBook: NewAddrStatsBook(),
+	}
+	if limits.enabled() {
+		ps.limits = limits
+	}
+	return ps, nil
 }
 
 func (ps *pstoremem) Close() (err error) {
@@ -94,6 +116,7 @@ func (ps *pstoremem) Peers() peer.IDSlice {
 }
 
 func (ps *pstoremem) PeerInfo(p peer.ID) peer.AddrInfo {
+	ps.touch(p)
 	return peer.AddrInfo{
 		ID:    p,
 		Addrs: ps.memoryAddrBook.Addrs(p),
@@ -105,10 +128,13 @@ func (ps *pstoremem) PeerInfo(p peer.ID) peer.AddrInfo {
 // * the ProtoBook
 // * the PeerMetadata
 // * the Metrics
+// * the AddrStatsBook
 // It DOES NOT remove the peer from the AddrBook.
 func (ps *pstoremem) RemovePeer(p peer.ID) {
 	ps.memoryKeyBook.RemovePeer(p)
 	ps.memoryProtoBook.RemovePeer(p)
 	ps.memoryPeerMetadata.RemovePeer(p)
 	ps.Metrics.RemovePeer(p)
+	ps.memoryAddrStatsBook.ClearAddrStats(p)
+	ps.removed(p)
 }
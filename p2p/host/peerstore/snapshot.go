@@ -0,0 +1,385 @@
+package peerstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	pstore "github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/record"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// SnapshotVersion is the version of the snapshot format written by TakeSnapshot.
+const SnapshotVersion = 1
+
+// DefaultSnapshotMetadataKeys are the metadata keys included in snapshots by default.
+var DefaultSnapshotMetadataKeys = []string{"AgentVersion", "ProtocolVersion"}
+
+// Snapshot is the state of a peerstore. It is independent of the peerstore implementation,
+// so it can be used to seed a peerstore from another one, or to migrate between implementations.
+type Snapshot struct {
+	Version int
+	// Time is the time the snapshot was taken at. Address TTLs are relative to it.
+	Time  time.Time
+	Peers []PeerSnapshot
+}
+
+// PeerSnapshot is the state of a single peer in a Snapshot.
+type PeerSnapshot struct {
+	ID    peer.ID
+	Addrs []AddrSnapshot `json:",omitempty"`
+	// SignedPeerRecord is the serialized envelope of the peer's signed peer record, if any.
+	SignedPeerRecord []byte `json:",omitempty"`
+	// PubKey and PrivKey are the peer's keys, serialized with crypto.MarshalPublicKey and
+	// crypto.MarshalPrivateKey. PrivKey is only included if requested, see WithPrivateKeys.
+	PubKey    []byte   `json:",omitempty"`
+	PrivKey   []byte   `json:",omitempty"`
+	Protocols []string `json:",omitempty"`
+	// Metadata holds the JSON-encoded values of the metadata keys included in the snapshot.
+	Metadata map[string]json.RawMessage `json:",omitempty"`
+	Latency  time.Duration              `json:",omitempty"`
+}
+
+// AddrSnapshot is an address of a peer in a Snapshot.
+type AddrSnapshot struct {
+	Addr string
+	// TTL is the TTL the address was added with.
+	TTL time.Duration
+	// Expires is the time the address expires at.
+	Expires time.Time
+}
+
+type snapshotConfig struct {
+	privateKeys  bool
+	metadataKeys []string
+	now          func() time.Time
+}
+
+// SnapshotOption is an option for TakeSnapshot and RestoreSnapshot.
+type SnapshotOption func(*snapshotConfig) error
+
+// WithPrivateKeys includes the private keys of peers in the snapshot.
+// They are omitted by default, so that snapshots can be shared safely, e.g. in bug reports.
+func WithPrivateKeys() SnapshotOption {
+	return func(cfg *snapshotConfig) error {
+		cfg.privateKeys = true
+		return nil
+	}
+}
+
+// WithMetadataKeys sets the metadata keys included in the snapshot. The peerstore doesn't
+// allow listing the metadata of a peer, so only these keys are exported. Values must be
+// encodable as JSON, and are restored as decoded by encoding/json, so they should be
+// strings, numbers or booleans. Defaults to DefaultSnapshotMetadataKeys.
+func WithMetadataKeys(keys ...string) SnapshotOption {
+	return func(cfg *snapshotConfig) error {
+		cfg.metadataKeys = keys
+		return nil
+	}
+}
+
+func newSnapshotConfig(opts []SnapshotOption) (*snapshotConfig, error) {
+	cfg := &snapshotConfig{metadataKeys: DefaultSnapshotMetadataKeys, now: time.Now}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// TakeSnapshot takes a snapshot of the addresses, signed peer records, keys, protocols,
+// metadata and latencies of all peers in ps.
+//
+// Address TTLs are only preserved if the address book implements peerstore.AddrTTLBook,
+// as pstoremem and pstoreds do. Otherwise, addresses are exported with the AddressTTL.
+func TakeSnapshot(ps pstore.Peerstore, opts ...SnapshotOption) (*Snapshot, error) {
+	cfg, err := newSnapshotConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{Version: SnapshotVersion, Time: cfg.now()}
+	for _, p := range ps.Peers() {
+		psnap, err := snapshotPeer(ps, p, snap.Time, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to take snapshot of peer %s: %w", p, err)
+		}
+		snap.Peers = append(snap.Peers, *psnap)
+	}
+	return snap, nil
+}
+
+func snapshotPeer(ps pstore.Peerstore, p peer.ID, now time.Time, cfg *snapshotConfig) (*PeerSnapshot, error) {
+	snap := &PeerSnapshot{ID: p, Latency: ps.LatencyEWMA(p)}
+
+	if ttlBook, ok := pstore.GetAddrTTLBook(ps); ok {
+		for _, e := range ttlBook.AddrEntries(p) {
+			snap.Addrs = append(snap.Addrs, AddrSnapshot{Addr: e.Addr.String(), TTL: e.TTL, Expires: e.Expires})
+		}
+	} else {
+		for _, a := range ps.Addrs(p) {
+			snap.Addrs = append(snap.Addrs, AddrSnapshot{Addr: a.String(), TTL: pstore.AddressTTL, Expires: now.Add(pstore.AddressTTL)})
+		}
+	}
+
+	if certBook, ok := pstore.GetCertifiedAddrBook(ps); ok {
+		if env := certBook.GetPeerRecord(p); env != nil {
+			raw, err := env.Marshal()
+			if err != nil {
+				return nil, err
+			}
+			snap.SignedPeerRecord = raw
+		}
+	}
+
+	if pk := ps.PubKey(p); pk != nil {
+		raw, err := ic.MarshalPublicKey(pk)
+		if err != nil {
+			return nil, err
+		}
+		snap.PubKey = raw
+	}
+	if cfg.privateKeys {
+		if sk := ps.PrivKey(p); sk != nil {
+			raw, err := ic.MarshalPrivateKey(sk)
+			if err != nil {
+				return nil, err
+			}
+			snap.PrivKey = raw
+		}
+	}
+
+	protos, err := ps.GetProtocols(p)
+	if err != nil {
+		return nil, err
+	}
+	snap.Protocols = protos
+
+	for _, key := range cfg.metadataKeys {
+		v, err := ps.Get(p, key)
+		if err == pstore.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metadata %q: %w", key, err)
+		}
+		if snap.Metadata == nil {
+			snap.Metadata = make(map[string]json.RawMessage)
+		}
+		snap.Metadata[key] = raw
+	}
+	return snap, nil
+}
+
+// RestoreError is returned by RestoreSnapshot if some of the peers in the snapshot couldn't be restored.
+type RestoreError struct {
+	// Errors holds an error for every peer that couldn't be restored.
+	Errors []error
+}
+
+func (e *RestoreError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("failed to restore %d peers: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// RestoreSnapshot adds the peers in snap to ps. Existing peers are merged with the snapshot.
+//
+// Addresses are added with the TTL they have left, and expired addresses are skipped. Addresses of
+// connected peers, with the ConnectedAddrTTL, are restored with the RecentlyConnectedAddrTTL,
+// since the peers aren't connected to ps. Private keys are only restored if WithPrivateKeys is given.
+// Metadata is restored for the keys in the snapshot, regardless of WithMetadataKeys.
+//
+// Peers that can't be restored, e.g. because their entry in the snapshot is malformed, are skipped,
+// and the other peers are still restored. Their errors are returned in a *RestoreError. The entry of
+// a peer is decoded before anything is written to ps, so malformed peers are never restored partially.
+func RestoreSnapshot(ps pstore.Peerstore, snap *Snapshot, opts ...SnapshotOption) error {
+	cfg, err := newSnapshotConfig(opts)
+	if err != nil {
+		return err
+	}
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version: %d", snap.Version)
+	}
+
+	// Address TTLs are relative to the snapshot, to not depend on the clocks of
+	// the exporting and importing nodes agreeing.
+	elapsed := cfg.now().Sub(snap.Time)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	var errs []error
+	for i := range snap.Peers {
+		if err := restorePeer(ps, &snap.Peers[i], snap.Time, elapsed, cfg); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore peer %s: %w", snap.Peers[i].ID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return &RestoreError{Errors: errs}
+	}
+	return nil
+}
+
+// restoredPeer is a decoded PeerSnapshot.
+type restoredPeer struct {
+	pk       ic.PubKey
+	sk       ic.PrivKey
+	addrs    map[time.Duration][]ma.Multiaddr
+	maxTTL   time.Duration
+	env      *record.Envelope
+	metadata map[string]interface{}
+}
+
+func restorePeer(ps pstore.Peerstore, snap *PeerSnapshot, taken time.Time, elapsed time.Duration, cfg *snapshotConfig) error {
+	rp, err := decodePeer(snap, taken, elapsed, cfg)
+	if err != nil {
+		return err
+	}
+	p := snap.ID
+
+	// keys first, since the peerstore checks them against the peer ID
+	if rp.pk != nil {
+		if err := ps.AddPubKey(p, rp.pk); err != nil {
+			return err
+		}
+	}
+	if rp.sk != nil {
+		if err := ps.AddPrivKey(p, rp.sk); err != nil {
+			return err
+		}
+	}
+
+	// The signed peer record is consumed before the addresses, so that its addresses are
+	// certified. It's kept for as long as the longest-lived address.
+	if rp.env != nil {
+		if certBook, ok := pstore.GetCertifiedAddrBook(ps); ok {
+			if _, err := certBook.ConsumePeerRecord(rp.env, rp.maxTTL); err != nil {
+				return err
+			}
+		}
+	}
+	for ttl, as := range rp.addrs {
+		ps.AddAddrs(p, as, ttl)
+	}
+
+	if len(snap.Protocols) > 0 {
+		if err := ps.AddProtocols(p, snap.Protocols...); err != nil {
+			return err
+		}
+	}
+	for key, v := range rp.metadata {
+		if err := ps.Put(p, key, v); err != nil {
+			return err
+		}
+	}
+	if snap.Latency > 0 {
+		ps.RecordLatency(p, snap.Latency)
+	}
+	return nil
+}
+
+// decodePeer decodes and validates the entry of a peer, without modifying the peerstore.
+func decodePeer(snap *PeerSnapshot, taken time.Time, elapsed time.Duration, cfg *snapshotConfig) (*restoredPeer, error) {
+	p := snap.ID
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	rp := &restoredPeer{addrs: make(map[time.Duration][]ma.Multiaddr, len(snap.Addrs))}
+	if len(snap.PubKey) > 0 {
+		pk, err := ic.UnmarshalPublicKey(snap.PubKey)
+		if err != nil {
+			return nil, err
+		}
+		rp.pk = pk
+	}
+	if len(snap.PrivKey) > 0 && cfg.privateKeys {
+		sk, err := ic.UnmarshalPrivateKey(snap.PrivKey)
+		if err != nil {
+			return nil, err
+		}
+		rp.sk = sk
+	}
+
+	for _, a := range snap.Addrs {
+		addr, err := ma.NewMultiaddr(a.Addr)
+		if err != nil {
+			return nil, err
+		}
+		ttl := restoredTTL(a, taken, elapsed)
+		if ttl <= 0 {
+			continue
+		}
+		rp.addrs[ttl] = append(rp.addrs[ttl], addr)
+		if ttl > rp.maxTTL {
+			rp.maxTTL = ttl
+		}
+	}
+
+	if len(snap.SignedPeerRecord) > 0 && rp.maxTTL > 0 {
+		env, rec, err := record.ConsumeEnvelope(snap.SignedPeerRecord, peer.PeerRecordEnvelopeDomain)
+		if err != nil {
+			return nil, err
+		}
+		if prec, ok := rec.(*peer.PeerRecord); !ok || prec.PeerID != p {
+			return nil, errors.New("signed peer record belongs to a different peer")
+		}
+		rp.env = env
+	}
+
+	for key, raw := range snap.Metadata {
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("failed to decode metadata %q: %w", key, err)
+		}
+		if rp.metadata == nil {
+			rp.metadata = make(map[string]interface{}, len(snap.Metadata))
+		}
+		rp.metadata[key] = v
+	}
+	return rp, nil
+}
+
+// restoredTTL returns the TTL to restore an address with, or a non-positive value if it expired.
+func restoredTTL(a AddrSnapshot, taken time.Time, elapsed time.Duration) time.Duration {
+	switch a.TTL {
+	case pstore.PermanentAddrTTL:
+		return pstore.PermanentAddrTTL
+	case pstore.ConnectedAddrTTL:
+		return pstore.RecentlyConnectedAddrTTL
+	}
+	return a.Expires.Sub(taken) - elapsed
+}
+
+// ExportSnapshot writes a JSON snapshot of ps to w, see TakeSnapshot.
+func ExportSnapshot(ps pstore.Peerstore, w io.Writer, opts ...SnapshotOption) error {
+	snap, err := TakeSnapshot(ps, opts...)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// ImportSnapshot reads a JSON snapshot written by ExportSnapshot from r, and restores it into ps,
+// see RestoreSnapshot.
+func ImportSnapshot(ps pstore.Peerstore, r io.Reader, opts ...SnapshotOption) error {
+	var snap Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	return RestoreSnapshot(ps, &snap, opts...)
+}
@@ -0,0 +1,171 @@
+package peerstore_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	pstore "github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func addrTTLs(t *testing.T, ps pstore.Peerstore, p peer.ID) map[string]time.Duration {
+	ttlBook, ok := pstore.GetAddrTTLBook(ps)
+	require.True(t, ok)
+	ttls := make(map[string]time.Duration)
+	for _, e := range ttlBook.AddrEntries(p) {
+		ttls[e.Addr.String()] = e.TTL
+	}
+	return ttls
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	priv, pub, err := ic.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	p, err := peer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+	other, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	src, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	defer src.Close()
+
+	require.NoError(t, src.AddPrivKey(p, priv))
+	src.AddAddr(p, ma.StringCast("/ip4/1.1.1.1/tcp/1"), pstore.PermanentAddrTTL)
+	src.AddAddr(p, ma.StringCast("/ip4/1.1.1.1/tcp/2"), time.Hour)
+	src.AddAddr(p, ma.StringCast("/ip4/1.1.1.1/tcp/3"), pstore.ConnectedAddrTTL)
+	rec := peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: p, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/1.1.1.1/tcp/4")}})
+	env, err := record.Seal(rec, priv)
+	require.NoError(t, err)
+	_, err = src.ConsumePeerRecord(env, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, src.AddProtocols(p, "/foo/1.0.0", "/bar/1.0.0"))
+	require.NoError(t, src.Put(p, "AgentVersion", "test/1.0"))
+	src.RecordLatency(p, 42*time.Millisecond)
+	src.AddAddr(other, ma.StringCast("/ip4/2.2.2.2/tcp/1"), time.Minute)
+
+	// memory to datastore
+	var buf bytes.Buffer
+	require.NoError(t, peerstore.ExportSnapshot(src, &buf))
+	dst, err := pstoreds.NewPeerstore(context.Background(), dssync.MutexWrap(ds.NewMapDatastore()), pstoreds.DefaultOpts())
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, peerstore.ImportSnapshot(dst, &buf))
+
+	require.ElementsMatch(t, []peer.ID{p, other}, dst.Peers())
+	ttls := addrTTLs(t, dst, p)
+	require.Len(t, ttls, 4)
+	require.Equal(t, time.Duration(pstore.PermanentAddrTTL), ttls["/ip4/1.1.1.1/tcp/1"])
+	require.InDelta(t, time.Hour, ttls["/ip4/1.1.1.1/tcp/2"], float64(time.Second))
+	// the peer isn't connected to the new peerstore
+	require.Equal(t, pstore.RecentlyConnectedAddrTTL, ttls["/ip4/1.1.1.1/tcp/3"])
+	require.NotNil(t, dst.GetPeerRecord(p))
+	require.True(t, dst.PubKey(p).Equals(pub))
+	require.Nil(t, dst.PrivKey(p), "private keys are only exported on request")
+	protos, err := dst.GetProtocols(p)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"/foo/1.0.0", "/bar/1.0.0"}, protos)
+	av, err := dst.Get(p, "AgentVersion")
+	require.NoError(t, err)
+	require.Equal(t, "test/1.0", av)
+	require.Equal(t, 42*time.Millisecond, dst.LatencyEWMA(p))
+	require.Len(t, dst.Addrs(other), 1)
+
+	// and back, with private keys
+	require.NoError(t, dst.AddPrivKey(p, priv))
+	buf.Reset()
+	require.NoError(t, peerstore.ExportSnapshot(dst, &buf, peerstore.WithPrivateKeys()))
+	mem, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	defer mem.Close()
+	require.NoError(t, peerstore.ImportSnapshot(mem, &buf, peerstore.WithPrivateKeys()))
+	require.True(t, mem.PrivKey(p).Equals(priv))
+	require.NotNil(t, mem.GetPeerRecord(p))
+	require.Len(t, mem.Addrs(p), 4)
+	require.Equal(t, time.Duration(pstore.PermanentAddrTTL), addrTTLs(t, mem, p)["/ip4/1.1.1.1/tcp/1"])
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ps, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	defer ps.Close()
+
+	taken := time.Now().Add(-time.Hour)
+	p, err := test.RandPeerID()
+	require.NoError(t, err)
+	snap := &peerstore.Snapshot{
+		Version: peerstore.SnapshotVersion,
+		Time:    taken,
+		Peers: []peerstore.PeerSnapshot{{
+			ID: p,
+			Addrs: []peerstore.AddrSnapshot{
+				{Addr: "/ip4/1.1.1.1/tcp/1", TTL: time.Minute, Expires: taken.Add(time.Minute)},
+				{Addr: "/ip4/1.1.1.1/tcp/2", TTL: 2 * time.Hour, Expires: taken.Add(2 * time.Hour)},
+			},
+		}},
+	}
+	require.NoError(t, peerstore.RestoreSnapshot(ps, snap))
+	// expired addresses are skipped
+	ttls := addrTTLs(t, ps, p)
+	require.Len(t, ttls, 1)
+	require.InDelta(t, time.Hour, ttls["/ip4/1.1.1.1/tcp/2"], float64(time.Second))
+
+	snap.Version = peerstore.SnapshotVersion + 1
+	require.Error(t, peerstore.RestoreSnapshot(ps, snap))
+}
+
+func TestSnapshotRestoreSkipsBadPeers(t *testing.T) {
+	ps, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	defer ps.Close()
+
+	good, err := test.RandPeerID()
+	require.NoError(t, err)
+	bad, err := test.RandPeerID()
+	require.NoError(t, err)
+	_, otherPub, err := ic.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	otherKey, err := ic.MarshalPublicKey(otherPub)
+	require.NoError(t, err)
+
+	taken := time.Now()
+	addr := peerstore.AddrSnapshot{Addr: "/ip4/1.1.1.1/tcp/1", TTL: time.Hour, Expires: taken.Add(time.Hour)}
+	snap := &peerstore.Snapshot{
+		Version: peerstore.SnapshotVersion,
+		Time:    taken,
+		Peers: []peerstore.PeerSnapshot{
+			// the address is malformed, so nothing is restored
+			{ID: bad, Protocols: []string{"/foo"}, Addrs: []peerstore.AddrSnapshot{addr, {Addr: "not an address", TTL: time.Hour, Expires: taken.Add(time.Hour)}}},
+			{ID: good, Protocols: []string{"/foo"}, Addrs: []peerstore.AddrSnapshot{addr}},
+			{ID: "", Addrs: []peerstore.AddrSnapshot{addr}},
+			// the key doesn't match the peer ID, which is only detected by the peerstore
+			{ID: bad, PubKey: otherKey},
+		},
+	}
+	err = peerstore.RestoreSnapshot(ps, snap)
+	var restoreErr *peerstore.RestoreError
+	require.ErrorAs(t, err, &restoreErr)
+	require.Len(t, restoreErr.Errors, 3)
+
+	require.Len(t, ps.Addrs(good), 1)
+	protos, err := ps.GetProtocols(good)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/foo"}, protos)
+	require.Empty(t, ps.Addrs(bad))
+	protos, err = ps.GetProtocols(bad)
+	require.NoError(t, err)
+	require.Empty(t, protos)
+}
@@ -0,0 +1,113 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p/core/peerstore"
+	pt "github.com/libp2p/go-libp2p/core/test"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+var addrStatsBookSuite = map[string]func(sb pstore.AddrStatsBook) func(*testing.T){
+	"RecordSuccessAndFailure": testAddrStatsRecord,
+	"LastDialSucceeded":       testAddrStatsLastDialSucceeded,
+	"Clear":                   testAddrStatsClear,
+}
+
+type AddrStatsBookFactory func() (pstore.AddrStatsBook, func())
+
+func TestAddrStatsBook(t *testing.T, factory AddrStatsBookFactory) {
+	for name, test := range addrStatsBookSuite {
+		// Create a new address stats book.
+		sb, closeFunc := factory()
+
+		// Run the test.
+		t.Run(name, test(sb))
+
+		// Cleanup.
+		if closeFunc != nil {
+			closeFunc()
+		}
+	}
+}
+
+func findAddrStats(t *testing.T, stats []pstore.AddrStats, addr ma.Multiaddr) pstore.AddrStats {
+	t.Helper()
+	for _, s := range stats {
+		if s.Addr.Equal(addr) {
+			return s
+		}
+	}
+	t.Fatalf("no stats for %s", addr)
+	return pstore.AddrStats{}
+}
+
+func testAddrStatsRecord(sb pstore.AddrStatsBook) func(t *testing.T) {
+	return func(t *testing.T) {
+		p := pt.RandPeerIDFatal(t)
+		addr1 := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+		addr2 := ma.StringCast("/ip4/1.2.3.4/udp/1234/quic")
+
+		require.Empty(t, sb.AddrStats(p))
+
+		sb.RecordDialSuccess(p, addr1, 10*time.Millisecond)
+		sb.RecordDialSuccess(p, addr1, 20*time.Millisecond)
+		sb.RecordDialFailure(p, addr1, "timeout")
+		sb.RecordDialFailure(p, addr2, "refused")
+
+		stats := sb.AddrStats(p)
+		require.Len(t, stats, 2)
+
+		s1 := findAddrStats(t, stats, addr1)
+		require.Equal(t, uint64(2), s1.Successes)
+		require.Equal(t, uint64(1), s1.Failures)
+		require.Equal(t, 20*time.Millisecond, s1.LastRTT)
+		require.Equal(t, "timeout", s1.LastErrorClass)
+		require.False(t, s1.LastSuccess.IsZero())
+		require.False(t, s1.LastFailure.IsZero())
+
+		s2 := findAddrStats(t, stats, addr2)
+		require.Zero(t, s2.Successes)
+		require.Equal(t, uint64(1), s2.Failures)
+		require.Equal(t, "refused", s2.LastErrorClass)
+
+		// stats of other peers are unaffected
+		require.Empty(t, sb.AddrStats(pt.RandPeerIDFatal(t)))
+	}
+}
+
+func testAddrStatsLastDialSucceeded(sb pstore.AddrStatsBook) func(t *testing.T) {
+	return func(t *testing.T) {
+		p := pt.RandPeerIDFatal(t)
+		addr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+
+		sb.RecordDialFailure(p, addr, "timeout")
+		require.False(t, findAddrStats(t, sb.AddrStats(p), addr).LastDialSucceeded())
+
+		time.Sleep(time.Millisecond)
+		sb.RecordDialSuccess(p, addr, time.Millisecond)
+		require.True(t, findAddrStats(t, sb.AddrStats(p), addr).LastDialSucceeded())
+
+		time.Sleep(time.Millisecond)
+		sb.RecordDialFailure(p, addr, "timeout")
+		require.False(t, findAddrStats(t, sb.AddrStats(p), addr).LastDialSucceeded())
+	}
+}
+
+func testAddrStatsClear(sb pstore.AddrStatsBook) func(t *testing.T) {
+	return func(t *testing.T) {
+		p1 := pt.RandPeerIDFatal(t)
+		p2 := pt.RandPeerIDFatal(t)
+		addr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+
+		sb.RecordDialSuccess(p1, addr, time.Millisecond)
+		sb.RecordDialSuccess(p2, addr, time.Millisecond)
+
+		sb.ClearAddrStats(p1)
+		require.Empty(t, sb.AddrStats(p1))
+		require.Len(t, sb.AddrStats(p2), 1)
+	}
+}
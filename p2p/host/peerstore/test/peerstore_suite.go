@@ -356,6 +356,21 @@ func testMetadata(ps pstore.Peerstore) func(t *testing.T) {
 			require.NoError(t, err)
 			require.Equal(t, val, "v1")
 		})
+
+		t.Run("removing a peer clears its address stats", func(t *testing.T) {
+			sb, ok := pstore.GetAddrStatsBook(ps)
+			if !ok {
+				t.Skip("peerstore doesn't implement AddrStatsBook")
+			}
+			p := peer.ID("foo")
+			otherP := peer.ID("foobar")
+			addr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+			sb.RecordDialSuccess(p, addr, time.Millisecond)
+			sb.RecordDialSuccess(otherP, addr, time.Millisecond)
+			ps.RemovePeer(p)
+			require.Empty(t, sb.AddrStats(p))
+			require.Len(t, sb.AddrStats(otherP), 1)
+		})
 	}
 }
 
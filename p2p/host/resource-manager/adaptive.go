@@ -0,0 +1,187 @@
+package rcmgr
+
+import (
+	"errors"
+	"math"
+	rtmetrics "runtime/metrics"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// AdaptiveLimitConfig configures how the limits of the system and transient scopes adapt to
+// memory pressure. Once the memory used by the Go runtime exceeds TightenAbove of the soft memory
+// limit, the limits are tightened to Factor of the configured limits. They are loosened again once
+// the memory usage drops below LoosenBelow of the soft memory limit.
+type AdaptiveLimitConfig struct {
+	// SoftLimit is the soft memory limit in bytes. If 0, the soft memory limit of the Go runtime
+	// (GOMEMLIMIT) is used, and if that is not set, the memory limit of the process's cgroup.
+	SoftLimit int64
+	// TightenAbove is the fraction of the soft memory limit above which the limits are tightened.
+	TightenAbove float64
+	// LoosenBelow is the fraction of the soft memory limit below which the limits are loosened.
+	LoosenBelow float64
+	// Factor is the fraction of the configured limits that applies while the limits are tightened.
+	// File descriptor limits are not tightened.
+	Factor float64
+	// Interval is the interval at which the memory usage is checked.
+	Interval time.Duration
+}
+
+// DefaultAdaptiveLimitConfig is the default configuration for adaptive limits.
+var DefaultAdaptiveLimitConfig = AdaptiveLimitConfig{
+	TightenAbove: 0.9,
+	LoosenBelow:  0.75,
+	Factor:       0.5,
+	Interval:     5 * time.Second,
+}
+
+type adaptiveLimits struct {
+	cfg AdaptiveLimitConfig
+
+	// readMemory returns the memory used by the Go runtime and the soft memory limit.
+	// A limit of 0 means that there is no soft memory limit.
+	readMemory func() (used, limit int64)
+
+	// tightened is protected by the resource manager's mutex
+	tightened bool
+}
+
+// WithAdaptiveLimits tightens the limits of the system and transient scopes when the memory
+// usage of the process nears the soft memory limit, and loosens them again afterwards.
+func WithAdaptiveLimits(cfg AdaptiveLimitConfig) Option {
+	return func(r *resourceManager) error {
+		if cfg.SoftLimit < 0 {
+			return errors.New("soft memory limit must not be negative")
+		}
+		if cfg.LoosenBelow <= 0 || cfg.LoosenBelow > cfg.TightenAbove {
+			return errors.New("adaptive limits must be loosened below a positive fraction that is not larger than the one they are tightened above")
+		}
+		if cfg.Factor <= 0 || cfg.Factor > 1 {
+			return errors.New("adaptive limit factor must be in (0, 1]")
+		}
+		if cfg.Interval <= 0 {
+			return errors.New("adaptive limit interval must be positive")
+		}
+		softLimit := cfg.SoftLimit
+		r.adaptive = &adaptiveLimits{
+			cfg:        cfg,
+			readMemory: func() (int64, int64) { return readGoMemory(), getSoftMemoryLimit(softLimit) },
+		}
+		return nil
+	}
+}
+
+// getSoftMemoryLimit returns the configured soft memory limit, or, if that is 0, the soft memory
+// limit of the Go runtime or the cgroup memory limit. It returns 0 if there is no limit.
+func getSoftMemoryLimit(configured int64) int64 {
+	if configured > 0 {
+		return configured
+	}
+	if l := goMemoryLimit(); l != math.MaxInt64 {
+		return l
+	}
+	return getCgroupLimits().memory
+}
+
+// readGoMemory returns the memory used by the Go runtime, in the way the soft memory limit accounts for it.
+func readGoMemory() int64 {
+	samples := []rtmetrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	rtmetrics.Read(samples)
+	return int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+}
+
+func (r *resourceManager) adaptiveBackground() {
+	defer r.wg.Done()
+
+	if _, limit := r.adaptive.readMemory(); limit == 0 {
+		log.Warn("adaptive limits are enabled, but there is no soft memory limit")
+	}
+
+	ticker := time.NewTicker(r.adaptive.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.adaptLimits()
+		case <-r.cancelCtx.Done():
+			return
+		}
+	}
+}
+
+// adaptLimits tightens or loosens the limits of the system and transient scopes,
+// depending on the current memory usage.
+func (r *resourceManager) adaptLimits() {
+	used, limit := r.adaptive.readMemory()
+
+	r.mx.Lock()
+	wasTightened := r.adaptive.tightened
+	switch {
+	case limit <= 0:
+		r.adaptive.tightened = false
+	case float64(used) >= r.adaptive.cfg.TightenAbove*float64(limit):
+		r.adaptive.tightened = true
+	case float64(used) < r.adaptive.cfg.LoosenBelow*float64(limit):
+		r.adaptive.tightened = false
+	}
+	tightened := r.adaptive.tightened
+	if tightened == wasTightened {
+		r.mx.Unlock()
+		return
+	}
+	limits := r.getLimiter()
+	r.system.resourceScope.SetLimit(r.adaptLimit(limits.GetSystemLimits()))
+	r.transient.resourceScope.SetLimit(r.adaptLimit(limits.GetTransientLimits()))
+	r.mx.Unlock()
+
+	if tightened {
+		log.Infow("memory pressure is high, tightening limits", "memory", used, "soft limit", limit)
+	} else {
+		log.Infow("memory pressure is low again, loosening limits", "memory", used, "soft limit", limit)
+	}
+	r.emitLimitsUpdated(event.EvtResourceLimitsUpdated{Scopes: []string{"system", "transient"}})
+}
+
+// adaptLimit returns the limit that applies to the system or transient scope, given the configured limit.
+// It must be called with the resource manager's mutex held.
+func (r *resourceManager) adaptLimit(l Limit) Limit {
+	if r.adaptive == nil || !r.adaptive.tightened {
+		return l
+	}
+	return tightenLimit(l, r.adaptive.cfg.Factor)
+}
+
+func tightenLimit(l Limit, factor float64) Limit {
+	scaleInt := func(n int) int {
+		if n == math.MaxInt || n <= 0 {
+			return n
+		}
+		if scaled := int(float64(n) * factor); scaled > 0 {
+			return scaled
+		}
+		return 1
+	}
+	memory := l.GetMemoryLimit()
+	if memory != math.MaxInt64 && memory > 0 {
+		memory = int64(float64(memory) * factor)
+		if memory == 0 {
+			memory = 1
+		}
+	}
+	return &BaseLimit{
+		Streams:         scaleInt(l.GetStreamTotalLimit()),
+		StreamsInbound:  scaleInt(l.GetStreamLimit(network.DirInbound)),
+		StreamsOutbound: scaleInt(l.GetStreamLimit(network.DirOutbound)),
+		Conns:           scaleInt(l.GetConnTotalLimit()),
+		ConnsInbound:    scaleInt(l.GetConnLimit(network.DirInbound)),
+		ConnsOutbound:   scaleInt(l.GetConnLimit(network.DirOutbound)),
+		FD:              l.GetFDLimit(),
+		Memory:          memory,
+	}
+}
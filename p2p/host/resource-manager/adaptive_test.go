@@ -0,0 +1,109 @@
+package rcmgr
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+
+	"github.com/stretchr/testify/require"
+)
+
+func withMemoryReader(readMemory func() (used, limit int64)) Option {
+	return func(r *resourceManager) error {
+		r.adaptive.readMemory = readMemory
+		return nil
+	}
+}
+
+func TestAdaptiveLimits(t *testing.T) {
+	var used, softLimit int64 = 0, 1000
+	readMemory := func() (int64, int64) { return atomic.LoadInt64(&used), atomic.LoadInt64(&softLimit) }
+
+	cfg := DefaultAdaptiveLimitConfig
+	cfg.Interval = time.Hour // adaptLimits is called directly
+	limits := updateTestLimits(10)
+	mgr, err := NewResourceManager(NewFixedLimiter(limits), WithAdaptiveLimits(cfg), withMemoryReader(readMemory))
+	require.NoError(t, err)
+	defer mgr.Close()
+	r := mgr.(*resourceManager)
+
+	bus := eventbus.NewBus()
+	require.NoError(t, r.SetEventBus(bus))
+	sub, err := bus.Subscribe(new(event.EvtResourceLimitsUpdated))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	systemConnLimit := func() int { return r.system.Limit().GetConnLimit(network.DirInbound) }
+	transientMemoryLimit := func() int64 { return r.transient.Limit().GetMemoryLimit() }
+	expectEvent := func() {
+		t.Helper()
+		select {
+		case evt := <-sub.Out():
+			require.Equal(t, []string{"system", "transient"}, evt.(event.EvtResourceLimitsUpdated).Scopes)
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a limits updated event")
+		}
+	}
+
+	atomic.StoreInt64(&used, 800)
+	r.adaptLimits()
+	require.Equal(t, 10, systemConnLimit())
+
+	atomic.StoreInt64(&used, 900)
+	r.adaptLimits()
+	require.Equal(t, 5, systemConnLimit())
+	require.Equal(t, int64(1<<19), transientMemoryLimit())
+	require.Equal(t, 10, r.system.Limit().GetFDLimit())
+	expectEvent()
+
+	// limits stay tightened until the memory usage drops below LoosenBelow
+	atomic.StoreInt64(&used, 800)
+	r.adaptLimits()
+	require.Equal(t, 5, systemConnLimit())
+
+	// limits that are updated while tightened are tightened as well
+	limits.System.ConnsInbound = 20
+	require.NoError(t, r.UpdateLimits(limits))
+	require.Equal(t, 10, systemConnLimit())
+	<-sub.Out()
+
+	atomic.StoreInt64(&used, 700)
+	r.adaptLimits()
+	require.Equal(t, 20, systemConnLimit())
+	require.Equal(t, int64(1<<20), transientMemoryLimit())
+	expectEvent()
+
+	// without a soft memory limit, the limits are never tightened
+	atomic.StoreInt64(&used, 1<<40)
+	atomic.StoreInt64(&softLimit, 0)
+	r.adaptLimits()
+	require.Equal(t, 20, systemConnLimit())
+}
+
+func TestAdaptiveLimitsConfig(t *testing.T) {
+	for _, modify := range []func(*AdaptiveLimitConfig){
+		func(cfg *AdaptiveLimitConfig) { cfg.SoftLimit = -1 },
+		func(cfg *AdaptiveLimitConfig) { cfg.LoosenBelow = 0 },
+		func(cfg *AdaptiveLimitConfig) { cfg.LoosenBelow = cfg.TightenAbove + 0.01 },
+		func(cfg *AdaptiveLimitConfig) { cfg.Factor = 0 },
+		func(cfg *AdaptiveLimitConfig) { cfg.Factor = 1.5 },
+		func(cfg *AdaptiveLimitConfig) { cfg.Interval = 0 },
+	} {
+		cfg := DefaultAdaptiveLimitConfig
+		modify(&cfg)
+		_, err := NewResourceManager(NewFixedLimiter(InfiniteLimits), WithAdaptiveLimits(cfg))
+		require.Error(t, err)
+	}
+}
+
+func TestTightenLimit(t *testing.T) {
+	l := tightenLimit(&infiniteBaseLimit, 0.5)
+	require.Equal(t, &infiniteBaseLimit, l)
+
+	l = tightenLimit(&BaseLimit{Conns: 1, ConnsInbound: 3, Memory: 1}, 0.5)
+	require.Equal(t, &BaseLimit{Conns: 1, ConnsInbound: 1, Memory: 1}, l)
+}
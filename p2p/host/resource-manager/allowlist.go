@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
 
+	"github.com/benbjohnson/clock"
 	"github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 )
@@ -21,10 +23,21 @@ type Allowlist struct {
 	// Analyze the benchmark before trying to optimize this.
 
 	// Any peer with these IPs are allowed
-	allowedNetworks []*net.IPNet
+	allowedNetworks []allowlistEntry
 
 	// Only the specified peers can use these IPs
-	allowedPeerByNetwork map[peer.ID][]*net.IPNet
+	allowedPeerByNetwork map[peer.ID][]allowlistEntry
+
+	clock clock.Clock
+}
+
+type allowlistEntry struct {
+	network *net.IPNet
+	expires time.Time // zero if the entry doesn't expire
+}
+
+func (e allowlistEntry) valid(now time.Time) bool {
+	return e.expires.IsZero() || now.Before(e.expires)
 }
 
 // WithAllowlistedMultiaddrs sets the multiaddrs to be in the allowlist
@@ -42,10 +55,18 @@ func WithAllowlistedMultiaddrs(mas []multiaddr.Multiaddr) Option {
 
 func newAllowlist() Allowlist {
 	return Allowlist{
-		allowedPeerByNetwork: make(map[peer.ID][]*net.IPNet),
+		allowedPeerByNetwork: make(map[peer.ID][]allowlistEntry),
+		clock:                clock.New(),
 	}
 }
 
+func (al *Allowlist) now() time.Time {
+	if al.clock == nil {
+		return time.Now()
+	}
+	return al.clock.Now()
+}
+
 func toIPNet(ma multiaddr.Multiaddr) (*net.IPNet, peer.ID, error) {
 	var ipString string
 	var mask string
@@ -112,16 +133,84 @@ func (al *Allowlist) Add(ma multiaddr.Multiaddr) error {
 	al.mu.Lock()
 	defer al.mu.Unlock()
 
+	al.add(allowedPeer, allowlistEntry{network: ipnet})
+	return nil
+}
+
+// AddWithTTL adds a multiaddr to the allowlist for the duration of ttl. See Add for the
+// supported multiaddrs. Adding a multiaddr that is already allowlisted with a TTL extends
+// its TTL.
+func (al *Allowlist) AddWithTTL(ma multiaddr.Multiaddr, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New("allowlist TTL must be positive")
+	}
+	ipnet, allowedPeer, err := toIPNet(ma)
+	if err != nil {
+		return err
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	now := al.now()
+	al.removeExpiredLocked(now)
+
+	expires := now.Add(ttl)
+	entries := al.allowedNetworks
+	if allowedPeer != "" {
+		entries = al.allowedPeerByNetwork[allowedPeer]
+	}
+	for i, e := range entries {
+		if e.expires.IsZero() || !e.network.IP.Equal(ipnet.IP) || !bytes.Equal(e.network.Mask, ipnet.Mask) {
+			continue
+		}
+		if expires.After(e.expires) {
+			entries[i].expires = expires
+		}
+		return nil
+	}
+	al.add(allowedPeer, allowlistEntry{network: ipnet, expires: expires})
+	return nil
+}
+
+func (al *Allowlist) add(allowedPeer peer.ID, e allowlistEntry) {
 	if allowedPeer != peer.ID("") {
 		// We have a peerID constraint
 		if al.allowedPeerByNetwork == nil {
-			al.allowedPeerByNetwork = make(map[peer.ID][]*net.IPNet)
+			al.allowedPeerByNetwork = make(map[peer.ID][]allowlistEntry)
 		}
-		al.allowedPeerByNetwork[allowedPeer] = append(al.allowedPeerByNetwork[allowedPeer], ipnet)
+		al.allowedPeerByNetwork[allowedPeer] = append(al.allowedPeerByNetwork[allowedPeer], e)
 	} else {
-		al.allowedNetworks = append(al.allowedNetworks, ipnet)
+		al.allowedNetworks = append(al.allowedNetworks, e)
+	}
+}
+
+// removeExpired removes the expired entries from the allowlist.
+func (al *Allowlist) removeExpired() {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	al.removeExpiredLocked(al.now())
+}
+
+func (al *Allowlist) removeExpiredLocked(now time.Time) {
+	removeExpired := func(entries []allowlistEntry) []allowlistEntry {
+		valid := entries[:0]
+		for _, e := range entries {
+			if e.valid(now) {
+				valid = append(valid, e)
+			}
+		}
+		return valid
+	}
+
+	al.allowedNetworks = removeExpired(al.allowedNetworks)
+	for p, entries := range al.allowedPeerByNetwork {
+		if entries = removeExpired(entries); len(entries) > 0 {
+			al.allowedPeerByNetwork[p] = entries
+		} else {
+			delete(al.allowedPeerByNetwork, p)
+		}
 	}
-	return nil
 }
 
 func (al *Allowlist) Remove(ma multiaddr.Multiaddr) error {
@@ -146,7 +235,7 @@ func (al *Allowlist) Remove(ma multiaddr.Multiaddr) error {
 	i := len(ipNetList)
 	for i > 0 {
 		i--
-		if ipNetList[i].IP.Equal(ipnet.IP) && bytes.Equal(ipNetList[i].Mask, ipnet.Mask) {
+		if ipNetList[i].network.IP.Equal(ipnet.IP) && bytes.Equal(ipNetList[i].network.Mask, ipnet.Mask) {
 			// swap remove
 			ipNetList[i] = ipNetList[len(ipNetList)-1]
 			ipNetList = ipNetList[:len(ipNetList)-1]
@@ -172,15 +261,16 @@ func (al *Allowlist) Allowed(ma multiaddr.Multiaddr) bool {
 	al.mu.RLock()
 	defer al.mu.RUnlock()
 
-	for _, network := range al.allowedNetworks {
-		if network.Contains(ip) {
+	now := al.now()
+	for _, e := range al.allowedNetworks {
+		if e.valid(now) && e.network.Contains(ip) {
 			return true
 		}
 	}
 
 	for _, allowedNetworks := range al.allowedPeerByNetwork {
-		for _, network := range allowedNetworks {
-			if network.Contains(ip) {
+		for _, e := range allowedNetworks {
+			if e.valid(now) && e.network.Contains(ip) {
 				return true
 			}
 		}
@@ -197,16 +287,17 @@ func (al *Allowlist) AllowedPeerAndMultiaddr(peerID peer.ID, ma multiaddr.Multia
 	al.mu.RLock()
 	defer al.mu.RUnlock()
 
-	for _, network := range al.allowedNetworks {
-		if network.Contains(ip) {
+	now := al.now()
+	for _, e := range al.allowedNetworks {
+		if e.valid(now) && e.network.Contains(ip) {
 			// We found a match that isn't constrained by a peerID
 			return true
 		}
 	}
 
 	if expectedNetworks, ok := al.allowedPeerByNetwork[peerID]; ok {
-		for _, expectedNetwork := range expectedNetworks {
-			if expectedNetwork.Contains(ip) {
+		for _, e := range expectedNetworks {
+			if e.valid(now) && e.network.Contains(ip) {
 				return true
 			}
 		}
@@ -0,0 +1,122 @@
+package rcmgr
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// AllowlistSource is a source of multiaddrs to allowlist, for example the addresses of a fleet
+// of nodes that change over time. See WithAllowlistSource.
+type AllowlistSource interface {
+	// Multiaddrs returns the multiaddrs that should currently be allowlisted.
+	Multiaddrs(ctx context.Context) ([]multiaddr.Multiaddr, error)
+}
+
+// AllowlistSourceFunc is an AllowlistSource implemented by a function.
+type AllowlistSourceFunc func(ctx context.Context) ([]multiaddr.Multiaddr, error)
+
+func (f AllowlistSourceFunc) Multiaddrs(ctx context.Context) ([]multiaddr.Multiaddr, error) {
+	return f(ctx)
+}
+
+// allowlistSourceTTLFactor is the number of refresh intervals that the multiaddrs of an allowlist
+// source stay allowlisted, so that they survive a failed refresh.
+const allowlistSourceTTLFactor = 3
+
+type allowlistSource struct {
+	src      AllowlistSource
+	interval time.Duration
+}
+
+// WithAllowlistSource allowlists the multiaddrs returned by src, and refreshes them every interval.
+// The multiaddrs are allowlisted for three intervals, so they expire once src stops returning them,
+// but survive a failed refresh.
+func WithAllowlistSource(src AllowlistSource, interval time.Duration) Option {
+	return func(r *resourceManager) error {
+		if interval <= 0 {
+			return errors.New("allowlist source refresh interval must be positive")
+		}
+		r.allowlistSources = append(r.allowlistSources, allowlistSource{src: src, interval: interval})
+		return nil
+	}
+}
+
+func (r *resourceManager) allowlistSourceBackground(s allowlistSource) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		r.refreshAllowlist(s)
+
+		select {
+		case <-ticker.C:
+		case <-r.cancelCtx.Done():
+			return
+		}
+	}
+}
+
+func (r *resourceManager) refreshAllowlist(s allowlistSource) {
+	ctx, cancel := context.WithTimeout(r.cancelCtx, s.interval)
+	defer cancel()
+
+	addrs, err := s.src.Multiaddrs(ctx)
+	if err != nil {
+		log.Warnw("failed to refresh allowlist", "error", err)
+		return
+	}
+	for _, addr := range addrs {
+		if err := r.allowlist.AddWithTTL(addr, allowlistSourceTTLFactor*s.interval); err != nil {
+			log.Debugw("failed to allowlist multiaddr", "addr", addr, "error", err)
+		}
+	}
+}
+
+// maxDNSResolutionDepth is the maximum number of nested /dnsaddr records that DNSAllowlistSource follows.
+const maxDNSResolutionDepth = 8
+
+// DNSAllowlistSource returns an AllowlistSource that resolves addrs using resolver. /dnsaddr
+// multiaddrs are resolved recursively, so e.g. /dnsaddr/bootstrap.libp2p.io allowlists the
+// IP addresses of all bootstrap nodes, constrained to their peer IDs.
+// If resolver is nil, madns.DefaultResolver is used.
+func DNSAllowlistSource(resolver *madns.Resolver, addrs ...multiaddr.Multiaddr) AllowlistSource {
+	if resolver == nil {
+		resolver = madns.DefaultResolver
+	}
+	return AllowlistSourceFunc(func(ctx context.Context) ([]multiaddr.Multiaddr, error) {
+		var resolved []multiaddr.Multiaddr
+		var lastErr error
+		toResolve := addrs
+		for depth := 0; len(toResolve) > 0 && depth < maxDNSResolutionDepth; depth++ {
+			var next []multiaddr.Multiaddr
+			for _, addr := range toResolve {
+				if !madns.Matches(addr) {
+					resolved = append(resolved, addr)
+					continue
+				}
+				res, err := resolver.Resolve(ctx, addr)
+				if err != nil {
+					lastErr = err
+					continue
+				}
+				next = append(next, res...)
+			}
+			toResolve = next
+		}
+		// Only fail if nothing could be resolved. Otherwise, a single failing name would
+		// prevent the others from being refreshed.
+		if len(resolved) == 0 {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, errors.New("no multiaddrs resolved")
+		}
+		return resolved, nil
+	})
+}
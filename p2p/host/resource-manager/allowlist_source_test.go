@@ -0,0 +1,129 @@
+package rcmgr
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/test"
+
+	"github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/stretchr/testify/require"
+)
+
+type traceEvtCounter struct {
+	mx     sync.Mutex
+	counts map[TraceEvtTyp]int
+}
+
+func (c *traceEvtCounter) ConsumeEvent(evt TraceEvt) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[TraceEvtTyp]int)
+	}
+	c.counts[evt.Type]++
+}
+
+func (c *traceEvtCounter) count(typ TraceEvtTyp) int {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.counts[typ]
+}
+
+func TestAllowlistSource(t *testing.T) {
+	var mx sync.Mutex
+	addr := "/ip4/1.2.3.4"
+	src := AllowlistSourceFunc(func(ctx context.Context) ([]multiaddr.Multiaddr, error) {
+		mx.Lock()
+		defer mx.Unlock()
+		if addr == "" {
+			return nil, errors.New("source unavailable")
+		}
+		return []multiaddr.Multiaddr{multiaddr.StringCast(addr)}, nil
+	})
+	setAddr := func(a string) {
+		mx.Lock()
+		defer mx.Unlock()
+		addr = a
+	}
+
+	limits := updateTestLimits(1)
+	limits.System = BaseLimit{}
+	limits.Transient = BaseLimit{}
+	counter := &traceEvtCounter{}
+	mgr, err := NewResourceManager(NewFixedLimiter(limits), WithAllowlistSource(src, 100*time.Millisecond), WithTraceReporter(counter))
+	require.NoError(t, err)
+	defer mgr.Close()
+	al := GetAllowlist(mgr)
+
+	maA := multiaddr.StringCast("/ip4/1.2.3.4/tcp/1234")
+	maB := multiaddr.StringCast("/ip4/2.2.3.4/tcp/1234")
+	require.Eventually(t, func() bool { return al.Allowed(maA) }, 5*time.Second, 10*time.Millisecond)
+
+	// allowlisted connections are admitted, even though the limits don't allow any connections
+	conn, err := mgr.OpenConnection(network.DirInbound, true, maA)
+	require.NoError(t, err)
+	conn.Done()
+	_, err = mgr.OpenConnection(network.DirInbound, true, maB)
+	require.Error(t, err)
+	require.Equal(t, 1, counter.count(TraceAllowlistHitEvt))
+	require.Equal(t, 1, counter.count(TraceAllowlistMissEvt))
+
+	// the entries survive failed refreshes for a while
+	setAddr("")
+	time.Sleep(150 * time.Millisecond)
+	require.True(t, al.Allowed(maA))
+
+	setAddr("/ip4/2.2.3.4")
+	require.Eventually(t, func() bool { return al.Allowed(maB) && !al.Allowed(maA) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestAllowlistSourceConfig(t *testing.T) {
+	src := AllowlistSourceFunc(func(ctx context.Context) ([]multiaddr.Multiaddr, error) { return nil, nil })
+	_, err := NewResourceManager(NewFixedLimiter(InfiniteLimits), WithAllowlistSource(src, 0))
+	require.Error(t, err)
+}
+
+func TestDNSAllowlistSource(t *testing.T) {
+	peerA := test.RandPeerIDFatal(t)
+	peerB := test.RandPeerIDFatal(t)
+	resolver, err := madns.NewResolver(madns.WithDefaultResolver(&madns.MockResolver{
+		IP: map[string][]net.IPAddr{
+			"node.example.com": {{IP: net.ParseIP("3.3.3.3")}},
+		},
+		TXT: map[string][]string{
+			"_dnsaddr.example.com": {
+				"dnsaddr=/dnsaddr/a.example.com/p2p/" + peerA.String(),
+				"dnsaddr=/dnsaddr/b.example.com/p2p/" + peerB.String(),
+			},
+			"_dnsaddr.a.example.com": {"dnsaddr=/ip4/1.1.1.1/tcp/4001/p2p/" + peerA.String()},
+			"_dnsaddr.b.example.com": {"dnsaddr=/ip6/::2/tcp/4001/p2p/" + peerB.String()},
+		},
+	}))
+	require.NoError(t, err)
+
+	src := DNSAllowlistSource(resolver,
+		multiaddr.StringCast("/dnsaddr/example.com"),
+		multiaddr.StringCast("/dns4/node.example.com/tcp/4001"),
+		multiaddr.StringCast("/ip4/4.4.4.4"),
+		multiaddr.StringCast("/dns4/unknown.example.com"),
+	)
+	addrs, err := src.Multiaddrs(context.Background())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/1.1.1.1/tcp/4001/p2p/" + peerA.String()),
+		multiaddr.StringCast("/ip6/::2/tcp/4001/p2p/" + peerB.String()),
+		multiaddr.StringCast("/ip4/3.3.3.3/tcp/4001"),
+		multiaddr.StringCast("/ip4/4.4.4.4"),
+	}, addrs)
+
+	// nothing could be resolved
+	_, err = DNSAllowlistSource(resolver, multiaddr.StringCast("/dns4/unknown.example.com")).Multiaddrs(context.Background())
+	require.Error(t, err)
+}
@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/test"
 
+	"github.com/benbjohnson/clock"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
 )
 
 func ExampleWithAllowlistedMultiaddrs() {
@@ -215,6 +218,47 @@ func TestRemoved(t *testing.T) {
 	}
 }
 
+func TestAllowlistTTL(t *testing.T) {
+	mockClock := clock.NewMock()
+	allowlist := newAllowlist()
+	allowlist.clock = mockClock
+
+	peerA := test.RandPeerIDFatal(t)
+	maA := multiaddr.StringCast("/ip4/1.2.3.4")
+	require.Error(t, allowlist.AddWithTTL(maA, 0))
+
+	require.NoError(t, allowlist.AddWithTTL(multiaddr.StringCast("/ip4/1.2.3.4"), time.Minute))
+	require.NoError(t, allowlist.AddWithTTL(multiaddr.StringCast("/ip4/2.2.3.4/p2p/"+peerA.String()), time.Minute))
+	require.True(t, allowlist.Allowed(maA))
+	require.True(t, allowlist.AllowedPeerAndMultiaddr(peerA, multiaddr.StringCast("/ip4/2.2.3.4")))
+
+	// extend the TTL of the entry without a peer
+	mockClock.Add(30 * time.Second)
+	require.NoError(t, allowlist.AddWithTTL(multiaddr.StringCast("/ip4/1.2.3.4"), time.Minute))
+	require.Len(t, allowlist.allowedNetworks, 1)
+	// a shorter TTL doesn't shorten it
+	require.NoError(t, allowlist.AddWithTTL(multiaddr.StringCast("/ip4/1.2.3.4"), time.Second))
+
+	mockClock.Add(31 * time.Second)
+	require.True(t, allowlist.Allowed(maA))
+	require.False(t, allowlist.AllowedPeerAndMultiaddr(peerA, multiaddr.StringCast("/ip4/2.2.3.4")))
+	require.False(t, allowlist.Allowed(multiaddr.StringCast("/ip4/2.2.3.4")))
+
+	allowlist.removeExpired()
+	require.Len(t, allowlist.allowedNetworks, 1)
+	require.Empty(t, allowlist.allowedPeerByNetwork)
+
+	mockClock.Add(30 * time.Second)
+	require.False(t, allowlist.Allowed(maA))
+
+	// entries without a TTL don't expire
+	require.NoError(t, allowlist.Add(maA))
+	mockClock.Add(time.Hour)
+	require.True(t, allowlist.Allowed(maA))
+	allowlist.removeExpired()
+	require.Len(t, allowlist.allowedNetworks, 1)
+}
+
 // BenchmarkAllowlistCheck benchmarks the allowlist with plausible conditions.
 func BenchmarkAllowlistCheck(b *testing.B) {
 	allowlist := newAllowlist()
@@ -0,0 +1,155 @@
+package rcmgr
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupLimits are the resource limits of the cgroup that the process runs in.
+type cgroupLimits struct {
+	// memory is the memory limit in bytes, 0 if the memory is not limited.
+	memory int64
+	// cpus is the CPU quota in number of CPUs, 0 if the CPU is not limited.
+	cpus float64
+}
+
+// cgroupV1Unlimited is the smallest value of memory.limit_in_bytes that means unlimited.
+// The kernel reports the largest positive int64, rounded down to the page size.
+const cgroupV1Unlimited = 1 << 62
+
+// readCgroupLimits reads the limits of the cgroup of the current process.
+// It supports cgroup v1 and v2. root is the root of the file system.
+func readCgroupLimits(root string) cgroupLimits {
+	f, err := os.Open(filepath.Join(root, "proc/self/cgroup"))
+	if err != nil {
+		return cgroupLimits{}
+	}
+	defer f.Close()
+
+	// Each line has the format hierarchy-ID:controller-list:cgroup-path.
+	// In cgroup v2, there's a single line with an empty controller list.
+	var v2Path, memoryPath, cpuPath string
+	var isV2, isMemoryV1, isCPUV1 bool
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			v2Path, isV2 = parts[2], true
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			switch controller {
+			case "memory":
+				memoryPath, isMemoryV1 = parts[2], true
+			case "cpu":
+				cpuPath, isCPUV1 = parts[2], true
+			}
+		}
+	}
+
+	var limits cgroupLimits
+	mount := filepath.Join(root, "sys/fs/cgroup")
+	switch {
+	case isMemoryV1:
+		limits.memory = readCgroupMemoryLimit(filepath.Join(mount, "memory"), memoryPath, "memory.limit_in_bytes")
+	case isV2:
+		limits.memory = readCgroupMemoryLimit(mount, v2Path, "memory.max")
+	}
+	switch {
+	case isCPUV1:
+		cpuMount := filepath.Join(mount, "cpu,cpuacct")
+		if _, err := os.Stat(cpuMount); err != nil {
+			cpuMount = filepath.Join(mount, "cpu")
+		}
+		limits.cpus = readCgroupCPUQuota(cpuMount, cpuPath, func(dir string) (string, string, error) {
+			quota, err := readCgroupFile(filepath.Join(dir, "cpu.cfs_quota_us"))
+			if err != nil {
+				return "", "", err
+			}
+			period, err := readCgroupFile(filepath.Join(dir, "cpu.cfs_period_us"))
+			return quota, period, err
+		})
+	case isV2:
+		limits.cpus = readCgroupCPUQuota(mount, v2Path, func(dir string) (string, string, error) {
+			s, err := readCgroupFile(filepath.Join(dir, "cpu.max"))
+			if err != nil {
+				return "", "", err
+			}
+			quota, period, _ := strings.Cut(s, " ")
+			return quota, period, nil
+		})
+	}
+	return limits
+}
+
+// walkCgroup calls read for the directory of the cgroup at path below mount, and for all of its ancestors.
+// Limits of an ancestor apply to all of its descendants.
+// If the cgroup directory doesn't exist, e.g. because the cgroup is the root of a container's
+// cgroup namespace, read is only called for mount.
+func walkCgroup(mount, path string, read func(dir string)) {
+	dir := filepath.Join(mount, path)
+	if _, err := os.Stat(dir); err != nil {
+		dir = mount
+	}
+	for {
+		read(dir)
+		if dir == mount || !strings.HasPrefix(dir, mount) {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func readCgroupMemoryLimit(mount, path, file string) int64 {
+	var limit int64
+	walkCgroup(mount, path, func(dir string) {
+		s, err := readCgroupFile(filepath.Join(dir, file))
+		if err != nil || s == "max" {
+			return
+		}
+		l, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || l <= 0 || l >= cgroupV1Unlimited {
+			return
+		}
+		if limit == 0 || l < limit {
+			limit = l
+		}
+	})
+	return limit
+}
+
+func readCgroupCPUQuota(mount, path string, read func(dir string) (quota, period string, err error)) float64 {
+	var cpus float64
+	walkCgroup(mount, path, func(dir string) {
+		quotaStr, periodStr, err := read(dir)
+		if err != nil || quotaStr == "max" {
+			return
+		}
+		quota, err := strconv.ParseInt(quotaStr, 10, 64)
+		if err != nil || quota <= 0 {
+			return
+		}
+		period, err := strconv.ParseInt(periodStr, 10, 64)
+		if err != nil || period <= 0 {
+			return
+		}
+		if c := float64(quota) / float64(period); cpus == 0 || c < cpus {
+			cpus = c
+		}
+	})
+	return cpus
+}
+
+func readCgroupFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,7 @@
+//go:build linux
+
+package rcmgr
+
+func getCgroupLimits() cgroupLimits {
+	return readCgroupLimits("/")
+}
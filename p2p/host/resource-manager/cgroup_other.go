@@ -0,0 +1,8 @@
+//go:build !linux
+
+package rcmgr
+
+// cgroups only exist on Linux.
+func getCgroupLimits() cgroupLimits {
+	return cgroupLimits{}
+}
@@ -0,0 +1,103 @@
+package rcmgr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeCgroupFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content+"\n"), 0o644))
+	}
+}
+
+func TestCgroupLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		files    map[string]string
+		expected cgroupLimits
+	}{
+		{
+			name:  "no cgroups",
+			files: map[string]string{},
+		},
+		{
+			name: "v2 unlimited",
+			files: map[string]string{
+				"proc/self/cgroup":         "0::/",
+				"sys/fs/cgroup/memory.max": "max",
+				"sys/fs/cgroup/cpu.max":    "max 100000",
+			},
+		},
+		{
+			name: "v2 container",
+			files: map[string]string{
+				"proc/self/cgroup":         "0::/",
+				"sys/fs/cgroup/memory.max": "1073741824",
+				"sys/fs/cgroup/cpu.max":    "150000 100000",
+			},
+			expected: cgroupLimits{memory: 1 << 30, cpus: 1.5},
+		},
+		{
+			name: "v2 nested",
+			files: map[string]string{
+				"proc/self/cgroup":                                   "0::/system.slice/node.service",
+				"sys/fs/cgroup/system.slice/memory.max":              "536870912",
+				"sys/fs/cgroup/system.slice/cpu.max":                 "max 100000",
+				"sys/fs/cgroup/system.slice/node.service/memory.max": "1073741824",
+				"sys/fs/cgroup/system.slice/node.service/cpu.max":    "50000 100000",
+			},
+			expected: cgroupLimits{memory: 1 << 29, cpus: 0.5},
+		},
+		{
+			name: "v1 container",
+			files: map[string]string{
+				"proc/self/cgroup": "12:memory:/docker/0123\n" +
+					"4:cpu,cpuacct:/docker/0123\n" +
+					"1:name=systemd:/docker/0123\n" +
+					"0::/docker/0123",
+				"sys/fs/cgroup/memory/memory.limit_in_bytes":   "2147483648",
+				"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us":   "200000",
+				"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us":  "100000",
+				"sys/fs/cgroup/unified/cgroup.controllers":     "",
+				"sys/fs/cgroup/unified/docker/0123/memory.max": "1",
+				"sys/fs/cgroup/unified/docker/0123/cpu.max":    "1 100000",
+			},
+			expected: cgroupLimits{memory: 2 << 30, cpus: 2},
+		},
+		{
+			name: "v1 unlimited",
+			files: map[string]string{
+				"proc/self/cgroup": "4:memory:/user.slice\n" +
+					"3:cpu:/user.slice",
+				"sys/fs/cgroup/memory/user.slice/memory.limit_in_bytes": "9223372036854771712",
+				"sys/fs/cgroup/memory/memory.limit_in_bytes":            "9223372036854771712",
+				"sys/fs/cgroup/cpu/user.slice/cpu.cfs_quota_us":         "-1",
+				"sys/fs/cgroup/cpu/user.slice/cpu.cfs_period_us":        "100000",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeCgroupFiles(t, root, tc.files)
+			require.Equal(t, tc.expected, readCgroupLimits(root))
+		})
+	}
+}
+
+func TestAutoScaleMemory(t *testing.T) {
+	require.Equal(t, int64(2<<30), autoScaleMemory(16<<30, cgroupLimits{}))
+	// the cgroup memory limit is lower than the system memory
+	require.Equal(t, int64(512<<20), autoScaleMemory(16<<30, cgroupLimits{memory: 4 << 30}))
+	// the cgroup memory limit is higher than the system memory
+	require.Equal(t, int64(2<<30), autoScaleMemory(16<<30, cgroupLimits{memory: 32 << 30}))
+	// the CPU quota
+	require.Equal(t, int64(1<<29), autoScaleMemory(16<<30, cgroupLimits{cpus: 0.5}))
+	require.Equal(t, int64(2<<30), autoScaleMemory(16<<30, cgroupLimits{cpus: 4}))
+}
@@ -0,0 +1,99 @@
+// analyze-trace analyzes a trace written by the resource manager (see rcmgr.WithTrace).
+//
+// It prints a summary of the trace, the scopes and protocols that blocked the most
+// reservations, and a limit configuration that would have covered the observed peak usage.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p/p2p/host/resource-manager/traceanalysis"
+)
+
+func main() {
+	top := flag.Int("top", 10, "number of blocked scopes and protocols to print")
+	headroom := flag.Float64("headroom", 0.2, "headroom to add to the observed peak usage when suggesting limits")
+	scope := flag.String("scope", "", "print the usage of this scope over time")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <trace file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), *top, *headroom, *scope); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(path string, top int, headroom float64, scope string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	a, err := traceanalysis.NewAnalyzer()
+	if err != nil {
+		return err
+	}
+	if err := a.ReadTrace(f); err != nil {
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		log.Println("trace is truncated, analyzing the events up to the truncation")
+	}
+
+	if scope != "" {
+		s := a.Scope(scope)
+		if s == nil {
+			return fmt.Errorf("no events for scope %s", scope)
+		}
+		fmt.Println("time\tmemory\tstreams in\tstreams out\tconns in\tconns out\tfd")
+		for _, sample := range s.Samples {
+			fmt.Printf("%s\t%d\t%d\t%d\t%d\t%d\t%d\n", sample.Time.Format(time.RFC3339Nano),
+				sample.Memory, sample.StreamsIn, sample.StreamsOut, sample.ConnsIn, sample.ConnsOut, sample.FD)
+		}
+		return nil
+	}
+
+	fmt.Printf("Trace from %s to %s (%s)\n", a.Start().Format(time.RFC3339), a.End().Format(time.RFC3339), a.End().Sub(a.Start()))
+	if a.Limits() == nil {
+		fmt.Println("The trace doesn't contain the limits the resource manager was started with.")
+	}
+	if s := a.Scope("system"); s != nil {
+		fmt.Printf("Peak system usage: memory %d, streams %d (%d in, %d out), conns %d (%d in, %d out), fd %d\n",
+			s.Peak.Memory, s.Peak.Streams, s.Peak.StreamsIn, s.Peak.StreamsOut, s.Peak.Conns, s.Peak.ConnsIn, s.Peak.ConnsOut, s.Peak.FD)
+	}
+
+	printBlocked := func(title string, blocked []traceanalysis.Blocked) {
+		fmt.Printf("\n%s:\n", title)
+		if len(blocked) == 0 {
+			fmt.Println("  none")
+		}
+		for _, b := range blocked {
+			fmt.Printf("  %s: %d (memory %d, streams %d, conns %d)\n", b.Name, b.Count,
+				b.ByResource[traceanalysis.ResourceMemory], b.ByResource[traceanalysis.ResourceStream], b.ByResource[traceanalysis.ResourceConnection])
+		}
+	}
+	printBlocked("Top blocked scopes", a.TopBlockedScopes(top))
+	printBlocked("Top blocked protocols", a.TopBlockedProtocols(top))
+	printBlocked("Top blocked services", a.TopBlockedServices(top))
+
+	fmt.Printf("\nSuggested limits (%.0f%% headroom):\n", headroom*100)
+	b, err := json.MarshalIndent(a.SuggestLimits(headroom), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
@@ -3,11 +3,14 @@ package rcmgr
 import (
 	"errors"
 
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/network"
 )
 
 type errStreamOrConnLimitExceeded struct {
 	current, attempted, limit int
+	resource                  event.LimitedResource
+	scope                     string // the scope whose limit was exceeded, set by the scope
 	err                       error
 }
 
@@ -55,6 +58,7 @@ func logValuesConnLimit(scope, edge string, dir network.Direction, usefd bool, s
 type errMemoryLimitExceeded struct {
 	current, attempted, limit int64
 	priority                  uint8
+	scope                     string // the scope whose limit was exceeded, set by the scope
 	err                       error
 }
 
@@ -79,3 +83,29 @@ func logValuesMemoryLimit(scope, edge string, stat network.ScopeStat, err error)
 	}
 	return append(logValues, "stat", stat, "error", err)
 }
+
+// setBlockingScope records the name of the scope whose limit was exceeded in a limit error.
+func setBlockingScope(err error, scope string) {
+	var streamOrConnErr *errStreamOrConnLimitExceeded
+	if errors.As(err, &streamOrConnErr) {
+		streamOrConnErr.scope = scope
+	}
+	var memoryErr *errMemoryLimitExceeded
+	if errors.As(err, &memoryErr) {
+		memoryErr.scope = scope
+	}
+}
+
+// blockingScope returns the name of the scope whose limit was exceeded, and the kind of
+// resource that couldn't be reserved. ok is false if err wasn't caused by a limit.
+func blockingScope(err error) (scope string, resource event.LimitedResource, ok bool) {
+	var streamOrConnErr *errStreamOrConnLimitExceeded
+	if errors.As(err, &streamOrConnErr) {
+		return streamOrConnErr.scope, streamOrConnErr.resource, true
+	}
+	var memoryErr *errMemoryLimitExceeded
+	if errors.As(err, &memoryErr) {
+		return memoryErr.scope, event.LimitedResourceMemory, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,147 @@
+package rcmgr
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+var (
+	// DefaultLimitExceededEventRate is the default maximum number of EvtResourceLimitExceeded
+	// events emitted per DefaultLimitExceededEventInterval.
+	DefaultLimitExceededEventRate = 10
+	// DefaultLimitExceededEventInterval is the default interval that the number of
+	// EvtResourceLimitExceeded events is limited in.
+	DefaultLimitExceededEventInterval = time.Second
+)
+
+type limitExceededEvents struct {
+	max      int
+	interval time.Duration
+
+	// queue decouples emitting the events from the scopes, which call emitLimitExceeded with their lock held
+	queue chan event.EvtResourceLimitExceeded
+
+	mx          sync.Mutex
+	windowStart time.Time
+	count       int // events queued since windowStart
+	suppressed  int // events dropped since the last queued event
+}
+
+// WithLimitExceededEventRate limits the number of EvtResourceLimitExceeded events to max per interval.
+// Events exceeding the rate are dropped.
+func WithLimitExceededEventRate(max int, interval time.Duration) Option {
+	return func(r *resourceManager) error {
+		if max <= 0 {
+			return errors.New("limit exceeded event rate must be positive")
+		}
+		if interval <= 0 {
+			return errors.New("limit exceeded event interval must be positive")
+		}
+		r.limitExceeded.max = max
+		r.limitExceeded.interval = interval
+		return nil
+	}
+}
+
+// SetEventBus sets the event bus that the resource manager emits its events on.
+// The host calls this when it is constructed.
+func (r *resourceManager) SetEventBus(bus event.Bus) error {
+	evtLimitsUpdated, err := bus.Emitter(&event.EvtResourceLimitsUpdated{})
+	if err != nil {
+		return err
+	}
+	evtLimitExceeded, err := bus.Emitter(&event.EvtResourceLimitExceeded{})
+	if err != nil {
+		evtLimitsUpdated.Close()
+		return err
+	}
+
+	r.emitters.mx.Lock()
+	defer r.emitters.mx.Unlock()
+
+	if r.emitters.evtLimitsUpdated != nil {
+		r.emitters.evtLimitsUpdated.Close()
+	}
+	if r.emitters.evtLimitExceeded != nil {
+		r.emitters.evtLimitExceeded.Close()
+	}
+	r.emitters.evtLimitsUpdated = evtLimitsUpdated
+	r.emitters.evtLimitExceeded = evtLimitExceeded
+	return nil
+}
+
+func (r *resourceManager) emitLimitsUpdated(evt event.EvtResourceLimitsUpdated) {
+	r.emitters.mx.Lock()
+	defer r.emitters.mx.Unlock()
+
+	if r.emitters.evtLimitsUpdated == nil {
+		return
+	}
+	if err := r.emitters.evtLimitsUpdated.Emit(evt); err != nil {
+		log.Warnw("failed to emit limits updated event", "error", err)
+	}
+}
+
+// emitLimitExceeded emits an EvtResourceLimitExceeded if err was caused by a limit, unless that
+// exceeds the event rate. It never blocks, so it's safe to call with scope locks held.
+func (r *resourceManager) emitLimitExceeded(err error, dir network.Direction, p peer.ID, proto protocol.ID) {
+	scope, resource, ok := blockingScope(err)
+	if !ok {
+		return
+	}
+
+	l := &r.limitExceeded
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if now := time.Now(); now.Sub(l.windowStart) >= l.interval {
+		l.windowStart = now
+		l.count = 0
+	}
+	if l.count >= l.max {
+		l.suppressed++
+		return
+	}
+
+	evt := event.EvtResourceLimitExceeded{
+		Scope:      scope,
+		Resource:   resource,
+		Direction:  dir,
+		Peer:       p,
+		Protocol:   proto,
+		Suppressed: l.suppressed,
+	}
+	select {
+	case l.queue <- evt:
+		l.count++
+		l.suppressed = 0
+	default:
+		// the event bus can't keep up
+		l.suppressed++
+	}
+}
+
+func (r *resourceManager) limitExceededBackground() {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case evt := <-r.limitExceeded.queue:
+			r.emitters.mx.Lock()
+			if r.emitters.evtLimitExceeded != nil {
+				if err := r.emitters.evtLimitExceeded.Emit(evt); err != nil {
+					log.Warnw("failed to emit limit exceeded event", "error", err)
+				}
+			}
+			r.emitters.mx.Unlock()
+		case <-r.cancelCtx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package rcmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitExceededEvents(t *testing.T) {
+	limits := updateTestLimits(1)
+	limits.ProtocolDefault.Streams = 1
+	limits.ProtocolDefault.StreamsOutbound = 1
+	mgr, err := NewResourceManager(NewFixedLimiter(limits), WithLimitExceededEventRate(3, time.Hour))
+	require.NoError(t, err)
+	defer mgr.Close()
+	r := mgr.(*resourceManager)
+
+	bus := eventbus.NewBus()
+	require.NoError(t, r.SetEventBus(bus))
+	sub, err := bus.Subscribe(new(event.EvtResourceLimitExceeded))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	nextEvent := func() event.EvtResourceLimitExceeded {
+		t.Helper()
+		select {
+		case evt := <-sub.Out():
+			return evt.(event.EvtResourceLimitExceeded)
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a limit exceeded event")
+		}
+		return event.EvtResourceLimitExceeded{}
+	}
+
+	conn, err := mgr.OpenConnection(network.DirInbound, true, dummyMA)
+	require.NoError(t, err)
+	defer conn.Done()
+	_, err = mgr.OpenConnection(network.DirInbound, true, dummyMA)
+	require.Error(t, err)
+	require.Equal(t, event.EvtResourceLimitExceeded{
+		Scope:     "transient",
+		Resource:  event.LimitedResourceConns,
+		Direction: network.DirInbound,
+	}, nextEvent())
+
+	p := peer.ID("A")
+	stream, err := mgr.OpenStream(p, network.DirOutbound)
+	require.NoError(t, err)
+	defer stream.Done()
+	require.NoError(t, stream.SetProtocol("/proto"))
+	stream2, err := mgr.OpenStream(p, network.DirOutbound)
+	require.NoError(t, err)
+	require.Error(t, stream2.SetProtocol("/proto"))
+	stream2.Done()
+	require.Equal(t, event.EvtResourceLimitExceeded{
+		Scope:     "protocol:/proto",
+		Resource:  event.LimitedResourceStreams,
+		Direction: network.DirOutbound,
+		Peer:      p,
+		Protocol:  "/proto",
+	}, nextEvent())
+
+	require.Error(t, stream.ReserveMemory(2<<20, network.ReservationPriorityAlways))
+	evt := nextEvent()
+	require.True(t, IsStreamScope(evt.Scope))
+	require.Equal(t, event.LimitedResourceMemory, evt.Resource)
+	require.Equal(t, network.DirUnknown, evt.Direction)
+	require.Equal(t, p, evt.Peer)
+	require.Equal(t, protocol.ID("/proto"), evt.Protocol)
+
+	// the rate is exceeded
+	require.Error(t, stream.ReserveMemory(2<<20, network.ReservationPriorityAlways))
+	require.Error(t, stream.ReserveMemory(2<<20, network.ReservationPriorityAlways))
+	select {
+	case evt := <-sub.Out():
+		t.Fatalf("didn't expect an event: %v", evt)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// start the next interval
+	r.limitExceeded.mx.Lock()
+	r.limitExceeded.windowStart = time.Time{}
+	r.limitExceeded.mx.Unlock()
+	span, err := stream.BeginSpan()
+	require.NoError(t, err)
+	defer span.Done()
+	require.Error(t, span.ReserveMemory(2<<20, network.ReservationPriorityAlways))
+	evt = nextEvent()
+	require.True(t, IsSpan(evt.Scope))
+	require.Equal(t, p, evt.Peer)
+	require.Equal(t, 2, evt.Suppressed)
+}
+
+func TestLimitExceededEventRateConfig(t *testing.T) {
+	_, err := NewResourceManager(NewFixedLimiter(InfiniteLimits), WithLimitExceededEventRate(0, time.Second))
+	require.Error(t, err)
+	_, err = NewResourceManager(NewFixedLimiter(InfiniteLimits), WithLimitExceededEventRate(1, 0))
+	require.Error(t, err)
+}
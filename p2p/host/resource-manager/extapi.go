@@ -5,6 +5,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
@@ -18,6 +19,18 @@ type ResourceScopeLimiter interface {
 
 var _ ResourceScopeLimiter = (*resourceScope)(nil)
 
+// LimitUpdater is a trait interface that allows you to replace the limits of the resource manager at runtime.
+type LimitUpdater interface {
+	// UpdateLimits replaces the limits of the resource manager with cfg.
+	// The limits of all live system, transient, service, protocol, peer and subnet scopes are updated;
+	// open connections and streams keep the limits they were created with.
+	UpdateLimits(cfg LimitConfig) error
+}
+
+var _ LimitUpdater = (*resourceManager)(nil)
+
+var _ event.BusSetter = (*resourceManager)(nil)
+
 // ResourceManagerStat is a trait that allows you to access resource manager state.
 type ResourceManagerState interface {
 	ListServices() []string
@@ -49,6 +49,12 @@ type Limiter interface {
 	GetConnLimits() Limit
 }
 
+// SubnetLimiter is an optional interface for Limiters that limit the connections per IP address and subnet.
+type SubnetLimiter interface {
+	GetIPv4SubnetLimits() []SubnetLimit
+	GetIPv6SubnetLimits() []SubnetLimit
+}
+
 // NewDefaultLimiterFromJSON creates a new limiter by parsing a json configuration,
 // using the default limits for fallback.
 func NewDefaultLimiterFromJSON(in io.Reader) (Limiter, error) {
@@ -79,6 +85,7 @@ type fixedLimiter struct {
 }
 
 var _ Limiter = (*fixedLimiter)(nil)
+var _ SubnetLimiter = (*fixedLimiter)(nil)
 
 func NewFixedLimiter(conf LimitConfig) Limiter {
 	log.Debugw("initializing new limiter with config", "limits", conf)
@@ -264,3 +271,11 @@ func (l *fixedLimiter) GetStreamLimits(_ peer.ID) Limit {
 func (l *fixedLimiter) GetConnLimits() Limit {
 	return &l.Conn
 }
+
+func (l *fixedLimiter) GetIPv4SubnetLimits() []SubnetLimit {
+	return l.IPv4Subnet
+}
+
+func (l *fixedLimiter) GetIPv6SubnetLimits() []SubnetLimit {
+	return l.IPv6Subnet
+}
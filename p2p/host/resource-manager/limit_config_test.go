@@ -53,6 +53,9 @@ func TestLimitConfigParser(t *testing.T) {
 	require.Contains(t, cfg.Peer, peerID)
 	require.Equal(t, int64(4097), cfg.Peer[peerID].Memory)
 
+	require.Equal(t, []SubnetLimit{{PrefixLength: 32, ConnsInbound: 8}, {PrefixLength: 24, ConnsInbound: 64}}, cfg.IPv4Subnet)
+	require.Empty(t, cfg.IPv6Subnet)
+
 	// Roundtrip
 	jsonBytes, err := json.Marshal(&cfg)
 	require.NoError(t, err)
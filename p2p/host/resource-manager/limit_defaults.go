@@ -135,6 +135,12 @@ type LimitConfig struct {
 
 	Conn   BaseLimit `json:",omitempty"`
 	Stream BaseLimit `json:",omitempty"`
+
+	// Limits on the connections per IP address and subnet. They are checked when a
+	// connection is opened, before the security handshake, and don't apply to
+	// connections from allowlisted multiaddrs.
+	IPv4Subnet []SubnetLimit `json:",omitempty"`
+	IPv6Subnet []SubnetLimit `json:",omitempty"`
 }
 
 func (cfg *LimitConfig) MarshalJSON() ([]byte, error) {
@@ -165,6 +171,12 @@ func (cfg *LimitConfig) Apply(c LimitConfig) {
 	cfg.PeerDefault.Apply(c.PeerDefault)
 	cfg.Conn.Apply(c.Conn)
 	cfg.Stream.Apply(c.Stream)
+	if len(cfg.IPv4Subnet) == 0 {
+		cfg.IPv4Subnet = c.IPv4Subnet
+	}
+	if len(cfg.IPv6Subnet) == 0 {
+		cfg.IPv6Subnet = c.IPv6Subnet
+	}
 
 	// TODO: the following could be solved a lot nicer, if only we could use generics
 	for s, l := range cfg.Service {
@@ -304,13 +316,34 @@ func (cfg *ScalingLimitConfig) Scale(memory int64, numFD int) LimitConfig {
 	return lc
 }
 
+// AutoScale scales the limits to the memory and file descriptors available to the process.
+// It uses 1/8 of the memory and 1/2 of the file descriptors. On Linux, the memory limit
+// of the process's cgroup (v1 or v2) is taken into account, and with a cgroup CPU quota,
+// the limits are scaled to at most 1 GiB of memory per CPU.
 func (cfg *ScalingLimitConfig) AutoScale() LimitConfig {
 	return cfg.Scale(
-		int64(memory.TotalMemory())/8,
+		autoScaleMemory(int64(memory.TotalMemory()), getCgroupLimits()),
 		getNumFDs()/2,
 	)
 }
 
+// memoryPerCPU is the maximum amount of memory that AutoScale scales the limits to per CPU,
+// if the process is limited by a cgroup CPU quota.
+const memoryPerCPU = 1 << 30
+
+func autoScaleMemory(total int64, cgroup cgroupLimits) int64 {
+	if cgroup.memory > 0 && cgroup.memory < total {
+		total = cgroup.memory
+	}
+	mem := total / 8
+	if cgroup.cpus > 0 {
+		if cpuMem := int64(cgroup.cpus * memoryPerCPU); cpuMem < mem {
+			mem = cpuMem
+		}
+	}
+	return mem
+}
+
 func scale(base BaseLimit, inc BaseLimitIncrease, memory int64, numFD int) BaseLimit {
 	// mebibytesAvailable represents how many MiBs we're allowed to use. Used to
 	// scale the limits. If this is below 128MiB we set it to 0 to just use the
@@ -0,0 +1,213 @@
+package rcmgr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// UpdateLimits replaces the limits of the resource manager with cfg, and emits an
+// EvtResourceLimitsUpdated on the event bus.
+//
+// The new limits are diffed against the limits of the live scopes, and the changed limits are
+// applied while no service, protocol, peer or subnet scopes can be created, so that no scope is left
+// with the old limits. Limits set with SetLimit on individual scopes are overwritten.
+// Connection and stream scopes are not updated; only connections and streams opened after the
+// update use the new limits.
+func (r *resourceManager) UpdateLimits(cfg LimitConfig) error {
+	limits := NewFixedLimiter(cfg)
+	if err := validateSubnetLimits(limits); err != nil {
+		return err
+	}
+
+	type update struct {
+		scope *resourceScope
+		limit Limit
+	}
+	var updates []update
+	addUpdate := func(s *resourceScope, l Limit) {
+		if !limitEqual(s.Limit(), l) {
+			updates = append(updates, update{scope: s, limit: l})
+		}
+	}
+
+	r.mx.Lock()
+	r.limitsMx.Lock()
+	r.limits = limits
+	r.limitsMx.Unlock()
+
+	addUpdate(r.system.resourceScope, r.adaptLimit(limits.GetSystemLimits()))
+	addUpdate(r.transient.resourceScope, r.adaptLimit(limits.GetTransientLimits()))
+	addUpdate(r.allowlistedSystem.resourceScope, limits.GetAllowlistedSystemLimits())
+	addUpdate(r.allowlistedTransient.resourceScope, limits.GetAllowlistedTransientLimits())
+	for svc, s := range r.svc {
+		addUpdate(s.resourceScope, limits.GetServiceLimits(svc))
+		for _, ps := range s.peerScopes() {
+			addUpdate(ps, limits.GetServicePeerLimits(svc))
+		}
+	}
+	for proto, s := range r.proto {
+		addUpdate(s.resourceScope, limits.GetProtocolLimits(proto))
+		for _, ps := range s.peerScopes() {
+			addUpdate(ps, limits.GetProtocolPeerLimits(proto))
+		}
+	}
+	for p, s := range r.peer {
+		addUpdate(s.resourceScope, limits.GetPeerLimits(p))
+	}
+	for _, s := range r.subnet {
+		addUpdate(s.resourceScope, subnetLimit(limits, s.subnet))
+	}
+
+	scopes := make([]string, 0, len(updates))
+	for _, u := range updates {
+		u.scope.SetLimit(u.limit)
+		scopes = append(scopes, u.scope.name)
+	}
+	r.mx.Unlock()
+
+	sort.Strings(scopes)
+	log.Infow("updated limits", "scopes", scopes)
+	r.emitLimitsUpdated(event.EvtResourceLimitsUpdated{Scopes: scopes})
+	return nil
+}
+
+// peerScopes returns the per-peer scopes of the service.
+// Peer scopes created after the limiter was replaced already use the new limits.
+func (s *serviceScope) peerScopes() []*resourceScope {
+	s.Lock()
+	defer s.Unlock()
+
+	result := make([]*resourceScope, 0, len(s.peers))
+	for _, ps := range s.peers {
+		result = append(result, ps)
+	}
+	return result
+}
+
+// peerScopes returns the per-peer scopes of the protocol.
+// Peer scopes created after the limiter was replaced already use the new limits.
+func (s *protocolScope) peerScopes() []*resourceScope {
+	s.Lock()
+	defer s.Unlock()
+
+	result := make([]*resourceScope, 0, len(s.peers))
+	for _, ps := range s.peers {
+		result = append(result, ps)
+	}
+	return result
+}
+
+func limitEqual(a, b Limit) bool {
+	return a.GetMemoryLimit() == b.GetMemoryLimit() &&
+		a.GetStreamLimit(network.DirInbound) == b.GetStreamLimit(network.DirInbound) &&
+		a.GetStreamLimit(network.DirOutbound) == b.GetStreamLimit(network.DirOutbound) &&
+		a.GetStreamTotalLimit() == b.GetStreamTotalLimit() &&
+		a.GetConnLimit(network.DirInbound) == b.GetConnLimit(network.DirInbound) &&
+		a.GetConnLimit(network.DirOutbound) == b.GetConnLimit(network.DirOutbound) &&
+		a.GetConnTotalLimit() == b.GetConnTotalLimit() &&
+		a.GetFDLimit() == b.GetFDLimit()
+}
+
+// DefaultLimitsFileWatchInterval is the interval at which WatchLimitsFile checks the file for changes.
+var DefaultLimitsFileWatchInterval = 10 * time.Second
+
+type limitsFileWatcher struct {
+	path     string
+	defaults LimitConfig
+	updater  LimitUpdater
+
+	modTime time.Time
+	size    int64
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	done      chan struct{}
+}
+
+// WatchLimitsFile watches the JSON limit configuration at path, and applies it to the resource
+// manager every time the file changes. Limits missing from the file are taken from defaults.
+// The file is checked for changes every DefaultLimitsFileWatchInterval. If the new configuration
+// can't be read, an error is logged and the current limits are kept. Replace the file atomically,
+// e.g. by renaming a new file over it, so that partially written files aren't read.
+//
+// The file is expected to contain the limits the resource manager was constructed with; it is only
+// applied once it changes. Close the returned io.Closer to stop watching.
+func WatchLimitsFile(mgr network.ResourceManager, path string, defaults LimitConfig) (io.Closer, error) {
+	updater, ok := mgr.(LimitUpdater)
+	if !ok {
+		return nil, errors.New("resource manager doesn't support updating limits")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &limitsFileWatcher{
+		path:     path,
+		defaults: defaults,
+		updater:  updater,
+		modTime:  fi.ModTime(),
+		size:     fi.Size(),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.background(DefaultLimitsFileWatchInterval)
+	return w, nil
+}
+
+func (w *limitsFileWatcher) background(interval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.check(); err != nil {
+				log.Errorw("failed to update limits from file", "path", w.path, "error", err)
+			}
+		case <-w.closing:
+			return
+		}
+	}
+}
+
+func (w *limitsFileWatcher) check() error {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(w.modTime) && fi.Size() == w.size {
+		return nil
+	}
+	// Only retry once the file changes again, instead of logging the same error on every tick.
+	w.modTime = fi.ModTime()
+	w.size = fi.Size()
+
+	f, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cfg, err := readLimiterConfigFromJSON(f, w.defaults)
+	if err != nil {
+		return fmt.Errorf("failed to parse limits: %w", err)
+	}
+	return w.updater.UpdateLimits(cfg)
+}
+
+func (w *limitsFileWatcher) Close() error {
+	w.closeOnce.Do(func() { close(w.closing) })
+	<-w.done
+	return nil
+}
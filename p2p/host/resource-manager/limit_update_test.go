@@ -0,0 +1,171 @@
+package rcmgr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+
+	"github.com/stretchr/testify/require"
+)
+
+func updateTestLimits(conns int) LimitConfig {
+	limit := BaseLimit{
+		Memory:          1 << 20,
+		Streams:         10,
+		StreamsInbound:  10,
+		StreamsOutbound: 10,
+		Conns:           conns,
+		ConnsInbound:    conns,
+		ConnsOutbound:   conns,
+		FD:              conns,
+	}
+	return LimitConfig{
+		System:               limit,
+		Transient:            limit,
+		AllowlistedSystem:    limit,
+		AllowlistedTransient: limit,
+		ServiceDefault:       limit,
+		ServicePeerDefault:   limit,
+		ProtocolDefault:      limit,
+		ProtocolPeerDefault:  limit,
+		PeerDefault:          limit,
+		Conn:                 limit,
+		Stream:               limit,
+	}
+}
+
+func TestUpdateLimits(t *testing.T) {
+	mgr, err := NewResourceManager(NewFixedLimiter(updateTestLimits(1)))
+	require.NoError(t, err)
+	defer mgr.Close()
+
+	bus := eventbus.NewBus()
+	require.NoError(t, mgr.(event.BusSetter).SetEventBus(bus))
+	sub, err := bus.Subscribe(new(event.EvtResourceLimitsUpdated))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	p := peer.ID("A")
+	conn, err := mgr.OpenConnection(network.DirInbound, true, dummyMA)
+	require.NoError(t, err)
+	defer conn.Done()
+	require.NoError(t, conn.SetPeer(p))
+	_, err = mgr.OpenConnection(network.DirInbound, true, dummyMA)
+	require.Error(t, err)
+
+	stream, err := mgr.OpenStream(p, network.DirInbound)
+	require.NoError(t, err)
+	defer stream.Done()
+	require.NoError(t, stream.SetProtocol("/proto"))
+	require.NoError(t, stream.SetService("svc"))
+
+	cfg := updateTestLimits(1)
+	cfg.System.Conns = 2
+	cfg.System.ConnsInbound = 2
+	cfg.System.FD = 2
+	cfg.Transient.ConnsInbound = 2
+	cfg.ServicePeerDefault.StreamsInbound = 5
+	cfg.PeerDefault.Conns = 2
+	cfg.PeerDefault.ConnsInbound = 2
+	cfg.PeerDefault.FD = 2
+	require.NoError(t, mgr.(LimitUpdater).UpdateLimits(cfg))
+
+	select {
+	case evt := <-sub.Out():
+		require.Equal(t, []string{"peer:" + p.String(), "service:svc.peer:" + p.String(), "system", "transient"},
+			evt.(event.EvtResourceLimitsUpdated).Scopes)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a limits updated event")
+	}
+
+	require.NoError(t, mgr.ViewSystem(func(s network.ResourceScope) error {
+		require.Equal(t, 2, s.(ResourceScopeLimiter).Limit().GetConnLimit(network.DirInbound))
+		return nil
+	}))
+	require.NoError(t, mgr.ViewService("svc", func(s network.ServiceScope) error {
+		require.Equal(t, 10, s.(*serviceScope).Limit().GetStreamLimit(network.DirInbound))
+		require.Equal(t, 5, s.(*serviceScope).getPeerScope(p).Limit().GetStreamLimit(network.DirInbound))
+		return nil
+	}))
+
+	// the raised limits apply to new connections
+	conn2, err := mgr.OpenConnection(network.DirInbound, true, dummyMA)
+	require.NoError(t, err)
+	defer conn2.Done()
+	require.NoError(t, conn2.SetPeer(p))
+
+	// new scopes are created with the new limits
+	require.NoError(t, mgr.ViewPeer(peer.ID("B"), func(s network.PeerScope) error {
+		require.Equal(t, 2, s.(*peerScope).Limit().GetConnLimit(network.DirInbound))
+		return nil
+	}))
+
+	// applying the same limits again doesn't change any scope
+	require.NoError(t, mgr.(LimitUpdater).UpdateLimits(cfg))
+	select {
+	case evt := <-sub.Out():
+		require.Empty(t, evt.(event.EvtResourceLimitsUpdated).Scopes)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a limits updated event")
+	}
+}
+
+func TestWatchLimitsFile(t *testing.T) {
+	defer func(d time.Duration) { DefaultLimitsFileWatchInterval = d }(DefaultLimitsFileWatchInterval)
+	DefaultLimitsFileWatchInterval = 10 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "limits.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"System": {"ConnsInbound": 1}}`), 0o644))
+
+	defaults := updateTestLimits(1)
+	mgr, err := NewResourceManager(NewFixedLimiter(defaults))
+	require.NoError(t, err)
+	defer mgr.Close()
+
+	w, err := WatchLimitsFile(mgr, path, defaults)
+	require.NoError(t, err)
+	defer w.Close()
+
+	systemConnLimit := func() (limit int) {
+		mgr.ViewSystem(func(s network.ResourceScope) error {
+			limit = s.(ResourceScopeLimiter).Limit().GetConnLimit(network.DirInbound)
+			return nil
+		})
+		return limit
+	}
+
+	// an invalid configuration is ignored
+	require.NoError(t, os.WriteFile(path, []byte(`{"System": `), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 1, systemConnLimit())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"System": {"ConnsInbound": 42}}`), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	require.Eventually(t, func() bool { return systemConnLimit() == 42 }, 5*time.Second, 10*time.Millisecond)
+
+	// limits missing from the file are taken from the defaults
+	require.NoError(t, mgr.ViewTransient(func(s network.ResourceScope) error {
+		require.Equal(t, 1, s.(ResourceScopeLimiter).Limit().GetConnLimit(network.DirInbound))
+		return nil
+	}))
+
+	require.NoError(t, w.Close())
+	require.NoError(t, os.WriteFile(path, []byte(`{"System": {"ConnsInbound": 23}}`), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(3*time.Second)))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 42, systemConnLimit())
+}
+
+func TestWatchLimitsFileUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{}`), 0o644))
+	_, err := WatchLimitsFile(network.NullResourceManager, path, LimitConfig{})
+	require.Error(t, err)
+}
@@ -0,0 +1,10 @@
+//go:build go1.19
+
+package rcmgr
+
+import "runtime/debug"
+
+// goMemoryLimit returns the soft memory limit of the Go runtime, math.MaxInt64 if it is not set.
+func goMemoryLimit() int64 {
+	return debug.SetMemoryLimit(-1)
+}
@@ -0,0 +1,10 @@
+//go:build !go1.19
+
+package rcmgr
+
+import "math"
+
+// The Go runtime only has a soft memory limit since Go 1.19.
+func goMemoryLimit() int64 {
+	return math.MaxInt64
+}
@@ -0,0 +1,346 @@
+package obs
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	promNamespace = "libp2p"
+	promSubsystem = "rcmgr"
+
+	// DefaultTopPeers is the default number of peers reported by the PrometheusTraceReporter
+	// for every resource.
+	DefaultTopPeers = 10
+)
+
+var (
+	peerConnsDesc   = prometheus.NewDesc(prometheus.BuildFQName(promNamespace, promSubsystem, "peer_connections"), "Number of peers by the number of connections they have", []string{"dir"}, nil)
+	peerStreamsDesc = prometheus.NewDesc(prometheus.BuildFQName(promNamespace, promSubsystem, "peer_streams"), "Number of peers by the number of streams they have", []string{"dir"}, nil)
+	peerMemoryDesc  = prometheus.NewDesc(prometheus.BuildFQName(promNamespace, promSubsystem, "peer_memory_bytes"), "Number of peers by the amount of memory reserved for them", nil, nil)
+
+	topPeerConnsDesc   = prometheus.NewDesc(prometheus.BuildFQName(promNamespace, promSubsystem, "top_peers_connections"), "Number of connections of the peers with the most connections", []string{"peer", "dir"}, nil)
+	topPeerStreamsDesc = prometheus.NewDesc(prometheus.BuildFQName(promNamespace, promSubsystem, "top_peers_streams"), "Number of streams of the peers with the most streams", []string{"peer", "dir"}, nil)
+	topPeerMemoryDesc  = prometheus.NewDesc(prometheus.BuildFQName(promNamespace, promSubsystem, "top_peers_memory_bytes"), "Amount of memory reserved for the peers with the most memory reserved", []string{"peer"}, nil)
+)
+
+type PrometheusOption func(*PrometheusTraceReporter) error
+
+// WithRegisterer sets the registerer the metrics are registered with.
+// By default, prometheus.DefaultRegisterer is used.
+func WithRegisterer(reg prometheus.Registerer) PrometheusOption {
+	return func(r *PrometheusTraceReporter) error {
+		r.reg = reg
+		return nil
+	}
+}
+
+// WithTopPeers sets the number of peers reported for every resource.
+// The peers using the most connections, streams and memory are reported individually.
+// All peers are reported in aggregate.
+func WithTopPeers(n int) PrometheusOption {
+	return func(r *PrometheusTraceReporter) error {
+		r.topPeers = n
+		return nil
+	}
+}
+
+// PrometheusTraceReporter reports the usage of the resource manager as Prometheus metrics,
+// using its traces.
+//
+// It reports the usage of the system, transient, service and protocol scopes, the usage of
+// the peers that use the most resources, and the distribution of the usage across all peers.
+// It also counts the blocked resource reservations, by the scope whose limit was exceeded,
+// the resource and the direction, and the hits and misses of the allowlist.
+type PrometheusTraceReporter struct {
+	reg      prometheus.Registerer
+	topPeers int
+
+	conns   *prometheus.GaugeVec
+	streams *prometheus.GaugeVec
+	memory  *prometheus.GaugeVec
+	fds     *prometheus.GaugeVec
+	blocked *prometheus.CounterVec
+	// allowlist counts the allowlist checks, by whether the connection was allowlisted
+	allowlist *prometheus.CounterVec
+
+	mx    sync.Mutex
+	peers map[peer.ID]*peerUsage
+}
+
+type peerUsage struct {
+	connsIn, connsOut     int
+	streamsIn, streamsOut int
+	memory                int64
+}
+
+func (u *peerUsage) isZero() bool {
+	return *u == peerUsage{}
+}
+
+var (
+	_ rcmgr.TraceReporter  = &PrometheusTraceReporter{}
+	_ prometheus.Collector = &PrometheusTraceReporter{}
+)
+
+// NewPrometheusTraceReporter creates a new PrometheusTraceReporter, and registers its metrics.
+func NewPrometheusTraceReporter(opts ...PrometheusOption) (*PrometheusTraceReporter, error) {
+	r := &PrometheusTraceReporter{
+		reg:      prometheus.DefaultRegisterer,
+		topPeers: DefaultTopPeers,
+		conns: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: promNamespace,
+			Subsystem: promSubsystem,
+			Name:      "connections",
+			Help:      "Number of connections",
+		}, []string{"dir", "scope"}),
+		streams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: promNamespace,
+			Subsystem: promSubsystem,
+			Name:      "streams",
+			Help:      "Number of streams",
+		}, []string{"dir", "scope", "service", "protocol"}),
+		memory: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: promNamespace,
+			Subsystem: promSubsystem,
+			Name:      "memory_bytes",
+			Help:      "Amount of memory reserved",
+		}, []string{"scope", "service", "protocol"}),
+		fds: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: promNamespace,
+			Subsystem: promSubsystem,
+			Name:      "fds",
+			Help:      "Number of file descriptors reserved",
+		}, []string{"scope"}),
+		blocked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: promNamespace,
+			Subsystem: promSubsystem,
+			Name:      "blocked_resources_total",
+			Help:      "Number of blocked resource reservations",
+		}, []string{"dir", "scope", "resource"}),
+		allowlist: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: promNamespace,
+			Subsystem: promSubsystem,
+			Name:      "allowlist_checks_total",
+			Help:      "Number of allowlist checks of connections that exceeded the limits or were allowlisted",
+		}, []string{"result"}),
+		peers: make(map[peer.ID]*peerUsage),
+	}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.reg.Register(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// scopeLabels returns the scope, service and protocol labels of the scope.
+// It returns false for the scopes that are not reported individually.
+func scopeLabels(name string) (scope, service, protocol string, ok bool) {
+	if rcmgr.IsSystemScope(name) || rcmgr.IsTransientScope(name) {
+		return name, "", "", true
+	}
+	if svc := rcmgr.ParseServiceScopeName(name); svc != "" {
+		return "service", svc, "", true
+	}
+	if proto := rcmgr.ParseProtocolScopeName(name); proto != "" {
+		return "protocol", "", proto, true
+	}
+	return "", "", "", false
+}
+
+func (r *PrometheusTraceReporter) ConsumeEvent(evt rcmgr.TraceEvt) {
+	switch evt.Type {
+	case rcmgr.TraceAddStreamEvt, rcmgr.TraceRemoveStreamEvt:
+		if p := rcmgr.ParsePeerScopeName(evt.Name); p.Validate() == nil {
+			r.updatePeer(p, func(u *peerUsage) { u.streamsIn, u.streamsOut = evt.StreamsIn, evt.StreamsOut })
+		} else if scope, svc, proto, ok := scopeLabels(evt.Name); ok {
+			r.streams.WithLabelValues("inbound", scope, svc, proto).Set(float64(evt.StreamsIn))
+			r.streams.WithLabelValues("outbound", scope, svc, proto).Set(float64(evt.StreamsOut))
+		}
+
+	case rcmgr.TraceAddConnEvt, rcmgr.TraceRemoveConnEvt:
+		if p := rcmgr.ParsePeerScopeName(evt.Name); p.Validate() == nil {
+			r.updatePeer(p, func(u *peerUsage) { u.connsIn, u.connsOut = evt.ConnsIn, evt.ConnsOut })
+		} else if rcmgr.IsSystemScope(evt.Name) || rcmgr.IsTransientScope(evt.Name) {
+			r.conns.WithLabelValues("inbound", evt.Name).Set(float64(evt.ConnsIn))
+			r.conns.WithLabelValues("outbound", evt.Name).Set(float64(evt.ConnsOut))
+			r.fds.WithLabelValues(evt.Name).Set(float64(evt.FD))
+		}
+
+	case rcmgr.TraceReserveMemoryEvt, rcmgr.TraceReleaseMemoryEvt:
+		if p := rcmgr.ParsePeerScopeName(evt.Name); p.Validate() == nil {
+			r.updatePeer(p, func(u *peerUsage) { u.memory = evt.Memory })
+		} else if scope, svc, proto, ok := scopeLabels(evt.Name); ok {
+			r.memory.WithLabelValues(scope, svc, proto).Set(float64(evt.Memory))
+		}
+
+	case rcmgr.TraceDestroyScopeEvt:
+		if p := rcmgr.ParsePeerScopeName(evt.Name); p.Validate() == nil {
+			r.mx.Lock()
+			delete(r.peers, p)
+			r.mx.Unlock()
+		}
+
+	case rcmgr.TraceBlockAddConnEvt, rcmgr.TraceBlockAddStreamEvt, rcmgr.TraceBlockReserveMemoryEvt:
+		var resource string
+		switch evt.Type {
+		case rcmgr.TraceBlockAddConnEvt:
+			resource = "connection"
+		case rcmgr.TraceBlockAddStreamEvt:
+			resource = "stream"
+		default:
+			resource = "memory"
+		}
+		// Only the top scope name. We don't want to get the peer ID here.
+		scopeName := strings.SplitN(evt.Name, ":", 2)[0]
+		// Drop the connection or stream ID.
+		scopeName = strings.SplitN(scopeName, "-", 2)[0]
+
+		// Memory reservations don't have a direction.
+		var dir string
+		if evt.DeltaIn != 0 {
+			dir = "inbound"
+		} else if evt.DeltaOut != 0 {
+			dir = "outbound"
+		}
+		r.blocked.WithLabelValues(dir, scopeName, resource).Inc()
+
+	case rcmgr.TraceAllowlistHitEvt:
+		r.allowlist.WithLabelValues("hit").Inc()
+	case rcmgr.TraceAllowlistMissEvt:
+		r.allowlist.WithLabelValues("miss").Inc()
+	}
+}
+
+func (r *PrometheusTraceReporter) updatePeer(p peer.ID, update func(*peerUsage)) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	u, ok := r.peers[p]
+	if !ok {
+		u = &peerUsage{}
+	}
+	update(u)
+	if u.isZero() {
+		delete(r.peers, p)
+		return
+	}
+	r.peers[p] = u
+}
+
+func (r *PrometheusTraceReporter) Describe(descs chan<- *prometheus.Desc) {
+	r.conns.Describe(descs)
+	r.streams.Describe(descs)
+	r.memory.Describe(descs)
+	r.fds.Describe(descs)
+	r.blocked.Describe(descs)
+	r.allowlist.Describe(descs)
+	descs <- peerConnsDesc
+	descs <- peerStreamsDesc
+	descs <- peerMemoryDesc
+	descs <- topPeerConnsDesc
+	descs <- topPeerStreamsDesc
+	descs <- topPeerMemoryDesc
+}
+
+func (r *PrometheusTraceReporter) Collect(metrics chan<- prometheus.Metric) {
+	r.conns.Collect(metrics)
+	r.streams.Collect(metrics)
+	r.memory.Collect(metrics)
+	r.fds.Collect(metrics)
+	r.blocked.Collect(metrics)
+	r.allowlist.Collect(metrics)
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	connsIn := newHistogram(oneTenThenExpDistribution)
+	connsOut := newHistogram(oneTenThenExpDistribution)
+	streamsIn := newHistogram(oneTenThenExpDistribution)
+	streamsOut := newHistogram(oneTenThenExpDistribution)
+	mem := newHistogram(memDistribution)
+	peers := make([]peer.ID, 0, len(r.peers))
+	for p, u := range r.peers {
+		peers = append(peers, p)
+		connsIn.observe(float64(u.connsIn))
+		connsOut.observe(float64(u.connsOut))
+		streamsIn.observe(float64(u.streamsIn))
+		streamsOut.observe(float64(u.streamsOut))
+		mem.observe(float64(u.memory))
+	}
+	metrics <- connsIn.metric(peerConnsDesc, "inbound")
+	metrics <- connsOut.metric(peerConnsDesc, "outbound")
+	metrics <- streamsIn.metric(peerStreamsDesc, "inbound")
+	metrics <- streamsOut.metric(peerStreamsDesc, "outbound")
+	metrics <- mem.metric(peerMemoryDesc)
+
+	for _, p := range r.top(peers, func(u *peerUsage) int64 { return int64(u.connsIn + u.connsOut) }) {
+		u := r.peers[p]
+		metrics <- prometheus.MustNewConstMetric(topPeerConnsDesc, prometheus.GaugeValue, float64(u.connsIn), p.String(), "inbound")
+		metrics <- prometheus.MustNewConstMetric(topPeerConnsDesc, prometheus.GaugeValue, float64(u.connsOut), p.String(), "outbound")
+	}
+	for _, p := range r.top(peers, func(u *peerUsage) int64 { return int64(u.streamsIn + u.streamsOut) }) {
+		u := r.peers[p]
+		metrics <- prometheus.MustNewConstMetric(topPeerStreamsDesc, prometheus.GaugeValue, float64(u.streamsIn), p.String(), "inbound")
+		metrics <- prometheus.MustNewConstMetric(topPeerStreamsDesc, prometheus.GaugeValue, float64(u.streamsOut), p.String(), "outbound")
+	}
+	for _, p := range r.top(peers, func(u *peerUsage) int64 { return u.memory }) {
+		metrics <- prometheus.MustNewConstMetric(topPeerMemoryDesc, prometheus.GaugeValue, float64(r.peers[p].memory), p.String())
+	}
+}
+
+// top returns the peers with the highest usage, omitting peers that don't use the resource.
+// It must be called with the mutex held.
+func (r *PrometheusTraceReporter) top(peers []peer.ID, usage func(*peerUsage) int64) []peer.ID {
+	sort.Slice(peers, func(i, j int) bool {
+		ui, uj := usage(r.peers[peers[i]]), usage(r.peers[peers[j]])
+		if ui != uj {
+			return ui > uj
+		}
+		return peers[i] < peers[j]
+	})
+	n := 0
+	for n < len(peers) && n < r.topPeers && usage(r.peers[peers[n]]) > 0 {
+		n++
+	}
+	return peers[:n]
+}
+
+// histogram is a histogram of the usage of the peers that use a resource.
+type histogram struct {
+	bounds  []float64
+	buckets map[float64]uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make(map[float64]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	if v == 0 {
+		return
+	}
+	h.count++
+	h.sum += v
+	for _, b := range h.bounds {
+		if v <= b {
+			h.buckets[b]++
+		}
+	}
+}
+
+func (h *histogram) metric(desc *prometheus.Desc, labels ...string) prometheus.Metric {
+	return prometheus.MustNewConstHistogram(desc, h.count, h.sum, h.buckets, labels...)
+}
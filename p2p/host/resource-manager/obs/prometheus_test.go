@@ -0,0 +1,146 @@
+package obs_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/test"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/host/resource-manager/obs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusScopeUsage(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	r, err := obs.NewPrometheusTraceReporter(obs.WithRegisterer(reg))
+	require.NoError(t, err)
+
+	mgr, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(rcmgr.DefaultLimits.AutoScale()), rcmgr.WithTraceReporter(r))
+	require.NoError(t, err)
+	defer mgr.Close()
+
+	connScope, err := mgr.OpenConnection(network.DirInbound, true, nil)
+	require.NoError(t, err)
+	defer connScope.Done()
+	require.NoError(t, connScope.SetPeer(test.RandPeerIDFatal(t)))
+	require.NoError(t, connScope.ReserveMemory(1<<10, network.ReservationPriorityAlways))
+	defer connScope.ReleaseMemory(1 << 10)
+
+	expected := `
+# HELP libp2p_rcmgr_connections Number of connections
+# TYPE libp2p_rcmgr_connections gauge
+libp2p_rcmgr_connections{dir="inbound",scope="system"} 1
+libp2p_rcmgr_connections{dir="inbound",scope="transient"} 0
+libp2p_rcmgr_connections{dir="outbound",scope="system"} 0
+libp2p_rcmgr_connections{dir="outbound",scope="transient"} 0
+# HELP libp2p_rcmgr_fds Number of file descriptors reserved
+# TYPE libp2p_rcmgr_fds gauge
+libp2p_rcmgr_fds{scope="system"} 1
+libp2p_rcmgr_fds{scope="transient"} 0
+# HELP libp2p_rcmgr_memory_bytes Amount of memory reserved
+# TYPE libp2p_rcmgr_memory_bytes gauge
+libp2p_rcmgr_memory_bytes{protocol="",scope="system",service=""} 1024
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"libp2p_rcmgr_connections", "libp2p_rcmgr_fds", "libp2p_rcmgr_memory_bytes"))
+}
+
+func TestPrometheusPeers(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	r, err := obs.NewPrometheusTraceReporter(obs.WithRegisterer(reg), obs.WithTopPeers(2))
+	require.NoError(t, err)
+
+	p1 := test.RandPeerIDFatal(t)
+	p2 := test.RandPeerIDFatal(t)
+	p3 := test.RandPeerIDFatal(t)
+	for i, p := range []string{p1.String(), p2.String(), p3.String()} {
+		r.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceAddStreamEvt, Name: "peer:" + p, DeltaIn: 1, StreamsIn: i + 1, StreamsOut: 2})
+	}
+	// streams of service and protocol peer scopes are not reported per peer
+	r.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceAddStreamEvt, Name: "service:foo.peer:" + p1.String(), DeltaIn: 1, StreamsIn: 100})
+
+	expected := `
+# HELP libp2p_rcmgr_top_peers_streams Number of streams of the peers with the most streams
+# TYPE libp2p_rcmgr_top_peers_streams gauge
+libp2p_rcmgr_top_peers_streams{dir="inbound",peer="` + p2.String() + `"} 2
+libp2p_rcmgr_top_peers_streams{dir="inbound",peer="` + p3.String() + `"} 3
+libp2p_rcmgr_top_peers_streams{dir="outbound",peer="` + p2.String() + `"} 2
+libp2p_rcmgr_top_peers_streams{dir="outbound",peer="` + p3.String() + `"} 2
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "libp2p_rcmgr_top_peers_streams"))
+	require.Equal(t, 2, testutil.CollectAndCount(r, "libp2p_rcmgr_peer_streams"))
+
+	// peers without any streams are not reported
+	r.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceRemoveStreamEvt, Name: "peer:" + p3.String(), DeltaIn: -3, DeltaOut: -2})
+	r.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceDestroyScopeEvt, Name: "peer:" + p2.String()})
+	expected = `
+# HELP libp2p_rcmgr_top_peers_streams Number of streams of the peers with the most streams
+# TYPE libp2p_rcmgr_top_peers_streams gauge
+libp2p_rcmgr_top_peers_streams{dir="inbound",peer="` + p1.String() + `"} 1
+libp2p_rcmgr_top_peers_streams{dir="outbound",peer="` + p1.String() + `"} 2
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "libp2p_rcmgr_top_peers_streams"))
+
+	// only p1 is left in the histograms
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	for _, mf := range mfs {
+		if mf.GetName() != "libp2p_rcmgr_peer_streams" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			require.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+		}
+	}
+}
+
+func TestPrometheusBlockedResources(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	r, err := obs.NewPrometheusTraceReporter(obs.WithRegisterer(reg))
+	require.NoError(t, err)
+
+	p := test.RandPeerIDFatal(t)
+	r.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceBlockAddStreamEvt, Name: "conn-1", DeltaOut: 1})
+	r.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceBlockAddStreamEvt, Name: "conn-2", DeltaOut: 1})
+	r.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceBlockAddConnEvt, Name: "peer:" + p.String(), DeltaIn: 1, Delta: 1})
+	r.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceBlockReserveMemoryEvt, Name: "system", Delta: 1 << 20})
+
+	expected := `
+# HELP libp2p_rcmgr_blocked_resources_total Number of blocked resource reservations
+# TYPE libp2p_rcmgr_blocked_resources_total counter
+libp2p_rcmgr_blocked_resources_total{dir="",resource="memory",scope="system"} 1
+libp2p_rcmgr_blocked_resources_total{dir="inbound",resource="connection",scope="peer"} 1
+libp2p_rcmgr_blocked_resources_total{dir="outbound",resource="stream",scope="conn"} 2
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "libp2p_rcmgr_blocked_resources_total"))
+}
+
+func TestPrometheusAllowlist(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	r, err := obs.NewPrometheusTraceReporter(obs.WithRegisterer(reg))
+	require.NoError(t, err)
+
+	r.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceAllowlistHitEvt})
+	r.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceAllowlistMissEvt})
+	r.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceAllowlistMissEvt})
+
+	expected := `
+# HELP libp2p_rcmgr_allowlist_checks_total Number of allowlist checks of connections that exceeded the limits or were allowlisted
+# TYPE libp2p_rcmgr_allowlist_checks_total counter
+libp2p_rcmgr_allowlist_checks_total{result="hit"} 1
+libp2p_rcmgr_allowlist_checks_total{result="miss"} 2
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "libp2p_rcmgr_allowlist_checks_total"))
+}
+
+func TestPrometheusDuplicateRegistration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	_, err := obs.NewPrometheusTraceReporter(obs.WithRegisterer(reg))
+	require.NoError(t, err)
+	_, err = obs.NewPrometheusTraceReporter(obs.WithRegisterer(reg))
+	require.Error(t, err)
+}
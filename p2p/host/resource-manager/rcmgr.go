@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
@@ -18,12 +19,23 @@ import (
 var log = logging.Logger("rcmgr")
 
 type resourceManager struct {
-	limits Limiter
+	limitsMx sync.RWMutex
+	limits   Limiter
+
+	emitters struct {
+		mx               sync.Mutex
+		evtLimitsUpdated event.Emitter
+		evtLimitExceeded event.Emitter
+	}
+	limitExceeded limitExceededEvents
 
 	trace   *trace
 	metrics *metrics
 
-	allowlist *Allowlist
+	allowlist        *Allowlist
+	allowlistSources []allowlistSource
+
+	adaptive *adaptiveLimits // nil if adaptive limits are disabled
 
 	system    *systemScope
 	transient *transientScope
@@ -40,6 +52,9 @@ type resourceManager struct {
 	proto map[protocol.ID]*protocolScope
 	peer  map[peer.ID]*peerScope
 
+	// subnet scopes, keyed by the subnet in CIDR notation
+	subnet map[string]*subnetScope
+
 	stickyProto map[protocol.ID]struct{}
 	stickyPeer  map[peer.ID]struct{}
 
@@ -102,6 +117,10 @@ type connectionScope struct {
 	rcmgr         *resourceManager
 	peer          *peerScope
 	endpoint      multiaddr.Multiaddr
+
+	// subnets are the scopes of the subnets that the remote IP address belongs to.
+	// They are empty for allowlisted connections.
+	subnets []*resourceScope
 }
 
 var _ network.ConnScope = (*connectionScope)(nil)
@@ -133,7 +152,10 @@ func NewResourceManager(limits Limiter, opts ...Option) (network.ResourceManager
 		svc:       make(map[string]*serviceScope),
 		proto:     make(map[protocol.ID]*protocolScope),
 		peer:      make(map[peer.ID]*peerScope),
+		subnet:    make(map[string]*subnetScope),
 	}
+	r.limitExceeded.max = DefaultLimitExceededEventRate
+	r.limitExceeded.interval = DefaultLimitExceededEventInterval
 
 	for _, opt := range opts {
 		if err := opt(r); err != nil {
@@ -141,6 +163,10 @@ func NewResourceManager(limits Limiter, opts ...Option) (network.ResourceManager
 		}
 	}
 
+	if err := validateSubnetLimits(limits); err != nil {
+		return nil, err
+	}
+
 	if err := r.trace.Start(limits); err != nil {
 		return nil, err
 	}
@@ -157,8 +183,21 @@ func NewResourceManager(limits Limiter, opts ...Option) (network.ResourceManager
 
 	r.cancelCtx, r.cancel = context.WithCancel(context.Background())
 
-	r.wg.Add(1)
+	r.limitExceeded.queue = make(chan event.EvtResourceLimitExceeded, r.limitExceeded.max)
+
+	r.wg.Add(2)
 	go r.background()
+	go r.limitExceededBackground()
+
+	if r.adaptive != nil {
+		r.wg.Add(1)
+		go r.adaptiveBackground()
+	}
+
+	for _, src := range r.allowlistSources {
+		r.wg.Add(1)
+		go r.allowlistSourceBackground(src)
+	}
 
 	return r, nil
 }
@@ -208,13 +247,20 @@ func (r *resourceManager) ViewPeer(p peer.ID, f func(network.PeerScope) error) e
 	return f(s)
 }
 
+func (r *resourceManager) getLimiter() Limiter {
+	r.limitsMx.RLock()
+	defer r.limitsMx.RUnlock()
+
+	return r.limits
+}
+
 func (r *resourceManager) getServiceScope(svc string) *serviceScope {
 	r.mx.Lock()
 	defer r.mx.Unlock()
 
 	s, ok := r.svc[svc]
 	if !ok {
-		s = newServiceScope(svc, r.limits.GetServiceLimits(svc), r)
+		s = newServiceScope(svc, r.getLimiter().GetServiceLimits(svc), r)
 		r.svc[svc] = s
 	}
 
@@ -228,7 +274,7 @@ func (r *resourceManager) getProtocolScope(proto protocol.ID) *protocolScope {
 
 	s, ok := r.proto[proto]
 	if !ok {
-		s = newProtocolScope(proto, r.limits.GetProtocolLimits(proto), r)
+		s = newProtocolScope(proto, r.getLimiter().GetProtocolLimits(proto), r)
 		r.proto[proto] = s
 	}
 
@@ -252,7 +298,7 @@ func (r *resourceManager) getPeerScope(p peer.ID) *peerScope {
 
 	s, ok := r.peer[p]
 	if !ok {
-		s = newPeerScope(p, r.limits.GetPeerLimits(p), r)
+		s = newPeerScope(p, r.getLimiter().GetPeerLimits(p), r)
 		r.peer[p] = s
 	}
 
@@ -288,17 +334,21 @@ func (r *resourceManager) nextStreamId() int64 {
 }
 
 func (r *resourceManager) OpenConnection(dir network.Direction, usefd bool, endpoint multiaddr.Multiaddr) (network.ConnManagementScope, error) {
-	var conn *connectionScope
-	conn = newConnectionScope(dir, usefd, r.limits.GetConnLimits(), r, endpoint)
+	subnets := r.getSubnetScopes(endpoint)
+	conn := newConnectionScope(dir, usefd, r.getLimiter().GetConnLimits(), r, endpoint, subnets)
+	for _, s := range subnets {
+		s.DecRef() // we have the reference in edges
+	}
 
 	err := conn.AddConn(dir, usefd)
 	if err != nil {
 		// Try again if this is an allowlisted connection
 		// Failed to open connection, let's see if this was allowlisted and try again
 		allowed := r.allowlist.Allowed(endpoint)
+		r.trace.AllowlistCheck(allowed)
 		if allowed {
 			conn.Done()
-			conn = newAllowListedConnectionScope(dir, usefd, r.limits.GetConnLimits(), r, endpoint)
+			conn = newAllowListedConnectionScope(dir, usefd, r.getLimiter().GetConnLimits(), r, endpoint)
 			err = conn.AddConn(dir, usefd)
 		}
 	}
@@ -306,6 +356,7 @@ func (r *resourceManager) OpenConnection(dir network.Direction, usefd bool, endp
 	if err != nil {
 		conn.Done()
 		r.metrics.BlockConn(dir, usefd)
+		r.emitLimitExceeded(err, dir, "", "")
 		return nil, err
 	}
 
@@ -315,13 +366,14 @@ func (r *resourceManager) OpenConnection(dir network.Direction, usefd bool, endp
 
 func (r *resourceManager) OpenStream(p peer.ID, dir network.Direction) (network.StreamManagementScope, error) {
 	peer := r.getPeerScope(p)
-	stream := newStreamScope(dir, r.limits.GetStreamLimits(p), peer, r)
+	stream := newStreamScope(dir, r.getLimiter().GetStreamLimits(p), peer, r)
 	peer.DecRef() // we have the reference in edges
 
 	err := stream.AddStream(dir)
 	if err != nil {
 		stream.Done()
 		r.metrics.BlockStream(p, dir)
+		r.emitLimitExceeded(err, dir, p, "")
 		return nil, err
 	}
 
@@ -334,13 +386,24 @@ func (r *resourceManager) Close() error {
 	r.wg.Wait()
 	r.trace.Close()
 
+	r.emitters.mx.Lock()
+	if r.emitters.evtLimitsUpdated != nil {
+		r.emitters.evtLimitsUpdated.Close()
+		r.emitters.evtLimitsUpdated = nil
+	}
+	if r.emitters.evtLimitExceeded != nil {
+		r.emitters.evtLimitExceeded.Close()
+		r.emitters.evtLimitExceeded = nil
+	}
+	r.emitters.mx.Unlock()
+
 	return nil
 }
 
 func (r *resourceManager) background() {
 	defer r.wg.Done()
 
-	// periodically garbage collects unused peer and protocol scopes
+	// periodically garbage collects unused peer and protocol scopes, and expired allowlist entries
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
@@ -348,6 +411,7 @@ func (r *resourceManager) background() {
 		select {
 		case <-ticker.C:
 			r.gc()
+			r.allowlist.removeExpired()
 		case <-r.cancelCtx.Done():
 			return
 		}
@@ -383,6 +447,13 @@ func (r *resourceManager) gc() {
 		}
 	}
 
+	for subnet, s := range r.subnet {
+		if s.IsUnused() {
+			s.Done()
+			delete(r.subnet, subnet)
+		}
+	}
+
 	for _, s := range r.svc {
 		s.Lock()
 		for _, p := range deadPeers {
@@ -409,64 +480,87 @@ func (r *resourceManager) gc() {
 }
 
 func newSystemScope(limit Limit, rcmgr *resourceManager, name string) *systemScope {
-	return &systemScope{
+	s := &systemScope{
 		resourceScope: newResourceScope(limit, nil, name, rcmgr.trace, rcmgr.metrics),
 	}
+	s.onLimitExceeded = func(err error) {
+		rcmgr.emitLimitExceeded(err, network.DirUnknown, "", "")
+	}
+	return s
 }
 
 func newTransientScope(limit Limit, rcmgr *resourceManager, name string, systemScope *resourceScope) *transientScope {
-	return &transientScope{
+	s := &transientScope{
 		resourceScope: newResourceScope(limit,
 			[]*resourceScope{systemScope},
 			name, rcmgr.trace, rcmgr.metrics),
 		system: rcmgr.system,
 	}
+	s.onLimitExceeded = func(err error) {
+		rcmgr.emitLimitExceeded(err, network.DirUnknown, "", "")
+	}
+	return s
 }
 
 func newServiceScope(service string, limit Limit, rcmgr *resourceManager) *serviceScope {
-	return &serviceScope{
+	s := &serviceScope{
 		resourceScope: newResourceScope(limit,
 			[]*resourceScope{rcmgr.system.resourceScope},
 			fmt.Sprintf("service:%s", service), rcmgr.trace, rcmgr.metrics),
 		service: service,
 		rcmgr:   rcmgr,
 	}
+	s.onLimitExceeded = func(err error) {
+		rcmgr.emitLimitExceeded(err, network.DirUnknown, "", "")
+	}
+	return s
 }
 
 func newProtocolScope(proto protocol.ID, limit Limit, rcmgr *resourceManager) *protocolScope {
-	return &protocolScope{
+	s := &protocolScope{
 		resourceScope: newResourceScope(limit,
 			[]*resourceScope{rcmgr.system.resourceScope},
 			fmt.Sprintf("protocol:%s", proto), rcmgr.trace, rcmgr.metrics),
 		proto: proto,
 		rcmgr: rcmgr,
 	}
+	s.onLimitExceeded = func(err error) {
+		rcmgr.emitLimitExceeded(err, network.DirUnknown, "", proto)
+	}
+	return s
 }
 
 func newPeerScope(p peer.ID, limit Limit, rcmgr *resourceManager) *peerScope {
-	return &peerScope{
+	s := &peerScope{
 		resourceScope: newResourceScope(limit,
 			[]*resourceScope{rcmgr.system.resourceScope},
 			peerScopeName(p), rcmgr.trace, rcmgr.metrics),
 		peer:  p,
 		rcmgr: rcmgr,
 	}
+	s.onLimitExceeded = func(err error) {
+		rcmgr.emitLimitExceeded(err, network.DirUnknown, p, "")
+	}
+	return s
 }
 
-func newConnectionScope(dir network.Direction, usefd bool, limit Limit, rcmgr *resourceManager, endpoint multiaddr.Multiaddr) *connectionScope {
-	return &connectionScope{
-		resourceScope: newResourceScope(limit,
-			[]*resourceScope{rcmgr.transient.resourceScope, rcmgr.system.resourceScope},
+func newConnectionScope(dir network.Direction, usefd bool, limit Limit, rcmgr *resourceManager, endpoint multiaddr.Multiaddr, subnets []*resourceScope) *connectionScope {
+	edges := append([]*resourceScope{rcmgr.transient.resourceScope, rcmgr.system.resourceScope}, subnets...)
+	s := &connectionScope{
+		resourceScope: newResourceScope(limit, edges,
 			connScopeName(rcmgr.nextConnId()), rcmgr.trace, rcmgr.metrics),
 		dir:      dir,
 		usefd:    usefd,
 		rcmgr:    rcmgr,
 		endpoint: endpoint,
+		subnets:  subnets,
 	}
+	s.onLimitExceeded = s.memoryLimitExceeded
+	return s
 }
 
 func newAllowListedConnectionScope(dir network.Direction, usefd bool, limit Limit, rcmgr *resourceManager, endpoint multiaddr.Multiaddr) *connectionScope {
-	return &connectionScope{
+	s := &connectionScope{
 		resourceScope: newResourceScope(limit,
 			[]*resourceScope{rcmgr.allowlistedTransient.resourceScope, rcmgr.allowlistedSystem.resourceScope},
 			connScopeName(rcmgr.nextConnId()), rcmgr.trace, rcmgr.metrics),
@@ -476,10 +570,12 @@ func newAllowListedConnectionScope(dir network.Direction, usefd bool, limit Limi
 		endpoint:      endpoint,
 		isAllowlisted: true,
 	}
+	s.onLimitExceeded = s.memoryLimitExceeded
+	return s
 }
 
 func newStreamScope(dir network.Direction, limit Limit, peer *peerScope, rcmgr *resourceManager) *streamScope {
-	return &streamScope{
+	s := &streamScope{
 		resourceScope: newResourceScope(limit,
 			[]*resourceScope{peer.resourceScope, rcmgr.transient.resourceScope, rcmgr.system.resourceScope},
 			streamScopeName(rcmgr.nextStreamId()), rcmgr.trace, rcmgr.metrics),
@@ -487,6 +583,26 @@ func newStreamScope(dir network.Direction, limit Limit, peer *peerScope, rcmgr *
 		rcmgr: peer.rcmgr,
 		peer:  peer,
 	}
+	s.onLimitExceeded = s.memoryLimitExceeded
+	return s
+}
+
+// memoryLimitExceeded is called with the scope's lock held.
+func (s *connectionScope) memoryLimitExceeded(err error) {
+	var p peer.ID
+	if s.peer != nil {
+		p = s.peer.peer
+	}
+	s.rcmgr.emitLimitExceeded(err, network.DirUnknown, p, "")
+}
+
+// memoryLimitExceeded is called with the scope's lock held.
+func (s *streamScope) memoryLimitExceeded(err error) {
+	var proto protocol.ID
+	if s.proto != nil {
+		proto = s.proto.proto
+	}
+	s.rcmgr.emitLimitExceeded(err, network.DirUnknown, s.peer.peer, proto)
 }
 
 func IsSystemScope(name string) bool {
@@ -583,7 +699,7 @@ func (s *serviceScope) getPeerScope(p peer.ID) *resourceScope {
 		return ps
 	}
 
-	l := s.rcmgr.limits.GetServicePeerLimits(s.service)
+	l := s.rcmgr.getLimiter().GetServicePeerLimits(s.service)
 
 	if s.peers == nil {
 		s.peers = make(map[peer.ID]*resourceScope)
@@ -610,7 +726,7 @@ func (s *protocolScope) getPeerScope(p peer.ID) *resourceScope {
 		return ps
 	}
 
-	l := s.rcmgr.limits.GetProtocolPeerLimits(s.proto)
+	l := s.rcmgr.getLimiter().GetProtocolPeerLimits(s.proto)
 
 	if s.peers == nil {
 		s.peers = make(map[peer.ID]*resourceScope)
@@ -674,11 +790,30 @@ func (s *connectionScope) transferAllowedToStandard() (err error) {
 	}
 	transientScope.IncRef()
 
-	// Update edges
-	s.edges = []*resourceScope{
-		systemScope,
-		transientScope,
+	defer func() {
+		if err != nil {
+			transientScope.ReleaseForChild(stat)
+			transientScope.DecRef()
+		}
+	}()
+
+	// The connection now counts against the limits of its subnets.
+	subnets := s.rcmgr.getSubnetScopes(s.endpoint)
+	for i, subnet := range subnets {
+		if err := subnet.ReserveForChild(stat); err != nil {
+			for _, reserved := range subnets[:i] {
+				reserved.ReleaseForChild(stat)
+			}
+			for _, subnet := range subnets {
+				subnet.DecRef()
+			}
+			return err
+		}
 	}
+
+	// Update edges
+	s.subnets = subnets
+	s.edges = append([]*resourceScope{systemScope, transientScope}, subnets...)
 	return nil
 }
 
@@ -697,7 +832,9 @@ func (s *connectionScope) SetPeer(p peer.ID) error {
 		system = s.rcmgr.allowlistedSystem
 		transient = s.rcmgr.allowlistedTransient
 
-		if !s.rcmgr.allowlist.AllowedPeerAndMultiaddr(p, s.endpoint) {
+		allowed := s.rcmgr.allowlist.AllowedPeerAndMultiaddr(p, s.endpoint)
+		s.rcmgr.trace.AllowlistCheck(allowed)
+		if !allowed {
 			s.isAllowlisted = false
 
 			// This is not an allowed peer + multiaddr combination. We need to
@@ -708,6 +845,7 @@ func (s *connectionScope) SetPeer(p peer.ID) error {
 			// was _almost_ an allowlisted connection.
 			if err := s.transferAllowedToStandard(); err != nil {
 				// Failed to transfer this connection to the standard scopes
+				s.rcmgr.emitLimitExceeded(err, s.dir, p, "")
 				return err
 			}
 
@@ -725,6 +863,7 @@ func (s *connectionScope) SetPeer(p peer.ID) error {
 		s.peer.DecRef()
 		s.peer = nil
 		s.rcmgr.metrics.BlockPeer(p)
+		s.rcmgr.emitLimitExceeded(err, s.dir, p, "")
 		return err
 	}
 
@@ -736,6 +875,7 @@ func (s *connectionScope) SetPeer(p peer.ID) error {
 		s.peer.resourceScope,
 		system.resourceScope,
 	}
+	edges = append(edges, s.subnets...)
 	s.resourceScope.edges = edges
 
 	s.rcmgr.metrics.AllowPeer(p)
@@ -770,6 +910,7 @@ func (s *streamScope) SetProtocol(proto protocol.ID) error {
 		s.proto.DecRef()
 		s.proto = nil
 		s.rcmgr.metrics.BlockProtocol(proto)
+		s.rcmgr.emitLimitExceeded(err, s.dir, s.peer.peer, proto)
 		return err
 	}
 
@@ -781,6 +922,7 @@ func (s *streamScope) SetProtocol(proto protocol.ID) error {
 		s.peerProtoScope.DecRef()
 		s.peerProtoScope = nil
 		s.rcmgr.metrics.BlockProtocolPeer(proto, s.peer.peer)
+		s.rcmgr.emitLimitExceeded(err, s.dir, s.peer.peer, proto)
 		return err
 	}
 
@@ -831,6 +973,7 @@ func (s *streamScope) SetService(svc string) error {
 		s.svc.DecRef()
 		s.svc = nil
 		s.rcmgr.metrics.BlockService(svc)
+		s.rcmgr.emitLimitExceeded(err, s.dir, s.peer.peer, s.proto.proto)
 		return err
 	}
 
@@ -843,6 +986,7 @@ func (s *streamScope) SetService(svc string) error {
 		s.peerSvcScope.DecRef()
 		s.peerSvcScope = nil
 		s.rcmgr.metrics.BlockServicePeer(svc, s.peer.peer)
+		s.rcmgr.emitLimitExceeded(err, s.dir, s.peer.peer, s.proto.proto)
 		return err
 	}
 
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/network"
 )
 
@@ -44,6 +45,10 @@ type resourceScope struct {
 	name    string   // for debugging purposes
 	trace   *trace   // debug tracing
 	metrics *metrics // metrics collection
+
+	// onLimitExceeded, if set, is called when a memory reservation in this scope is blocked by the
+	// limit of the scope or one of its edges. It is called with the scope's lock held.
+	onLimitExceeded func(err error)
 }
 
 var _ network.ResourceScope = (*resourceScope)(nil)
@@ -175,6 +180,7 @@ func (rc *resources) addStreams(incount, outcount int) error {
 				current:   rc.nstreamsIn,
 				attempted: incount,
 				limit:     limit,
+				resource:  event.LimitedResourceStreams,
 				err:       fmt.Errorf("cannot reserve inbound stream: %w", network.ErrResourceLimitExceeded),
 			}
 		}
@@ -186,6 +192,7 @@ func (rc *resources) addStreams(incount, outcount int) error {
 				current:   rc.nstreamsOut,
 				attempted: outcount,
 				limit:     limit,
+				resource:  event.LimitedResourceStreams,
 				err:       fmt.Errorf("cannot reserve outbound stream: %w", network.ErrResourceLimitExceeded),
 			}
 		}
@@ -196,6 +203,7 @@ func (rc *resources) addStreams(incount, outcount int) error {
 			current:   rc.nstreamsIn + rc.nstreamsOut,
 			attempted: incount + outcount,
 			limit:     limit,
+			resource:  event.LimitedResourceStreams,
 			err:       fmt.Errorf("cannot reserve stream: %w", network.ErrResourceLimitExceeded),
 		}
 	}
@@ -248,6 +256,7 @@ func (rc *resources) addConns(incount, outcount, fdcount int) error {
 				current:   rc.nconnsIn,
 				attempted: incount,
 				limit:     limit,
+				resource:  event.LimitedResourceConns,
 				err:       fmt.Errorf("cannot reserve inbound connection: %w", network.ErrResourceLimitExceeded),
 			}
 		}
@@ -259,6 +268,7 @@ func (rc *resources) addConns(incount, outcount, fdcount int) error {
 				current:   rc.nconnsOut,
 				attempted: outcount,
 				limit:     limit,
+				resource:  event.LimitedResourceConns,
 				err:       fmt.Errorf("cannot reserve outbound connection: %w", network.ErrResourceLimitExceeded),
 			}
 		}
@@ -269,6 +279,7 @@ func (rc *resources) addConns(incount, outcount, fdcount int) error {
 			current:   rc.nconnsIn + rc.nconnsOut,
 			attempted: incount + outcount,
 			limit:     connLimit,
+			resource:  event.LimitedResourceConns,
 			err:       fmt.Errorf("cannot reserve connection: %w", network.ErrResourceLimitExceeded),
 		}
 	}
@@ -279,6 +290,7 @@ func (rc *resources) addConns(incount, outcount, fdcount int) error {
 				current:   rc.nfd,
 				attempted: fdcount,
 				limit:     limit,
+				resource:  event.LimitedResourceFD,
 				err:       fmt.Errorf("cannot reserve file descriptor: %w", network.ErrResourceLimitExceeded),
 			}
 		}
@@ -348,14 +360,20 @@ func (s *resourceScope) ReserveMemory(size int, prio uint8) error {
 
 	if err := s.rc.reserveMemory(int64(size), prio); err != nil {
 		log.Debugw("blocked memory reservation", logValuesMemoryLimit(s.name, "", s.rc.stat(), err)...)
+		setBlockingScope(err, s.name)
 		s.trace.BlockReserveMemory(s.name, prio, int64(size), s.rc.memory)
 		s.metrics.BlockMemory(size)
+		s.limitExceeded(err)
 		return s.wrapError(err)
 	}
 
 	if err := s.reserveMemoryForEdges(size, prio); err != nil {
 		s.rc.releaseMemory(int64(size))
 		s.metrics.BlockMemory(size)
+		if s.owner == nil {
+			// span scopes reserve the memory in their owner, which already reported it
+			s.limitExceeded(err)
+		}
 		return s.wrapError(err)
 	}
 
@@ -364,6 +382,20 @@ func (s *resourceScope) ReserveMemory(size int, prio uint8) error {
 	return nil
 }
 
+// limitExceeded must be called with the scope's lock held.
+func (s *resourceScope) limitExceeded(err error) {
+	if s.owner != nil {
+		// span scopes report to the scope that owns the span tree
+		s.owner.Lock()
+		defer s.owner.Unlock()
+		s.owner.limitExceeded(err)
+		return
+	}
+	if s.onLimitExceeded != nil {
+		s.onLimitExceeded(err)
+	}
+}
+
 func (s *resourceScope) reserveMemoryForEdges(size int, prio uint8) error {
 	if s.owner != nil {
 		return s.owner.ReserveMemory(size, prio)
@@ -412,6 +444,7 @@ func (s *resourceScope) ReserveMemoryForChild(size int64, prio uint8) (network.S
 	}
 
 	if err := s.rc.reserveMemory(size, prio); err != nil {
+		setBlockingScope(err, s.name)
 		s.trace.BlockReserveMemory(s.name, prio, size, s.rc.memory)
 		return s.rc.stat(), s.wrapError(err)
 	}
@@ -455,6 +488,7 @@ func (s *resourceScope) AddStream(dir network.Direction) error {
 
 	if err := s.rc.addStream(dir); err != nil {
 		log.Debugw("blocked stream", logValuesStreamLimit(s.name, "", dir, s.rc.stat(), err)...)
+		setBlockingScope(err, s.name)
 		s.trace.BlockAddStream(s.name, dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
 		return s.wrapError(err)
 	}
@@ -503,6 +537,7 @@ func (s *resourceScope) AddStreamForChild(dir network.Direction) (network.ScopeS
 	}
 
 	if err := s.rc.addStream(dir); err != nil {
+		setBlockingScope(err, s.name)
 		s.trace.BlockAddStream(s.name, dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
 		return s.rc.stat(), s.wrapError(err)
 	}
@@ -557,6 +592,7 @@ func (s *resourceScope) AddConn(dir network.Direction, usefd bool) error {
 
 	if err := s.rc.addConn(dir, usefd); err != nil {
 		log.Debugw("blocked connection", logValuesConnLimit(s.name, "", dir, usefd, s.rc.stat(), err)...)
+		setBlockingScope(err, s.name)
 		s.trace.BlockAddConn(s.name, dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
 		return s.wrapError(err)
 	}
@@ -605,6 +641,7 @@ func (s *resourceScope) AddConnForChild(dir network.Direction, usefd bool) (netw
 	}
 
 	if err := s.rc.addConn(dir, usefd); err != nil {
+		setBlockingScope(err, s.name)
 		s.trace.BlockAddConn(s.name, dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
 		return s.rc.stat(), s.wrapError(err)
 	}
@@ -657,17 +694,20 @@ func (s *resourceScope) ReserveForChild(st network.ScopeStat) error {
 	}
 
 	if err := s.rc.reserveMemory(st.Memory, network.ReservationPriorityAlways); err != nil {
+		setBlockingScope(err, s.name)
 		s.trace.BlockReserveMemory(s.name, 255, st.Memory, s.rc.memory)
 		return s.wrapError(err)
 	}
 
 	if err := s.rc.addStreams(st.NumStreamsInbound, st.NumStreamsOutbound); err != nil {
+		setBlockingScope(err, s.name)
 		s.trace.BlockAddStreams(s.name, st.NumStreamsInbound, st.NumStreamsOutbound, s.rc.nstreamsIn, s.rc.nstreamsOut)
 		s.rc.releaseMemory(st.Memory)
 		return s.wrapError(err)
 	}
 
 	if err := s.rc.addConns(st.NumConnsInbound, st.NumConnsOutbound, st.NumFD); err != nil {
+		setBlockingScope(err, s.name)
 		s.trace.BlockAddConns(s.name, st.NumConnsInbound, st.NumConnsOutbound, st.NumFD, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
 
 		s.rc.releaseMemory(st.Memory)
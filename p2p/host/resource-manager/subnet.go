@@ -0,0 +1,167 @@
+package rcmgr
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// SubnetLimit limits the connections from the IP addresses that share a prefix.
+// Each subnet is tracked in its own scope, which is named after the subnet, e.g. subnet:1.2.3.0/24.
+// A limit of 0 means that the respective connections are not limited.
+type SubnetLimit struct {
+	// PrefixLength is the length of the shared prefix in bits.
+	// Use 32 for IPv4, or 128 for IPv6, to limit the connections per IP address.
+	PrefixLength int
+
+	Conns         int `json:",omitempty"`
+	ConnsInbound  int `json:",omitempty"`
+	ConnsOutbound int `json:",omitempty"`
+}
+
+func (l *SubnetLimit) baseLimit() BaseLimit {
+	limit := infiniteBaseLimit
+	if l.Conns > 0 {
+		limit.Conns = l.Conns
+	}
+	if l.ConnsInbound > 0 {
+		limit.ConnsInbound = l.ConnsInbound
+	}
+	if l.ConnsOutbound > 0 {
+		limit.ConnsOutbound = l.ConnsOutbound
+	}
+	return limit
+}
+
+type subnetScope struct {
+	*resourceScope
+
+	subnet *net.IPNet
+}
+
+func newSubnetScope(subnet *net.IPNet, limit Limit, rcmgr *resourceManager) *subnetScope {
+	return &subnetScope{
+		resourceScope: newResourceScope(limit, nil, subnetScopeName(subnet), rcmgr.trace, rcmgr.metrics),
+		subnet:        subnet,
+	}
+}
+
+func subnetScopeName(subnet *net.IPNet) string {
+	return "subnet:" + subnet.String()
+}
+
+func IsSubnetScope(name string) bool {
+	return strings.HasPrefix(name, "subnet:")
+}
+
+// ParseSubnetScopeName returns the subnet of a subnet scope name.
+// Returns nil if the name is not a valid subnet scope name.
+func ParseSubnetScopeName(name string) *net.IPNet {
+	if !IsSubnetScope(name) {
+		return nil
+	}
+	_, subnet, err := net.ParseCIDR(name[len("subnet:"):])
+	if err != nil {
+		return nil
+	}
+	return subnet
+}
+
+func checkSubnetLimits(limits []SubnetLimit, bits int) error {
+	seen := make(map[int]struct{}, len(limits))
+	for _, l := range limits {
+		if l.PrefixLength < 0 || l.PrefixLength > bits {
+			return fmt.Errorf("invalid subnet prefix length: %d", l.PrefixLength)
+		}
+		if _, ok := seen[l.PrefixLength]; ok {
+			return fmt.Errorf("duplicate subnet prefix length: %d", l.PrefixLength)
+		}
+		seen[l.PrefixLength] = struct{}{}
+	}
+	return nil
+}
+
+func validateSubnetLimits(limits Limiter) error {
+	sl, ok := limits.(SubnetLimiter)
+	if !ok {
+		return nil
+	}
+	if err := checkSubnetLimits(sl.GetIPv4SubnetLimits(), 32); err != nil {
+		return fmt.Errorf("invalid IPv4 subnet limits: %w", err)
+	}
+	if err := checkSubnetLimits(sl.GetIPv6SubnetLimits(), 128); err != nil {
+		return fmt.Errorf("invalid IPv6 subnet limits: %w", err)
+	}
+	return nil
+}
+
+// subnetLimits returns the subnet limits that apply to ip.
+// The returned IP address has the length that corresponds to its IP version.
+func subnetLimits(limits Limiter, ip net.IP) (net.IP, []SubnetLimit, int) {
+	sl, ok := limits.(SubnetLimiter)
+	if !ok {
+		return ip, nil, 0
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, sl.GetIPv4SubnetLimits(), 32
+	}
+	return ip, sl.GetIPv6SubnetLimits(), 128
+}
+
+// getSubnetScopes returns the scopes of the subnets that a connection from endpoint belongs to.
+// The caller owns a reference to each of the returned scopes.
+func (r *resourceManager) getSubnetScopes(endpoint multiaddr.Multiaddr) []*resourceScope {
+	if endpoint == nil {
+		return nil
+	}
+	// Relayed connections don't come from the IP address of the relay.
+	if _, err := endpoint.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+		return nil
+	}
+	ip, err := manet.ToIP(endpoint)
+	if err != nil {
+		return nil
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	ip, limits, bits := subnetLimits(r.getLimiter(), ip)
+	if len(limits) == 0 {
+		return nil
+	}
+
+	scopes := make([]*resourceScope, 0, len(limits))
+	for _, l := range limits {
+		mask := net.CIDRMask(l.PrefixLength, bits)
+		subnet := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		key := subnet.String()
+		s, ok := r.subnet[key]
+		if !ok {
+			limit := l.baseLimit()
+			s = newSubnetScope(subnet, &limit, r)
+			r.subnet[key] = s
+		}
+		s.IncRef()
+		scopes = append(scopes, s.resourceScope)
+	}
+	return scopes
+}
+
+// subnetLimit returns the limit of a subnet scope.
+// Scopes of subnets that are not limited anymore get an infinite limit, until they're garbage collected.
+func subnetLimit(limits Limiter, subnet *net.IPNet) Limit {
+	ones, _ := subnet.Mask.Size()
+	_, subnetLimits, _ := subnetLimits(limits, subnet.IP)
+	for _, l := range subnetLimits {
+		if l.PrefixLength == ones {
+			limit := l.baseLimit()
+			return &limit
+		}
+	}
+	limit := infiniteBaseLimit
+	return &limit
+}
@@ -0,0 +1,169 @@
+package rcmgr
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/test"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func newSubnetTestManager(t *testing.T, opts ...Option) network.ResourceManager {
+	limits := InfiniteLimits
+	limits.IPv4Subnet = []SubnetLimit{
+		{PrefixLength: 32, ConnsInbound: 2},
+		{PrefixLength: 24, ConnsInbound: 3},
+	}
+	limits.IPv6Subnet = []SubnetLimit{
+		{PrefixLength: 128, Conns: 1},
+		{PrefixLength: 56, Conns: 2},
+	}
+	mgr, err := NewResourceManager(NewFixedLimiter(limits), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { mgr.Close() })
+	return mgr
+}
+
+func TestSubnetLimits(t *testing.T) {
+	mgr := newSubnetTestManager(t)
+
+	open := func(addr string) (network.ConnManagementScope, error) {
+		return mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast(addr))
+	}
+
+	c1, err := open("/ip4/1.2.3.4/tcp/1234")
+	require.NoError(t, err)
+	c2, err := open("/ip4/1.2.3.4/udp/1234/quic")
+	require.NoError(t, err)
+	// per IP address
+	_, err = open("/ip4/1.2.3.4/tcp/1235")
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+	// per subnet
+	c3, err := open("/ip4/1.2.3.5/tcp/1234")
+	require.NoError(t, err)
+	_, err = open("/ip4/1.2.3.6/tcp/1234")
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+	// outbound connections are not limited
+	c4, err := mgr.OpenConnection(network.DirOutbound, true, multiaddr.StringCast("/ip4/1.2.3.4/tcp/1234"))
+	require.NoError(t, err)
+	c4.Done()
+	// other subnets are not affected
+	c5, err := open("/ip4/1.2.4.4/tcp/1234")
+	require.NoError(t, err)
+	c5.Done()
+
+	// the connections still count against their subnets once they're attached to a peer
+	require.NoError(t, c1.SetPeer(test.RandPeerIDFatal(t)))
+	_, err = open("/ip4/1.2.3.7/tcp/1234")
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+
+	c1.Done()
+	c4, err = open("/ip4/1.2.3.4/tcp/1235")
+	require.NoError(t, err)
+	c2.Done()
+	c3.Done()
+	c4.Done()
+
+	// IPv6
+	c6, err := open("/ip6/2001:db8::1/tcp/1234")
+	require.NoError(t, err)
+	_, err = mgr.OpenConnection(network.DirOutbound, true, multiaddr.StringCast("/ip6/2001:db8::1/tcp/1234"))
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+	c7, err := open("/ip6/2001:db8:0:ff::1/tcp/1234")
+	require.NoError(t, err)
+	_, err = open("/ip6/2001:db8::2/tcp/1234")
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+	c6.Done()
+	c7.Done()
+
+	// relayed connections don't come from the relay's IP address
+	for i := 0; i < 3; i++ {
+		c, err := open("/ip4/1.2.3.4/tcp/1234/p2p/" + test.RandPeerIDFatal(t).String() + "/p2p-circuit")
+		require.NoError(t, err)
+		defer c.Done()
+	}
+}
+
+func TestSubnetLimitsWithAllowlist(t *testing.T) {
+	peerA := test.RandPeerIDFatal(t)
+	mgr := newSubnetTestManager(t, WithAllowlistedMultiaddrs([]multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/1.2.3.4"),
+		multiaddr.StringCast("/ip4/4.3.2.1/p2p/" + peerA.String()),
+	}))
+
+	open := func(addr string) network.ConnManagementScope {
+		c, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast(addr))
+		require.NoError(t, err)
+		return c
+	}
+
+	// allowlisted IP addresses are not limited per IP address
+	for i := 0; i < 5; i++ {
+		c := open("/ip4/1.2.3.4/tcp/1234")
+		require.NoError(t, c.SetPeer(test.RandPeerIDFatal(t)))
+		defer c.Done()
+	}
+
+	// allowlisted connections that turn out to be from another peer count against their subnet
+	for i := 0; i < 2; i++ {
+		c := open("/ip4/4.3.2.1/tcp/1234")
+		require.NoError(t, c.SetPeer(test.RandPeerIDFatal(t)))
+		defer c.Done()
+	}
+	c := open("/ip4/4.3.2.1/tcp/1234")
+	require.ErrorIs(t, c.SetPeer(test.RandPeerIDFatal(t)), network.ErrResourceLimitExceeded)
+	c.Done()
+	c = open("/ip4/4.3.2.1/tcp/1234")
+	require.NoError(t, c.SetPeer(peerA))
+	defer c.Done()
+}
+
+func TestSubnetLimitsUpdate(t *testing.T) {
+	mgr := newSubnetTestManager(t)
+
+	c, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.4/tcp/1234"))
+	require.NoError(t, err)
+	defer c.Done()
+
+	limits := InfiniteLimits
+	limits.IPv4Subnet = []SubnetLimit{{PrefixLength: 32, ConnsInbound: 1}}
+	require.NoError(t, mgr.(LimitUpdater).UpdateLimits(limits))
+
+	_, err = mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.4/tcp/1234"))
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+	for i := 0; i < 5; i++ {
+		c, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.5/tcp/1234"))
+		require.NoError(t, err)
+		c.Done()
+	}
+
+	limits.IPv4Subnet = []SubnetLimit{{PrefixLength: 33}}
+	require.Error(t, mgr.(LimitUpdater).UpdateLimits(limits))
+}
+
+func TestSubnetLimitsValidation(t *testing.T) {
+	for _, subnets := range [][]SubnetLimit{
+		{{PrefixLength: -1}},
+		{{PrefixLength: 33}},
+		{{PrefixLength: 24}, {PrefixLength: 24}},
+	} {
+		limits := InfiniteLimits
+		limits.IPv4Subnet = subnets
+		_, err := NewResourceManager(NewFixedLimiter(limits))
+		require.Error(t, err)
+	}
+
+	limits := InfiniteLimits
+	limits.IPv6Subnet = []SubnetLimit{{PrefixLength: 129}}
+	_, err := NewResourceManager(NewFixedLimiter(limits))
+	require.Error(t, err)
+}
+
+func TestParseSubnetScopeName(t *testing.T) {
+	require.Equal(t, "1.2.3.0/24", ParseSubnetScopeName("subnet:1.2.3.0/24").String())
+	require.Equal(t, "2001:db8::/56", ParseSubnetScopeName("subnet:2001:db8::/56").String())
+	require.Nil(t, ParseSubnetScopeName("peer:1.2.3.0/24"))
+	require.Nil(t, ParseSubnetScopeName("subnet:foo"))
+}
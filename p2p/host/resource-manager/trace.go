@@ -69,6 +69,8 @@ const (
 	TraceAddConnEvt            TraceEvtTyp = "add_conn"
 	TraceBlockAddConnEvt       TraceEvtTyp = "block_add_conn"
 	TraceRemoveConnEvt         TraceEvtTyp = "remove_conn"
+	TraceAllowlistHitEvt       TraceEvtTyp = "allowlist_hit"
+	TraceAllowlistMissEvt      TraceEvtTyp = "allowlist_miss"
 )
 
 type scopeClass struct {
@@ -181,6 +183,19 @@ func (s scopeClass) MarshalJSON() ([]byte, error) {
 		}
 	}
 
+	// Subnet scope
+	if IsSubnetScope(name) {
+		return json.Marshal(struct {
+			Class  string
+			Subnet string
+			Span   string `json:",omitempty"`
+		}{
+			Class:  "subnet",
+			Subnet: name[7:],
+			Span:   span,
+		})
+	}
+
 	return nil, fmt.Errorf("unrecognized scope: %s", name)
 }
 
@@ -696,3 +711,15 @@ func (t *trace) RemoveConns(scope string, deltaIn, deltaOut, deltafd, nconnsIn,
 		FD:       nfd,
 	})
 }
+
+func (t *trace) AllowlistCheck(allowed bool) {
+	if t == nil {
+		return
+	}
+
+	typ := TraceAllowlistMissEvt
+	if allowed {
+		typ = TraceAllowlistHitEvt
+	}
+	t.push(TraceEvt{Type: typ})
+}
@@ -0,0 +1,693 @@
+// Package traceanalysis analyzes the traces written by the resource manager.
+//
+// It replays the events of a trace to rebuild the resource usage of every scope over time,
+// counts the blocked resource reservations, and suggests limits that would have covered
+// the observed usage.
+package traceanalysis
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+// Usage is the resource usage of a scope.
+type Usage struct {
+	Memory     int64
+	StreamsIn  int
+	StreamsOut int
+	ConnsIn    int
+	ConnsOut   int
+	FD         int
+}
+
+// Streams returns the total number of streams.
+func (u Usage) Streams() int { return u.StreamsIn + u.StreamsOut }
+
+// Conns returns the total number of connections.
+func (u Usage) Conns() int { return u.ConnsIn + u.ConnsOut }
+
+// Peak is the peak resource usage of a scope. The peak of each resource is tracked separately,
+// and includes the usage that would have resulted from blocked reservations.
+type Peak struct {
+	Usage
+	Streams int
+	Conns   int
+}
+
+func (p *Peak) update(u Usage) {
+	if u.Memory > p.Memory {
+		p.Memory = u.Memory
+	}
+	if u.StreamsIn > p.StreamsIn {
+		p.StreamsIn = u.StreamsIn
+	}
+	if u.StreamsOut > p.StreamsOut {
+		p.StreamsOut = u.StreamsOut
+	}
+	if u.Streams() > p.Streams {
+		p.Streams = u.Streams()
+	}
+	if u.ConnsIn > p.ConnsIn {
+		p.ConnsIn = u.ConnsIn
+	}
+	if u.ConnsOut > p.ConnsOut {
+		p.ConnsOut = u.ConnsOut
+	}
+	if u.Conns() > p.Conns {
+		p.Conns = u.Conns()
+	}
+	if u.FD > p.FD {
+		p.FD = u.FD
+	}
+}
+
+func (p *Peak) merge(p2 Peak) {
+	p.update(p2.Usage)
+	if p2.Streams > p.Streams {
+		p.Streams = p2.Streams
+	}
+	if p2.Conns > p.Conns {
+		p.Conns = p2.Conns
+	}
+}
+
+// Sample is the resource usage of a scope after an event.
+type Sample struct {
+	Time time.Time
+	Usage
+}
+
+// ScopeUsage is the resource usage of a single scope over time.
+type ScopeUsage struct {
+	Name string
+	// Samples is the usage after every change of the usage of the scope. It is only recorded for
+	// scopes that outlive single connections and streams, see Analyzer. If the analyzer was
+	// created with WithMaxSamples, only the most recent samples are kept.
+	Samples []Sample
+	// Current is the usage after the last event of the scope.
+	Current Usage
+	Peak    Peak
+	// Blocked counts the blocked reservations, by resource.
+	Blocked map[Resource]int
+}
+
+// Resource is the kind of a resource that a reservation was blocked for.
+type Resource string
+
+const (
+	ResourceMemory     Resource = "memory"
+	ResourceStream     Resource = "stream"
+	ResourceConnection Resource = "connection"
+)
+
+// Blocked is the number of blocked reservations of a scope, a protocol or a service.
+type Blocked struct {
+	Name  string
+	Count int
+	// ByResource counts the blocked reservations, by resource.
+	ByResource map[Resource]int
+}
+
+// Analyzer replays the events of a resource manager trace.
+// It implements rcmgr.TraceReporter, so it can also analyze the events of a running resource manager.
+//
+// Usage samples are not recorded for connection, stream and span scopes, since there's one
+// of them for every connection and stream, and they are forgotten once they are destroyed.
+// Their peak usage and blocked reservations are still tracked.
+//
+// When analyzing the events of a running resource manager, the samples of long-running nodes
+// grow without bound, since every peer scope is sampled. Use WithMaxSamples to bound them.
+type Analyzer struct {
+	maxSamples int
+
+	mx         sync.Mutex
+	start, end time.Time
+	limits     *rcmgr.LimitConfig
+	scopes     map[string]*ScopeUsage
+
+	connPeak, streamPeak Peak
+	// retiredBlocked counts the blocked reservations of connection and stream scopes that were destroyed
+	retiredBlocked map[string]map[Resource]int
+}
+
+var _ rcmgr.TraceReporter = (*Analyzer)(nil)
+
+// Option is an option for NewAnalyzer.
+type Option func(*Analyzer) error
+
+// WithMaxSamples keeps at most n samples per scope, dropping the oldest ones.
+// A value of 0 disables recording samples. By default, all samples are kept.
+func WithMaxSamples(n int) Option {
+	return func(a *Analyzer) error {
+		if n < 0 {
+			return fmt.Errorf("invalid maximum number of samples: %d", n)
+		}
+		a.maxSamples = n
+		return nil
+	}
+}
+
+func NewAnalyzer(opts ...Option) (*Analyzer, error) {
+	a := &Analyzer{
+		maxSamples: -1,
+		scopes:     make(map[string]*ScopeUsage),
+		retiredBlocked: map[string]map[Resource]int{
+			"conn":   make(map[Resource]int),
+			"stream": make(map[Resource]int),
+		},
+	}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// ReadTrace feeds the events of a trace file written by rcmgr.WithTrace into the analyzer.
+// Since the trace is written until the resource manager is closed, the trace might be truncated.
+// In that case, the events up to the truncation are analyzed, and io.ErrUnexpectedEOF is returned.
+func (a *Analyzer) ReadTrace(r io.Reader) error {
+	gzIn, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+	defer gzIn.Close()
+
+	dec := json.NewDecoder(gzIn)
+	for {
+		var evt rcmgr.TraceEvt
+		if err := dec.Decode(&evt); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("failed to decode trace event: %w", err)
+		}
+		a.ConsumeEvent(evt)
+	}
+}
+
+// ConsumeEvent consumes a single trace event.
+func (a *Analyzer) ConsumeEvent(evt rcmgr.TraceEvt) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	t, err := time.Parse(time.RFC3339Nano, evt.Time)
+	if err == nil {
+		if a.start.IsZero() {
+			a.start = t
+		}
+		a.end = t
+	}
+
+	if evt.Type == rcmgr.TraceStartEvt {
+		a.limits = decodeLimits(evt.Limit)
+		return
+	}
+	if evt.Name == "" {
+		return
+	}
+
+	s, ok := a.scopes[evt.Name]
+	if !ok {
+		s = &ScopeUsage{Name: evt.Name}
+		a.scopes[evt.Name] = s
+	}
+
+	usage := s.Current
+	attempted := usage
+	switch evt.Type {
+	case rcmgr.TraceCreateScopeEvt:
+		return
+	case rcmgr.TraceDestroyScopeEvt:
+		// Don't keep the state of every connection and stream around.
+		if !recordSamples(evt.Name) {
+			if group := blockedScopeGroup(evt.Name); group != "" {
+				for r, count := range s.Blocked {
+					a.retiredBlocked[group][r] += count
+				}
+			}
+			delete(a.scopes, evt.Name)
+		}
+		return
+	case rcmgr.TraceReserveMemoryEvt, rcmgr.TraceReleaseMemoryEvt:
+		usage.Memory = evt.Memory
+	case rcmgr.TraceAddStreamEvt, rcmgr.TraceRemoveStreamEvt:
+		usage.StreamsIn, usage.StreamsOut = evt.StreamsIn, evt.StreamsOut
+	case rcmgr.TraceAddConnEvt, rcmgr.TraceRemoveConnEvt:
+		usage.ConnsIn, usage.ConnsOut, usage.FD = evt.ConnsIn, evt.ConnsOut, evt.FD
+	case rcmgr.TraceBlockReserveMemoryEvt:
+		attempted.Memory = evt.Memory + evt.Delta
+		s.block(ResourceMemory)
+	case rcmgr.TraceBlockAddStreamEvt:
+		attempted.StreamsIn, attempted.StreamsOut = evt.StreamsIn+evt.DeltaIn, evt.StreamsOut+evt.DeltaOut
+		s.block(ResourceStream)
+	case rcmgr.TraceBlockAddConnEvt:
+		attempted.ConnsIn, attempted.ConnsOut = evt.ConnsIn+evt.DeltaIn, evt.ConnsOut+evt.DeltaOut
+		attempted.FD = evt.FD + int(evt.Delta)
+		s.block(ResourceConnection)
+	default:
+		return
+	}
+
+	if usage != s.Current {
+		s.Current = usage
+		if recordSamples(evt.Name) {
+			a.addSample(s, Sample{Time: t, Usage: usage})
+		}
+	}
+	s.Peak.update(usage)
+	s.Peak.update(attempted)
+
+	switch {
+	case rcmgr.IsConnScope(evt.Name):
+		a.connPeak.merge(s.Peak)
+	case rcmgr.IsStreamScope(evt.Name):
+		a.streamPeak.merge(s.Peak)
+	}
+}
+
+func (a *Analyzer) addSample(s *ScopeUsage, sample Sample) {
+	switch {
+	case a.maxSamples == 0:
+		return
+	case a.maxSamples > 0 && len(s.Samples) >= a.maxSamples:
+		// Shift the samples, so that the slice doesn't keep growing.
+		n := copy(s.Samples, s.Samples[len(s.Samples)-a.maxSamples+1:])
+		s.Samples = s.Samples[:n]
+	}
+	s.Samples = append(s.Samples, sample)
+}
+
+func (s *ScopeUsage) clone() *ScopeUsage {
+	c := *s
+	c.Samples = append([]Sample(nil), s.Samples...)
+	if s.Blocked != nil {
+		c.Blocked = make(map[Resource]int, len(s.Blocked))
+		for r, count := range s.Blocked {
+			c.Blocked[r] = count
+		}
+	}
+	return &c
+}
+
+func (s *ScopeUsage) block(r Resource) {
+	if s.Blocked == nil {
+		s.Blocked = make(map[Resource]int)
+	}
+	s.Blocked[r]++
+}
+
+// blockedScopeGroup returns the name that the blocked reservations of connection and stream scopes are grouped by.
+func blockedScopeGroup(name string) string {
+	switch {
+	case rcmgr.IsConnScope(name):
+		return "conn"
+	case rcmgr.IsStreamScope(name):
+		return "stream"
+	}
+	return ""
+}
+
+func recordSamples(name string) bool {
+	return !rcmgr.IsConnScope(name) && !rcmgr.IsStreamScope(name) && !rcmgr.IsSpan(name)
+}
+
+func decodeLimits(limit interface{}) *rcmgr.LimitConfig {
+	if limit == nil {
+		return nil
+	}
+	b, err := json.Marshal(limit)
+	if err != nil {
+		return nil
+	}
+	var cfg rcmgr.LimitConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil
+	}
+	return &cfg
+}
+
+// Start returns the time of the first event.
+func (a *Analyzer) Start() time.Time {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	return a.start
+}
+
+// End returns the time of the last event.
+func (a *Analyzer) End() time.Time {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	return a.end
+}
+
+// Limits returns the limits that the resource manager was started with.
+// It returns nil if the trace doesn't contain them.
+func (a *Analyzer) Limits() *rcmgr.LimitConfig {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	return a.limits
+}
+
+// Scope returns a copy of the usage of the scope with the given name, or nil if the trace contains no events of the scope.
+func (a *Analyzer) Scope(name string) *ScopeUsage {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	s, ok := a.scopes[name]
+	if !ok {
+		return nil
+	}
+	return s.clone()
+}
+
+// Scopes returns the names of all scopes that the trace contains events of, in lexicographical order.
+func (a *Analyzer) Scopes() []string {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	names := make([]string, 0, len(a.scopes))
+	for name := range a.scopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TopBlockedScopes returns the n scopes with the most blocked reservations.
+// Connection and stream scopes are grouped into "conn" and "stream".
+func (a *Analyzer) TopBlockedScopes(n int) []Blocked {
+	return a.topBlocked(n, a.retiredBlocked, func(name string) string {
+		if group := blockedScopeGroup(name); group != "" {
+			return group
+		}
+		return name
+	})
+}
+
+// TopBlockedProtocols returns the n protocols with the most blocked reservations, counting
+// the reservations blocked by the protocol scopes, and the per-peer protocol scopes.
+func (a *Analyzer) TopBlockedProtocols(n int) []Blocked {
+	return a.topBlocked(n, nil, func(name string) string {
+		proto, _, ok := parseScopeName(name, "protocol:")
+		if !ok {
+			return ""
+		}
+		return proto
+	})
+}
+
+// TopBlockedServices returns the n services with the most blocked reservations, counting
+// the reservations blocked by the service scopes, and the per-peer service scopes.
+func (a *Analyzer) TopBlockedServices(n int) []Blocked {
+	return a.topBlocked(n, nil, func(name string) string {
+		svc, _, ok := parseScopeName(name, "service:")
+		if !ok {
+			return ""
+		}
+		return svc
+	})
+}
+
+// topBlocked groups the blocked reservations of the scopes by key, and returns the n groups with the most blocked reservations.
+// Scopes with an empty key are skipped. The blocked reservations in initial are counted as well.
+func (a *Analyzer) topBlocked(n int, initial map[string]map[Resource]int, key func(name string) string) []Blocked {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	groups := make(map[string]*Blocked)
+	add := func(k string, blocked map[Resource]int) {
+		b, ok := groups[k]
+		if !ok {
+			b = &Blocked{Name: k, ByResource: make(map[Resource]int)}
+			groups[k] = b
+		}
+		for r, count := range blocked {
+			b.Count += count
+			b.ByResource[r] += count
+		}
+	}
+	for k, blocked := range initial {
+		if len(blocked) > 0 {
+			add(k, blocked)
+		}
+	}
+	for name, s := range a.scopes {
+		if len(s.Blocked) == 0 {
+			continue
+		}
+		if k := key(name); k != "" {
+			add(k, s.Blocked)
+		}
+	}
+
+	result := make([]Blocked, 0, len(groups))
+	for _, b := range groups {
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Name < result[j].Name
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// parseScopeName parses the names of service and protocol scopes, and their per-peer scopes.
+func parseScopeName(name, prefix string) (base string, isPeer bool, ok bool) {
+	if !strings.HasPrefix(name, prefix) || rcmgr.IsSpan(name) {
+		return "", false, false
+	}
+	base = name[len(prefix):]
+	if idx := strings.Index(base, ".peer:"); idx >= 0 {
+		return base[:idx], true, true
+	}
+	return base, false, true
+}
+
+// SuggestLimits suggests limits that would have covered the peak usage observed in the trace,
+// including the reservations that were blocked. The peaks are raised by headroom, e.g. 0.2 for 20%.
+//
+// If the trace contains the limits that the resource manager was started with, limits are only
+// ever raised. Otherwise, limits of resources that were never used are left at 0, and can be
+// filled in with LimitConfig.Apply.
+func (a *Analyzer) SuggestLimits(headroom float64) rcmgr.LimitConfig {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	var cfg rcmgr.LimitConfig
+	if a.limits != nil {
+		cfg = copyLimits(*a.limits)
+	}
+
+	var servicePeak, servicePeerPeak, protocolPeak, protocolPeerPeak, peerPeak Peak
+	servicePeaks := make(map[string]Peak)
+	servicePeerPeaks := make(map[string]Peak)
+	protocolPeaks := make(map[string]Peak)
+	protocolPeerPeaks := make(map[string]Peak)
+	peerPeaks := make(map[string]Peak)
+	ipv4SubnetPeaks := make(map[int]Peak)
+	ipv6SubnetPeaks := make(map[int]Peak)
+	mergeInto := func(m map[string]Peak, k string, p Peak) {
+		peak := m[k]
+		peak.merge(p)
+		m[k] = peak
+	}
+
+	for name, s := range a.scopes {
+		switch {
+		case name == "system":
+			cfg.System = raise(cfg.System, s.Peak, headroom)
+		case name == "transient":
+			cfg.Transient = raise(cfg.Transient, s.Peak, headroom)
+		case name == "allowlistedSystem":
+			cfg.AllowlistedSystem = raise(cfg.AllowlistedSystem, s.Peak, headroom)
+		case name == "allowlistedTransient":
+			cfg.AllowlistedTransient = raise(cfg.AllowlistedTransient, s.Peak, headroom)
+		case strings.HasPrefix(name, "service:"):
+			svc, isPeer, ok := parseScopeName(name, "service:")
+			if !ok {
+				continue
+			}
+			if isPeer {
+				servicePeerPeak.merge(s.Peak)
+				mergeInto(servicePeerPeaks, svc, s.Peak)
+			} else {
+				servicePeak.merge(s.Peak)
+				mergeInto(servicePeaks, svc, s.Peak)
+			}
+		case strings.HasPrefix(name, "protocol:"):
+			proto, isPeer, ok := parseScopeName(name, "protocol:")
+			if !ok {
+				continue
+			}
+			if isPeer {
+				protocolPeerPeak.merge(s.Peak)
+				mergeInto(protocolPeerPeaks, proto, s.Peak)
+			} else {
+				protocolPeak.merge(s.Peak)
+				mergeInto(protocolPeaks, proto, s.Peak)
+			}
+		case strings.HasPrefix(name, "peer:") && !rcmgr.IsSpan(name):
+			peerPeak.merge(s.Peak)
+			if p := rcmgr.ParsePeerScopeName(name); p != "" {
+				mergeInto(peerPeaks, string(p), s.Peak)
+			}
+		case rcmgr.IsSubnetScope(name):
+			subnet := rcmgr.ParseSubnetScopeName(name)
+			if subnet == nil {
+				continue
+			}
+			ones, bits := subnet.Mask.Size()
+			peaks := ipv6SubnetPeaks
+			if bits == 8*net.IPv4len {
+				peaks = ipv4SubnetPeaks
+			}
+			peak := peaks[ones]
+			peak.merge(s.Peak)
+			peaks[ones] = peak
+		}
+	}
+
+	cfg.ServiceDefault = raise(cfg.ServiceDefault, servicePeak, headroom)
+	cfg.ServicePeerDefault = raise(cfg.ServicePeerDefault, servicePeerPeak, headroom)
+	cfg.ProtocolDefault = raise(cfg.ProtocolDefault, protocolPeak, headroom)
+	cfg.ProtocolPeerDefault = raise(cfg.ProtocolPeerDefault, protocolPeerPeak, headroom)
+	cfg.PeerDefault = raise(cfg.PeerDefault, peerPeak, headroom)
+	cfg.Conn = raise(cfg.Conn, a.connPeak, headroom)
+	cfg.Stream = raise(cfg.Stream, a.streamPeak, headroom)
+
+	// Only raise the limits of services, protocols and peers with explicit limits.
+	for svc, l := range cfg.Service {
+		cfg.Service[svc] = raise(l, servicePeaks[svc], headroom)
+	}
+	for svc, l := range cfg.ServicePeer {
+		cfg.ServicePeer[svc] = raise(l, servicePeerPeaks[svc], headroom)
+	}
+	for proto, l := range cfg.Protocol {
+		cfg.Protocol[proto] = raise(l, protocolPeaks[string(proto)], headroom)
+	}
+	for proto, l := range cfg.ProtocolPeer {
+		cfg.ProtocolPeer[proto] = raise(l, protocolPeerPeaks[string(proto)], headroom)
+	}
+	for p, l := range cfg.Peer {
+		cfg.Peer[p] = raise(l, peerPeaks[string(p)], headroom)
+	}
+
+	cfg.IPv4Subnet = raiseSubnetLimits(cfg.IPv4Subnet, ipv4SubnetPeaks, headroom)
+	cfg.IPv6Subnet = raiseSubnetLimits(cfg.IPv6Subnet, ipv6SubnetPeaks, headroom)
+	return cfg
+}
+
+func copyLimits(cfg rcmgr.LimitConfig) rcmgr.LimitConfig {
+	if cfg.Service != nil {
+		m := make(map[string]rcmgr.BaseLimit, len(cfg.Service))
+		for k, v := range cfg.Service {
+			m[k] = v
+		}
+		cfg.Service = m
+	}
+	if cfg.ServicePeer != nil {
+		m := make(map[string]rcmgr.BaseLimit, len(cfg.ServicePeer))
+		for k, v := range cfg.ServicePeer {
+			m[k] = v
+		}
+		cfg.ServicePeer = m
+	}
+	if cfg.Protocol != nil {
+		m := make(map[protocol.ID]rcmgr.BaseLimit, len(cfg.Protocol))
+		for k, v := range cfg.Protocol {
+			m[k] = v
+		}
+		cfg.Protocol = m
+	}
+	if cfg.ProtocolPeer != nil {
+		m := make(map[protocol.ID]rcmgr.BaseLimit, len(cfg.ProtocolPeer))
+		for k, v := range cfg.ProtocolPeer {
+			m[k] = v
+		}
+		cfg.ProtocolPeer = m
+	}
+	if cfg.Peer != nil {
+		m := make(map[peer.ID]rcmgr.BaseLimit, len(cfg.Peer))
+		for k, v := range cfg.Peer {
+			m[k] = v
+		}
+		cfg.Peer = m
+	}
+	cfg.IPv4Subnet = append([]rcmgr.SubnetLimit(nil), cfg.IPv4Subnet...)
+	cfg.IPv6Subnet = append([]rcmgr.SubnetLimit(nil), cfg.IPv6Subnet...)
+	return cfg
+}
+
+// raise raises the limits of l that don't cover the peak (plus headroom).
+func raise(l rcmgr.BaseLimit, peak Peak, headroom float64) rcmgr.BaseLimit {
+	raiseInt := func(limit *int, peak int) {
+		if s := withHeadroom(int64(peak), headroom); s > int64(*limit) {
+			*limit = int(s)
+		}
+	}
+	raiseInt(&l.StreamsInbound, peak.StreamsIn)
+	raiseInt(&l.StreamsOutbound, peak.StreamsOut)
+	raiseInt(&l.Streams, peak.Streams)
+	raiseInt(&l.ConnsInbound, peak.ConnsIn)
+	raiseInt(&l.ConnsOutbound, peak.ConnsOut)
+	raiseInt(&l.Conns, peak.Conns)
+	raiseInt(&l.FD, peak.FD)
+	if s := withHeadroom(peak.Memory, headroom); s > l.Memory {
+		l.Memory = s
+	}
+	return l
+}
+
+func raiseSubnetLimits(limits []rcmgr.SubnetLimit, peaks map[int]Peak, headroom float64) []rcmgr.SubnetLimit {
+	for i, l := range limits {
+		peak, ok := peaks[l.PrefixLength]
+		if !ok {
+			continue
+		}
+		// A limit of 0 means that the connections are not limited.
+		if l.Conns > 0 && int64(l.Conns) < withHeadroom(int64(peak.Conns), headroom) {
+			l.Conns = int(withHeadroom(int64(peak.Conns), headroom))
+		}
+		if l.ConnsInbound > 0 && int64(l.ConnsInbound) < withHeadroom(int64(peak.ConnsIn), headroom) {
+			l.ConnsInbound = int(withHeadroom(int64(peak.ConnsIn), headroom))
+		}
+		if l.ConnsOutbound > 0 && int64(l.ConnsOutbound) < withHeadroom(int64(peak.ConnsOut), headroom) {
+			l.ConnsOutbound = int(withHeadroom(int64(peak.ConnsOut), headroom))
+		}
+		limits[i] = l
+	}
+	return limits
+}
+
+func withHeadroom(n int64, headroom float64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	s := math.Ceil(float64(n) * (1 + headroom))
+	if s >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(s)
+}
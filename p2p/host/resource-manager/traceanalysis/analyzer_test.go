@@ -0,0 +1,244 @@
+package traceanalysis
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/test"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+var testLimits = rcmgr.LimitConfig{
+	System: rcmgr.BaseLimit{
+		Memory:          1 << 20,
+		StreamsInbound:  10,
+		StreamsOutbound: 10,
+		Streams:         10,
+		ConnsInbound:    2,
+		ConnsOutbound:   2,
+		Conns:           2,
+		FD:              2,
+	},
+	Transient: rcmgr.BaseLimit{
+		Memory:          1 << 20,
+		StreamsInbound:  10,
+		StreamsOutbound: 10,
+		Streams:         10,
+		ConnsInbound:    2,
+		ConnsOutbound:   2,
+		Conns:           2,
+		FD:              2,
+	},
+	ProtocolDefault: rcmgr.BaseLimit{
+		Memory:          1 << 20,
+		StreamsInbound:  1,
+		StreamsOutbound: 1,
+		Streams:         1,
+	},
+	ProtocolPeerDefault: rcmgr.BaseLimit{
+		Memory:          1 << 20,
+		StreamsInbound:  10,
+		StreamsOutbound: 10,
+		Streams:         10,
+	},
+	PeerDefault: rcmgr.BaseLimit{
+		Memory:          1 << 20,
+		StreamsInbound:  10,
+		StreamsOutbound: 10,
+		Streams:         10,
+		ConnsInbound:    2,
+		ConnsOutbound:   2,
+		Conns:           2,
+		FD:              2,
+	},
+	Conn: rcmgr.BaseLimit{
+		Memory:        1 << 20,
+		ConnsInbound:  1,
+		ConnsOutbound: 1,
+		Conns:         1,
+		FD:            1,
+	},
+	Stream: rcmgr.BaseLimit{
+		Memory:          1024,
+		StreamsInbound:  1,
+		StreamsOutbound: 1,
+		Streams:         1,
+	},
+}
+
+// writeTrace runs a resource manager that writes its trace to a file, and returns the path of the file,
+// as well as an analyzer that consumed the events while the resource manager was running.
+func writeTrace(t *testing.T) (string, *Analyzer) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "trace.json.gz")
+	live, err := NewAnalyzer()
+	require.NoError(t, err)
+	mgr, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(testLimits), rcmgr.WithTrace(path), rcmgr.WithTraceReporter(live))
+	require.NoError(t, err)
+
+	p := test.RandPeerIDFatal(t)
+	var conns []network.ConnManagementScope
+	for i := 0; i < 3; i++ {
+		conn, err := mgr.OpenConnection(network.DirInbound, true, ma.StringCast("/ip4/1.2.3.4/tcp/1234"))
+		if i == 2 {
+			require.Error(t, err)
+			break
+		}
+		require.NoError(t, err)
+		conns = append(conns, conn)
+	}
+
+	stream, err := mgr.OpenStream(p, network.DirOutbound)
+	require.NoError(t, err)
+	require.NoError(t, stream.SetProtocol("/proto"))
+	// blocked by the protocol limit
+	stream2, err := mgr.OpenStream(p, network.DirOutbound)
+	require.NoError(t, err)
+	require.Error(t, stream2.SetProtocol("/proto"))
+	stream2.Done()
+
+	// blocked by the stream limit
+	require.NoError(t, stream.ReserveMemory(512, network.ReservationPriorityAlways))
+	require.Error(t, stream.ReserveMemory(1024, network.ReservationPriorityAlways))
+	stream.Done()
+
+	for _, c := range conns {
+		c.Done()
+	}
+	require.NoError(t, mgr.Close())
+	return path, live
+}
+
+func TestAnalyzer(t *testing.T) {
+	path, live := writeTrace(t)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	a, err := NewAnalyzer()
+	require.NoError(t, err)
+	require.NoError(t, a.ReadTrace(f))
+
+	require.Equal(t, live.Scopes(), a.Scopes())
+	require.False(t, a.Start().IsZero())
+	require.False(t, a.End().Before(a.Start()))
+	require.NotNil(t, a.Limits())
+	require.Equal(t, testLimits.System, a.Limits().System)
+
+	system := a.Scope("system")
+	require.NotNil(t, system)
+	require.Equal(t, Usage{}, system.Current)
+	require.Equal(t, 2, system.Peak.ConnsIn)
+	require.Equal(t, 2, system.Peak.FD)
+	require.Equal(t, 2, system.Peak.StreamsOut)
+	require.Equal(t, int64(512), system.Peak.Memory)
+	require.NotEmpty(t, system.Samples)
+	require.Equal(t, system.Current, system.Samples[len(system.Samples)-1].Usage)
+
+	transient := a.Scope("transient")
+	require.NotNil(t, transient)
+	// the third connection was blocked by the transient scope
+	require.Equal(t, 3, transient.Peak.ConnsIn)
+	require.Equal(t, map[Resource]int{ResourceConnection: 1}, transient.Blocked)
+
+	require.Equal(t, []Blocked{{Name: "/proto", Count: 1, ByResource: map[Resource]int{ResourceStream: 1}}}, a.TopBlockedProtocols(10))
+	require.Empty(t, a.TopBlockedServices(10))
+	blocked := a.TopBlockedScopes(10)
+	require.Len(t, blocked, 3)
+	require.ElementsMatch(t, []string{"transient", "protocol:/proto", "stream"}, []string{blocked[0].Name, blocked[1].Name, blocked[2].Name})
+	require.Len(t, a.TopBlockedScopes(1), 1)
+
+	limits := a.SuggestLimits(0.5)
+	require.Equal(t, 3, limits.System.ConnsInbound)
+	require.Equal(t, 3, limits.System.FD)
+	require.Equal(t, 10, limits.System.StreamsOutbound)
+	require.Equal(t, int64(1<<20), limits.System.Memory)
+	require.Equal(t, 5, limits.Transient.ConnsInbound)
+	require.Equal(t, 3, limits.ProtocolDefault.StreamsOutbound)
+	require.Equal(t, 3, limits.ProtocolDefault.Streams)
+	require.Equal(t, int64(2304), limits.Stream.Memory)
+	// the analyzed limits are not modified
+	require.Equal(t, testLimits.System, a.Limits().System)
+}
+
+func TestAnalyzerTruncatedTrace(t *testing.T) {
+	path, _ := writeTrace(t)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	a, err := NewAnalyzer()
+	require.NoError(t, err)
+	require.ErrorIs(t, a.ReadTrace(bytes.NewReader(data[:len(data)-len(data)/4])), io.ErrUnexpectedEOF)
+	require.NotNil(t, a.Limits())
+	require.NotNil(t, a.Scope("system"))
+}
+
+func TestSuggestLimitsWithoutConfiguredLimits(t *testing.T) {
+	a, err := NewAnalyzer()
+	require.NoError(t, err)
+	a.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceAddConnEvt, Name: "system", ConnsIn: 7, FD: 3})
+	a.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceBlockReserveMemoryEvt, Name: "service:svc", Memory: 100, Delta: 900})
+	a.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceReserveMemoryEvt, Name: "service:svc.peer:foobar", Memory: 200})
+	require.Nil(t, a.Limits())
+
+	limits := a.SuggestLimits(0)
+	require.Equal(t, rcmgr.BaseLimit{ConnsInbound: 7, Conns: 7, FD: 3}, limits.System)
+	require.Equal(t, rcmgr.BaseLimit{Memory: 1000}, limits.ServiceDefault)
+	require.Equal(t, rcmgr.BaseLimit{Memory: 200}, limits.ServicePeerDefault)
+	require.Equal(t, []Blocked{{Name: "svc", Count: 1, ByResource: map[Resource]int{ResourceMemory: 1}}}, a.TopBlockedServices(0))
+}
+
+func TestMaxSamples(t *testing.T) {
+	_, err := NewAnalyzer(WithMaxSamples(-1))
+	require.Error(t, err)
+
+	a, err := NewAnalyzer(WithMaxSamples(3))
+	require.NoError(t, err)
+	for i := 1; i <= 10; i++ {
+		a.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceReserveMemoryEvt, Name: "system", Memory: int64(i)})
+	}
+	system := a.Scope("system")
+	require.Len(t, system.Samples, 3)
+	for i, s := range system.Samples {
+		require.Equal(t, int64(8+i), s.Memory)
+	}
+	require.Equal(t, int64(10), system.Peak.Memory)
+
+	a, err = NewAnalyzer(WithMaxSamples(0))
+	require.NoError(t, err)
+	a.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceReserveMemoryEvt, Name: "system", Memory: 1})
+	require.Empty(t, a.Scope("system").Samples)
+	require.Equal(t, int64(1), a.Scope("system").Current.Memory)
+}
+
+func TestAnalyzerConcurrentAccess(t *testing.T) {
+	a, err := NewAnalyzer(WithMaxSamples(10))
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			a.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceReserveMemoryEvt, Name: "system", Memory: int64(i)})
+			a.ConsumeEvent(rcmgr.TraceEvt{Type: rcmgr.TraceBlockAddStreamEvt, Name: "protocol:/proto", DeltaOut: 1})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if s := a.Scope("system"); s != nil {
+			_ = len(s.Samples)
+		}
+		a.Scopes()
+		a.TopBlockedProtocols(1)
+		a.SuggestLimits(0.1)
+	}
+	<-done
+	require.Equal(t, int64(999), a.Scope("system").Current.Memory)
+}
@@ -0,0 +1,352 @@
+// Package writesched shares the bandwidth of a connection between the streams of a stream
+// multiplexer, according to the priorities of the streams.
+//
+// Stream multiplexers like yamux and mplex put the frames of all streams into a single
+// send queue, and write them to the connection in order. A stream that sends a lot of data
+// fills that queue, and delays the frames of all other streams. The Scheduler bounds the
+// amount of data queued inside the multiplexer. Once that bound is reached, writes wait,
+// and are admitted in weighted fair queueing order: a stream with priority p gets p+1 times
+// the bandwidth of a stream with priority 0.
+//
+// A Scheduler is only activated once the priority of one of its streams is raised. Until
+// then, writes are passed to the multiplexer directly, exactly as without a Scheduler.
+package writesched
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Scheduler schedules the writes of the streams of a single connection.
+type Scheduler struct {
+	active int32 // accessed atomically, 1 once the priority of a stream was raised
+
+	chunkSize int // the maximum number of bytes that is admitted at once
+	maxQueued int // the number of bytes queued in the multiplexer above which writes wait
+
+	mx      sync.Mutex
+	closed  bool
+	queued  int     // bytes handed to the multiplexer, but not written to the connection yet
+	pending int     // bytes admitted, but not handed to the multiplexer yet
+	early   int     // bytes written to the connection before the write handing them to the multiplexer returned
+	vtime   float64 // the start tag of the last admitted write
+	waiting []*waiter
+}
+
+type waiter struct {
+	size          int
+	start, finish float64
+	admitted      chan struct{}
+}
+
+// New creates a new Scheduler.
+//
+// Writes are split into chunks of at most chunkSize bytes. Writes wait once maxQueued bytes
+// are queued in the multiplexer. maxQueued should not be larger than what the multiplexer
+// queues before it blocks writes, otherwise writes wait inside the multiplexer instead.
+func New(chunkSize, maxQueued int) *Scheduler {
+	return &Scheduler{chunkSize: chunkSize, maxQueued: maxQueued}
+}
+
+// Conn wraps the connection that the multiplexer writes to.
+// This allows the Scheduler to learn how much data is queued inside the multiplexer.
+func (s *Scheduler) Conn(nc net.Conn) net.Conn {
+	return &conn{Conn: nc, sched: s}
+}
+
+// Close releases all waiting writes, and stops scheduling.
+// It is called when the wrapped connection is closed.
+func (s *Scheduler) Close() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.closed = true
+	for _, w := range s.waiting {
+		s.admitWriteLocked(w.start, w.size)
+		close(w.admitted)
+	}
+	s.waiting = nil
+}
+
+// NewStream creates the scheduling state of a new stream.
+// The stream starts out with priority 0.
+func (s *Scheduler) NewStream() *Stream {
+	return &Stream{
+		sched:           s,
+		weight:          1,
+		deadlineChanged: make(chan struct{}, 1),
+		reset:           make(chan struct{}),
+	}
+}
+
+func (s *Scheduler) isActive() bool {
+	return atomic.LoadInt32(&s.active) == 1
+}
+
+// written is called when the multiplexer wrote n bytes to the connection.
+func (s *Scheduler) written(n int) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.queued -= n
+	if s.queued < 0 {
+		// These bytes were either handed to the multiplexer by a write that didn't return yet,
+		// or they are frame headers and control frames.
+		s.early -= s.queued
+		if s.early > s.pending {
+			s.early = s.pending
+		}
+		s.queued = 0
+	}
+	s.admitLocked()
+}
+
+// handed is called when a write of size bytes, admitted earlier, returned after handing
+// n bytes to the multiplexer.
+func (s *Scheduler) handed(size, n int) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.pending -= size
+	s.queued += n
+	if s.early > 0 {
+		d := s.early
+		if d > s.queued {
+			d = s.queued
+		}
+		s.queued -= d
+		s.early -= d
+		if s.early > s.pending {
+			s.early = s.pending
+		}
+	}
+	s.admitLocked()
+}
+
+// admitLocked admits waiting writes in the order of their finish tags, as long as they fit
+// into the multiplexer's queue.
+//
+// Writes that were admitted, but didn't return yet, might be blocked on the stream's flow
+// control window. They can't be relied on to trigger the admission of the next write.
+// Once the multiplexer doesn't hold any data anymore, all waiting writes are admitted, so
+// that the connection doesn't stall.
+func (s *Scheduler) admitLocked() {
+	for len(s.waiting) > 0 {
+		next := 0
+		for i, w := range s.waiting {
+			if w.finish < s.waiting[next].finish {
+				next = i
+			}
+		}
+		w := s.waiting[next]
+		if s.queued > 0 && !s.fitsLocked(w.size) {
+			return
+		}
+		s.waiting = append(s.waiting[:next], s.waiting[next+1:]...)
+		s.admitWriteLocked(w.start, w.size)
+		close(w.admitted)
+	}
+}
+
+func (s *Scheduler) fitsLocked(size int) bool {
+	return s.queued+s.pending+size <= s.maxQueued
+}
+
+func (s *Scheduler) admitWriteLocked(start float64, size int) {
+	s.pending += size
+	if start > s.vtime {
+		s.vtime = start
+	}
+}
+
+// removeLocked removes a waiting write.
+// It returns false if the write was already admitted.
+func (s *Scheduler) removeLocked(w *waiter) bool {
+	for i, ww := range s.waiting {
+		if ww == w {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Stream is the scheduling state of a single stream.
+type Stream struct {
+	sched *Scheduler
+
+	// protected by the Scheduler's mutex
+	weight   float64
+	finish   float64 // the finish tag of the stream's last write
+	deadline time.Time
+
+	deadlineChanged chan struct{}
+	resetOnce       sync.Once
+	reset           chan struct{}
+}
+
+// SetPriority sets the priority of the stream.
+// Raising the priority of any stream activates the Scheduler.
+func (st *Stream) SetPriority(prio uint8) {
+	st.sched.mx.Lock()
+	st.weight = float64(prio) + 1
+	st.sched.mx.Unlock()
+
+	if prio > 0 {
+		atomic.StoreInt32(&st.sched.active, 1)
+	}
+}
+
+// SetWriteDeadline sets the deadline for writes waiting to be admitted.
+func (st *Stream) SetWriteDeadline(t time.Time) {
+	st.sched.mx.Lock()
+	st.deadline = t
+	st.sched.mx.Unlock()
+
+	select {
+	case st.deadlineChanged <- struct{}{}:
+	default:
+	}
+}
+
+// Reset releases all writes of this stream that are waiting to be admitted.
+// It is called when the stream is reset. The released writes are passed to the
+// multiplexer, which then fails them.
+func (st *Stream) Reset() {
+	st.resetOnce.Do(func() { close(st.reset) })
+}
+
+// Write writes b by calling write once the Scheduler admitted it.
+// write is usually the Write method of the multiplexer's stream.
+func (st *Stream) Write(b []byte, write func([]byte) (int, error)) (int, error) {
+	if !st.sched.isActive() {
+		return write(b)
+	}
+
+	var n int
+	for n < len(b) {
+		chunk := b[n:]
+		if len(chunk) > st.sched.chunkSize {
+			chunk = chunk[:st.sched.chunkSize]
+		}
+		if err := st.admit(len(chunk)); err != nil {
+			return n, err
+		}
+		m, err := write(chunk)
+		st.sched.handed(len(chunk), m)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// admit blocks until a write of size bytes is admitted.
+func (st *Stream) admit(size int) error {
+	s := st.sched
+	s.mx.Lock()
+	start := s.vtime
+	if st.finish > start {
+		start = st.finish
+	}
+	st.finish = start + float64(size)/st.weight
+	if s.closed || (len(s.waiting) == 0 && s.fitsLocked(size)) {
+		s.admitWriteLocked(start, size)
+		s.mx.Unlock()
+		return nil
+	}
+	w := &waiter{
+		size:     size,
+		start:    start,
+		finish:   st.finish,
+		admitted: make(chan struct{}),
+	}
+	s.waiting = append(s.waiting, w)
+	s.admitLocked()
+	s.mx.Unlock()
+
+	for {
+		s.mx.Lock()
+		deadline := st.deadline
+		s.mx.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+		select {
+		case <-w.admitted:
+		case <-st.deadlineChanged:
+		case <-timeout:
+			if st.cancel(w) {
+				return os.ErrDeadlineExceeded
+			}
+		case <-st.reset:
+			// The multiplexer fails the write.
+			st.release(w)
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-w.admitted:
+			return nil
+		default:
+		}
+	}
+}
+
+// cancel removes a waiting write.
+// It returns false if the write was admitted in the meantime.
+func (st *Stream) cancel(w *waiter) bool {
+	s := st.sched
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if !s.removeLocked(w) {
+		return false
+	}
+	// Removing a write can unblock the writes queued behind it.
+	s.admitLocked()
+	return true
+}
+
+// release admits a waiting write right away.
+func (st *Stream) release(w *waiter) {
+	s := st.sched
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.removeLocked(w) {
+		s.admitWriteLocked(w.start, w.size)
+		close(w.admitted)
+	}
+}
+
+// conn observes the writes of the multiplexer to the underlying connection.
+type conn struct {
+	net.Conn
+	sched *Scheduler
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if c.sched.isActive() {
+		c.sched.written(n)
+	}
+	if err != nil {
+		c.sched.Close()
+	}
+	return n, err
+}
+
+func (c *conn) Close() error {
+	c.sched.Close()
+	return c.Conn.Close()
+}
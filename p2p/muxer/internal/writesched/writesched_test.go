@@ -0,0 +1,215 @@
+package writesched
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func numWaiting(s *Scheduler) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return len(s.waiting)
+}
+
+func TestInactive(t *testing.T) {
+	s := New(10, 10)
+	st := s.NewStream()
+	var writes [][]byte
+	n, err := st.Write(make([]byte, 100), func(b []byte) (int, error) {
+		writes = append(writes, b)
+		return len(b), nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 100, n)
+	// writes are neither split nor queued
+	require.Len(t, writes, 1)
+}
+
+func TestWeightedOrder(t *testing.T) {
+	s := New(10, 20)
+	low := s.NewStream()
+	high := s.NewStream()
+	high.SetPriority(3)
+
+	var mx sync.Mutex
+	var order []string
+	written := make(chan struct{}, 100)
+	record := func(name string) func([]byte) (int, error) {
+		return func(b []byte) (int, error) {
+			mx.Lock()
+			order = append(order, name)
+			mx.Unlock()
+			written <- struct{}{}
+			return len(b), nil
+		}
+	}
+
+	// fill the queue
+	_, err := low.Write(make([]byte, 20), record("fill"))
+	require.NoError(t, err)
+	<-written
+	<-written
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, err := low.Write(make([]byte, 40), record("low"))
+		require.NoError(t, err)
+	}()
+	require.Eventually(t, func() bool { return numWaiting(s) == 1 }, time.Second, time.Millisecond)
+	go func() {
+		defer wg.Done()
+		_, err := high.Write(make([]byte, 40), record("high"))
+		require.NoError(t, err)
+	}()
+	require.Eventually(t, func() bool { return numWaiting(s) == 2 }, time.Second, time.Millisecond)
+
+	// drain the queue one chunk at a time
+	for i := 0; i < 8; i++ {
+		s.written(10)
+		<-written
+	}
+	wg.Wait()
+	// high gets 4 times the bandwidth of low
+	require.Equal(t, []string{"fill", "fill", "high", "high", "high", "high", "low", "low", "low", "low"}, order)
+}
+
+func TestPrioritizedWriteOvertakesBulk(t *testing.T) {
+	s := New(10, 20)
+	bulk := s.NewStream()
+	control := s.NewStream()
+	control.SetPriority(255)
+
+	var mx sync.Mutex
+	var order []string
+	written := make(chan struct{}, 100)
+	record := func(name string) func([]byte) (int, error) {
+		return func(b []byte) (int, error) {
+			mx.Lock()
+			order = append(order, name)
+			mx.Unlock()
+			written <- struct{}{}
+			return len(b), nil
+		}
+	}
+
+	// the bulk transfer fills the queue, and has more data waiting
+	_, err := bulk.Write(make([]byte, 20), record("bulk"))
+	require.NoError(t, err)
+	<-written
+	<-written
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, err := bulk.Write(make([]byte, 30), record("bulk"))
+		require.NoError(t, err)
+	}()
+	require.Eventually(t, func() bool { return numWaiting(s) == 1 }, time.Second, time.Millisecond)
+	go func() {
+		defer wg.Done()
+		_, err := control.Write(make([]byte, 10), record("control"))
+		require.NoError(t, err)
+	}()
+	require.Eventually(t, func() bool { return numWaiting(s) == 2 }, time.Second, time.Millisecond)
+
+	// the control message is admitted as soon as there's room, before the waiting bulk data
+	for i := 0; i < 4; i++ {
+		s.written(10)
+		<-written
+	}
+	wg.Wait()
+	require.Equal(t, []string{"bulk", "bulk", "control", "bulk", "bulk", "bulk"}, order)
+}
+
+func TestWriteDeadline(t *testing.T) {
+	s := New(10, 10)
+	st := s.NewStream()
+	st.SetPriority(1)
+	write := func(b []byte) (int, error) { return len(b), nil }
+
+	// fill the queue
+	_, err := st.Write(make([]byte, 10), write)
+	require.NoError(t, err)
+
+	st.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	_, err = st.Write(make([]byte, 10), write)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	require.Zero(t, numWaiting(s))
+
+	// the write can be admitted again
+	st.SetWriteDeadline(time.Time{})
+	s.written(10)
+	_, err = st.Write(make([]byte, 10), write)
+	require.NoError(t, err)
+}
+
+func TestReset(t *testing.T) {
+	s := New(10, 10)
+	st := s.NewStream()
+	st.SetPriority(1)
+
+	_, err := st.Write(make([]byte, 10), func(b []byte) (int, error) { return len(b), nil })
+	require.NoError(t, err)
+
+	errReset := errors.New("reset")
+	done := make(chan error)
+	go func() {
+		_, err := st.Write(make([]byte, 10), func([]byte) (int, error) { return 0, errReset })
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return numWaiting(s) == 1 }, time.Second, time.Millisecond)
+	st.Reset()
+	// the write is passed to the multiplexer, which fails it
+	require.ErrorIs(t, <-done, errReset)
+}
+
+func TestNoStallOnBlockedWrite(t *testing.T) {
+	s := New(10, 10)
+	blocked := s.NewStream()
+	blocked.SetPriority(1)
+	st := s.NewStream()
+
+	// This write is admitted, and then blocks in the multiplexer, e.g. on the flow control window.
+	unblock := make(chan struct{})
+	go blocked.Write(make([]byte, 10), func(b []byte) (int, error) {
+		<-unblock
+		return len(b), nil
+	})
+	defer close(unblock)
+	require.Eventually(t, func() bool {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		return s.pending == 10
+	}, time.Second, time.Millisecond)
+
+	// the multiplexer's queue is empty, so other streams can still write
+	_, err := st.Write(make([]byte, 10), func(b []byte) (int, error) { return len(b), nil })
+	require.NoError(t, err)
+}
+
+func TestClose(t *testing.T) {
+	s := New(10, 10)
+	st := s.NewStream()
+	st.SetPriority(1)
+	write := func(b []byte) (int, error) { return len(b), nil }
+
+	_, err := st.Write(make([]byte, 10), write)
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		_, err := st.Write(make([]byte, 10), write)
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return numWaiting(s) == 1 }, time.Second, time.Millisecond)
+	s.Close()
+	require.NoError(t, <-done)
+}
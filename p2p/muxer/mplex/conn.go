@@ -4,17 +4,24 @@ import (
 	"context"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/muxer/internal/writesched"
 
 	mp "github.com/libp2p/go-mplex"
 )
 
-type conn mp.Multiplex
+type conn struct {
+	m     *mp.Multiplex
+	sched *writesched.Scheduler // nil if writes are not scheduled
+}
 
 var _ network.MuxedConn = &conn{}
 
 // NewMuxedConn constructs a new Conn from a *mp.Multiplex.
+//
+// Streams of this conn don't support priorities: scheduling writes requires wrapping
+// the net.Conn before the multiplexer is created. Use PriorityTransport.NewConn for that.
 func NewMuxedConn(m *mp.Multiplex) network.MuxedConn {
-	return (*conn)(m)
+	return &conn{m: m}
 }
 
 func (c *conn) Close() error {
@@ -31,7 +38,7 @@ func (c *conn) OpenStream(ctx context.Context) (network.MuxedStream, error) {
 	if err != nil {
 		return nil, err
 	}
-	return (*stream)(s), nil
+	return c.newStream(s), nil
 }
 
 // AcceptStream accepts a stream opened by the other side.
@@ -40,9 +47,17 @@ func (c *conn) AcceptStream() (network.MuxedStream, error) {
 	if err != nil {
 		return nil, err
 	}
-	return (*stream)(s), nil
+	return c.newStream(s), nil
+}
+
+func (c *conn) newStream(s *mp.Stream) *stream {
+	str := &stream{s: s}
+	if c.sched != nil {
+		str.sched = c.sched.NewStream()
+	}
+	return str
 }
 
 func (c *conn) mplex() *mp.Multiplex {
-	return (*mp.Multiplex)(c)
+	return c.m
 }
@@ -4,14 +4,21 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/muxer/internal/writesched"
 
 	mp "github.com/libp2p/go-mplex"
 )
 
 // stream implements network.MuxedStream over mplex.Stream.
-type stream mp.Stream
+type stream struct {
+	s     *mp.Stream
+	sched *writesched.Stream // nil if the conn doesn't schedule writes
+}
 
-var _ network.MuxedStream = &stream{}
+var (
+	_ network.MuxedStream       = &stream{}
+	_ network.PrioritizedStream = &stream{}
+)
 
 func (s *stream) Read(b []byte) (n int, err error) {
 	n, err = s.mplex().Read(b)
@@ -23,7 +30,11 @@ func (s *stream) Read(b []byte) (n int, err error) {
 }
 
 func (s *stream) Write(b []byte) (n int, err error) {
-	n, err = s.mplex().Write(b)
+	if s.sched != nil {
+		n, err = s.sched.Write(b, s.mplex().Write)
+	} else {
+		n, err = s.mplex().Write(b)
+	}
 	if err == mp.ErrStreamReset {
 		err = network.ErrReset
 	}
@@ -44,10 +55,17 @@ func (s *stream) CloseRead() error {
 }
 
 func (s *stream) Reset() error {
-	return s.mplex().Reset()
+	err := s.mplex().Reset()
+	if s.sched != nil {
+		s.sched.Reset()
+	}
+	return err
 }
 
 func (s *stream) SetDeadline(t time.Time) error {
+	if s.sched != nil {
+		s.sched.SetWriteDeadline(t)
+	}
 	return s.mplex().SetDeadline(t)
 }
 
@@ -56,9 +74,21 @@ func (s *stream) SetReadDeadline(t time.Time) error {
 }
 
 func (s *stream) SetWriteDeadline(t time.Time) error {
+	if s.sched != nil {
+		s.sched.SetWriteDeadline(t)
+	}
 	return s.mplex().SetWriteDeadline(t)
 }
 
+// SetPriority sets the priority of the stream's writes.
+func (s *stream) SetPriority(prio uint8) error {
+	if s.sched == nil {
+		return network.ErrStreamPriorityNotSupported
+	}
+	s.sched.SetPriority(prio)
+	return nil
+}
+
 func (s *stream) mplex() *mp.Stream {
-	return (*mp.Stream)(s)
+	return s.s
 }
@@ -4,6 +4,7 @@ import (
 	"net"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/muxer/internal/writesched"
 
 	mp "github.com/libp2p/go-mplex"
 )
@@ -13,6 +14,11 @@ var DefaultTransport = &Transport{}
 
 var _ network.Multiplexer = &Transport{}
 
+// DefaultPriorityTransport has default settings for PriorityTransport
+var DefaultPriorityTransport = &PriorityTransport{}
+
+var _ network.Multiplexer = &PriorityTransport{}
+
 // Transport implements mux.Multiplexer that constructs
 // mplex-backed muxed connections.
 type Transport struct{}
@@ -22,5 +28,20 @@ func (t *Transport) NewConn(nc net.Conn, isServer bool, scope network.PeerScope)
 	if err != nil {
 		return nil, err
 	}
-	return NewMuxedConn(m), nil
+	return &conn{m: m}, nil
+}
+
+// PriorityTransport is a Transport whose streams support priorities, see network.PrioritizedStream.
+// Its connections observe the multiplexer's writes to the underlying connection, to bound the data
+// queued in the multiplexer once the priority of a stream is raised.
+type PriorityTransport struct{}
+
+func (t *PriorityTransport) NewConn(nc net.Conn, isServer bool, scope network.PeerScope) (network.MuxedConn, error) {
+	// mplex only queues a few small buffers, and blocks writes after that.
+	sched := writesched.New(mp.ChunkSize, mp.MaxBuffers*mp.ChunkSize)
+	m, err := mp.NewMultiplex(sched.Conn(nc), isServer, scope)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{m: m, sched: sched}, nil
 }
@@ -13,6 +13,10 @@ func TestDefaultTransport(t *testing.T) {
 	test.SubtestAll(t, DefaultTransport)
 }
 
+func TestPriorityTransport(t *testing.T) {
+	test.SubtestAll(t, DefaultPriorityTransport)
+}
+
 type memoryScope struct {
 	network.PeerScope
 	limit    int
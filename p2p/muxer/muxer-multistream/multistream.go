@@ -38,6 +38,12 @@ func (t *Transport) AddTransport(path string, tpt network.Multiplexer) {
 	t.OrderPreference = append(t.OrderPreference, path)
 }
 
+// GetTransportByKey returns the stream multiplexer registered for the protocol ID key.
+func (t *Transport) GetTransportByKey(key string) (network.Multiplexer, bool) {
+	tpt, ok := t.tpts[key]
+	return tpt, ok
+}
+
 func (t *Transport) NewConn(nc net.Conn, isServer bool, scope network.PeerScope) (network.MuxedConn, error) {
 	if t.NegotiateTimeout != 0 {
 		if err := nc.SetDeadline(time.Now().Add(t.NegotiateTimeout)); err != nil {
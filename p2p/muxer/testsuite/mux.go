@@ -631,6 +631,215 @@ func SubtestStress1Conn100Stream100Msg10MB(t *testing.T, tr network.Multiplexer)
 	})
 }
 
+// SubtestStreamPriority checks that streams with different priorities deliver their data
+// intact while they're sending at the same time. The scheduling itself is tested by the
+// unit tests of the write scheduler, since it depends on timing here.
+// It is skipped if the stream multiplexer doesn't support priorities.
+func SubtestStreamPriority(t *testing.T, tr network.Multiplexer) {
+	a, b := tcpPipe(t)
+	defer a.Close()
+	defer b.Close()
+
+	muxa, err := tr.NewConn(a, true, nil)
+	checkErr(t, err)
+	defer muxa.Close()
+	muxb, err := tr.NewConn(b, false, nil)
+	checkErr(t, err)
+	defer muxb.Close()
+
+	prios := []uint8{0, 1, 3, 255}
+	data := make([][]byte, len(prios))
+	for i := range data {
+		data[i] = randBuf(256 << 10)
+	}
+
+	var wg sync.WaitGroup
+	for i, prio := range prios {
+		str, err := muxa.OpenStream(context.Background())
+		checkErr(t, err)
+		ps, ok := str.(network.PrioritizedStream)
+		if !ok {
+			t.Skip("stream multiplexer doesn't support priorities")
+		}
+		if err := ps.SetPriority(prio); err == network.ErrStreamPriorityNotSupported {
+			t.Skip("stream multiplexer doesn't support priorities")
+		} else {
+			checkErr(t, err)
+		}
+		wg.Add(1)
+		go func(str network.MuxedStream, data []byte) {
+			defer wg.Done()
+			defer str.Close()
+			// the first byte identifies the stream
+			if _, err := str.Write(data); err != nil {
+				t.Error(err)
+			}
+		}(str, append([]byte{byte(i)}, data[i]...))
+	}
+
+	for range prios {
+		str, err := muxb.AcceptStream()
+		checkErr(t, err)
+		wg.Add(1)
+		go func(str network.MuxedStream) {
+			defer wg.Done()
+			defer str.Close()
+			received, err := io.ReadAll(str)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(received) == 0 || int(received[0]) >= len(data) {
+				t.Error("unexpected stream")
+				return
+			}
+			if !bytes.Equal(data[received[0]], received[1:]) {
+				t.Errorf("data of stream %d corrupted", received[0])
+			}
+		}(str)
+	}
+	wg.Wait()
+}
+
+// throttledConn slows down reading from a connection, so that the connection, and not
+// the reading application, limits the throughput.
+type throttledConn struct {
+	net.Conn
+}
+
+func (c *throttledConn) Read(b []byte) (int, error) {
+	if len(b) > 4<<10 {
+		b = b[:4<<10]
+	}
+	time.Sleep(50 * time.Microsecond)
+	return c.Conn.Read(b)
+}
+
+// SubtestStreamPriorityProgress checks that a high-priority stream overtakes a bulk transfer
+// that saturates the connection: while the high-priority stream sends a message, only a
+// bounded number of bytes of the bulk transfer may be received.
+// It is skipped if the stream multiplexer doesn't support priorities.
+func SubtestStreamPriorityProgress(t *testing.T, tr network.Multiplexer) {
+	const (
+		bulkSize = 4 << 20
+		msgSize  = 256 << 10
+		// the bulk data that is allowed to be received while the message is sent.
+		// Without prioritization, the streams share the connection, and about msgSize is received.
+		maxOvertaken = 128 << 10
+	)
+
+	// net.Pipe doesn't buffer, so the data that is queued is queued in the multiplexer
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	muxa, err := tr.NewConn(a, true, nil)
+	checkErr(t, err)
+	defer muxa.Close()
+	muxb, err := tr.NewConn(&throttledConn{Conn: b}, false, nil)
+	checkErr(t, err)
+	defer muxb.Close()
+
+	// the first byte identifies the stream
+	openStream := func(id byte, prio uint8) network.MuxedStream {
+		str, err := muxa.OpenStream(context.Background())
+		checkErr(t, err)
+		ps, ok := str.(network.PrioritizedStream)
+		if !ok {
+			t.Skip("stream multiplexer doesn't support priorities")
+		}
+		if err := ps.SetPriority(prio); err == network.ErrStreamPriorityNotSupported {
+			t.Skip("stream multiplexer doesn't support priorities")
+		} else {
+			checkErr(t, err)
+		}
+		_, err = str.Write([]byte{id})
+		checkErr(t, err)
+		return str
+	}
+	bulk := openStream(0, 0)
+	defer bulk.Close()
+	urgent := openStream(1, 255)
+	defer urgent.Close()
+
+	var mx sync.Mutex
+	var bulkReceived int
+	getBulkReceived := func() int {
+		mx.Lock()
+		defer mx.Unlock()
+		return bulkReceived
+	}
+	msgDone := make(chan int, 1)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		str, err := muxb.AcceptStream()
+		checkErr(t, err)
+		go func(str network.MuxedStream) {
+			defer str.Close()
+			id := make([]byte, 1)
+			if _, err := io.ReadFull(str, id); err != nil {
+				errs <- err
+				return
+			}
+			if id[0] == 1 {
+				_, err := io.ReadFull(str, make([]byte, msgSize))
+				msgDone <- getBulkReceived()
+				errs <- err
+				return
+			}
+			buf := make([]byte, 32<<10)
+			for {
+				n, err := str.Read(buf)
+				mx.Lock()
+				bulkReceived += n
+				mx.Unlock()
+				if err == io.EOF {
+					errs <- nil
+					return
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(str)
+	}
+
+	go func() {
+		data := make([]byte, 1<<20)
+		for i := 0; i < bulkSize/len(data); i++ {
+			if _, err := bulk.Write(data); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		bulk.Close()
+	}()
+
+	// wait until the bulk transfer saturates the connection
+	require.Eventually(t, func() bool { return getBulkReceived() >= 1<<20 }, 10*time.Second, time.Millisecond)
+	start := getBulkReceived()
+	_, err = urgent.Write(make([]byte, msgSize))
+	checkErr(t, err)
+	var end int
+	select {
+	case end = <-msgDone:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the high-priority message")
+	}
+	require.Less(t, end, bulkSize, "the high-priority message should arrive before the bulk transfer completes")
+	require.LessOrEqual(t, end-start, maxOvertaken, "too much bulk data was received while sending the high-priority message")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			checkErr(t, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for the transfers")
+		}
+	}
+}
+
 // Subtests are all the subtests run by SubtestAll
 var subtests = []TransportTest{
 	SubtestSimpleWrite,
@@ -644,6 +853,8 @@ var subtests = []TransportTest{
 	SubtestStreamOpenStress,
 	SubtestStreamReset,
 	SubtestStreamLeftOpen,
+	SubtestStreamPriority,
+	SubtestStreamPriorityProgress,
 }
 
 // SubtestAll runs all the stream multiplexer tests against the target
@@ -4,18 +4,25 @@ import (
 	"context"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/muxer/internal/writesched"
 
 	"github.com/libp2p/go-yamux/v4"
 )
 
 // conn implements mux.MuxedConn over yamux.Session.
-type conn yamux.Session
+type conn struct {
+	s     *yamux.Session
+	sched *writesched.Scheduler // nil if writes are not scheduled
+}
 
 var _ network.MuxedConn = &conn{}
 
 // NewMuxedConn constructs a new MuxedConn from a yamux.Session.
+//
+// Streams of this conn don't support priorities: scheduling writes requires wrapping
+// the net.Conn before the session is created. Use PriorityTransport.NewConn for that.
 func NewMuxedConn(m *yamux.Session) network.MuxedConn {
-	return (*conn)(m)
+	return &conn{s: m}
 }
 
 // Close closes underlying yamux
@@ -35,15 +42,26 @@ func (c *conn) OpenStream(ctx context.Context) (network.MuxedStream, error) {
 		return nil, err
 	}
 
-	return (*stream)(s), nil
+	return c.newStream(s), nil
 }
 
 // AcceptStream accepts a stream opened by the other side.
 func (c *conn) AcceptStream() (network.MuxedStream, error) {
 	s, err := c.yamux().AcceptStream()
-	return (*stream)(s), err
+	if err != nil {
+		return nil, err
+	}
+	return c.newStream(s), nil
+}
+
+func (c *conn) newStream(s *yamux.Stream) *stream {
+	str := &stream{s: s}
+	if c.sched != nil {
+		str.sched = c.sched.NewStream()
+	}
+	return str
 }
 
 func (c *conn) yamux() *yamux.Session {
-	return (*yamux.Session)(c)
+	return c.s
 }
@@ -4,14 +4,21 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/muxer/internal/writesched"
 
 	"github.com/libp2p/go-yamux/v4"
 )
 
 // stream implements mux.MuxedStream over yamux.Stream.
-type stream yamux.Stream
+type stream struct {
+	s     *yamux.Stream
+	sched *writesched.Stream // nil if the conn doesn't schedule writes
+}
 
-var _ network.MuxedStream = &stream{}
+var (
+	_ network.MuxedStream       = &stream{}
+	_ network.PrioritizedStream = &stream{}
+)
 
 func (s *stream) Read(b []byte) (n int, err error) {
 	n, err = s.yamux().Read(b)
@@ -23,7 +30,11 @@ func (s *stream) Read(b []byte) (n int, err error) {
 }
 
 func (s *stream) Write(b []byte) (n int, err error) {
-	n, err = s.yamux().Write(b)
+	if s.sched != nil {
+		n, err = s.sched.Write(b, s.yamux().Write)
+	} else {
+		n, err = s.yamux().Write(b)
+	}
 	if err == yamux.ErrStreamReset {
 		err = network.ErrReset
 	}
@@ -36,7 +47,11 @@ func (s *stream) Close() error {
 }
 
 func (s *stream) Reset() error {
-	return s.yamux().Reset()
+	err := s.yamux().Reset()
+	if s.sched != nil {
+		s.sched.Reset()
+	}
+	return err
 }
 
 func (s *stream) CloseRead() error {
@@ -48,6 +63,9 @@ func (s *stream) CloseWrite() error {
 }
 
 func (s *stream) SetDeadline(t time.Time) error {
+	if s.sched != nil {
+		s.sched.SetWriteDeadline(t)
+	}
 	return s.yamux().SetDeadline(t)
 }
 
@@ -56,9 +74,21 @@ func (s *stream) SetReadDeadline(t time.Time) error {
 }
 
 func (s *stream) SetWriteDeadline(t time.Time) error {
+	if s.sched != nil {
+		s.sched.SetWriteDeadline(t)
+	}
 	return s.yamux().SetWriteDeadline(t)
 }
 
+// SetPriority sets the priority of the stream's writes.
+func (s *stream) SetPriority(prio uint8) error {
+	if s.sched == nil {
+		return network.ErrStreamPriorityNotSupported
+	}
+	s.sched.SetPriority(prio)
+	return nil
+}
+
 func (s *stream) yamux() *yamux.Stream {
-	return (*yamux.Stream)(s)
+	return s.s
 }
@@ -6,12 +6,25 @@ import (
 	"net"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/muxer/internal/writesched"
 
 	"github.com/libp2p/go-yamux/v4"
 )
 
 var DefaultTransport *Transport
 
+// DefaultPriorityTransport has the same configuration as DefaultTransport,
+// and supports stream priorities.
+var DefaultPriorityTransport *PriorityTransport
+
+// yamux queues up to 64 frames. Once a stream priority is set, writes are split into
+// small frames, and the amount of data queued in yamux is bounded, so that a prioritized
+// stream's frames don't have to wait behind megabytes of another stream's data.
+const (
+	schedChunkSize = 16 << 10
+	schedMaxQueued = 64 << 10
+)
+
 func init() {
 	config := yamux.DefaultConfig()
 	// We've bumped this to 16MiB as this critically limits throughput.
@@ -29,6 +42,8 @@ func init() {
 	// This is now dynamically limited by the resource manager.
 	config.MaxIncomingStreams = math.MaxUint32
 	DefaultTransport = (*Transport)(config)
+	priorityConfig := *config
+	DefaultPriorityTransport = (*PriorityTransport)(&priorityConfig)
 }
 
 // Transport implements mux.Multiplexer that constructs
@@ -38,24 +53,48 @@ type Transport yamux.Config
 var _ network.Multiplexer = &Transport{}
 
 func (t *Transport) NewConn(nc net.Conn, isServer bool, scope network.PeerScope) (network.MuxedConn, error) {
+	return newConn(nc, isServer, scope, t.Config(), nil)
+}
+
+func (t *Transport) Config() *yamux.Config {
+	return (*yamux.Config)(t)
+}
+
+// PriorityTransport is a Transport whose streams support priorities, see network.PrioritizedStream.
+// Its connections observe the session's writes to the underlying connection, to bound the data
+// queued in the session once the priority of a stream is raised.
+type PriorityTransport yamux.Config
+
+var _ network.Multiplexer = &PriorityTransport{}
+
+func (t *PriorityTransport) NewConn(nc net.Conn, isServer bool, scope network.PeerScope) (network.MuxedConn, error) {
+	return newConn(nc, isServer, scope, t.Config(), writesched.New(schedChunkSize, schedMaxQueued))
+}
+
+func (t *PriorityTransport) Config() *yamux.Config {
+	return (*yamux.Config)(t)
+}
+
+// newConn creates a yamux session over nc. If sched is not nil, it schedules the writes of the streams.
+func newConn(nc net.Conn, isServer bool, scope network.PeerScope, config *yamux.Config, sched *writesched.Scheduler) (network.MuxedConn, error) {
 	var newSpan func() (yamux.MemoryManager, error)
 	if scope != nil {
 		newSpan = func() (yamux.MemoryManager, error) { return scope.BeginSpan() }
 	}
 
+	if sched != nil {
+		nc = sched.Conn(nc)
+	}
+
 	var s *yamux.Session
 	var err error
 	if isServer {
-		s, err = yamux.Server(nc, t.Config(), newSpan)
+		s, err = yamux.Server(nc, config, newSpan)
 	} else {
-		s, err = yamux.Client(nc, t.Config(), newSpan)
+		s, err = yamux.Client(nc, config, newSpan)
 	}
 	if err != nil {
 		return nil, err
 	}
-	return NewMuxedConn(s), nil
-}
-
-func (t *Transport) Config() *yamux.Config {
-	return (*yamux.Config)(t)
+	return &conn{s: s, sched: sched}, nil
 }
@@ -13,3 +13,9 @@ func TestDefaultTransport(t *testing.T) {
 
 	tmux.SubtestAll(t, DefaultTransport)
 }
+
+func TestPriorityTransport(t *testing.T) {
+	delete(tmux.Subtests, "github.com/libp2p/go-libp2p-testing/suites/mux.SubtestStress1Conn1000Stream10Msg")
+
+	tmux.SubtestAll(t, DefaultPriorityTransport)
+}
@@ -0,0 +1,229 @@
+package conngater
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/canonicallog"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// AutoBanConfig configures the automatic blocking of misbehaving peers. See WithAutoBan.
+type AutoBanConfig struct {
+	// Strikes is the number of misbehavior reports within Window after which a peer is blocked.
+	Strikes int
+	Window  time.Duration
+	// BanDuration is how long a peer is blocked the first time. The duration doubles
+	// every time the peer is blocked again, up to MaxBanDuration. The escalation is
+	// reset once the peer behaves for MaxBanDuration after a block expired.
+	// If MaxBanDuration is 0, it defaults to BanDuration.
+	BanDuration    time.Duration
+	MaxBanDuration time.Duration
+	// BlockIP also blocks IP addresses, counting the misbehavior reports of all peers
+	// using the same IP address. Relayed peers are never counted towards the IP address
+	// of their relay.
+	BlockIP bool
+	// ManualReportsOnly only consumes the reports passed to ReportMisbehavior. By default,
+	// the reports logged with canonicallog.LogMisbehavingPeer are consumed as well. These are
+	// process-wide, so with multiple hosts in a process, every gater bans the peers reported
+	// by any of the hosts.
+	ManualReportsOnly bool
+}
+
+// maxQueuedReports is the maximum number of misbehavior reports queued for processing.
+// Further reports are dropped until the queue drains.
+const maxQueuedReports = 256
+
+// WithAutoBan blocks peers that misbehave repeatedly, as reported to canonicallog.LogMisbehavingPeer
+// or ReportMisbehavior. The blocks expire, see BlockPeerFor. Reports are processed in the background;
+// Close must be called to stop consuming them.
+func WithAutoBan(cfg AutoBanConfig) Option {
+	return func(cg *BasicConnectionGater) error {
+		if cfg.Strikes <= 0 {
+			return errors.New("auto ban strikes must be positive")
+		}
+		if cfg.Window <= 0 || cfg.BanDuration <= 0 {
+			return errors.New("auto ban window and ban duration must be positive")
+		}
+		if cfg.MaxBanDuration == 0 {
+			cfg.MaxBanDuration = cfg.BanDuration
+		}
+		if cfg.MaxBanDuration < cfg.BanDuration {
+			return errors.New("auto ban max ban duration must not be smaller than the ban duration")
+		}
+		cg.autoBan = &autoBan{
+			cfg:       cfg,
+			offenders: make(map[string]*offender),
+			reports:   make(chan timedReport, maxQueuedReports),
+			closing:   make(chan struct{}),
+		}
+		return nil
+	}
+}
+
+type autoBan struct {
+	cfg AutoBanConfig
+
+	reports   chan timedReport
+	pending   sync.WaitGroup // reports queued or being processed
+	closeOnce sync.Once
+	closing   chan struct{}
+	done      sync.WaitGroup
+
+	mx        sync.Mutex
+	offenders map[string]*offender
+	lastSweep time.Time
+}
+
+type timedReport struct {
+	canonicallog.MisbehaviorReport
+	time time.Time
+}
+
+type offender struct {
+	strikes []time.Time // within the window
+	bans    int         // number of consecutive bans, for the escalation
+	banEnd  time.Time   // expiry of the last ban
+}
+
+// strike records a misbehavior report, and returns the duration to ban the offender for,
+// if it reached the number of strikes.
+func (a *autoBan) strike(key string, now time.Time) (ban time.Duration, ok bool) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	a.sweep(now)
+
+	o, found := a.offenders[key]
+	if !found {
+		o = &offender{}
+		a.offenders[key] = o
+	}
+	o.strikes = append(o.recentStrikes(now, a.cfg.Window), now)
+	if len(o.strikes) < a.cfg.Strikes {
+		return 0, false
+	}
+	o.strikes = nil
+
+	if !o.banEnd.IsZero() && now.Sub(o.banEnd) >= a.cfg.MaxBanDuration {
+		o.bans = 0
+	}
+	ban = a.cfg.BanDuration
+	for i := 0; i < o.bans && ban < a.cfg.MaxBanDuration; i++ {
+		ban *= 2
+	}
+	if ban > a.cfg.MaxBanDuration {
+		ban = a.cfg.MaxBanDuration
+	}
+	o.bans++
+	o.banEnd = now.Add(ban)
+	return ban, true
+}
+
+// sweep forgets offenders that have neither recent strikes nor a ban that still counts
+// towards the escalation. It runs at most once per window.
+func (a *autoBan) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < a.cfg.Window {
+		return
+	}
+	a.lastSweep = now
+	for key, o := range a.offenders {
+		if len(o.recentStrikes(now, a.cfg.Window)) == 0 && now.Sub(o.banEnd) >= a.cfg.MaxBanDuration {
+			delete(a.offenders, key)
+		}
+	}
+}
+
+func (o *offender) recentStrikes(now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for i < len(o.strikes) && now.Sub(o.strikes[i]) >= window {
+		i++
+	}
+	return o.strikes[i:]
+}
+
+// ReportMisbehavior records a misbehavior report, and blocks the peer, and its IP address if
+// AutoBanConfig.BlockIP is set, once it reached the configured number of strikes.
+// The report is processed in the background, so this never blocks; reports are dropped
+// if too many are queued. It is a no-op if auto banning isn't enabled.
+func (cg *BasicConnectionGater) ReportMisbehavior(r canonicallog.MisbehaviorReport) {
+	a := cg.autoBan
+	if a == nil {
+		return
+	}
+	select {
+	case <-a.closing:
+		return
+	default:
+	}
+
+	a.pending.Add(1)
+	select {
+	case a.reports <- timedReport{MisbehaviorReport: r, time: cg.clock.Now()}:
+	default:
+		a.pending.Done()
+		log.Debugw("dropping misbehavior report, too many queued", "peer", r.Peer)
+	}
+}
+
+func (cg *BasicConnectionGater) startAutoBan() {
+	a := cg.autoBan
+	if !a.cfg.ManualReportsOnly {
+		cg.removeAutoBanHook = canonicallog.AddMisbehaviorHook(cg.ReportMisbehavior)
+	}
+	a.done.Add(1)
+	go func() {
+		defer a.done.Done()
+		for {
+			select {
+			case r := <-a.reports:
+				cg.processReport(r)
+				a.pending.Done()
+			case <-a.closing:
+				return
+			}
+		}
+	}()
+}
+
+func (cg *BasicConnectionGater) stopAutoBan() {
+	if cg.removeAutoBanHook != nil {
+		cg.removeAutoBanHook()
+	}
+	a := cg.autoBan
+	a.closeOnce.Do(func() { close(a.closing) })
+	a.done.Wait()
+}
+
+func (cg *BasicConnectionGater) processReport(r timedReport) {
+	a := cg.autoBan
+	if r.Peer != "" {
+		if d, ok := a.strike("peer:"+string(r.Peer), r.time); ok {
+			log.Infow("blocking misbehaving peer", "peer", r.Peer, "duration", d)
+			if err := cg.BlockPeerFor(r.Peer, d); err != nil {
+				log.Warnw("failed to block misbehaving peer", "peer", r.Peer, "error", err)
+			}
+		}
+	}
+
+	if !a.cfg.BlockIP || r.Addr == nil {
+		return
+	}
+	// The IP address of a relayed connection belongs to the relay.
+	if _, err := r.Addr.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+		return
+	}
+	ip, err := manet.ToIP(r.Addr)
+	if err != nil {
+		return
+	}
+	if d, ok := a.strike("ip:"+ip.String(), r.time); ok {
+		log.Infow("blocking IP address of misbehaving peers", "ip", ip, "duration", d)
+		if err := cg.BlockAddrFor(ip, d); err != nil {
+			log.Warnw("failed to block IP address of misbehaving peers", "ip", ip, "error", err)
+		}
+	}
+}
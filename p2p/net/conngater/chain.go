@@ -0,0 +1,288 @@
+package conngater
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ChainMode determines how a Chain combines the decisions of its gaters.
+type ChainMode int
+
+const (
+	// ChainAll allows a connection if all gaters allow it.
+	// The gaters are consulted in order, up to the first one that denies the connection.
+	ChainAll ChainMode = iota
+	// ChainAny allows a connection if any gater allows it.
+	// The gaters are consulted in order, up to the first one that allows the connection.
+	ChainAny
+)
+
+// NamedGater is a connection gater with a name that identifies it in denials.
+type NamedGater struct {
+	Name string
+	connmgr.ConnectionGater
+}
+
+// maxQueuedGatedEvents is the maximum number of EvtConnectionGated events queued for emission.
+// Further events are dropped until the event bus catches up.
+const maxQueuedGatedEvents = 256
+
+// ChainOption is an option for the Chain.
+type ChainOption func(*Chain) error
+
+// WithChainMetrics counts the denials of the chain in the libp2p_conngater_denials_total
+// metric, labeled by gater and stage, and registers it with reg. Chains registering with
+// the same registry share the metric.
+func WithChainMetrics(reg prometheus.Registerer) ChainOption {
+	return func(c *Chain) error {
+		denials := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "libp2p_conngater_denials_total",
+			Help: "Connections denied by connection gaters",
+		}, []string{"gater", "stage"})
+		if err := reg.Register(denials); err != nil {
+			are, ok := err.(prometheus.AlreadyRegisteredError)
+			if !ok {
+				return err
+			}
+			denials, ok = are.ExistingCollector.(*prometheus.CounterVec)
+			if !ok {
+				return errors.New("libp2p_conngater_denials_total registered with a different type")
+			}
+		}
+		c.denials = denials
+		return nil
+	}
+}
+
+// Chain is a connection gater that combines several gaters, see ChainMode.
+// When a connection is denied, the chain logs the gater that denied it, counts the
+// denial if metrics are enabled, and emits an EvtConnectionGated on the event bus.
+// In ChainAny mode, denials are attributed to the last gater.
+type Chain struct {
+	mode   ChainMode
+	gaters []NamedGater
+
+	denials *prometheus.CounterVec
+
+	eventsMx      sync.Mutex
+	emitter       event.Emitter
+	events        []event.EvtConnectionGated
+	emitting      bool
+	droppedEvents int
+}
+
+var (
+	_ connmgr.ConnectionGater = (*Chain)(nil)
+	_ connmgr.GaterNotifee    = (*Chain)(nil)
+	_ event.BusSetter         = (*Chain)(nil)
+)
+
+// NewChain creates a gater that combines gaters according to mode.
+func NewChain(mode ChainMode, gaters []NamedGater, opts ...ChainOption) (*Chain, error) {
+	if mode != ChainAll && mode != ChainAny {
+		return nil, fmt.Errorf("invalid chain mode: %d", mode)
+	}
+	if len(gaters) == 0 {
+		return nil, errors.New("chain needs at least one gater")
+	}
+	names := make(map[string]struct{}, len(gaters))
+	for _, g := range gaters {
+		if g.ConnectionGater == nil {
+			return nil, fmt.Errorf("gater %q is nil", g.Name)
+		}
+		if _, ok := names[g.Name]; ok {
+			return nil, fmt.Errorf("duplicate gater name: %q", g.Name)
+		}
+		names[g.Name] = struct{}{}
+	}
+
+	c := &Chain{mode: mode, gaters: gaters}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// SetEventBus sets the event bus that denials are emitted on as EvtConnectionGated.
+// The host calls this when it is constructed with the chain as its connection gater.
+func (c *Chain) SetEventBus(bus event.Bus) error {
+	emitter, err := bus.Emitter(&event.EvtConnectionGated{})
+	if err != nil {
+		return err
+	}
+
+	c.eventsMx.Lock()
+	defer c.eventsMx.Unlock()
+	if c.emitter != nil {
+		c.emitter.Close()
+	}
+	c.emitter = emitter
+	return nil
+}
+
+// Notifee returns a notifiee that forwards connection events to the gaters that
+// implement connmgr.GaterNotifee. The host registers it with the network when it is
+// constructed with the chain as its connection gater.
+func (c *Chain) Notifee() network.Notifiee {
+	var ns chainNotifee
+	for _, g := range c.gaters {
+		if gn, ok := g.ConnectionGater.(connmgr.GaterNotifee); ok {
+			ns = append(ns, gn.Notifee())
+		}
+	}
+	return ns
+}
+
+type chainNotifee []network.Notifiee
+
+func (ns chainNotifee) Connected(n network.Network, c network.Conn) {
+	for _, nn := range ns {
+		nn.Connected(n, c)
+	}
+}
+
+func (ns chainNotifee) Disconnected(n network.Network, c network.Conn) {
+	for _, nn := range ns {
+		nn.Disconnected(n, c)
+	}
+}
+
+func (ns chainNotifee) Listen(n network.Network, a ma.Multiaddr) {
+	for _, nn := range ns {
+		nn.Listen(n, a)
+	}
+}
+
+func (ns chainNotifee) ListenClose(n network.Network, a ma.Multiaddr) {
+	for _, nn := range ns {
+		nn.ListenClose(n, a)
+	}
+}
+
+// evaluate consults the gaters, and returns the gater that decided, and its disconnect reason.
+func (c *Chain) evaluate(intercept func(connmgr.ConnectionGater) (bool, control.DisconnectReason)) (allow bool, reason control.DisconnectReason, gater string) {
+	for _, g := range c.gaters {
+		allow, reason = intercept(g.ConnectionGater)
+		gater = g.Name
+		if allow == (c.mode == ChainAny) {
+			break
+		}
+	}
+	return allow, reason, gater
+}
+
+func (c *Chain) denied(evt event.EvtConnectionGated) {
+	log.Debugw("connection gated", "gater", evt.Gater, "stage", evt.Stage, "peer", evt.Peer, "addr", evt.Addr)
+	if c.denials != nil {
+		c.denials.WithLabelValues(evt.Gater, string(evt.Stage)).Inc()
+	}
+
+	c.eventsMx.Lock()
+	defer c.eventsMx.Unlock()
+
+	if c.emitter == nil {
+		return
+	}
+	if len(c.events) >= maxQueuedGatedEvents {
+		c.droppedEvents++
+		return
+	}
+	c.events = append(c.events, evt)
+	if !c.emitting {
+		// Emitting may block, so it's done in the background, to not stall connection establishment.
+		c.emitting = true
+		go c.emitEvents()
+	}
+}
+
+func (c *Chain) emitEvents() {
+	for {
+		c.eventsMx.Lock()
+		if len(c.events) == 0 {
+			if c.droppedEvents > 0 {
+				log.Warnw("dropped connection gated events", "count", c.droppedEvents)
+				c.droppedEvents = 0
+			}
+			c.emitting = false
+			c.eventsMx.Unlock()
+			return
+		}
+		evt := c.events[0]
+		c.events = c.events[1:]
+		emitter := c.emitter
+		c.eventsMx.Unlock()
+
+		if err := emitter.Emit(evt); err != nil {
+			log.Warnw("failed to emit connection gated event", "error", err)
+		}
+	}
+}
+
+func (c *Chain) InterceptPeerDial(p peer.ID) (allow bool) {
+	allow, _, gater := c.evaluate(func(g connmgr.ConnectionGater) (bool, control.DisconnectReason) {
+		return g.InterceptPeerDial(p), 0
+	})
+	if !allow {
+		c.denied(event.EvtConnectionGated{Gater: gater, Stage: event.GatingStagePeerDial, Direction: network.DirOutbound, Peer: p})
+	}
+	return allow
+}
+
+func (c *Chain) InterceptAddrDial(p peer.ID, a ma.Multiaddr) (allow bool) {
+	allow, _, gater := c.evaluate(func(g connmgr.ConnectionGater) (bool, control.DisconnectReason) {
+		return g.InterceptAddrDial(p, a), 0
+	})
+	if !allow {
+		c.denied(event.EvtConnectionGated{Gater: gater, Stage: event.GatingStageAddrDial, Direction: network.DirOutbound, Peer: p, Addr: a})
+	}
+	return allow
+}
+
+func (c *Chain) InterceptAccept(cma network.ConnMultiaddrs) (allow bool) {
+	allow, _, gater := c.evaluate(func(g connmgr.ConnectionGater) (bool, control.DisconnectReason) {
+		return g.InterceptAccept(cma), 0
+	})
+	if !allow {
+		c.denied(event.EvtConnectionGated{Gater: gater, Stage: event.GatingStageAccept, Direction: network.DirInbound, Addr: cma.RemoteMultiaddr()})
+	}
+	return allow
+}
+
+func (c *Chain) InterceptSecured(dir network.Direction, p peer.ID, cma network.ConnMultiaddrs) (allow bool) {
+	allow, _, gater := c.evaluate(func(g connmgr.ConnectionGater) (bool, control.DisconnectReason) {
+		return g.InterceptSecured(dir, p, cma), 0
+	})
+	if !allow {
+		c.denied(event.EvtConnectionGated{Gater: gater, Stage: event.GatingStageSecured, Direction: dir, Peer: p, Addr: cma.RemoteMultiaddr()})
+	}
+	return allow
+}
+
+func (c *Chain) InterceptUpgraded(conn network.Conn) (allow bool, reason control.DisconnectReason) {
+	allow, reason, gater := c.evaluate(func(g connmgr.ConnectionGater) (bool, control.DisconnectReason) {
+		return g.InterceptUpgraded(conn)
+	})
+	if !allow {
+		c.denied(event.EvtConnectionGated{
+			Gater:     gater,
+			Stage:     event.GatingStageUpgraded,
+			Direction: conn.Stat().Direction,
+			Peer:      conn.RemotePeer(),
+			Addr:      conn.RemoteMultiaddr(),
+			Reason:    reason,
+		})
+	}
+	return allow, reason
+}
@@ -0,0 +1,141 @@
+package conngater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// testGater allows or denies all connections, and counts how often it was consulted.
+type testGater struct {
+	allow  bool
+	reason control.DisconnectReason
+	calls  int
+}
+
+var _ connmgr.ConnectionGater = (*testGater)(nil)
+
+func (g *testGater) InterceptPeerDial(peer.ID) bool {
+	g.calls++
+	return g.allow
+}
+
+func (g *testGater) InterceptAddrDial(peer.ID, ma.Multiaddr) bool {
+	g.calls++
+	return g.allow
+}
+
+func (g *testGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	g.calls++
+	return g.allow
+}
+
+func (g *testGater) InterceptSecured(network.Direction, peer.ID, network.ConnMultiaddrs) bool {
+	g.calls++
+	return g.allow
+}
+
+func (g *testGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	g.calls++
+	return g.allow, g.reason
+}
+
+type chainTestConn struct {
+	network.Conn
+}
+
+func (c *chainTestConn) RemotePeer() peer.ID           { return peer.ID("A") }
+func (c *chainTestConn) RemoteMultiaddr() ma.Multiaddr { return ma.StringCast("/ip4/1.2.3.4/tcp/1234") }
+func (c *chainTestConn) Stat() network.ConnStats {
+	return network.ConnStats{Stats: network.Stats{Direction: network.DirInbound}}
+}
+
+func TestChainAll(t *testing.T) {
+	allow := &testGater{allow: true}
+	deny := &testGater{reason: 42}
+	after := &testGater{allow: true}
+	reg := prometheus.NewRegistry()
+	c, err := NewChain(ChainAll, []NamedGater{{"allow", allow}, {"deny", deny}, {"after", after}}, WithChainMetrics(reg))
+	require.NoError(t, err)
+
+	bus := eventbus.NewBus()
+	require.NoError(t, c.SetEventBus(bus))
+	sub, err := bus.Subscribe(new(event.EvtConnectionGated))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	ok, reason := c.InterceptUpgraded(&chainTestConn{})
+	require.False(t, ok)
+	require.Equal(t, control.DisconnectReason(42), reason)
+	// evaluation stops at the first denial
+	require.Equal(t, 1, allow.calls)
+	require.Equal(t, 1, deny.calls)
+	require.Zero(t, after.calls)
+
+	select {
+	case evt := <-sub.Out():
+		require.Equal(t, event.EvtConnectionGated{
+			Gater:     "deny",
+			Stage:     event.GatingStageUpgraded,
+			Direction: network.DirInbound,
+			Peer:      peer.ID("A"),
+			Addr:      ma.StringCast("/ip4/1.2.3.4/tcp/1234"),
+			Reason:    42,
+		}, evt)
+	case <-time.After(time.Second):
+		t.Fatal("expected a connection gated event")
+	}
+
+	require.False(t, c.InterceptPeerDial(peer.ID("A")))
+	require.False(t, c.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/1.2.3.4/tcp/1234")}))
+	require.Equal(t, 1.0, testutil.ToFloat64(c.denials.WithLabelValues("deny", "upgraded")))
+	require.Equal(t, 1.0, testutil.ToFloat64(c.denials.WithLabelValues("deny", "accept")))
+
+	// a second chain shares the metric
+	c2, err := NewChain(ChainAll, []NamedGater{{"deny", deny}}, WithChainMetrics(reg))
+	require.NoError(t, err)
+	require.False(t, c2.InterceptPeerDial(peer.ID("A")))
+	require.Equal(t, 2.0, testutil.ToFloat64(c.denials.WithLabelValues("deny", "peer_dial")))
+}
+
+func TestChainAny(t *testing.T) {
+	deny1 := &testGater{reason: 1}
+	deny2 := &testGater{reason: 2}
+	allow := &testGater{allow: true}
+
+	c, err := NewChain(ChainAny, []NamedGater{{"deny1", deny1}, {"allow", allow}, {"deny2", deny2}})
+	require.NoError(t, err)
+	require.True(t, c.InterceptSecured(network.DirInbound, peer.ID("A"), &mockConnMultiaddrs{}))
+	// evaluation stops at the first gater that allows the connection
+	require.Equal(t, 1, deny1.calls)
+	require.Zero(t, deny2.calls)
+
+	// the denial is attributed to the last gater
+	c, err = NewChain(ChainAny, []NamedGater{{"deny1", deny1}, {"deny2", deny2}})
+	require.NoError(t, err)
+	ok, reason := c.InterceptUpgraded(&chainTestConn{})
+	require.False(t, ok)
+	require.Equal(t, control.DisconnectReason(2), reason)
+}
+
+func TestChainConfig(t *testing.T) {
+	_, err := NewChain(ChainAll, nil)
+	require.Error(t, err)
+	_, err = NewChain(ChainMode(42), []NamedGater{{"a", &testGater{}}})
+	require.Error(t, err)
+	_, err = NewChain(ChainAll, []NamedGater{{"a", &testGater{}}, {"a", &testGater{}}})
+	require.Error(t, err)
+	_, err = NewChain(ChainAll, []NamedGater{{"a", nil}})
+	require.Error(t, err)
+}
@@ -13,6 +13,7 @@ import (
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 
+	"github.com/benbjohnson/clock"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
 	"github.com/ipfs/go-datastore/query"
@@ -28,7 +29,29 @@ type BasicConnectionGater struct {
 	blockedAddrs   map[string]struct{}
 	blockedSubnets map[string]*net.IPNet
 
-	ds datastore.Datastore
+	expiringPeers   expiringBlocks
+	expiringAddrs   expiringBlocks
+	expiringSubnets expiringBlocks
+	// expiringDsMx serializes the writes of expiring blocks to the datastore,
+	// which happen outside of the main lock.
+	expiringDsMx sync.Mutex
+
+	ds    datastore.Datastore
+	clock clock.Clock
+
+	autoBan           *autoBan
+	removeAutoBanHook func()
+}
+
+// Option is an option for the BasicConnectionGater.
+type Option func(*BasicConnectionGater) error
+
+// WithClock sets the clock used to expire blocks.
+func WithClock(c clock.Clock) Option {
+	return func(cg *BasicConnectionGater) error {
+		cg.clock = c
+		return nil
+	}
 }
 
 var log = logging.Logger("net/conngater")
@@ -43,11 +66,20 @@ const (
 // NewBasicConnectionGater creates a new connection gater.
 // The ds argument is an (optional, can be nil) datastore to persist the connection gater
 // filters.
-func NewBasicConnectionGater(ds datastore.Datastore) (*BasicConnectionGater, error) {
+func NewBasicConnectionGater(ds datastore.Datastore, opts ...Option) (*BasicConnectionGater, error) {
 	cg := &BasicConnectionGater{
-		blockedPeers:   make(map[peer.ID]struct{}),
-		blockedAddrs:   make(map[string]struct{}),
-		blockedSubnets: make(map[string]*net.IPNet),
+		blockedPeers:    make(map[peer.ID]struct{}),
+		blockedAddrs:    make(map[string]struct{}),
+		blockedSubnets:  make(map[string]*net.IPNet),
+		expiringPeers:   make(expiringBlocks),
+		expiringAddrs:   make(expiringBlocks),
+		expiringSubnets: make(expiringBlocks),
+		clock:           clock.New(),
+	}
+	for _, opt := range opts {
+		if err := opt(cg); err != nil {
+			return nil, err
+		}
 	}
 
 	if ds != nil {
@@ -56,11 +88,38 @@ func NewBasicConnectionGater(ds datastore.Datastore) (*BasicConnectionGater, err
 		if err != nil {
 			return nil, err
 		}
+		if err := cg.loadExpiringRules(context.Background()); err != nil {
+			cg.Close()
+			return nil, err
+		}
+	}
+
+	if cg.autoBan != nil {
+		cg.startAutoBan()
 	}
 
 	return cg, nil
 }
 
+// Close stops consuming misbehavior reports, and stops the timers expiring blocks.
+// Blocks persisted in the datastore are loaded again by the next connection gater.
+func (cg *BasicConnectionGater) Close() error {
+	if cg.autoBan != nil {
+		cg.stopAutoBan()
+	}
+
+	cg.Lock()
+	defer cg.Unlock()
+
+	for _, blocks := range []expiringBlocks{cg.expiringPeers, cg.expiringAddrs, cg.expiringSubnets} {
+		for key, b := range blocks {
+			b.timer.Stop()
+			delete(blocks, key)
+		}
+	}
+	return nil
+}
+
 func (cg *BasicConnectionGater) loadRules(ctx context.Context) error {
 	// load blocked peers
 	res, err := cg.ds.Query(ctx, query.Query{Prefix: keyPeer})
@@ -149,6 +208,10 @@ func (cg *BasicConnectionGater) UnblockPeer(p peer.ID) error {
 		}
 	}
 
+	if err := cg.removeExpiring(cg.expiringPeers, string(p), keyPeerExpiring+p.String()); err != nil {
+		return err
+	}
+
 	cg.Lock()
 	defer cg.Unlock()
 
@@ -162,10 +225,15 @@ func (cg *BasicConnectionGater) ListBlockedPeers() []peer.ID {
 	cg.RLock()
 	defer cg.RUnlock()
 
-	result := make([]peer.ID, 0, len(cg.blockedPeers))
+	result := make([]peer.ID, 0, len(cg.blockedPeers)+len(cg.expiringPeers))
 	for p := range cg.blockedPeers {
 		result = append(result, p)
 	}
+	for p := range cg.expiringPeers {
+		if _, ok := cg.blockedPeers[peer.ID(p)]; !ok {
+			result = append(result, peer.ID(p))
+		}
+	}
 
 	return result
 }
@@ -199,6 +267,10 @@ func (cg *BasicConnectionGater) UnblockAddr(ip net.IP) error {
 		}
 	}
 
+	if err := cg.removeExpiring(cg.expiringAddrs, ip.String(), keyAddrExpiring+ip.String()); err != nil {
+		return err
+	}
+
 	cg.Lock()
 	defer cg.Unlock()
 
@@ -212,11 +284,16 @@ func (cg *BasicConnectionGater) ListBlockedAddrs() []net.IP {
 	cg.RLock()
 	defer cg.RUnlock()
 
-	result := make([]net.IP, 0, len(cg.blockedAddrs))
+	result := make([]net.IP, 0, len(cg.blockedAddrs)+len(cg.expiringAddrs))
 	for ipStr := range cg.blockedAddrs {
 		ip := net.ParseIP(ipStr)
 		result = append(result, ip)
 	}
+	for ipStr := range cg.expiringAddrs {
+		if _, ok := cg.blockedAddrs[ipStr]; !ok {
+			result = append(result, net.ParseIP(ipStr))
+		}
+	}
 
 	return result
 }
@@ -250,6 +327,10 @@ func (cg *BasicConnectionGater) UnblockSubnet(ipnet *net.IPNet) error {
 		}
 	}
 
+	if err := cg.removeExpiring(cg.expiringSubnets, ipnet.String(), keySubnetExpiring+ipnet.String()); err != nil {
+		return err
+	}
+
 	cg.Lock()
 	defer cg.Unlock()
 
@@ -263,10 +344,15 @@ func (cg *BasicConnectionGater) ListBlockedSubnets() []*net.IPNet {
 	cg.RLock()
 	defer cg.RUnlock()
 
-	result := make([]*net.IPNet, 0, len(cg.blockedSubnets))
+	result := make([]*net.IPNet, 0, len(cg.blockedSubnets)+len(cg.expiringSubnets))
 	for _, ipnet := range cg.blockedSubnets {
 		result = append(result, ipnet)
 	}
+	for key, b := range cg.expiringSubnets {
+		if _, ok := cg.blockedSubnets[key]; !ok {
+			result = append(result, b.ipnet)
+		}
+	}
 
 	return result
 }
@@ -278,8 +364,7 @@ func (cg *BasicConnectionGater) InterceptPeerDial(p peer.ID) (allow bool) {
 	cg.RLock()
 	defer cg.RUnlock()
 
-	_, block := cg.blockedPeers[p]
-	return !block
+	return !cg.isPeerBlockedLocked(p)
 }
 
 func (cg *BasicConnectionGater) InterceptAddrDial(p peer.ID, a ma.Multiaddr) (allow bool) {
@@ -293,18 +378,7 @@ func (cg *BasicConnectionGater) InterceptAddrDial(p peer.ID, a ma.Multiaddr) (al
 		return true
 	}
 
-	_, block := cg.blockedAddrs[ip.String()]
-	if block {
-		return false
-	}
-
-	for _, ipnet := range cg.blockedSubnets {
-		if ipnet.Contains(ip) {
-			return false
-		}
-	}
-
-	return true
+	return !cg.isIPBlockedLocked(ip)
 }
 
 func (cg *BasicConnectionGater) InterceptAccept(cma network.ConnMultiaddrs) (allow bool) {
@@ -319,18 +393,7 @@ func (cg *BasicConnectionGater) InterceptAccept(cma network.ConnMultiaddrs) (all
 		return true
 	}
 
-	_, block := cg.blockedAddrs[ip.String()]
-	if block {
-		return false
-	}
-
-	for _, ipnet := range cg.blockedSubnets {
-		if ipnet.Contains(ip) {
-			return false
-		}
-	}
-
-	return true
+	return !cg.isIPBlockedLocked(ip)
 }
 
 func (cg *BasicConnectionGater) InterceptSecured(dir network.Direction, p peer.ID, cma network.ConnMultiaddrs) (allow bool) {
@@ -343,10 +406,41 @@ func (cg *BasicConnectionGater) InterceptSecured(dir network.Direction, p peer.I
 	cg.RLock()
 	defer cg.RUnlock()
 
-	_, block := cg.blockedPeers[p]
-	return !block
+	return !cg.isPeerBlockedLocked(p)
 }
 
 func (cg *BasicConnectionGater) InterceptUpgraded(network.Conn) (allow bool, reason control.DisconnectReason) {
 	return true, 0
 }
+
+// isPeerBlockedLocked reports whether a peer is blocked. cg must be read-locked.
+func (cg *BasicConnectionGater) isPeerBlockedLocked(p peer.ID) bool {
+	if _, block := cg.blockedPeers[p]; block {
+		return true
+	}
+	_, block := cg.expiringPeers[string(p)]
+	return block
+}
+
+// isIPBlockedLocked reports whether an IP address is blocked. cg must be read-locked.
+func (cg *BasicConnectionGater) isIPBlockedLocked(ip net.IP) bool {
+	if _, block := cg.blockedAddrs[ip.String()]; block {
+		return true
+	}
+	if _, block := cg.expiringAddrs[ip.String()]; block {
+		return true
+	}
+
+	for _, ipnet := range cg.blockedSubnets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	for _, b := range cg.expiringSubnets {
+		if b.ipnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,219 @@
+package conngater
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/benbjohnson/clock"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// Blocks with an expiry are stored under separate keys. Their values are prefixed
+// with the expiry time, in nanoseconds since the epoch.
+const (
+	keyPeerExpiring   = "/peer-expiring/"
+	keyAddrExpiring   = "/addr-expiring/"
+	keySubnetExpiring = "/subnet-expiring/"
+)
+
+// expiringBlock is a block that is removed when it expires.
+type expiringBlock struct {
+	expires time.Time
+	timer   *clock.Timer
+	ipnet   *net.IPNet // only set for subnets
+}
+
+// expiringBlocks is a set of blocks with an expiry, keyed by the string representation
+// of the blocked peer, IP address or subnet.
+type expiringBlocks map[string]*expiringBlock
+
+// BlockPeerFor blocks a peer for the duration d. The block is removed automatically when it
+// expires. If the peer is already blocked for longer, the block is left unchanged.
+// Note: active connections to the peer are not automatically closed.
+func (cg *BasicConnectionGater) BlockPeerFor(p peer.ID, d time.Duration) error {
+	return cg.blockFor(cg.expiringPeers, string(p), keyPeerExpiring+p.String(), []byte(p), nil, d)
+}
+
+// BlockAddrFor blocks an IP address for the duration d. The block is removed automatically when it
+// expires. If the address is already blocked for longer, the block is left unchanged.
+// Note: active connections to the IP address are not automatically closed.
+func (cg *BasicConnectionGater) BlockAddrFor(ip net.IP, d time.Duration) error {
+	return cg.blockFor(cg.expiringAddrs, ip.String(), keyAddrExpiring+ip.String(), []byte(ip), nil, d)
+}
+
+// BlockSubnetFor blocks an IP subnet for the duration d. The block is removed automatically when it
+// expires. If the subnet is already blocked for longer, the block is left unchanged.
+// Note: active connections to the IP subnet are not automatically closed.
+func (cg *BasicConnectionGater) BlockSubnetFor(ipnet *net.IPNet, d time.Duration) error {
+	return cg.blockFor(cg.expiringSubnets, ipnet.String(), keySubnetExpiring+ipnet.String(), []byte(ipnet.String()), ipnet, d)
+}
+
+func (cg *BasicConnectionGater) blockFor(blocks expiringBlocks, key, dsKey string, value []byte, ipnet *net.IPNet, d time.Duration) error {
+	if d <= 0 {
+		return errors.New("block duration must be positive")
+	}
+	expires := cg.clock.Now().Add(d)
+
+	cg.Lock()
+	if b, ok := blocks[key]; ok && !b.expires.Before(expires) {
+		cg.Unlock()
+		return nil
+	}
+	cg.addExpiringLocked(blocks, key, dsKey, ipnet, expires)
+	cg.Unlock()
+
+	// The block is persisted outside of the lock, to not stall the interceptors on the datastore.
+	if cg.ds != nil {
+		if err := cg.persistExpiring(blocks, key, dsKey, value); err != nil {
+			log.Errorf("error writing expiring block to datastore: %s", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// persistExpiring writes the current state of a block to the datastore. Concurrent writes are
+// serialized, and every write persists the latest state, so the datastore ends up in the state
+// of the last change, even if the block expires or is replaced while it's written.
+func (cg *BasicConnectionGater) persistExpiring(blocks expiringBlocks, key, dsKey string, value []byte) error {
+	cg.expiringDsMx.Lock()
+	defer cg.expiringDsMx.Unlock()
+
+	for {
+		cg.RLock()
+		b := blocks[key]
+		cg.RUnlock()
+
+		var err error
+		if b == nil {
+			err = cg.ds.Delete(context.Background(), datastore.NewKey(dsKey))
+		} else {
+			buf := make([]byte, 8+len(value))
+			binary.BigEndian.PutUint64(buf, uint64(b.expires.UnixNano()))
+			copy(buf[8:], value)
+			err = cg.ds.Put(context.Background(), datastore.NewKey(dsKey), buf)
+		}
+		if err != nil {
+			return err
+		}
+
+		cg.RLock()
+		changed := blocks[key] != b
+		cg.RUnlock()
+		if !changed {
+			return nil
+		}
+	}
+}
+
+// addExpiringLocked adds a block, and schedules its removal. cg must be locked.
+func (cg *BasicConnectionGater) addExpiringLocked(blocks expiringBlocks, key, dsKey string, ipnet *net.IPNet, expires time.Time) {
+	if b, ok := blocks[key]; ok {
+		b.timer.Stop()
+	}
+	b := &expiringBlock{expires: expires, ipnet: ipnet}
+	b.timer = cg.clock.AfterFunc(expires.Sub(cg.clock.Now()), func() { cg.expire(blocks, key, dsKey, b) })
+	blocks[key] = b
+}
+
+func (cg *BasicConnectionGater) expire(blocks expiringBlocks, key, dsKey string, b *expiringBlock) {
+	cg.Lock()
+	defer cg.Unlock()
+
+	if blocks[key] != b {
+		// the block was removed or replaced in the meantime.
+		return
+	}
+	delete(blocks, key)
+	if cg.ds != nil {
+		if err := cg.ds.Delete(context.Background(), datastore.NewKey(dsKey)); err != nil {
+			log.Errorf("error deleting expired block from datastore: %s", err)
+		}
+	}
+}
+
+// removeExpiring removes a block before it expires.
+func (cg *BasicConnectionGater) removeExpiring(blocks expiringBlocks, key, dsKey string) error {
+	if cg.ds != nil {
+		if err := cg.ds.Delete(context.Background(), datastore.NewKey(dsKey)); err != nil {
+			log.Errorf("error deleting expiring block from datastore: %s", err)
+			return err
+		}
+	}
+
+	cg.Lock()
+	defer cg.Unlock()
+
+	if b, ok := blocks[key]; ok {
+		b.timer.Stop()
+		delete(blocks, key)
+	}
+	return nil
+}
+
+func (cg *BasicConnectionGater) loadExpiringRules(ctx context.Context) error {
+	load := func(prefix string, add func(value []byte, dsKey string, expires time.Time) error) error {
+		res, err := cg.ds.Query(ctx, query.Query{Prefix: prefix})
+		if err != nil {
+			log.Errorf("error querying datastore for expiring blocks: %s", err)
+			return err
+		}
+		defer res.Close()
+
+		now := cg.clock.Now()
+		for r := range res.Next() {
+			if r.Error != nil {
+				log.Errorf("query result error: %s", r.Error)
+				return r.Error
+			}
+			if len(r.Entry.Value) < 8 {
+				log.Errorf("invalid expiring block in datastore: %s", r.Entry.Key)
+				continue
+			}
+			expires := time.Unix(0, int64(binary.BigEndian.Uint64(r.Entry.Value)))
+			if !expires.After(now) {
+				if err := cg.ds.Delete(ctx, datastore.NewKey(r.Entry.Key)); err != nil {
+					log.Errorf("error deleting expired block from datastore: %s", err)
+				}
+				continue
+			}
+			if err := add(r.Entry.Value[8:], r.Entry.Key, expires); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	cg.Lock()
+	defer cg.Unlock()
+
+	err := load(keyPeerExpiring, func(value []byte, dsKey string, expires time.Time) error {
+		cg.addExpiringLocked(cg.expiringPeers, string(value), dsKey, nil, expires)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = load(keyAddrExpiring, func(value []byte, dsKey string, expires time.Time) error {
+		cg.addExpiringLocked(cg.expiringAddrs, net.IP(value).String(), dsKey, nil, expires)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return load(keySubnetExpiring, func(value []byte, dsKey string, expires time.Time) error {
+		_, ipnet, err := net.ParseCIDR(string(value))
+		if err != nil {
+			log.Errorf("error parsing CIDR subnet: %s", err)
+			return err
+		}
+		cg.addExpiringLocked(cg.expiringSubnets, ipnet.String(), dsKey, ipnet, expires)
+		return nil
+	})
+}
@@ -0,0 +1,161 @@
+package conngater
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/canonicallog"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/benbjohnson/clock"
+	"github.com/ipfs/go-datastore"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiringBlocks(t *testing.T) {
+	ds := datastore.NewMapDatastore()
+	mockClock := clock.NewMock()
+	cg, err := NewBasicConnectionGater(ds, WithClock(mockClock))
+	require.NoError(t, err)
+
+	peerA := peer.ID("A")
+	ip := net.ParseIP("1.2.3.4")
+	_, ipnet, err := net.ParseCIDR("2.3.4.0/24")
+	require.NoError(t, err)
+
+	require.Error(t, cg.BlockPeerFor(peerA, 0))
+	require.NoError(t, cg.BlockPeerFor(peerA, time.Minute))
+	require.NoError(t, cg.BlockAddrFor(ip, 2*time.Minute))
+	require.NoError(t, cg.BlockSubnetFor(ipnet, 3*time.Minute))
+	// a shorter block doesn't shorten the existing one
+	require.NoError(t, cg.BlockPeerFor(peerA, time.Second))
+
+	require.False(t, cg.InterceptPeerDial(peerA))
+	require.False(t, cg.InterceptAddrDial(peerA, ma.StringCast("/ip4/1.2.3.4/tcp/1234")))
+	require.False(t, cg.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/2.3.4.5/tcp/1234")}))
+	require.Equal(t, []peer.ID{peerA}, cg.ListBlockedPeers())
+	require.Len(t, cg.ListBlockedAddrs(), 1)
+	require.Len(t, cg.ListBlockedSubnets(), 1)
+
+	// the blocks are persisted
+	mockClock.Add(90 * time.Second)
+	require.NoError(t, cg.Close())
+	cg, err = NewBasicConnectionGater(ds, WithClock(mockClock))
+	require.NoError(t, err)
+	defer cg.Close()
+	require.True(t, cg.InterceptPeerDial(peerA))
+	require.False(t, cg.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/1.2.3.4/tcp/1234")}))
+	require.False(t, cg.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/2.3.4.5/tcp/1234")}))
+
+	// the blocks expire
+	mockClock.Add(time.Minute)
+	require.True(t, cg.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/1.2.3.4/tcp/1234")}))
+	require.False(t, cg.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/2.3.4.5/tcp/1234")}))
+	require.NoError(t, cg.UnblockSubnet(ipnet))
+	require.True(t, cg.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/2.3.4.5/tcp/1234")}))
+	require.Empty(t, cg.ListBlockedAddrs())
+	require.Empty(t, cg.ListBlockedSubnets())
+
+	// and are removed from the datastore
+	mockClock.Add(time.Hour)
+	cg2, err := NewBasicConnectionGater(ds, WithClock(mockClock))
+	require.NoError(t, err)
+	defer cg2.Close()
+	require.Empty(t, cg2.ListBlockedPeers())
+	require.Empty(t, cg2.ListBlockedAddrs())
+	require.Empty(t, cg2.ListBlockedSubnets())
+}
+
+func TestAutoBan(t *testing.T) {
+	mockClock := clock.NewMock()
+	cg, err := NewBasicConnectionGater(nil, WithClock(mockClock), WithAutoBan(AutoBanConfig{
+		Strikes:        3,
+		Window:         time.Minute,
+		BanDuration:    time.Minute,
+		MaxBanDuration: 3 * time.Minute,
+		BlockIP:        true,
+	}))
+	require.NoError(t, err)
+	defer cg.Close()
+
+	peerA := peer.ID("A")
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	misbehave := func(p peer.ID) {
+		canonicallog.LogMisbehavingPeer(p, ma.StringCast("/ip4/5.6.7.8/tcp/1234"), "test", nil, "misbehaving")
+		// reports are processed in the background
+		cg.autoBan.pending.Wait()
+	}
+
+	// strikes outside the window don't count
+	misbehave(peerA)
+	misbehave(peerA)
+	mockClock.Add(time.Minute)
+	misbehave(peerA)
+	misbehave(peerA)
+	require.True(t, cg.InterceptPeerDial(peerA))
+	misbehave(peerA)
+	require.False(t, cg.InterceptPeerDial(peerA))
+
+	// the ban duration escalates
+	mockClock.Add(time.Minute)
+	require.True(t, cg.InterceptPeerDial(peerA))
+	for i := 0; i < 3; i++ {
+		misbehave(peerA)
+	}
+	mockClock.Add(time.Minute)
+	require.False(t, cg.InterceptPeerDial(peerA))
+	mockClock.Add(time.Minute)
+	require.True(t, cg.InterceptPeerDial(peerA))
+
+	// reports of different peers from the same IP address block the address
+	require.True(t, cg.InterceptAccept(&mockConnMultiaddrs{remote: addr}))
+	for _, p := range []peer.ID{"B", "C", "D"} {
+		canonicallog.LogMisbehavingPeer(p, addr, "test", nil, "misbehaving")
+	}
+	cg.autoBan.pending.Wait()
+	require.False(t, cg.InterceptAccept(&mockConnMultiaddrs{remote: addr}))
+	require.True(t, cg.InterceptPeerDial(peer.ID("B")))
+
+	// relayed peers don't count towards the IP address of the relay
+	relay := ma.StringCast("/ip4/9.9.9.9/tcp/1234")
+	for _, p := range []peer.ID{"F", "G", "H"} {
+		canonicallog.LogMisbehavingPeer(p, relay.Encapsulate(ma.StringCast("/p2p-circuit")), "test", nil, "misbehaving")
+	}
+	cg.autoBan.pending.Wait()
+	require.True(t, cg.InterceptAccept(&mockConnMultiaddrs{remote: relay}))
+
+	// reports are ignored after closing
+	require.NoError(t, cg.Close())
+	for i := 0; i < 3; i++ {
+		misbehave(peer.ID("E"))
+	}
+	require.True(t, cg.InterceptPeerDial(peer.ID("E")))
+}
+
+func TestAutoBanManualReports(t *testing.T) {
+	cg, err := NewBasicConnectionGater(nil, WithAutoBan(AutoBanConfig{
+		Strikes:           1,
+		Window:            time.Minute,
+		BanDuration:       time.Minute,
+		ManualReportsOnly: true,
+	}))
+	require.NoError(t, err)
+	defer cg.Close()
+
+	canonicallog.LogMisbehavingPeer(peer.ID("A"), ma.StringCast("/ip4/1.2.3.4/tcp/1234"), "test", nil, "misbehaving")
+	cg.autoBan.pending.Wait()
+	require.True(t, cg.InterceptPeerDial(peer.ID("A")))
+
+	cg.ReportMisbehavior(canonicallog.MisbehaviorReport{Peer: peer.ID("A")})
+	cg.autoBan.pending.Wait()
+	require.False(t, cg.InterceptPeerDial(peer.ID("A")))
+}
+
+func TestAutoBanConfig(t *testing.T) {
+	_, err := NewBasicConnectionGater(nil, WithAutoBan(AutoBanConfig{Window: time.Minute, BanDuration: time.Minute}))
+	require.Error(t, err)
+	_, err = NewBasicConnectionGater(nil, WithAutoBan(AutoBanConfig{Strikes: 1, Window: time.Minute, BanDuration: time.Minute, MaxBanDuration: time.Second}))
+	require.Error(t, err)
+}
@@ -0,0 +1,442 @@
+package conngater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/benbjohnson/clock"
+	asnutil "github.com/libp2p/go-libp2p-asn-util"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Policy is a set of ASN- and prefix-based gating rules. It can be read from JSON, see PolicyFromJSON.
+// ASNs are given as numbers, with or without an "AS" prefix, and prefixes in CIDR notation.
+//
+// ASN rules only apply to addresses that the ASN resolver knows. The DefaultASNResolver only
+// knows IPv6 addresses, see WithASNResolver.
+//
+// Relayed addresses are never matched, since their IP address belongs to the relay.
+// The connection to the relay itself is subject to the policy.
+type Policy struct {
+	// BlockASNs are the ASNs that connections are blocked from and to.
+	BlockASNs []string `json:",omitempty"`
+	// BlockPrefixes are the IP prefixes that connections are blocked from and to.
+	BlockPrefixes []string `json:",omitempty"`
+	// InboundAllowASNs and InboundAllowPrefixes restrict inbound connections. If any of
+	// them is set, inbound connections are only accepted from the listed ASNs and prefixes.
+	// Since inbound connections from addresses of unknown ASNs are rejected, InboundAllowASNs
+	// requires an ASN resolver that knows IPv4 addresses, set with WithASNResolver.
+	InboundAllowASNs     []string `json:",omitempty"`
+	InboundAllowPrefixes []string `json:",omitempty"`
+	// MaxPeersPerASN is the maximum number of connected peers per ASN. 0 means no limit.
+	// Peers are counted from InterceptSecured on, and the host registers the gater's
+	// notifiee to track them, see PolicyGater.Notifee.
+	MaxPeersPerASN int `json:",omitempty"`
+}
+
+// PolicyFromJSON reads a Policy from JSON. Unknown fields are rejected.
+func PolicyFromJSON(r io.Reader) (*Policy, error) {
+	var p Policy
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ASNResolver returns the ASN that an IP address belongs to, or an empty string if it's unknown.
+type ASNResolver func(ip net.IP) string
+
+// DefaultASNResolver resolves ASNs using the database embedded in go-libp2p-asn-util.
+// The database only covers IPv6 addresses, so ASN rules never match IPv4 addresses,
+// unless a different resolver is set with WithASNResolver.
+func DefaultASNResolver(ip net.IP) string {
+	if ip.To4() != nil {
+		return ""
+	}
+	asn, _ := asnutil.Store.AsnForIPv6(ip)
+	return asn
+}
+
+// PolicyOption is an option for the PolicyGater.
+type PolicyOption func(*PolicyGater) error
+
+// WithASNResolver sets the resolver used to look up the ASN of IP addresses.
+func WithASNResolver(r ASNResolver) PolicyOption {
+	return func(g *PolicyGater) error {
+		g.resolveASN = r
+		g.customResolver = true
+		return nil
+	}
+}
+
+// WithPolicyClock sets the clock used to expire connections that were secured,
+// but never reported as connected.
+func WithPolicyClock(c clock.Clock) PolicyOption {
+	return func(g *PolicyGater) error {
+		g.clock = c
+		return nil
+	}
+}
+
+// WithInnerGater sets a gater that is consulted before the policy. A connection is only
+// allowed if both the inner gater and the policy allow it. This is used to combine the
+// policy with a BasicConnectionGater.
+func WithInnerGater(inner connmgr.ConnectionGater) PolicyOption {
+	return func(g *PolicyGater) error {
+		g.inner = inner
+		return nil
+	}
+}
+
+// pendingTimeout is how long a connection that passed InterceptSecured counts against
+// MaxPeersPerASN without a Connected notification, e.g. because it failed to upgrade.
+const pendingTimeout = time.Minute
+
+// PolicyGater is a connection gater that enforces a Policy in InterceptAddrDial,
+// InterceptAccept and InterceptSecured.
+type PolicyGater struct {
+	inner          connmgr.ConnectionGater
+	innerNotifee   network.Notifiee
+	resolveASN     ASNResolver
+	customResolver bool
+	clock          clock.Clock
+
+	blockASNs       map[string]struct{}
+	blockPrefixes   []*net.IPNet
+	allowASNs       map[string]struct{}
+	allowPrefixes   []*net.IPNet
+	restrictInbound bool
+	maxPeersPerASN  int
+
+	mx       sync.Mutex
+	asnPeers map[string]map[peer.ID]int // number of connections per peer, by ASN
+	// expiry times of the connections that were secured, but aren't connected yet, by ASN
+	asnPending map[string]map[peer.ID][]time.Time
+}
+
+var (
+	_ connmgr.ConnectionGater = (*PolicyGater)(nil)
+	_ connmgr.GaterNotifee    = (*PolicyGater)(nil)
+)
+
+// NewPolicyGater creates a connection gater enforcing policy.
+func NewPolicyGater(policy *Policy, opts ...PolicyOption) (*PolicyGater, error) {
+	g := &PolicyGater{
+		resolveASN:     DefaultASNResolver,
+		blockASNs:      parseASNs(policy.BlockASNs),
+		allowASNs:      parseASNs(policy.InboundAllowASNs),
+		maxPeersPerASN: policy.MaxPeersPerASN,
+		clock:          clock.New(),
+		asnPeers:       make(map[string]map[peer.ID]int),
+		asnPending:     make(map[string]map[peer.ID][]time.Time),
+	}
+	var err error
+	if g.blockPrefixes, err = parsePrefixes(policy.BlockPrefixes); err != nil {
+		return nil, err
+	}
+	if g.allowPrefixes, err = parsePrefixes(policy.InboundAllowPrefixes); err != nil {
+		return nil, err
+	}
+	g.restrictInbound = len(g.allowASNs) > 0 || len(g.allowPrefixes) > 0
+	if g.maxPeersPerASN < 0 {
+		return nil, fmt.Errorf("invalid max peers per ASN: %d", g.maxPeersPerASN)
+	}
+
+	for _, opt := range opts {
+		if err := opt(g); err != nil {
+			return nil, err
+		}
+	}
+	if len(g.allowASNs) > 0 && !g.customResolver {
+		return nil, errors.New("inbound allowed ASNs require an ASN resolver for IPv4 addresses, see WithASNResolver")
+	}
+	if (len(g.blockASNs) > 0 || g.maxPeersPerASN > 0) && !g.customResolver {
+		log.Warn("the default ASN resolver only knows IPv6 addresses: blocked ASNs and max peers per ASN don't apply to IPv4 addresses, see WithASNResolver")
+	}
+	if n, ok := g.inner.(connmgr.GaterNotifee); ok {
+		g.innerNotifee = n.Notifee()
+	}
+	return g, nil
+}
+
+func parseASNs(asns []string) map[string]struct{} {
+	m := make(map[string]struct{}, len(asns))
+	for _, asn := range asns {
+		asn = strings.TrimSpace(asn)
+		if len(asn) > 2 && strings.EqualFold(asn[:2], "AS") {
+			asn = asn[2:]
+		}
+		m[asn] = struct{}{}
+	}
+	return m
+}
+
+func parsePrefixes(prefixes []string) ([]*net.IPNet, error) {
+	out := make([]*net.IPNet, 0, len(prefixes))
+	for _, p := range prefixes {
+		_, ipnet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix %q: %w", p, err)
+		}
+		out = append(out, ipnet)
+	}
+	return out, nil
+}
+
+func containsIP(prefixes []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range prefixes {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipOf returns the IP address of addr. It returns false for addresses without an IP address,
+// and for relayed addresses, whose IP address belongs to the relay.
+func ipOf(addr ma.Multiaddr) (net.IP, bool) {
+	if _, err := addr.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+		return nil, false
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return nil, false
+	}
+	return ip, true
+}
+
+// blocked reports whether the policy blocks connections from and to addr.
+func (g *PolicyGater) blocked(addr ma.Multiaddr) bool {
+	ip, ok := ipOf(addr)
+	if !ok {
+		return false
+	}
+	if containsIP(g.blockPrefixes, ip) {
+		return true
+	}
+	if len(g.blockASNs) == 0 {
+		return false
+	}
+	_, block := g.blockASNs[g.resolveASN(ip)]
+	return block
+}
+
+// inboundAllowed reports whether the policy allows inbound connections from addr.
+func (g *PolicyGater) inboundAllowed(addr ma.Multiaddr) bool {
+	if !g.restrictInbound {
+		return true
+	}
+	ip, ok := ipOf(addr)
+	if !ok {
+		return true
+	}
+	if containsIP(g.allowPrefixes, ip) {
+		return true
+	}
+	asn := g.resolveASN(ip)
+	if asn == "" {
+		return false
+	}
+	_, allow := g.allowASNs[asn]
+	return allow
+}
+
+func (g *PolicyGater) asnOf(addr ma.Multiaddr) string {
+	ip, ok := ipOf(addr)
+	if !ok {
+		return ""
+	}
+	return g.resolveASN(ip)
+}
+
+func (g *PolicyGater) InterceptPeerDial(p peer.ID) (allow bool) {
+	return g.inner == nil || g.inner.InterceptPeerDial(p)
+}
+
+func (g *PolicyGater) InterceptAddrDial(p peer.ID, a ma.Multiaddr) (allow bool) {
+	if g.inner != nil && !g.inner.InterceptAddrDial(p, a) {
+		return false
+	}
+	return !g.blocked(a)
+}
+
+func (g *PolicyGater) InterceptAccept(cma network.ConnMultiaddrs) (allow bool) {
+	if g.inner != nil && !g.inner.InterceptAccept(cma) {
+		return false
+	}
+	a := cma.RemoteMultiaddr()
+	return !g.blocked(a) && g.inboundAllowed(a)
+}
+
+func (g *PolicyGater) InterceptSecured(dir network.Direction, p peer.ID, cma network.ConnMultiaddrs) (allow bool) {
+	if g.inner != nil && !g.inner.InterceptSecured(dir, p, cma) {
+		return false
+	}
+	if g.maxPeersPerASN == 0 {
+		return true
+	}
+	asn := g.asnOf(cma.RemoteMultiaddr())
+	if asn == "" {
+		return true
+	}
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	peers := g.asnPeers[asn]
+	pending := g.prunePending(asn)
+	_, connected := peers[p]
+	_, isPending := pending[p]
+	if !connected && !isPending {
+		// peers with concurrent handshakes are counted, so that they can't exceed the limit together.
+		count := len(peers)
+		for pp := range pending {
+			if _, ok := peers[pp]; !ok {
+				count++
+			}
+		}
+		if count >= g.maxPeersPerASN {
+			log.Debugw("too many peers in ASN", "asn", asn, "peer", p)
+			return false
+		}
+	}
+	if pending == nil {
+		pending = make(map[peer.ID][]time.Time)
+		g.asnPending[asn] = pending
+	}
+	pending[p] = append(pending[p], g.clock.Now().Add(pendingTimeout))
+	return true
+}
+
+// prunePending removes the expired pending connections of an ASN, and returns the rest.
+// The lock must be held.
+func (g *PolicyGater) prunePending(asn string) map[peer.ID][]time.Time {
+	pending, ok := g.asnPending[asn]
+	if !ok {
+		return nil
+	}
+	now := g.clock.Now()
+	for p, expiries := range pending {
+		i := 0
+		for i < len(expiries) && !expiries[i].After(now) {
+			i++
+		}
+		if i == len(expiries) {
+			delete(pending, p)
+		} else {
+			pending[p] = expiries[i:]
+		}
+	}
+	if len(pending) == 0 {
+		delete(g.asnPending, asn)
+		return nil
+	}
+	return pending
+}
+
+func (g *PolicyGater) InterceptUpgraded(c network.Conn) (allow bool, reason control.DisconnectReason) {
+	if g.inner != nil {
+		return g.inner.InterceptUpgraded(c)
+	}
+	return true, 0
+}
+
+// Notifee returns a notifiee that tracks the connected peers per ASN, to enforce
+// Policy.MaxPeersPerASN, and forwards connection events to the inner gater if it
+// implements connmgr.GaterNotifee. The host registers it with the network when it is
+// constructed with the gater, directly or in a Chain. Otherwise, it must be registered
+// by hand, e.g.
+//
+//	h.Network().Notify(gater.Notifee())
+func (g *PolicyGater) Notifee() network.Notifiee {
+	return (*policyNotifee)(g)
+}
+
+type policyNotifee PolicyGater
+
+func (n *policyNotifee) Connected(nw network.Network, c network.Conn) {
+	g := (*PolicyGater)(n)
+	if g.innerNotifee != nil {
+		g.innerNotifee.Connected(nw, c)
+	}
+	if g.maxPeersPerASN == 0 {
+		return
+	}
+	asn := g.asnOf(c.RemoteMultiaddr())
+	if asn == "" {
+		return
+	}
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	peers, ok := g.asnPeers[asn]
+	if !ok {
+		peers = make(map[peer.ID]int)
+		g.asnPeers[asn] = peers
+	}
+	peers[c.RemotePeer()]++
+
+	// the connection isn't pending anymore
+	if pending := g.asnPending[asn]; len(pending[c.RemotePeer()]) > 0 {
+		if expiries := pending[c.RemotePeer()][1:]; len(expiries) > 0 {
+			pending[c.RemotePeer()] = expiries
+		} else {
+			delete(pending, c.RemotePeer())
+			if len(pending) == 0 {
+				delete(g.asnPending, asn)
+			}
+		}
+	}
+}
+
+func (n *policyNotifee) Disconnected(nw network.Network, c network.Conn) {
+	g := (*PolicyGater)(n)
+	if g.innerNotifee != nil {
+		g.innerNotifee.Disconnected(nw, c)
+	}
+	if g.maxPeersPerASN == 0 {
+		return
+	}
+	asn := g.asnOf(c.RemoteMultiaddr())
+	if asn == "" {
+		return
+	}
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	peers, ok := g.asnPeers[asn]
+	if !ok {
+		return
+	}
+	if peers[c.RemotePeer()]--; peers[c.RemotePeer()] <= 0 {
+		delete(peers, c.RemotePeer())
+	}
+	if len(peers) == 0 {
+		delete(g.asnPeers, asn)
+	}
+}
+
+func (n *policyNotifee) Listen(nw network.Network, a ma.Multiaddr) {
+	if g := (*PolicyGater)(n); g.innerNotifee != nil {
+		g.innerNotifee.Listen(nw, a)
+	}
+}
+
+func (n *policyNotifee) ListenClose(nw network.Network, a ma.Multiaddr) {
+	if g := (*PolicyGater)(n); g.innerNotifee != nil {
+		g.innerNotifee.ListenClose(nw, a)
+	}
+}
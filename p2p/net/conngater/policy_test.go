@@ -0,0 +1,189 @@
+package conngater
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/benbjohnson/clock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// testASNResolver maps 1.0.0.0/8 to AS1, 2.0.0.0/8 to AS2, and so on.
+func testASNResolver(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] < 10 {
+		return string(rune('0' + ip4[0]))
+	}
+	return ""
+}
+
+type policyConn struct {
+	network.Conn
+	p      peer.ID
+	remote ma.Multiaddr
+}
+
+func (c *policyConn) RemotePeer() peer.ID           { return c.p }
+func (c *policyConn) RemoteMultiaddr() ma.Multiaddr { return c.remote }
+
+func TestPolicyFromJSON(t *testing.T) {
+	p, err := PolicyFromJSON(strings.NewReader(`{"BlockASNs": ["AS1"], "BlockPrefixes": ["10.0.0.0/8"], "MaxPeersPerASN": 3}`))
+	require.NoError(t, err)
+	require.Equal(t, &Policy{BlockASNs: []string{"AS1"}, BlockPrefixes: []string{"10.0.0.0/8"}, MaxPeersPerASN: 3}, p)
+
+	_, err = PolicyFromJSON(strings.NewReader(`{"BlockASN": ["AS1"]}`))
+	require.Error(t, err)
+	_, err = NewPolicyGater(&Policy{BlockPrefixes: []string{"10.0.0.0"}})
+	require.Error(t, err)
+	// the default resolver doesn't know IPv4 addresses, which would all be rejected
+	_, err = NewPolicyGater(&Policy{InboundAllowASNs: []string{"AS1"}})
+	require.Error(t, err)
+}
+
+func TestPolicyGaterBlock(t *testing.T) {
+	g, err := NewPolicyGater(&Policy{
+		BlockASNs:     []string{"AS1"},
+		BlockPrefixes: []string{"2.3.0.0/16"},
+	}, WithASNResolver(testASNResolver))
+	require.NoError(t, err)
+
+	p := peer.ID("A")
+	require.False(t, g.InterceptAddrDial(p, ma.StringCast("/ip4/1.2.3.4/tcp/1234")))
+	require.False(t, g.InterceptAddrDial(p, ma.StringCast("/ip4/2.3.4.5/tcp/1234")))
+	require.True(t, g.InterceptAddrDial(p, ma.StringCast("/ip4/2.4.4.5/tcp/1234")))
+	require.False(t, g.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/1.2.3.4/tcp/1234")}))
+	require.True(t, g.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/3.2.3.4/tcp/1234")}))
+}
+
+func TestPolicyGaterInboundAllow(t *testing.T) {
+	g, err := NewPolicyGater(&Policy{
+		InboundAllowASNs:     []string{"2"},
+		InboundAllowPrefixes: []string{"3.3.0.0/16"},
+	}, WithASNResolver(testASNResolver))
+	require.NoError(t, err)
+
+	require.True(t, g.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/2.2.3.4/tcp/1234")}))
+	require.True(t, g.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/3.3.3.4/tcp/1234")}))
+	require.False(t, g.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/3.2.3.4/tcp/1234")}))
+	require.False(t, g.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/10.2.3.4/tcp/1234")}))
+	// outbound connections aren't restricted
+	require.True(t, g.InterceptAddrDial(peer.ID("A"), ma.StringCast("/ip4/3.2.3.4/tcp/1234")))
+}
+
+func TestPolicyGaterMaxPeersPerASN(t *testing.T) {
+	g, err := NewPolicyGater(&Policy{MaxPeersPerASN: 2}, WithASNResolver(testASNResolver))
+	require.NoError(t, err)
+	not := g.Notifee()
+
+	connect := func(p peer.ID, addr string) network.Conn {
+		c := &policyConn{p: p, remote: ma.StringCast(addr)}
+		if !g.InterceptSecured(network.DirInbound, p, c) {
+			return nil
+		}
+		not.Connected(nil, c)
+		return c
+	}
+
+	connA := connect("A", "/ip4/1.1.1.1/tcp/1")
+	require.NotNil(t, connA)
+	require.NotNil(t, connect("B", "/ip4/1.1.1.2/tcp/1"))
+	require.Nil(t, connect("C", "/ip4/1.1.1.3/tcp/1"))
+	// already connected peers can open more connections
+	require.NotNil(t, connect("A", "/ip4/1.1.1.4/tcp/1"))
+	// other ASNs aren't affected
+	require.NotNil(t, connect("C", "/ip4/2.1.1.3/tcp/1"))
+
+	not.Disconnected(nil, connA)
+	require.Nil(t, connect("D", "/ip4/1.1.1.5/tcp/1"))
+	not.Disconnected(nil, &policyConn{p: "A", remote: ma.StringCast("/ip4/1.1.1.4/tcp/1")})
+	require.NotNil(t, connect("D", "/ip4/1.1.1.5/tcp/1"))
+}
+
+func TestPolicyGaterRelayed(t *testing.T) {
+	g, err := NewPolicyGater(&Policy{
+		BlockASNs:      []string{"AS1"},
+		MaxPeersPerASN: 1,
+	}, WithASNResolver(testASNResolver))
+	require.NoError(t, err)
+	not := g.Notifee()
+
+	// the IP address of a relayed address belongs to the relay
+	blockedRelay := ma.StringCast("/ip4/1.2.3.4/tcp/1234/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit")
+	require.False(t, g.InterceptAddrDial("A", blockedRelay.Decapsulate(ma.StringCast("/p2p-circuit"))))
+	require.True(t, g.InterceptAddrDial("A", blockedRelay))
+
+	// relayed peers aren't counted towards the ASN of the relay
+	relay := ma.StringCast("/ip4/2.2.3.4/tcp/1234/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit")
+	for _, p := range []peer.ID{"A", "B"} {
+		c := &policyConn{p: p, remote: relay}
+		require.True(t, g.InterceptSecured(network.DirInbound, p, c))
+		not.Connected(nil, c)
+	}
+	require.True(t, g.InterceptSecured(network.DirInbound, "C", &policyConn{p: "C", remote: ma.StringCast("/ip4/2.2.3.5/tcp/1")}))
+}
+
+func TestPolicyGaterMaxPeersPerASNPending(t *testing.T) {
+	clk := clock.NewMock()
+	g, err := NewPolicyGater(&Policy{MaxPeersPerASN: 2}, WithASNResolver(testASNResolver), WithPolicyClock(clk))
+	require.NoError(t, err)
+
+	secure := func(p peer.ID) bool {
+		return g.InterceptSecured(network.DirInbound, p, &mockConnMultiaddrs{remote: ma.StringCast("/ip4/1.1.1.1/tcp/1")})
+	}
+	// concurrent handshakes count against the limit
+	require.True(t, secure("A"))
+	require.True(t, secure("B"))
+	require.False(t, secure("C"))
+	require.True(t, secure("A"))
+
+	// once connected, peers aren't counted twice
+	g.Notifee().Connected(nil, &policyConn{p: "A", remote: ma.StringCast("/ip4/1.1.1.1/tcp/1")})
+	require.False(t, secure("C"))
+
+	// handshakes that never complete expire
+	clk.Add(pendingTimeout)
+	require.True(t, secure("C"))
+	require.False(t, secure("D"))
+}
+
+func TestPolicyGaterInnerNotifee(t *testing.T) {
+	var connected []peer.ID
+	inner := &notifeeGater{Notifiee: &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) { connected = append(connected, c.RemotePeer()) },
+	}}
+	g, err := NewPolicyGater(&Policy{}, WithInnerGater(inner))
+	require.NoError(t, err)
+	c, err := NewChain(ChainAll, []NamedGater{{Name: "policy", ConnectionGater: g}})
+	require.NoError(t, err)
+
+	c.Notifee().Connected(nil, &policyConn{p: "A", remote: ma.StringCast("/ip4/1.1.1.1/tcp/1")})
+	require.Equal(t, []peer.ID{"A"}, connected)
+}
+
+type notifeeGater struct {
+	connmgr.ConnectionGater
+	network.Notifiee
+}
+
+func (g *notifeeGater) Notifee() network.Notifiee { return g.Notifiee }
+
+func TestPolicyGaterInner(t *testing.T) {
+	inner, err := NewBasicConnectionGater(nil)
+	require.NoError(t, err)
+	g, err := NewPolicyGater(&Policy{BlockPrefixes: []string{"1.0.0.0/8"}}, WithInnerGater(inner))
+	require.NoError(t, err)
+
+	require.NoError(t, inner.BlockAddr(net.ParseIP("2.2.2.2")))
+	require.False(t, g.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/1.2.3.4/tcp/1234")}))
+	require.False(t, g.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/2.2.2.2/tcp/1234")}))
+	require.True(t, g.InterceptAccept(&mockConnMultiaddrs{remote: ma.StringCast("/ip4/3.3.3.3/tcp/1234")}))
+
+	require.NoError(t, inner.BlockPeer(peer.ID("A")))
+	require.False(t, g.InterceptPeerDial(peer.ID("A")))
+	require.True(t, g.InterceptPeerDial(peer.ID("B")))
+}
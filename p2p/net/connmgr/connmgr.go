@@ -9,6 +9,7 @@ import (
 
 	"github.com/benbjohnson/clock"
 	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 
@@ -36,6 +37,16 @@ type BasicConnMgr struct {
 	plk       sync.RWMutex
 	protected map[peer.ID]map[string]struct{}
 
+	// protection changes that weren't persisted yet, keyed by tag, and peers whose
+	// persisted protections are to be restored. Only used if a datastore is configured.
+	persistMx          sync.Mutex
+	protectionOps      map[peer.ID]map[string]bool
+	protectionRestores map[peer.ID]struct{}
+	persistSignal      chan struct{}
+
+	// groupMaxSignal triggers enforcing the peer group maximums. Only used if a group has a maximum.
+	groupMaxSignal chan struct{}
+
 	// channel-based semaphore that enforces only a single trim is in progress
 	trimMutex sync.Mutex
 	connCount int32
@@ -46,6 +57,8 @@ type BasicConnMgr struct {
 	lastTrimMu sync.RWMutex
 	lastTrim   time.Time
 
+	trimHistory trimHistory
+
 	refCount                sync.WaitGroup
 	ctx                     context.Context
 	cancel                  func()
@@ -106,6 +119,9 @@ func NewConnManager(low, hi int, opts ...Option) (*BasicConnMgr, error) {
 		gracePeriod:   time.Minute,
 		silencePeriod: 10 * time.Second,
 		clock:         clock.New(),
+
+		trimHistorySize: DefaultTrimHistorySize,
+		persistenceTTL:  DefaultPersistenceTTL,
 	}
 	for _, o := range opts {
 		if err := o(cfg); err != nil {
@@ -143,6 +159,20 @@ func NewConnManager(low, hi int, opts ...Option) (*BasicConnMgr, error) {
 
 	cm.refCount.Add(1)
 	go cm.background()
+
+	if cfg.hasGroupMax() {
+		cm.groupMaxSignal = make(chan struct{}, 1)
+		cm.refCount.Add(1)
+		go cm.groupMaxLoop()
+	}
+
+	if cfg.datastore != nil {
+		cm.protectionOps = make(map[peer.ID]map[string]bool)
+		cm.protectionRestores = make(map[peer.ID]struct{})
+		cm.persistSignal = make(chan struct{}, 1)
+		cm.refCount.Add(1)
+		go cm.persistProtections()
+	}
 	return cm, nil
 }
 
@@ -185,6 +215,7 @@ func (cm *BasicConnMgr) Close() error {
 		return err
 	}
 	cm.refCount.Wait()
+	cm.closeTrimHistory()
 	return nil
 }
 
@@ -197,13 +228,23 @@ func (cm *BasicConnMgr) Protect(id peer.ID, tag string) {
 		tags = make(map[string]struct{}, 2)
 		cm.protected[id] = tags
 	}
+	if _, ok := tags[tag]; ok {
+		return
+	}
 	tags[tag] = struct{}{}
+	if cm.cfg.datastore != nil {
+		cm.queueProtection(id, tag, true)
+	}
 }
 
 func (cm *BasicConnMgr) Unprotect(id peer.ID, tag string) (protected bool) {
 	cm.plk.Lock()
 	defer cm.plk.Unlock()
 
+	if cm.cfg.datastore != nil {
+		// the peer's persisted protections may not have been restored yet.
+		cm.queueProtection(id, tag, false)
+	}
 	tags, ok := cm.protected[id]
 	if !ok {
 		return false
@@ -296,7 +337,9 @@ func (p peerInfos) SortByValueAndStreams(sortByMoreStreams bool) {
 // TrimOpenConns closes the connections of as many peers as needed to make the peer count
 // equal the low watermark. Peers are sorted in ascending order based on their total value,
 // pruning those peers with the lowest scores first, as long as they are not within their
-// grace period.
+// grace period. If peer groups are configured (see WithPeerGroups), peers in groups
+// exceeding their maximum are pruned first, and peers are kept if pruning them would take
+// a group below its minimum.
 //
 // This function blocks until a trim is completed. If a trim is underway, a new
 // one won't be started, and instead it'll wait until that one is completed before
@@ -376,7 +419,28 @@ func (cm *BasicConnMgr) getConnsToCloseEmergency(target int) []network.Conn {
 	// Sort peers according to their value.
 	candidates.SortByValueAndStreams(true)
 
+	now := cm.clock.Now()
 	selected := make([]network.Conn, 0, target+10)
+	var decisions []TrimDecision
+	trimmed := make(map[peer.ID]struct{})
+	decide := func(inf peerInfo, protected bool) {
+		if _, ok := trimmed[inf.id]; ok || len(inf.conns) == 0 {
+			return
+		}
+		trimmed[inf.id] = struct{}{}
+		decisions = append(decisions, TrimDecision{
+			Time: now,
+			EvtPeerTrimmed: event.EvtPeerTrimmed{
+				Peer:      inf.id,
+				Reason:    event.TrimReasonMemoryEmergency,
+				Value:     inf.value,
+				Conns:     len(inf.conns),
+				Protected: protected,
+			},
+		})
+	}
+	defer func() { cm.recordTrims(decisions) }()
+
 	for _, inf := range candidates {
 		if target <= 0 {
 			break
@@ -385,6 +449,7 @@ func (cm *BasicConnMgr) getConnsToCloseEmergency(target int) []network.Conn {
 			selected = append(selected, c)
 		}
 		target -= len(inf.conns)
+		decide(inf, false)
 	}
 	if len(selected) >= target {
 		// We found enough connections that were not protected.
@@ -413,6 +478,7 @@ func (cm *BasicConnMgr) getConnsToCloseEmergency(target int) []network.Conn {
 			selected = append(selected, c)
 		}
 		target -= len(inf.conns)
+		decide(inf, cm.IsProtected(inf.id, ""))
 	}
 	return selected
 }
@@ -472,27 +538,67 @@ func (cm *BasicConnMgr) getConnsToClose() []network.Conn {
 	// slightly overallocate because we may have more than one conns per peer
 	selected := make([]network.Conn, 0, target+10)
 
-	for _, inf := range candidates {
-		if target <= 0 {
-			break
-		}
-
+	var quotas *groupQuotas
+	if len(cm.cfg.peerGroups) > 0 {
+		quotas = cm.newGroupQuotas()
+	}
+	now := cm.clock.Now()
+	var decisions []TrimDecision
+	// selectPeer selects the connections of a candidate, unless that would violate a peer group minimum.
+	selectPeer := func(inf peerInfo, reason event.TrimReason, group string) bool {
 		// lock this to protect from concurrent modifications from connect/disconnect events
 		s := cm.segments.get(inf.id)
 		s.Lock()
+		defer s.Unlock()
+
 		if len(inf.conns) == 0 && inf.temp {
 			// handle temporary entries for early tags -- this entry has gone past the grace period
 			// and still holds no connections, so prune it.
 			delete(s.peers, inf.id)
-		} else {
-			for c := range inf.conns {
-				selected = append(selected, c)
+			return true
+		}
+		if quotas != nil {
+			if quotas.reserved(inf.id, len(inf.conns)) {
+				return false
 			}
-			target -= len(inf.conns)
+			quotas.remove(inf.id, len(inf.conns))
 		}
-		s.Unlock()
+		for c := range inf.conns {
+			selected = append(selected, c)
+		}
+		target -= len(inf.conns)
+		decisions = append(decisions, TrimDecision{
+			Time: now,
+			EvtPeerTrimmed: event.EvtPeerTrimmed{
+				Peer:   inf.id,
+				Reason: reason,
+				Group:  group,
+				Value:  inf.value,
+				Conns:  len(inf.conns),
+			},
+		})
+		return true
 	}
 
+	done := make([]bool, len(candidates))
+	if quotas != nil {
+		// First, close the least valuable connections of peer groups that exceed their maximum.
+		for i, inf := range candidates {
+			if group, ok := quotas.exceedsMax(inf.id); ok {
+				done[i] = selectPeer(inf, event.TrimReasonGroupMax, group)
+			}
+		}
+	}
+	for i, inf := range candidates {
+		if target <= 0 {
+			break
+		}
+		if !done[i] {
+			selectPeer(inf, event.TrimReasonLowWatermark, "")
+		}
+	}
+
+	cm.recordTrims(decisions)
 	return selected
 }
 
@@ -640,12 +746,20 @@ func (nn *cmNotifee) Connected(n network.Network, c network.Conn) {
 			conns:     make(map[network.Conn]time.Time),
 		}
 		s.peers[id] = pinfo
+		if cm.cfg.datastore != nil {
+			cm.decayer.restore(id)
+			cm.restoreProtections(id)
+		}
 	} else if pinfo.temp {
 		// we had created a temporary entry for this peer to buffer early tags before the
 		// Connected notification arrived: flip the temporary flag, and update the firstSeen
 		// timestamp to the real one.
 		pinfo.temp = false
 		pinfo.firstSeen = cm.clock.Now()
+		if cm.cfg.datastore != nil {
+			cm.decayer.restore(id)
+			cm.restoreProtections(id)
+		}
 	}
 
 	_, ok = pinfo.conns[c]
@@ -656,6 +770,7 @@ func (nn *cmNotifee) Connected(n network.Network, c network.Conn) {
 
 	pinfo.conns[c] = cm.clock.Now()
 	atomic.AddInt32(&cm.connCount, 1)
+	cm.signalGroupMax()
 }
 
 // Disconnected is called by notifiers to inform that an existing connection has been closed or terminated.
@@ -683,6 +798,12 @@ func (nn *cmNotifee) Disconnected(n network.Network, c network.Conn) {
 	delete(cinf.conns, c)
 	if len(cinf.conns) == 0 {
 		delete(s.peers, p)
+		if cm.cfg.datastore != nil {
+			cm.decayer.persist(persistCmd{peer: p, values: snapshotDecaying(cinf)})
+			// refresh the persisted protections, so that they don't expire
+			// while the peer is connected.
+			cm.queueProtection(p, "", false)
+		}
 	}
 	atomic.AddInt32(&cm.connCount, -1)
 }
@@ -21,6 +21,7 @@ type tconn struct {
 	network.Conn
 
 	peer             peer.ID
+	addr             ma.Multiaddr
 	closed           uint32 // to be used atomically. Closed if 1
 	disconnectNotify func(net network.Network, conn network.Conn)
 }
@@ -51,6 +52,9 @@ func (c *tconn) Stat() network.ConnStats {
 }
 
 func (c *tconn) RemoteMultiaddr() ma.Multiaddr {
+	if c.addr != nil {
+		return c.addr
+	}
 	addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/udp/1234")
 	if err != nil {
 		panic("cannot create multiaddr")
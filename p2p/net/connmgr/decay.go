@@ -45,6 +45,12 @@ type decayer struct {
 	removeTagCh chan removeCmd
 	closeTagCh  chan *decayingTag
 
+	// persistCh queues the persistence of decaying tag values of disconnected
+	// peers, and their restoration when they reconnect. Both go through the same
+	// channel, so that a peer that reconnects quickly is restored from the values
+	// persisted when it disconnected.
+	persistCh chan persistCmd
+
 	// closure thingies.
 	closeCh chan struct{}
 	doneCh  chan struct{}
@@ -85,6 +91,7 @@ func NewDecayer(cfg *DecayerCfg, mgr *BasicConnMgr) (*decayer, error) {
 		bumpTagCh:   make(chan bumpCmd, 128),
 		removeTagCh: make(chan removeCmd, 128),
 		closeTagCh:  make(chan *decayingTag, 128),
+		persistCh:   make(chan persistCmd, 128),
 		closeCh:     make(chan struct{}),
 		doneCh:      make(chan struct{}),
 	}
@@ -147,13 +154,21 @@ func (d *decayer) Close() error {
 //
 //  1. Manages decay.
 //  2. Applies score bumps.
-//  3. Yields when closed.
+//  3. Persists, restores and expires decaying tag values, if a datastore is configured.
+//  4. Yields when closed.
 func (d *decayer) process() {
 	defer close(d.doneCh)
 
 	ticker := d.clock.Ticker(d.cfg.Resolution)
 	defer ticker.Stop()
 
+	var gcCh <-chan time.Time
+	if d.mgr.cfg.datastore != nil {
+		gcTicker := d.clock.Ticker(persistGCInterval)
+		defer gcTicker.Stop()
+		gcCh = gcTicker.C
+	}
+
 	var (
 		bmp   bumpCmd
 		now   time.Time
@@ -274,7 +289,20 @@ func (d *decayer) process() {
 				s.Unlock()
 			}
 
+		case cmd := <-d.persistCh:
+			if cmd.restore {
+				d.restoreDecaying(cmd.peer)
+			} else {
+				d.persistDecaying(cmd)
+			}
+
+		case <-gcCh:
+			d.gcDecaying()
+
 		case <-d.closeCh:
+			if d.mgr.cfg.datastore != nil {
+				d.persistAll()
+			}
 			return
 		}
 	}
@@ -353,3 +381,51 @@ func (t *decayingTag) Close() error {
 		return fmt.Errorf("unable to close decaying tag %s; queue full (len=%d)", t.name, len(t.trkr.closeTagCh))
 	}
 }
+
+// persist queues persisting the decaying tag values of a peer that disconnected.
+func (d *decayer) persist(cmd persistCmd) {
+	select {
+	case d.persistCh <- cmd:
+	default:
+		log.Warnw("unable to persist decaying tags; queue full", "peer", cmd.peer, "len", len(d.persistCh))
+	}
+}
+
+// restore queues restoring the decaying tag values of a peer that connected.
+func (d *decayer) restore(p peer.ID) {
+	select {
+	case d.persistCh <- persistCmd{peer: p, restore: true}:
+	default:
+		log.Warnw("unable to restore decaying tags; queue full", "peer", p, "len", len(d.persistCh))
+	}
+}
+
+// persistAll persists the decaying tag values of all peers, including
+// the values of disconnected peers that are still queued.
+func (d *decayer) persistAll() {
+	for {
+		select {
+		case cmd := <-d.persistCh:
+			if !cmd.restore {
+				d.persistDecaying(cmd)
+			}
+			continue
+		default:
+		}
+		break
+	}
+
+	var cmds []persistCmd
+	for _, s := range d.mgr.segments {
+		s.Lock()
+		for id, p := range s.peers {
+			if !p.temp {
+				cmds = append(cmds, persistCmd{peer: id, values: snapshotDecaying(p)})
+			}
+		}
+		s.Unlock()
+	}
+	for _, cmd := range cmds {
+		d.persistDecaying(cmd)
+	}
+}
@@ -0,0 +1,278 @@
+package connmgr
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// PeerGroup is a group of peers with a connection quota. When trimming, the connection
+// manager keeps at least Min connections to peers in the group, and closes the connections
+// of the least valuable peers in the group until at most Max are left.
+//
+// Max is also enforced when the number of connections is below the watermarks: when a group
+// exceeds its maximum, its newest connections are closed, including connections in the grace
+// period. Groups are checked whenever a connection is added, and periodically, since the
+// membership of a peer can change while it's connected, e.g. once its protocols are known.
+//
+// Like the watermarks, quotas count connections. Connections to protected peers count
+// towards the quotas, but are never closed to enforce Max. Quotas are not enforced on
+// memory emergency trims.
+type PeerGroup struct {
+	// Name identifies the group in logs.
+	Name string
+	// Match reports whether a peer belongs to the group. A peer may belong to several groups.
+	Match PeerMatcher
+	// Min is the number of connections reserved for the group. 0 means no reservation.
+	Min int
+	// Max is the maximum number of connections to the group. 0 means no maximum.
+	Max int
+}
+
+// PeerMatcher decides whether a peer belongs to a PeerGroup. tags holds the peer's tags,
+// including decaying tags, and conns the peer's connections. They must not be modified.
+type PeerMatcher func(p peer.ID, tags map[string]int, conns []network.Conn) bool
+
+// MatchProtocols returns a PeerMatcher that matches peers supporting any of protos, according
+// to the protocol book pb. Usually, pb is the peerstore of the host that uses the connection manager.
+func MatchProtocols(pb peerstore.ProtoBook, protos ...string) PeerMatcher {
+	return func(p peer.ID, _ map[string]int, _ []network.Conn) bool {
+		supported, err := pb.SupportsProtocols(p, protos...)
+		return err == nil && len(supported) > 0
+	}
+}
+
+// MatchTag returns a PeerMatcher that matches peers that have been tagged with tag.
+func MatchTag(tag string) PeerMatcher {
+	return func(_ peer.ID, tags map[string]int, _ []network.Conn) bool {
+		_, ok := tags[tag]
+		return ok
+	}
+}
+
+// MatchRelayOnly returns a PeerMatcher that matches peers that are only connected through relays.
+func MatchRelayOnly() PeerMatcher {
+	return func(_ peer.ID, _ map[string]int, conns []network.Conn) bool {
+		if len(conns) == 0 {
+			return false
+		}
+		for _, c := range conns {
+			if _, err := c.RemoteMultiaddr().ValueForProtocol(ma.P_CIRCUIT); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// groupQuotas tracks the number of connections to each peer group during a trim.
+type groupQuotas struct {
+	groups  []PeerGroup
+	conns   []int
+	members map[peer.ID][]int // indexes of the groups each peer belongs to
+}
+
+// newGroupQuotas assigns all connected peers to the configured peer groups.
+func (cm *BasicConnMgr) newGroupQuotas() *groupQuotas {
+	type peerSnapshot struct {
+		id    peer.ID
+		tags  map[string]int
+		conns []network.Conn
+	}
+
+	// Copy the tags, so that the matchers don't run with the segment locks held.
+	var peers []peerSnapshot
+	for _, s := range cm.segments {
+		s.Lock()
+		for id, inf := range s.peers {
+			if len(inf.conns) == 0 {
+				continue
+			}
+			tags := make(map[string]int, len(inf.tags)+len(inf.decaying))
+			for t, v := range inf.tags {
+				tags[t] = v
+			}
+			for t, v := range inf.decaying {
+				tags[t.name] = v.Value
+			}
+			conns := make([]network.Conn, 0, len(inf.conns))
+			for c := range inf.conns {
+				conns = append(conns, c)
+			}
+			peers = append(peers, peerSnapshot{id: id, tags: tags, conns: conns})
+		}
+		s.Unlock()
+	}
+
+	q := &groupQuotas{
+		groups:  cm.cfg.peerGroups,
+		conns:   make([]int, len(cm.cfg.peerGroups)),
+		members: make(map[peer.ID][]int),
+	}
+	for _, p := range peers {
+		for i, g := range q.groups {
+			if g.Match(p.id, p.tags, p.conns) {
+				q.members[p.id] = append(q.members[p.id], i)
+				q.conns[i] += len(p.conns)
+			}
+		}
+	}
+	return q
+}
+
+// exceedsMax returns the name of a group of p that has more connections than its maximum.
+func (q *groupQuotas) exceedsMax(p peer.ID) (group string, ok bool) {
+	for _, i := range q.members[p] {
+		if max := q.groups[i].Max; max > 0 && q.conns[i] > max {
+			return q.groups[i].Name, true
+		}
+	}
+	return "", false
+}
+
+// reserved reports whether closing nconns connections to p would take one of its groups below its minimum.
+func (q *groupQuotas) reserved(p peer.ID, nconns int) bool {
+	for _, i := range q.members[p] {
+		if min := q.groups[i].Min; min > 0 && q.conns[i]-nconns < min {
+			log.Debugw("keeping peer to satisfy peer group quota", "peer", p, "group", q.groups[i].Name)
+			return true
+		}
+	}
+	return false
+}
+
+// remove accounts for closing nconns connections to p.
+func (q *groupQuotas) remove(p peer.ID, nconns int) {
+	for _, i := range q.members[p] {
+		q.conns[i] -= nconns
+	}
+}
+
+// hasGroupMax reports whether any of the configured peer groups has a maximum.
+func (cfg *config) hasGroupMax() bool {
+	for _, g := range cfg.peerGroups {
+		if g.Max > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// signalGroupMax schedules checking the peer group maximums.
+func (cm *BasicConnMgr) signalGroupMax() {
+	if cm.groupMaxSignal == nil {
+		return
+	}
+	select {
+	case cm.groupMaxSignal <- struct{}{}:
+	default:
+	}
+}
+
+// groupMaxLoop enforces the peer group maximums when connections are added, and periodically.
+func (cm *BasicConnMgr) groupMaxLoop() {
+	defer cm.refCount.Done()
+
+	interval := cm.cfg.gracePeriod / 2
+	if cm.cfg.silencePeriod != 0 {
+		interval = cm.cfg.silencePeriod
+	}
+	ticker := cm.clock.Ticker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cm.groupMaxSignal:
+		case <-ticker.C:
+		case <-cm.ctx.Done():
+			return
+		}
+		cm.enforceGroupMax()
+	}
+}
+
+// enforceGroupMax closes the newest connections of the peer groups that exceed their maximum.
+// Unlike trims, it doesn't depend on the watermarks, and it doesn't spare connections in the
+// grace period, since a group would otherwise exceed its maximum until its connections leave it.
+func (cm *BasicConnMgr) enforceGroupMax() {
+	cm.trimMutex.Lock()
+	defer cm.trimMutex.Unlock()
+
+	q := cm.newGroupQuotas()
+	type candidate struct {
+		conn   network.Conn
+		peer   peer.ID
+		opened time.Time
+		value  int
+	}
+	var candidates []candidate
+	cm.plk.RLock()
+	for p := range q.members {
+		if _, ok := q.exceedsMax(p); !ok {
+			continue
+		}
+		if _, ok := cm.protected[p]; ok {
+			continue
+		}
+		s := cm.segments.get(p)
+		s.Lock()
+		if inf, ok := s.peers[p]; ok {
+			for c, opened := range inf.conns {
+				candidates = append(candidates, candidate{conn: c, peer: p, opened: opened, value: inf.value})
+			}
+		}
+		s.Unlock()
+	}
+	cm.plk.RUnlock()
+	if len(candidates) == 0 {
+		return
+	}
+
+	// Close the newest connections first, and the connections of the least valuable peers among them.
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].opened.Equal(candidates[j].opened) {
+			return candidates[i].opened.After(candidates[j].opened)
+		}
+		return candidates[i].value < candidates[j].value
+	})
+
+	now := cm.clock.Now()
+	var selected []network.Conn
+	var decisions []TrimDecision
+	decisionIdx := make(map[peer.ID]int)
+	for _, c := range candidates {
+		group, ok := q.exceedsMax(c.peer)
+		if !ok || q.reserved(c.peer, 1) {
+			continue
+		}
+		q.remove(c.peer, 1)
+		selected = append(selected, c.conn)
+		i, ok := decisionIdx[c.peer]
+		if !ok {
+			i = len(decisions)
+			decisionIdx[c.peer] = i
+			decisions = append(decisions, TrimDecision{
+				Time: now,
+				EvtPeerTrimmed: event.EvtPeerTrimmed{
+					Peer:   c.peer,
+					Reason: event.TrimReasonGroupMax,
+					Group:  group,
+					Value:  c.value,
+				},
+			})
+		}
+		decisions[i].Conns++
+	}
+
+	cm.recordTrims(decisions)
+	for _, c := range selected {
+		log.Infow("closing conn to enforce peer group maximum", "peer", c.RemotePeer())
+		c.Close()
+	}
+}
@@ -0,0 +1,156 @@
+package connmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	tu "github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerGroupMin(t *testing.T) {
+	cm, err := NewConnManager(5, 10, WithGracePeriod(0), WithPeerGroups(PeerGroup{
+		Name:  "sync",
+		Match: MatchTag("sync"),
+		Min:   3,
+	}))
+	require.NoError(t, err)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var syncConns []network.Conn
+	for i := 0; i < 10; i++ {
+		conn := randConn(t, nil)
+		not.Connected(nil, conn)
+		if i < 4 {
+			// the sync peers are the least valuable ones
+			cm.TagPeer(conn.RemotePeer(), "sync", 0)
+			syncConns = append(syncConns, conn)
+		} else {
+			cm.TagPeer(conn.RemotePeer(), "value", 10)
+		}
+	}
+
+	closed := cm.getConnsToClose()
+	require.Len(t, closed, 5)
+	var closedSync int
+	for _, c := range closed {
+		for _, sc := range syncConns {
+			if c == sc {
+				closedSync++
+			}
+		}
+	}
+	require.Equal(t, 1, closedSync)
+}
+
+func TestPeerGroupMax(t *testing.T) {
+	ps, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	defer ps.Close()
+
+	cm, err := NewConnManager(8, 10, WithGracePeriod(0), WithPeerGroups(PeerGroup{
+		Name:  "relay",
+		Match: MatchProtocols(ps, "/relay"),
+		Max:   2,
+	}))
+	require.NoError(t, err)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	relayPeers := make(map[peer.ID]struct{})
+	for i := 0; i < 10; i++ {
+		conn := randConn(t, nil)
+		not.Connected(nil, conn)
+		if i < 6 {
+			// the relay peers are the most valuable ones
+			require.NoError(t, ps.AddProtocols(conn.RemotePeer(), "/relay"))
+			cm.TagPeer(conn.RemotePeer(), "value", 10+i)
+			relayPeers[conn.RemotePeer()] = struct{}{}
+		}
+	}
+
+	// Trimming to the low watermark only requires closing 2 connections,
+	// but 4 have to be closed to enforce the maximum.
+	closed := cm.getConnsToClose()
+	require.Len(t, closed, 4)
+	for _, c := range closed {
+		require.Contains(t, relayPeers, c.RemotePeer())
+		require.GreaterOrEqual(t, cm.GetTagInfo(c.RemotePeer()).Value, 10)
+		require.Less(t, cm.GetTagInfo(c.RemotePeer()).Value, 14)
+	}
+}
+
+func TestPeerGroupConfig(t *testing.T) {
+	_, err := NewConnManager(1, 2, WithPeerGroups(PeerGroup{Name: "nomatch", Min: 1}))
+	require.Error(t, err)
+	_, err = NewConnManager(1, 2, WithPeerGroups(PeerGroup{Name: "negative", Match: MatchTag("foo"), Min: -1}))
+	require.Error(t, err)
+	_, err = NewConnManager(1, 2, WithPeerGroups(PeerGroup{Name: "inverted", Match: MatchTag("foo"), Min: 2, Max: 1}))
+	require.Error(t, err)
+}
+
+func TestPeerGroupMaxBelowWatermarks(t *testing.T) {
+	cm, err := NewConnManager(100, 200, WithPeerGroups(PeerGroup{
+		Name:  "relay-only",
+		Match: MatchRelayOnly(),
+		Max:   3,
+	}))
+	require.NoError(t, err)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	relayAddr := ma.StringCast("/ip4/1.2.3.4/tcp/1/p2p/QmbHVEEepCi7rn7VL7Exxpd2Ci9NNB6ifvqwhsrbRMgQFP/p2p-circuit")
+	protected := tu.RandPeerIDFatal(t)
+	cm.Protect(protected, "test")
+	var relayConns []*tconn
+	for i := 0; i < 5; i++ {
+		p := tu.RandPeerIDFatal(t)
+		if i == 4 {
+			p = protected
+		}
+		c := &tconn{peer: p, addr: relayAddr}
+		c.disconnectNotify = not.Disconnected
+		not.Connected(nil, c)
+		relayConns = append(relayConns, c)
+		// direct connections don't belong to the group
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+	cm.enforceGroupMax()
+
+	// the newest unprotected connections are closed, regardless of the grace period
+	for i, c := range relayConns {
+		require.Equal(t, i == 2 || i == 3, c.isClosed(), "connection %d", i)
+	}
+	require.Equal(t, 8, cm.GetInfo().ConnCount)
+
+	history := cm.TrimHistory()
+	require.Len(t, history, 2)
+	for _, d := range history {
+		require.Equal(t, event.TrimReasonGroupMax, d.Reason)
+		require.Equal(t, "relay-only", d.Group)
+		require.Equal(t, 1, d.Conns)
+	}
+
+	// connecting triggers enforcing the maximum
+	c := &tconn{peer: tu.RandPeerIDFatal(t), addr: relayAddr}
+	c.disconnectNotify = not.Disconnected
+	not.Connected(nil, c)
+	require.Eventually(t, c.isClosed, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMatchRelayOnly(t *testing.T) {
+	relayAddr := ma.StringCast("/ip4/1.2.3.4/tcp/1/p2p/QmbHVEEepCi7rn7VL7Exxpd2Ci9NNB6ifvqwhsrbRMgQFP/p2p-circuit")
+	relayed := &tconn{addr: relayAddr}
+	direct := &tconn{}
+	match := MatchRelayOnly()
+	require.True(t, match("", nil, []network.Conn{relayed}))
+	require.False(t, match("", nil, []network.Conn{relayed, direct}))
+	require.False(t, match("", nil, nil))
+}
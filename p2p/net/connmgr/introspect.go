@@ -0,0 +1,237 @@
+package connmgr
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// DefaultTrimHistorySize is the default number of trim decisions that BasicConnMgr remembers.
+var DefaultTrimHistorySize = 100
+
+// PeerState is a snapshot of the state the connection manager keeps for a peer.
+type PeerState struct {
+	Peer peer.ID
+	// FirstSeen is the time the peer connected.
+	FirstSeen time.Time
+	// InGracePeriod is true if the peer connected within the grace period,
+	// so that it isn't trimmed yet.
+	InGracePeriod bool
+	// Temporary is true if the peer has been tagged, but isn't connected yet.
+	Temporary bool
+	// Value is the value of the peer, i.e. the sum of all its tags.
+	Value int
+	// Tags are the values of the peer's tags, excluding decaying tags.
+	Tags map[string]int
+	// Decaying are the peer's decaying tag values, by tag name.
+	Decaying map[string]connmgr.DecayingValue
+	// Protections are the tags the peer is protected with, in lexicographical order.
+	Protections []string
+	// Conns is the number of connections to the peer.
+	Conns int
+}
+
+// TrimDecision records that the connection manager closed the connections to a peer.
+type TrimDecision struct {
+	Time time.Time
+	event.EvtPeerTrimmed
+}
+
+// trimHistory remembers the last trim decisions, and emits them on the event bus.
+type trimHistory struct {
+	mx        sync.Mutex
+	decisions []TrimDecision // ring buffer
+	next      int
+
+	emitterMx sync.Mutex
+	emitter   event.Emitter
+}
+
+// WithTrimHistorySize sets the number of trim decisions that are remembered. See TrimHistory.
+func WithTrimHistorySize(n int) Option {
+	return func(cfg *config) error {
+		if n < 0 {
+			return errors.New("trim history size must be non-negative")
+		}
+		cfg.trimHistorySize = n
+		return nil
+	}
+}
+
+var _ event.BusSetter = (*BasicConnMgr)(nil)
+
+// SetEventBus sets the event bus that trim decisions are emitted on as EvtPeerTrimmed.
+// The host calls this when it is constructed.
+func (cm *BasicConnMgr) SetEventBus(bus event.Bus) error {
+	emitter, err := bus.Emitter(&event.EvtPeerTrimmed{})
+	if err != nil {
+		return err
+	}
+
+	h := &cm.trimHistory
+	h.emitterMx.Lock()
+	defer h.emitterMx.Unlock()
+
+	if h.emitter != nil {
+		h.emitter.Close()
+	}
+	h.emitter = emitter
+	return nil
+}
+
+// recordTrims remembers the decisions, and emits them on the event bus.
+// It must not be called with segment locks held, since emitting may block.
+func (cm *BasicConnMgr) recordTrims(decisions []TrimDecision) {
+	if len(decisions) == 0 {
+		return
+	}
+
+	h := &cm.trimHistory
+	if size := cm.cfg.trimHistorySize; size > 0 {
+		h.mx.Lock()
+		for _, d := range decisions {
+			if len(h.decisions) < size {
+				h.decisions = append(h.decisions, d)
+			} else {
+				h.decisions[h.next] = d
+			}
+			h.next = (h.next + 1) % size
+		}
+		h.mx.Unlock()
+	}
+
+	h.emitterMx.Lock()
+	defer h.emitterMx.Unlock()
+	if h.emitter == nil {
+		return
+	}
+	for _, d := range decisions {
+		if err := h.emitter.Emit(d.EvtPeerTrimmed); err != nil {
+			log.Warnw("failed to emit peer trimmed event", "error", err)
+		}
+	}
+}
+
+func (cm *BasicConnMgr) closeTrimHistory() {
+	h := &cm.trimHistory
+	h.emitterMx.Lock()
+	defer h.emitterMx.Unlock()
+	if h.emitter != nil {
+		h.emitter.Close()
+		h.emitter = nil
+	}
+}
+
+// TrimHistory returns the last trim decisions, oldest first.
+func (cm *BasicConnMgr) TrimHistory() []TrimDecision {
+	h := &cm.trimHistory
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	out := make([]TrimDecision, 0, len(h.decisions))
+	if len(h.decisions) == cm.cfg.trimHistorySize {
+		out = append(out, h.decisions[h.next:]...)
+		return append(out, h.decisions[:h.next]...)
+	}
+	return append(out, h.decisions...)
+}
+
+// Protections returns the protected peers, with the tags they are protected with
+// in lexicographical order.
+func (cm *BasicConnMgr) Protections() map[peer.ID][]string {
+	cm.plk.RLock()
+	defer cm.plk.RUnlock()
+
+	out := make(map[peer.ID][]string, len(cm.protected))
+	for p := range cm.protected {
+		out[p] = cm.protectionsLocked(p)
+	}
+	return out
+}
+
+// protectionsLocked returns the protection tags of a peer. cm.plk must be held.
+func (cm *BasicConnMgr) protectionsLocked(p peer.ID) []string {
+	tags := cm.protected[p]
+	if len(tags) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(tags))
+	for t := range tags {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// InspectPeer returns the state of a peer, or nil if the connection manager
+// doesn't track the peer and it isn't protected.
+func (cm *BasicConnMgr) InspectPeer(p peer.ID) *PeerState {
+	cm.plk.RLock()
+	protections := cm.protectionsLocked(p)
+	cm.plk.RUnlock()
+
+	s := cm.segments.get(p)
+	s.Lock()
+	pi, ok := s.peers[p]
+	if !ok {
+		s.Unlock()
+		if protections == nil {
+			return nil
+		}
+		return &PeerState{Peer: p, Protections: protections}
+	}
+	st := cm.peerState(pi, cm.clock.Now().Add(-cm.cfg.gracePeriod))
+	s.Unlock()
+
+	st.Protections = protections
+	return &st
+}
+
+// InspectPeers returns the state of all peers that the connection manager tracks, ordered by peer ID.
+// Protected peers that aren't tracked are not included, see Protections.
+func (cm *BasicConnMgr) InspectPeers() []PeerState {
+	gracePeriodStart := cm.clock.Now().Add(-cm.cfg.gracePeriod)
+	var out []PeerState
+	for _, s := range cm.segments {
+		s.Lock()
+		for _, pi := range s.peers {
+			out = append(out, cm.peerState(pi, gracePeriodStart))
+		}
+		s.Unlock()
+	}
+
+	cm.plk.RLock()
+	for i := range out {
+		out[i].Protections = cm.protectionsLocked(out[i].Peer)
+	}
+	cm.plk.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Peer < out[j].Peer })
+	return out
+}
+
+// peerState copies the state of a peer. The segment lock must be held.
+func (cm *BasicConnMgr) peerState(pi *peerInfo, gracePeriodStart time.Time) PeerState {
+	st := PeerState{
+		Peer:          pi.id,
+		FirstSeen:     pi.firstSeen,
+		InGracePeriod: !pi.temp && pi.firstSeen.After(gracePeriodStart),
+		Temporary:     pi.temp,
+		Value:         pi.value,
+		Tags:          make(map[string]int, len(pi.tags)),
+		Decaying:      make(map[string]connmgr.DecayingValue, len(pi.decaying)),
+		Conns:         len(pi.conns),
+	}
+	for t, v := range pi.tags {
+		st.Tags[t] = v
+	}
+	for t, v := range pi.decaying {
+		st.Decaying[t.name] = *v
+	}
+	return st
+}
@@ -0,0 +1,99 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectPeers(t *testing.T) {
+	cm, err := NewConnManager(10, 20, WithGracePeriod(time.Hour))
+	require.NoError(t, err)
+	defer cm.Close()
+
+	conn := randConn(t, nil)
+	id := conn.RemotePeer()
+	cm.Notifee().Connected(nil, conn)
+	cm.TagPeer(id, "foo", 5)
+	cm.Protect(id, "b")
+	cm.Protect(id, "a")
+	tag, err := cm.RegisterDecayingTag("pop", time.Hour, connmgr.DecayNone(), connmgr.BumpSumUnbounded())
+	require.NoError(t, err)
+	require.NoError(t, tag.Bump(id, 3))
+	require.Eventually(t, func() bool { return cm.InspectPeer(id).Value == 8 }, time.Second, 10*time.Millisecond)
+
+	// a protected peer that isn't connected
+	other := peer.ID("other")
+	cm.Protect(other, "c")
+
+	st := cm.InspectPeer(id)
+	require.Equal(t, id, st.Peer)
+	require.True(t, st.InGracePeriod)
+	require.False(t, st.Temporary)
+	require.Equal(t, map[string]int{"foo": 5}, st.Tags)
+	require.Equal(t, 3, st.Decaying["pop"].Value)
+	require.Equal(t, []string{"a", "b"}, st.Protections)
+	require.Equal(t, 1, st.Conns)
+
+	require.Equal(t, &PeerState{Peer: other, Protections: []string{"c"}}, cm.InspectPeer(other))
+	require.Nil(t, cm.InspectPeer(peer.ID("unknown")))
+
+	peers := cm.InspectPeers()
+	require.Len(t, peers, 1)
+	require.Equal(t, *st, peers[0])
+	require.Equal(t, map[peer.ID][]string{id: {"a", "b"}, other: {"c"}}, cm.Protections())
+}
+
+func TestTrimHistory(t *testing.T) {
+	cm, err := NewConnManager(1, 2, WithGracePeriod(0), WithTrimHistorySize(2))
+	require.NoError(t, err)
+	defer cm.Close()
+
+	bus := eventbus.NewBus()
+	require.NoError(t, cm.SetEventBus(bus))
+	sub, err := bus.Subscribe(new(event.EvtPeerTrimmed), eventbus.BufSize(10))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	not := cm.Notifee()
+	var trimmed []peer.ID
+	for i := 0; i < 4; i++ {
+		conn := randConn(t, not.Disconnected)
+		not.Connected(nil, conn)
+		if i == 3 {
+			// the most valuable peer is kept
+			cm.TagPeer(conn.RemotePeer(), "foo", 10)
+		} else {
+			cm.TagPeer(conn.RemotePeer(), "foo", i)
+			trimmed = append(trimmed, conn.RemotePeer())
+		}
+	}
+	cm.TrimOpenConns(context.Background())
+
+	for _, p := range trimmed {
+		select {
+		case evt := <-sub.Out():
+			e := evt.(event.EvtPeerTrimmed)
+			require.Equal(t, p, e.Peer)
+			require.Equal(t, event.TrimReasonLowWatermark, e.Reason)
+			require.Equal(t, 1, e.Conns)
+		case <-time.After(time.Second):
+			t.Fatal("expected a peer trimmed event")
+		}
+	}
+
+	// only the last two decisions are remembered
+	history := cm.TrimHistory()
+	require.Len(t, history, 2)
+	require.Equal(t, trimmed[1], history[0].Peer)
+	require.Equal(t, 1, history[0].Value)
+	require.Equal(t, trimmed[2], history[1].Peer)
+	require.Equal(t, 2, history[1].Value)
+}
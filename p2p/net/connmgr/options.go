@@ -2,9 +2,11 @@ package connmgr
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/benbjohnson/clock"
+	ds "github.com/ipfs/go-datastore"
 )
 
 // config is the configuration struct for the basic connection manager.
@@ -16,6 +18,11 @@ type config struct {
 	decayer       *DecayerCfg
 	emergencyTrim bool
 	clock         clock.Clock
+	peerGroups    []PeerGroup
+	datastore     ds.Datastore
+
+	trimHistorySize int
+	persistenceTTL  time.Duration
 }
 
 // Option represents an option for the basic connection manager.
@@ -62,3 +69,47 @@ func WithSilencePeriod(p time.Duration) Option {
 		return nil
 	}
 }
+
+// WithPeerGroups configures peer groups with connection quotas, which are kept when trimming.
+// Group maximums are also enforced below the watermarks, see PeerGroup.
+func WithPeerGroups(groups ...PeerGroup) Option {
+	return func(cfg *config) error {
+		for _, g := range groups {
+			if g.Match == nil {
+				return fmt.Errorf("peer group %q has no matcher", g.Name)
+			}
+			if g.Min < 0 || g.Max < 0 {
+				return fmt.Errorf("peer group %q has a negative quota", g.Name)
+			}
+			if g.Max > 0 && g.Min > g.Max {
+				return fmt.Errorf("peer group %q has a minimum larger than its maximum", g.Name)
+			}
+		}
+		cfg.peerGroups = append(cfg.peerGroups, groups...)
+		return nil
+	}
+}
+
+// WithDatastore persists decaying tag values and peer protections in store.
+// Both are restored in the background when the peer reconnects, decaying tag values
+// once the tag has been registered, so IsProtected doesn't report persisted protections
+// of a peer until then. Values decay while the peer is disconnected, as if it were connected.
+// The state of peers that don't reconnect is removed after the persistence TTL, see WithPersistenceTTL.
+func WithDatastore(store ds.Datastore) Option {
+	return func(cfg *config) error {
+		cfg.datastore = store
+		return nil
+	}
+}
+
+// WithPersistenceTTL sets the time after which the persisted state of a disconnected peer
+// is removed from the datastore. Defaults to DefaultPersistenceTTL.
+func WithPersistenceTTL(ttl time.Duration) Option {
+	return func(cfg *config) error {
+		if ttl <= 0 {
+			return errors.New("persistence TTL must be positive")
+		}
+		cfg.persistenceTTL = ttl
+		return nil
+	}
+}
@@ -0,0 +1,379 @@
+package connmgr
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"time"
+
+	pool "github.com/libp2p/go-buffer-pool"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-base32"
+)
+
+// Decaying tag values and protections are stored under the following db key patterns:
+// /connmgr/decaying/<b32 peer id no padding>
+// /connmgr/protected/<b32 peer id no padding>
+var (
+	decayingBase  = ds.NewKey("/connmgr/decaying")
+	protectedBase = ds.NewKey("/connmgr/protected")
+)
+
+// DefaultPersistenceTTL is the default time after which the persisted state of
+// a peer that doesn't connect again is removed, see WithPersistenceTTL.
+const DefaultPersistenceTTL = 7 * 24 * time.Hour
+
+// persistGCInterval is the interval at which expired records are removed from the datastore.
+const persistGCInterval = time.Hour
+
+// persistedValue is the persisted state of a decaying tag value.
+type persistedValue struct {
+	Value     int
+	Added     time.Time
+	LastVisit time.Time
+}
+
+// persistedDecaying is the persisted state of the decaying tag values of a peer,
+// keyed by tag name.
+type persistedDecaying struct {
+	Values map[string]persistedValue
+	// Saved is the time the values were persisted at, when the peer disconnected.
+	Saved time.Time
+}
+
+// persistedProtection is the persisted state of the protections of a peer.
+type persistedProtection struct {
+	Tags map[string]struct{}
+	// LastSeen is the last time the protections changed, or the peer disconnected.
+	LastSeen time.Time
+}
+
+// persistCmd represents a command to persist the decaying tag values of a peer
+// that disconnected, or to restore them if restore is set. The values are keyed by tag name.
+type persistCmd struct {
+	peer    peer.ID
+	values  map[string]persistedValue
+	restore bool
+}
+
+func peerKey(base ds.Key, p peer.ID) ds.Key {
+	return base.ChildString(base32.RawStdEncoding.EncodeToString([]byte(p)))
+}
+
+func putGob(store ds.Datastore, k ds.Key, val interface{}) error {
+	var buf pool.Buffer
+	if err := gob.NewEncoder(&buf).Encode(val); err != nil {
+		return err
+	}
+	return store.Put(context.TODO(), k, buf.Bytes())
+}
+
+// getGob decodes the value stored under k into val. It returns false if there is no value.
+func getGob(store ds.Datastore, k ds.Key, val interface{}) (bool, error) {
+	b, err := store.Get(context.TODO(), k)
+	if err != nil {
+		if err == ds.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(val); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// snapshotDecaying copies the decaying tag values of a peer. The segment lock must be held.
+func snapshotDecaying(pi *peerInfo) map[string]persistedValue {
+	values := make(map[string]persistedValue, len(pi.decaying))
+	for t, v := range pi.decaying {
+		values[t.name] = persistedValue{Value: v.Value, Added: v.Added, LastVisit: v.LastVisit}
+	}
+	return values
+}
+
+// persistDecaying stores the decaying tag values of a peer, or deletes them if there are none.
+func (d *decayer) persistDecaying(cmd persistCmd) {
+	store := d.mgr.cfg.datastore
+	k := peerKey(decayingBase, cmd.peer)
+	var err error
+	if len(cmd.values) == 0 {
+		err = store.Delete(context.TODO(), k)
+	} else {
+		err = putGob(store, k, &persistedDecaying{Values: cmd.values, Saved: d.clock.Now()})
+	}
+	if err != nil {
+		log.Warnw("failed to persist decaying tags", "peer", cmd.peer, "error", err)
+	}
+}
+
+// loadDecaying loads the persisted decaying tag values of a peer.
+func loadDecaying(store ds.Datastore, p peer.ID) (map[string]persistedValue, error) {
+	var rec persistedDecaying
+	if _, err := getGob(store, peerKey(decayingBase, p), &rec); err != nil {
+		return nil, err
+	}
+	return rec.Values, nil
+}
+
+// restoreDecaying restores the persisted decaying tag values of a peer that reconnected.
+// Values of tags that aren't registered, or that the peer already has, are ignored.
+// Values are decayed for the intervals that passed while the peer was disconnected.
+func (d *decayer) restoreDecaying(p peer.ID) {
+	values, err := loadDecaying(d.mgr.cfg.datastore, p)
+	if err != nil {
+		log.Warnw("failed to load decaying tags", "peer", p, "error", err)
+		return
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	now := d.clock.Now()
+	d.tagsMu.Lock()
+	defer d.tagsMu.Unlock()
+
+	s := d.mgr.segments.get(p)
+	s.Lock()
+	defer s.Unlock()
+
+	pi, ok := s.peers[p]
+	if !ok || pi.temp {
+		// the peer disconnected again.
+		return
+	}
+	for name, pv := range values {
+		tag, ok := d.knownTags[name]
+		if !ok {
+			continue
+		}
+		if _, ok := pi.decaying[tag]; ok {
+			continue
+		}
+		v := &connmgr.DecayingValue{
+			Tag:       tag,
+			Peer:      p,
+			Added:     pv.Added,
+			LastVisit: pv.LastVisit,
+			Value:     pv.Value,
+		}
+		if decayMissed(v, now) {
+			continue
+		}
+		pi.decaying[tag] = v
+		pi.value += v.Value
+	}
+}
+
+// decayMissed applies the decays of the intervals since the value was last visited,
+// and reports whether the value should be removed.
+func decayMissed(v *connmgr.DecayingValue, now time.Time) bool {
+	tag := v.Tag.(*decayingTag)
+	for !v.LastVisit.Add(tag.interval).After(now) {
+		after, rm := tag.decayFn(*v)
+		if rm {
+			return true
+		}
+		v.LastVisit = v.LastVisit.Add(tag.interval)
+		if after == v.Value {
+			// the value won't decay any further.
+			break
+		}
+		v.Value = after
+	}
+	return false
+}
+
+// gcDecaying removes the persisted decaying tag values of peers that didn't connect
+// again within the persistence TTL.
+func (d *decayer) gcDecaying() {
+	now := d.clock.Now()
+	gcPersisted(d.mgr, decayingBase, func(b []byte) bool {
+		var rec persistedDecaying
+		return gob.NewDecoder(bytes.NewReader(b)).Decode(&rec) != nil || now.Sub(rec.Saved) >= d.mgr.cfg.persistenceTTL
+	})
+}
+
+// queueProtection records a protection change to be persisted in the background.
+// An empty tag only refreshes the record of the peer, see gcProtections.
+func (cm *BasicConnMgr) queueProtection(p peer.ID, tag string, protect bool) {
+	cm.persistMx.Lock()
+	ops, ok := cm.protectionOps[p]
+	if !ok {
+		ops = make(map[string]bool, 1)
+		cm.protectionOps[p] = ops
+	}
+	if tag != "" {
+		ops[tag] = protect
+	}
+	cm.persistMx.Unlock()
+	cm.signalPersist()
+}
+
+// restoreProtections queues restoring the persisted protections of a peer that connected.
+func (cm *BasicConnMgr) restoreProtections(p peer.ID) {
+	cm.persistMx.Lock()
+	cm.protectionRestores[p] = struct{}{}
+	cm.persistMx.Unlock()
+	cm.signalPersist()
+}
+
+func (cm *BasicConnMgr) signalPersist() {
+	select {
+	case cm.persistSignal <- struct{}{}:
+	default:
+	}
+}
+
+// persistProtections persists protection changes and restores the protections of
+// peers that connected, in the background, so that Protect and Unprotect don't wait
+// for the datastore.
+func (cm *BasicConnMgr) persistProtections() {
+	defer cm.refCount.Done()
+
+	gcTicker := cm.clock.Ticker(persistGCInterval)
+	defer gcTicker.Stop()
+
+	for {
+		select {
+		case <-cm.persistSignal:
+			cm.flushProtections()
+		case <-gcTicker.C:
+			cm.gcProtections()
+		case <-cm.ctx.Done():
+			cm.flushProtections()
+			return
+		}
+	}
+}
+
+// flushProtections applies the queued protection changes to the persisted records,
+// and then restores the queued peers, so that restored records are up to date.
+func (cm *BasicConnMgr) flushProtections() {
+	cm.persistMx.Lock()
+	ops, restores := cm.protectionOps, cm.protectionRestores
+	cm.protectionOps = make(map[peer.ID]map[string]bool)
+	cm.protectionRestores = make(map[peer.ID]struct{})
+	cm.persistMx.Unlock()
+
+	store := cm.cfg.datastore
+	for p, tagOps := range ops {
+		k := peerKey(protectedBase, p)
+		var rec persistedProtection
+		found, err := getGob(store, k, &rec)
+		if err != nil {
+			log.Warnw("failed to load peer protection", "peer", p, "error", err)
+		}
+		if rec.Tags == nil {
+			rec.Tags = make(map[string]struct{}, len(tagOps))
+		}
+		for tag, protect := range tagOps {
+			if protect {
+				rec.Tags[tag] = struct{}{}
+			} else {
+				delete(rec.Tags, tag)
+			}
+		}
+		switch {
+		case len(rec.Tags) > 0:
+			rec.LastSeen = cm.clock.Now()
+			err = putGob(store, k, &rec)
+		case found:
+			err = store.Delete(context.TODO(), k)
+		}
+		if err != nil {
+			log.Warnw("failed to persist peer protection", "peer", p, "error", err)
+		}
+	}
+
+	for p := range restores {
+		var rec persistedProtection
+		if _, err := getGob(store, peerKey(protectedBase, p), &rec); err != nil {
+			log.Warnw("failed to load peer protection", "peer", p, "error", err)
+			continue
+		}
+		if len(rec.Tags) > 0 {
+			cm.mergeProtections(p, rec.Tags)
+		}
+	}
+}
+
+// mergeProtections adds restored protection tags of a peer. Tags that were changed
+// since they were loaded are skipped, since the change is more recent.
+func (cm *BasicConnMgr) mergeProtections(p peer.ID, restored map[string]struct{}) {
+	cm.plk.Lock()
+	defer cm.plk.Unlock()
+	cm.persistMx.Lock()
+	defer cm.persistMx.Unlock()
+
+	pending := cm.protectionOps[p]
+	tags, ok := cm.protected[p]
+	for tag := range restored {
+		if _, ok := pending[tag]; ok {
+			continue
+		}
+		if !ok {
+			tags = make(map[string]struct{}, len(restored))
+			cm.protected[p] = tags
+			ok = true
+		}
+		tags[tag] = struct{}{}
+	}
+}
+
+// gcProtections removes the persisted protections of peers that didn't connect again
+// within the persistence TTL.
+func (cm *BasicConnMgr) gcProtections() {
+	now := cm.clock.Now()
+	gcPersisted(cm, protectedBase, func(b []byte) bool {
+		var rec persistedProtection
+		return gob.NewDecoder(bytes.NewReader(b)).Decode(&rec) != nil || now.Sub(rec.LastSeen) >= cm.cfg.persistenceTTL
+	})
+}
+
+// gcPersisted deletes the records under base for which expired returns true,
+// unless the peer is connected.
+func gcPersisted(cm *BasicConnMgr, base ds.Key, expired func([]byte) bool) {
+	store := cm.cfg.datastore
+	results, err := store.Query(context.TODO(), query.Query{Prefix: base.String()})
+	if err != nil {
+		log.Warnw("failed to query datastore for GC", "prefix", base, "error", err)
+		return
+	}
+	var keys []ds.Key
+	for r := range results.Next() {
+		if r.Error != nil {
+			log.Warnw("failed to query datastore for GC", "prefix", base, "error", r.Error)
+			break
+		}
+		k := ds.RawKey(r.Key)
+		id, err := base32.RawStdEncoding.DecodeString(k.BaseNamespace())
+		if err != nil || len(id) == 0 {
+			keys = append(keys, k)
+			continue
+		}
+		if !expired(r.Value) || cm.isConnected(peer.ID(id)) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	results.Close()
+
+	for _, k := range keys {
+		if err := store.Delete(context.TODO(), k); err != nil {
+			log.Warnw("failed to delete expired record", "key", k, "error", err)
+		}
+	}
+}
+
+func (cm *BasicConnMgr) isConnected(p peer.ID) bool {
+	s := cm.segments.get(p)
+	s.Lock()
+	defer s.Unlock()
+	pi, ok := s.peers[p]
+	return ok && !pi.temp
+}
@@ -0,0 +1,213 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/benbjohnson/clock"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistDecayingTags(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	mockClock := clock.NewMock()
+	newConnMgr := func() *BasicConnMgr {
+		cm, err := NewConnManager(10, 20,
+			WithDatastore(store),
+			DecayerConfig(&DecayerCfg{Resolution: TestResolution, Clock: mockClock}),
+		)
+		require.NoError(t, err)
+		return cm
+	}
+
+	cm := newConnMgr()
+	tag, err := cm.RegisterDecayingTag("pop", TestResolution, connmgr.DecayFixed(1), connmgr.BumpSumUnbounded())
+	require.NoError(t, err)
+	conn := randConn(t, cm.Notifee().Disconnected)
+	id := conn.RemotePeer()
+	cm.Notifee().Connected(nil, conn)
+	require.NoError(t, tag.Bump(id, 10))
+	require.Eventually(t, func() bool { return cm.GetTagInfo(id).Value == 10 }, time.Second, 10*time.Millisecond)
+
+	// the values are persisted when the peer disconnects
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool {
+		ok, err := store.Has(context.Background(), peerKey(decayingBase, id))
+		return err == nil && ok
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, cm.Close())
+
+	// the values decay while the peer is disconnected, and are restored when it reconnects
+	mockClock.Add(3 * TestResolution)
+	cm = newConnMgr()
+	defer cm.Close()
+	_, err = cm.RegisterDecayingTag("pop", TestResolution, connmgr.DecayFixed(1), connmgr.BumpSumUnbounded())
+	require.NoError(t, err)
+	cm.Notifee().Connected(nil, randConnForPeer(id))
+	require.Eventually(t, func() bool { return cm.GetTagInfo(id).Value == 7 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, 7, cm.GetTagInfo(id).Tags["pop"])
+}
+
+func TestPersistDecayingTagsOnClose(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	cm, err := NewConnManager(10, 20, WithDatastore(store))
+	require.NoError(t, err)
+	tag, err := cm.RegisterDecayingTag("pop", time.Hour, connmgr.DecayNone(), connmgr.BumpSumUnbounded())
+	require.NoError(t, err)
+	conn := randConn(t, nil)
+	cm.Notifee().Connected(nil, conn)
+	require.NoError(t, tag.Bump(conn.RemotePeer(), 5))
+	require.Eventually(t, func() bool { return cm.GetTagInfo(conn.RemotePeer()).Value == 5 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, cm.Close())
+
+	values, err := loadDecaying(store, conn.RemotePeer())
+	require.NoError(t, err)
+	require.Equal(t, 5, values["pop"].Value)
+}
+
+// blockingDatastore blocks writes to the key block until unblock is closed.
+type blockingDatastore struct {
+	ds.Datastore
+	block   ds.Key
+	blocked chan struct{}
+	unblock chan struct{}
+}
+
+func (d *blockingDatastore) Put(ctx context.Context, k ds.Key, value []byte) error {
+	if k.Equal(d.block) {
+		close(d.blocked)
+		<-d.unblock
+	}
+	return d.Datastore.Put(ctx, k, value)
+}
+
+func TestPersistDecayingTagsQuickReconnect(t *testing.T) {
+	blocker := randConn(t, nil)
+	store := &blockingDatastore{
+		Datastore: dssync.MutexWrap(ds.NewMapDatastore()),
+		block:     peerKey(decayingBase, blocker.RemotePeer()),
+		blocked:   make(chan struct{}),
+		unblock:   make(chan struct{}),
+	}
+	cm, err := NewConnManager(10, 20, WithDatastore(store))
+	require.NoError(t, err)
+	defer cm.Close()
+	tag, err := cm.RegisterDecayingTag("pop", time.Hour, connmgr.DecayNone(), connmgr.BumpSumUnbounded())
+	require.NoError(t, err)
+
+	conn := randConn(t, cm.Notifee().Disconnected)
+	id := conn.RemotePeer()
+	cm.Notifee().Connected(nil, conn)
+	require.NoError(t, tag.Bump(id, 5))
+	require.Eventually(t, func() bool { return cm.GetTagInfo(id).Value == 5 }, time.Second, 10*time.Millisecond)
+
+	// block the decayer while persisting the values of another peer
+	cm.Notifee().Connected(nil, blocker)
+	require.NoError(t, tag.Bump(blocker.RemotePeer(), 1))
+	require.Eventually(t, func() bool { return cm.GetTagInfo(blocker.RemotePeer()).Value == 1 }, time.Second, 10*time.Millisecond)
+	cm.Notifee().Disconnected(nil, blocker)
+	<-store.blocked
+
+	// the peer disconnects and reconnects, before its values are persisted
+	require.NoError(t, conn.Close())
+	cm.Notifee().Connected(nil, randConnForPeer(id))
+	close(store.unblock)
+
+	require.Eventually(t, func() bool { return cm.GetTagInfo(id).Value == 5 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, 5, cm.GetTagInfo(id).Tags["pop"])
+}
+
+func TestPersistProtections(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	cm, err := NewConnManager(10, 20, WithDatastore(store))
+	require.NoError(t, err)
+	a, b, c := peer.ID("A"), peer.ID("B"), peer.ID("C")
+	cm.Protect(a, "foo")
+	cm.Protect(a, "bar")
+	cm.Protect(a, "baz")
+	cm.Protect(b, "foo")
+	cm.Unprotect(a, "bar")
+	cm.Unprotect(b, "foo")
+	cm.Protect(c, "foo")
+	require.NoError(t, cm.Close())
+
+	cm, err = NewConnManager(10, 20, WithDatastore(store))
+	require.NoError(t, err)
+	defer cm.Close()
+
+	// protections are restored when the peer reconnects
+	require.False(t, cm.IsProtected(a, ""))
+	// changes made before that are applied to the persisted protections
+	cm.Protect(a, "qux")
+	cm.Unprotect(a, "baz")
+	cm.Notifee().Connected(nil, randConnForPeer(a))
+	cm.Notifee().Connected(nil, randConnForPeer(b))
+	require.Eventually(t, func() bool { return cm.IsProtected(a, "foo") }, time.Second, 10*time.Millisecond)
+	require.True(t, cm.IsProtected(a, "qux"))
+	require.False(t, cm.IsProtected(a, "bar"))
+	require.False(t, cm.IsProtected(a, "baz"))
+	require.False(t, cm.IsProtected(b, ""))
+	require.False(t, cm.IsProtected(c, ""), "C didn't reconnect")
+}
+
+func TestPersistenceTTL(t *testing.T) {
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+	mockClock := clock.NewMock()
+	cm, err := NewConnManager(10, 20,
+		WithDatastore(store),
+		WithPersistenceTTL(time.Hour),
+		WithClock(mockClock),
+		WithSilencePeriod(time.Minute),
+		DecayerConfig(&DecayerCfg{Resolution: time.Minute, Clock: mockClock}),
+	)
+	require.NoError(t, err)
+	defer cm.Close()
+	tag, err := cm.RegisterDecayingTag("pop", time.Hour, connmgr.DecayNone(), connmgr.BumpSumUnbounded())
+	require.NoError(t, err)
+
+	connected, gone := peer.ID("A"), peer.ID("B")
+	cm.Protect(connected, "foo")
+	cm.Protect(gone, "foo")
+	cm.Notifee().Connected(nil, randConnForPeer(connected))
+	conn := randConnForPeer(gone)
+	cm.Notifee().Connected(nil, conn)
+	require.NoError(t, tag.Bump(gone, 5))
+	require.Eventually(t, func() bool { return cm.GetTagInfo(gone).Value == 5 }, time.Second, 10*time.Millisecond)
+	cm.Notifee().Disconnected(nil, conn)
+	require.Eventually(t, func() bool {
+		ok, err := store.Has(context.Background(), peerKey(decayingBase, gone))
+		return err == nil && ok
+	}, time.Second, 10*time.Millisecond)
+	cm.flushProtections()
+
+	mockClock.Add(30 * time.Minute)
+	cm.gcProtections()
+	cm.decayer.gcDecaying()
+	ok, err := store.Has(context.Background(), peerKey(protectedBase, gone))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// records of peers that are still connected are kept
+	mockClock.Add(time.Hour)
+	cm.gcProtections()
+	cm.decayer.gcDecaying()
+	for _, k := range []ds.Key{peerKey(protectedBase, gone), peerKey(decayingBase, gone)} {
+		ok, err := store.Has(context.Background(), k)
+		require.NoError(t, err)
+		require.False(t, ok, k)
+	}
+	ok, err = store.Has(context.Background(), peerKey(protectedBase, connected))
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func randConnForPeer(p peer.ID) network.Conn {
+	return &tconn{peer: p}
+}
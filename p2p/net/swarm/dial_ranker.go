@@ -0,0 +1,163 @@
+package swarm
+
+import (
+	"sort"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// DefaultTCPDelay is the delay after which DefaultDialRanker dials
+	// non-QUIC addresses, giving QUIC dials a head start.
+	DefaultTCPDelay = 250 * time.Millisecond
+
+	// DefaultRelayDelay is the delay after which DefaultDialRanker dials
+	// relay addresses.
+	DefaultRelayDelay = 500 * time.Millisecond
+
+	// DefaultHappyEyeballsDelay is the connection attempt delay recommended by RFC 8305.
+	DefaultHappyEyeballsDelay = 250 * time.Millisecond
+)
+
+// AddrDelay is an address together with the delay after which it should be
+// dialed. The delay is relative to the time the dial worker started dialing
+// the peer.
+type AddrDelay struct {
+	Addr  ma.Multiaddr
+	Delay time.Duration
+}
+
+// DialRanker assigns dial delays to a peer's addresses.
+//
+// The dial worker dials addresses in increasing order of delay. If all dials
+// in flight fail, the next group of addresses is dialed right away without
+// waiting for its delay to elapse. A DialRanker should return every address
+// it was passed exactly once; addresses it omits are dialed last.
+type DialRanker func([]ma.Multiaddr) []AddrDelay
+
+// NoDelayDialRanker orders addresses by preference and dials all of them at
+// once. This is the swarm's default.
+func NoDelayDialRanker(addrs []ma.Multiaddr) []AddrDelay {
+	ranked := rankAddrs(addrs)
+	res := make([]AddrDelay, 0, len(ranked))
+	for _, a := range ranked {
+		res = append(res, AddrDelay{Addr: a})
+	}
+	return res
+}
+
+// DefaultDialRanker dials QUIC addresses first, all other direct addresses
+// after DefaultTCPDelay, and relay addresses after DefaultRelayDelay.
+func DefaultDialRanker(addrs []ma.Multiaddr) []AddrDelay {
+	return NewDelayDialRanker(DefaultTCPDelay, DefaultRelayDelay)(addrs)
+}
+
+// NewDelayDialRanker returns a DialRanker that dials QUIC addresses
+// immediately, all other direct addresses (TCP, WebSocket, ...) after
+// tcpDelay, and relay addresses after relayDelay.
+func NewDelayDialRanker(tcpDelay, relayDelay time.Duration) DialRanker {
+	return func(addrs []ma.Multiaddr) []AddrDelay {
+		ranked := rankAddrs(addrs)
+		res := make([]AddrDelay, 0, len(ranked))
+		for _, a := range ranked {
+			var delay time.Duration
+			switch {
+			case isRelayAddr(a):
+				delay = relayDelay
+			case !isQUIC(a):
+				delay = tcpDelay
+			}
+			res = append(res, AddrDelay{Addr: a, Delay: delay})
+		}
+		sort.SliceStable(res, func(i, j int) bool { return res[i].Delay < res[j].Delay })
+		return res
+	}
+}
+
+// happyEyeballs delays the IPv4 addresses in ads by delay relative to the first
+// IPv6 address using the same transport protocol (TCP or UDP), if there is one.
+// Relay addresses are left untouched.
+func happyEyeballs(ads []AddrDelay, delay time.Duration) []AddrDelay {
+	// the earliest IPv6 dial per transport protocol
+	firstIP6 := make(map[int]time.Duration, 2)
+	for _, ad := range ads {
+		ip, tpt, ok := ipAndTransport(ad.Addr)
+		if !ok || ip != ma.P_IP6 {
+			continue
+		}
+		if d, ok := firstIP6[tpt]; !ok || ad.Delay < d {
+			firstIP6[tpt] = ad.Delay
+		}
+	}
+	if len(firstIP6) == 0 {
+		return ads
+	}
+
+	res := make([]AddrDelay, 0, len(ads))
+	for _, ad := range ads {
+		if ip, tpt, ok := ipAndTransport(ad.Addr); ok && ip == ma.P_IP4 {
+			if d, ok := firstIP6[tpt]; ok && ad.Delay < d+delay {
+				ad.Delay = d + delay
+			}
+		}
+		res = append(res, ad)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Delay < res[j].Delay })
+	return res
+}
+
+// ipAndTransport returns the IP and transport protocol codes of a direct
+// /ip4 or /ip6 address.
+func ipAndTransport(a ma.Multiaddr) (ip, tpt int, ok bool) {
+	if isRelayAddr(a) {
+		return 0, 0, false
+	}
+	protos := a.Protocols()
+	if len(protos) < 2 {
+		return 0, 0, false
+	}
+	ip, tpt = protos[0].Code, protos[1].Code
+	if (ip != ma.P_IP4 && ip != ma.P_IP6) || (tpt != ma.P_TCP && tpt != ma.P_UDP) {
+		return 0, 0, false
+	}
+	return ip, tpt, true
+}
+
+// ranks addresses in descending order of preference for dialing, with the following rules:
+// NonRelay > Relay
+// NonWS > WS
+// Private > Public
+// UDP > TCP
+func rankAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	addrTier := func(a ma.Multiaddr) (tier int) {
+		if isRelayAddr(a) {
+			tier |= 0b1000
+		}
+		if isExpensiveAddr(a) {
+			tier |= 0b0100
+		}
+		if !manet.IsPrivateAddr(a) {
+			tier |= 0b0010
+		}
+		if isFdConsumingAddr(a) {
+			tier |= 0b0001
+		}
+
+		return tier
+	}
+
+	tiers := make([][]ma.Multiaddr, 16)
+	for _, a := range addrs {
+		tier := addrTier(a)
+		tiers[tier] = append(tiers[tier], a)
+	}
+
+	result := make([]ma.Multiaddr, 0, len(addrs))
+	for _, tier := range tiers {
+		result = append(result, tier...)
+	}
+
+	return result
+}
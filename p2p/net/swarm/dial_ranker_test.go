@@ -0,0 +1,81 @@
+package swarm
+
+import (
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelayDialRanker(t *testing.T) {
+	quicAddr := ma.StringCast("/ip4/1.2.3.4/udp/1234/quic")
+	tcpAddr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	wsAddr := ma.StringCast("/ip4/1.2.3.4/tcp/1235/ws")
+	relayAddr := ma.StringCast("/ip4/1.2.3.5/udp/1234/quic/p2p/QmbHVEEepCi7rn7VL7Exxpd2Ci9NNB6ifvqwhsrbRMgQFP/p2p-circuit")
+
+	ranker := NewDelayDialRanker(time.Second, 2*time.Second)
+	res := ranker([]ma.Multiaddr{relayAddr, wsAddr, tcpAddr, quicAddr})
+	require.Equal(t, []AddrDelay{
+		{Addr: quicAddr, Delay: 0},
+		{Addr: tcpAddr, Delay: time.Second},
+		{Addr: wsAddr, Delay: time.Second},
+		{Addr: relayAddr, Delay: 2 * time.Second},
+	}, res)
+}
+
+func TestNoDelayDialRanker(t *testing.T) {
+	quicAddr := ma.StringCast("/ip4/1.2.3.4/udp/1234/quic")
+	tcpAddr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	privAddr := ma.StringCast("/ip4/192.168.1.1/tcp/1234")
+
+	res := NoDelayDialRanker([]ma.Multiaddr{tcpAddr, quicAddr, privAddr})
+	require.Equal(t, []AddrDelay{
+		{Addr: privAddr},
+		{Addr: quicAddr},
+		{Addr: tcpAddr},
+	}, res)
+}
+
+func TestRankedDials(t *testing.T) {
+	a1 := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	a2 := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	a3 := ma.StringCast("/ip4/1.2.3.4/tcp/3")
+
+	// the ranker drops a2, duplicates a1 and returns an address it wasn't asked about
+	ranker := func([]ma.Multiaddr) []AddrDelay {
+		return []AddrDelay{
+			{Addr: a1, Delay: time.Second},
+			{Addr: a1, Delay: 2 * time.Second},
+			{Addr: a3, Delay: 3 * time.Second},
+		}
+	}
+	res := rankedDials(ranker, []ma.Multiaddr{a1, a2})
+	require.Equal(t, []AddrDelay{
+		{Addr: a1, Delay: time.Second},
+		{Addr: a2, Delay: time.Second},
+	}, res)
+}
+
+func TestHappyEyeballsRanking(t *testing.T) {
+	tcp4 := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	tcp6 := ma.StringCast("/ip6/2001:db8::1/tcp/1234")
+	quic4 := ma.StringCast("/ip4/1.2.3.4/udp/1234/quic")
+	quic6 := ma.StringCast("/ip6/2001:db8::1/udp/1234/quic")
+	tcp4Only := ma.StringCast("/ip4/1.2.3.5/tcp/1234")
+
+	ads := NewDelayDialRanker(time.Second, 2*time.Second)([]ma.Multiaddr{tcp4, tcp6, quic4, quic6})
+	require.Equal(t, []AddrDelay{
+		{Addr: quic6, Delay: 0},
+		{Addr: quic4, Delay: 100 * time.Millisecond},
+		{Addr: tcp6, Delay: time.Second},
+		{Addr: tcp4, Delay: time.Second + 100*time.Millisecond},
+	}, happyEyeballs(ads, 100*time.Millisecond))
+
+	// without an IPv6 address, IPv4 addresses aren't delayed
+	ads = NoDelayDialRanker([]ma.Multiaddr{tcp4Only, quic6})
+	require.ElementsMatch(t, []AddrDelay{
+		{Addr: quic6},
+		{Addr: tcp4Only},
+	}, happyEyeballs(ads, 100*time.Millisecond))
+}
@@ -2,13 +2,14 @@ package swarm
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 
 	ma "github.com/multiformats/go-multiaddr"
-	manet "github.com/multiformats/go-multiaddr/net"
 )
 
 // /////////////////////////////////////////////////////////////////////////////////
@@ -35,6 +36,7 @@ type pendRequest struct {
 type addrDial struct {
 	addr     ma.Multiaddr
 	ctx      context.Context
+	cancel   context.CancelFunc
 	conn     *Conn
 	err      error
 	requests []int
@@ -52,10 +54,12 @@ type dialWorker struct {
 
 	connected bool // true when a connection has been successfully established
 
-	nextDial []ma.Multiaddr
-
-	// ready when we have more addresses to dial (nextDial is not empty)
-	triggerDial <-chan struct{}
+	// addresses waiting to be dialed, sorted by delay
+	dialQueue []AddrDelay
+	// number of dials started that haven't completed yet
+	dialsInFlight int
+	// the time the current round of dials started; dial delays are relative to it
+	startTime time.Time
 
 	// for testing
 	wg sync.WaitGroup
@@ -76,13 +80,42 @@ func (w *dialWorker) loop() {
 	w.wg.Add(1)
 	defer w.wg.Done()
 	defer w.s.limiter.clearAllPeerDials(w.peer)
-
-	// used to signal readiness to dial and completion of the dial
-	ready := make(chan struct{})
-	close(ready)
+	defer func() {
+		for _, ad := range w.pending {
+			ad.cancel()
+		}
+	}()
+
+	// fires when the next group of addresses in the dial queue should be dialed
+	var dialTimer *time.Timer
+	var dialTimerCh <-chan time.Time
+	defer func() {
+		if dialTimer != nil {
+			dialTimer.Stop()
+		}
+	}()
+	scheduleNextDial := func() {
+		if dialTimer != nil {
+			dialTimer.Stop()
+			dialTimer = nil
+			dialTimerCh = nil
+		}
+		if len(w.dialQueue) == 0 {
+			return
+		}
+		// if there are no dials in flight, there's no point in waiting
+		var delay time.Duration
+		if w.dialsInFlight > 0 {
+			delay = time.Until(w.startTime.Add(w.dialQueue[0].Delay))
+		}
+		dialTimer = time.NewTimer(delay)
+		dialTimerCh = dialTimer.C
+	}
 
 loop:
 	for {
+		scheduleNextDial()
+
 		select {
 		case req, ok := <-w.reqch:
 			if !ok {
@@ -102,9 +135,6 @@ loop:
 			}
 
 			// at this point, len(addrs) > 0 or else it would be error from addrsForDial
-			// ranke them to process in order
-			addrs = w.rankAddrs(addrs)
-
 			// create the pending request object
 			pr := &pendRequest{
 				req:   req,
@@ -154,6 +184,12 @@ loop:
 			w.reqno++
 			w.requests[w.reqno] = pr
 
+			// simultaneous connect and forced direct dials are time sensitive,
+			// so we don't delay any of their addresses
+			simConnect, _, _ := network.GetSimultaneousConnect(req.ctx)
+			forceDirect, _ := network.GetForceDirectDial(req.ctx)
+			noDelay := simConnect || forceDirect
+
 			for _, ad := range tojoin {
 				if !ad.dialed {
 					if simConnect, isClient, reason := network.GetSimultaneousConnect(req.ctx); simConnect {
@@ -161,41 +197,68 @@ loop:
 							ad.ctx = network.WithSimultaneousConnect(ad.ctx, isClient, reason)
 						}
 					}
+					if noDelay {
+						w.enqueue([]AddrDelay{{Addr: ad.addr}})
+					}
 				}
 				ad.requests = append(ad.requests, w.reqno)
 			}
 
 			if len(todial) > 0 {
 				for _, a := range todial {
-					w.pending[a] = &addrDial{addr: a, ctx: req.ctx, requests: []int{w.reqno}}
+					ctx, cancel := context.WithCancel(req.ctx)
+					w.pending[a] = &addrDial{addr: a, ctx: ctx, cancel: cancel, requests: []int{w.reqno}}
 				}
 
-				w.nextDial = append(w.nextDial, todial...)
-				w.nextDial = w.rankAddrs(w.nextDial)
+				// start a new round of dials if we're not dialing already
+				if len(w.dialQueue) == 0 && w.dialsInFlight == 0 {
+					w.startTime = time.Now()
+				}
 
-				// trigger a new dial now to account for the new addrs we added
-				w.triggerDial = ready
+				ranker := w.s.dialRanker
+				if noDelay {
+					ranker = NoDelayDialRanker
+				}
+				dials := rankedDials(ranker, todial)
+				if !noDelay {
+					if w.s.happyEyeballsDelay > 0 {
+						dials = happyEyeballs(dials, w.s.happyEyeballsDelay)
+					}
+					dials = w.s.preferKnownGoodAddrs(w.peer, dials)
+				}
+				w.enqueue(dials)
 			}
 
-		case <-w.triggerDial:
-			for _, addr := range w.nextDial {
+		case <-dialTimerCh:
+			for _, adelay := range w.nextBatch() {
+				ad := w.pending[adelay.Addr]
+				if !w.hasPendingRequests(ad) {
+					// all requests for this addr have completed, there's no point in dialing it
+					ad.cancel()
+					delete(w.pending, ad.addr)
+					continue
+				}
+
 				// spawn the dial
-				ad := w.pending[addr]
-				err := w.s.dialNextAddr(ad.ctx, w.peer, addr, w.resch)
+				ad.dialed = true
+				err := w.s.dialNextAddr(ad.ctx, w.peer, ad.addr, w.resch)
 				if err != nil {
+					ad.cancel()
 					w.dispatchError(ad, err)
+					continue
 				}
+				w.dialsInFlight++
 			}
 
-			w.nextDial = nil
-			w.triggerDial = nil
-
 		case res := <-w.resch:
+			w.dialsInFlight--
+
 			if res.Conn != nil {
 				w.connected = true
 			}
 
 			ad := w.pending[res.Addr]
+			ad.cancel()
 
 			if res.Conn != nil {
 				// we got a connection, add it to the swarm
@@ -222,6 +285,10 @@ loop:
 				ad.conn = conn
 				ad.requests = nil
 
+				if w.s.happyEyeballsDelay > 0 {
+					w.cancelLosers()
+				}
+
 				continue loop
 			}
 
@@ -278,39 +345,88 @@ func (w *dialWorker) dispatchError(ad *addrDial, err error) {
 	}
 }
 
-// ranks addresses in descending order of preference for dialing, with the following rules:
-// NonRelay > Relay
-// NonWS > WS
-// Private > Public
-// UDP > TCP
-func (w *dialWorker) rankAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
-	addrTier := func(a ma.Multiaddr) (tier int) {
-		if isRelayAddr(a) {
-			tier |= 0b1000
-		}
-		if isExpensiveAddr(a) {
-			tier |= 0b0100
-		}
-		if !manet.IsPrivateAddr(a) {
-			tier |= 0b0010
+// enqueue adds addresses to the dial queue, keeping it sorted by delay.
+// Addresses already in the queue are moved to their new position.
+func (w *dialWorker) enqueue(ads []AddrDelay) {
+	for _, ad := range ads {
+		for i, q := range w.dialQueue {
+			if q.Addr.Equal(ad.Addr) {
+				w.dialQueue = append(w.dialQueue[:i], w.dialQueue[i+1:]...)
+				break
+			}
 		}
-		if isFdConsumingAddr(a) {
-			tier |= 0b0001
+		w.dialQueue = append(w.dialQueue, ad)
+	}
+	sort.SliceStable(w.dialQueue, func(i, j int) bool { return w.dialQueue[i].Delay < w.dialQueue[j].Delay })
+}
+
+// nextBatch removes and returns the addresses from the dial queue that are due to be dialed.
+// If no dials are in flight, the next group of addresses is returned even if its delay
+// hasn't elapsed yet.
+func (w *dialWorker) nextBatch() []AddrDelay {
+	if len(w.dialQueue) == 0 {
+		return nil
+	}
+	elapsed := time.Since(w.startTime)
+	if w.dialsInFlight == 0 && w.dialQueue[0].Delay > elapsed {
+		elapsed = w.dialQueue[0].Delay
+	}
+	var n int
+	for n < len(w.dialQueue) && w.dialQueue[n].Delay <= elapsed {
+		n++
+	}
+	batch := make([]AddrDelay, n)
+	copy(batch, w.dialQueue[:n])
+	w.dialQueue = w.dialQueue[n:]
+	return batch
+}
+
+// cancelLosers cancels the dials in flight that no pending request is waiting for anymore
+func (w *dialWorker) cancelLosers() {
+	for _, ad := range w.pending {
+		if ad.dialed && ad.conn == nil && ad.err == nil && !w.hasPendingRequests(ad) {
+			ad.cancel()
 		}
+	}
+}
 
-		return tier
+// hasPendingRequests returns whether any of the requests an addr dial was started for
+// are still waiting for a response
+func (w *dialWorker) hasPendingRequests(ad *addrDial) bool {
+	for _, reqno := range ad.requests {
+		if _, ok := w.requests[reqno]; ok {
+			return true
+		}
 	}
+	return false
+}
 
-	tiers := make([][]ma.Multiaddr, 16)
+// rankedDials runs the ranker on addrs. Addresses the ranker returns that weren't passed to it
+// are ignored, and addresses it drops are appended with the largest delay it assigned, so that
+// every address is dialed eventually.
+func rankedDials(ranker DialRanker, addrs []ma.Multiaddr) []AddrDelay {
+	todo := make(map[string]ma.Multiaddr, len(addrs))
 	for _, a := range addrs {
-		tier := addrTier(a)
-		tiers[tier] = append(tiers[tier], a)
+		todo[string(a.Bytes())] = a
 	}
 
-	result := make([]ma.Multiaddr, 0, len(addrs))
-	for _, tier := range tiers {
-		result = append(result, tier...)
+	var maxDelay time.Duration
+	res := make([]AddrDelay, 0, len(addrs))
+	for _, ad := range ranker(addrs) {
+		a, ok := todo[string(ad.Addr.Bytes())]
+		if !ok {
+			continue
+		}
+		delete(todo, string(ad.Addr.Bytes()))
+		if ad.Delay > maxDelay {
+			maxDelay = ad.Delay
+		}
+		res = append(res, AddrDelay{Addr: a, Delay: ad.Delay})
 	}
-
-	return result
+	for _, a := range addrs {
+		if _, ok := todo[string(a.Bytes())]; ok {
+			res = append(res, AddrDelay{Addr: a, Delay: maxDelay})
+		}
+	}
+	return res
 }
@@ -34,7 +34,7 @@ func newPeer(t *testing.T) (crypto.PrivKey, peer.ID) {
 	return priv, id
 }
 
-func makeSwarm(t *testing.T) *Swarm {
+func makeSwarm(t *testing.T, opts ...Option) *Swarm {
 	priv, id := newPeer(t)
 
 	ps, err := pstoremem.NewPeerstore()
@@ -43,7 +43,7 @@ func makeSwarm(t *testing.T) *Swarm {
 	ps.AddPrivKey(id, priv)
 	t.Cleanup(func() { ps.Close() })
 
-	s, err := NewSwarm(id, ps, WithDialTimeout(time.Second))
+	s, err := NewSwarm(id, ps, append([]Option{WithDialTimeout(time.Second)}, opts...)...)
 	require.NoError(t, err)
 
 	upgrader := makeUpgrader(t, s)
@@ -340,3 +340,110 @@ func TestDialWorkerLoopConcurrentFailureStress(t *testing.T) {
 	close(reqch)
 	worker.wg.Wait()
 }
+
+func getAddrs(t *testing.T, s *Swarm) (tcpAddr, quicAddr ma.Multiaddr) {
+	for _, a := range s.ListenAddresses() {
+		if isQUIC(a) {
+			quicAddr = a
+		} else {
+			tcpAddr = a
+		}
+	}
+	require.NotNil(t, tcpAddr)
+	require.NotNil(t, quicAddr)
+	return tcpAddr, quicAddr
+}
+
+func TestDialWorkerLoopRanking(t *testing.T) {
+	// dial TCP first and only dial QUIC much later
+	tcpFirst := func(addrs []ma.Multiaddr) []AddrDelay {
+		res := make([]AddrDelay, 0, len(addrs))
+		for _, a := range addrs {
+			if isQUIC(a) {
+				res = append(res, AddrDelay{Addr: a, Delay: time.Hour})
+			} else {
+				res = append(res, AddrDelay{Addr: a})
+			}
+		}
+		return res
+	}
+
+	for _, tc := range []struct {
+		name   string
+		ranker DialRanker
+		isQUIC bool
+	}{
+		{name: "quic first", ranker: NewDelayDialRanker(time.Hour, time.Hour), isQUIC: true},
+		{name: "tcp first", ranker: tcpFirst, isQUIC: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s1 := makeSwarm(t, WithDialRanker(tc.ranker))
+			s2 := makeSwarm(t)
+			defer s1.Close()
+			defer s2.Close()
+
+			s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+			reqch := make(chan dialRequest)
+			resch := make(chan dialResponse)
+			worker := newDialWorker(s1, s2.LocalPeer(), reqch)
+			go worker.loop()
+
+			reqch <- dialRequest{ctx: context.Background(), resch: resch}
+			select {
+			case res := <-resch:
+				require.NoError(t, res.err)
+				require.Equal(t, tc.isQUIC, isQUIC(res.conn.RemoteMultiaddr()))
+			case <-time.After(10 * time.Second):
+				t.Fatal("dial didn't complete")
+			}
+			// the delayed address must not have been dialed
+			require.Len(t, s1.ConnsToPeer(s2.LocalPeer()), 1)
+
+			close(reqch)
+			worker.wg.Wait()
+		})
+	}
+}
+
+func TestDialWorkerLoopRankingFailure(t *testing.T) {
+	s2 := makeSwarm(t)
+	defer s2.Close()
+	_, quicAddr := getAddrs(t, s2)
+
+	// nothing listens on this port, so dials fail right away
+	badAddr := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	ranker := func(addrs []ma.Multiaddr) []AddrDelay {
+		res := make([]AddrDelay, 0, len(addrs))
+		for _, a := range addrs {
+			if a.Equal(badAddr) {
+				res = append(res, AddrDelay{Addr: a})
+			} else {
+				res = append(res, AddrDelay{Addr: a, Delay: time.Hour})
+			}
+		}
+		return res
+	}
+	s1 := makeSwarm(t, WithDialRanker(ranker))
+	defer s1.Close()
+
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), []ma.Multiaddr{badAddr, quicAddr}, peerstore.PermanentAddrTTL)
+
+	reqch := make(chan dialRequest)
+	resch := make(chan dialResponse)
+	worker := newDialWorker(s1, s2.LocalPeer(), reqch)
+	go worker.loop()
+
+	// once the first dial fails, the delayed address is dialed immediately
+	reqch <- dialRequest{ctx: context.Background(), resch: resch}
+	select {
+	case res := <-resch:
+		require.NoError(t, res.err)
+		require.True(t, isQUIC(res.conn.RemoteMultiaddr()))
+	case <-time.After(10 * time.Second):
+		t.Fatal("dial didn't complete")
+	}
+
+	close(reqch)
+	worker.wg.Wait()
+}
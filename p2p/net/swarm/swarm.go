@@ -85,6 +85,32 @@ func WithDialTimeoutLocal(t time.Duration) Option {
 	}
 }
 
+// WithDialRanker sets the DialRanker used to schedule dials to a peer's addresses.
+// By default all addresses are dialed at once, see NoDelayDialRanker.
+func WithDialRanker(d DialRanker) Option {
+	return func(s *Swarm) error {
+		if d == nil {
+			return errors.New("swarm: dial ranker cannot be nil")
+		}
+		s.dialRanker = d
+		return nil
+	}
+}
+
+// WithHappyEyeballs enables RFC 8305 style racing of IPv6 and IPv4 addresses.
+// IPv6 addresses are dialed first, and IPv4 addresses using the same transport
+// protocol only after delay. Once a connection is established, the dials still
+// in flight are canceled.
+func WithHappyEyeballs(delay time.Duration) Option {
+	return func(s *Swarm) error {
+		if delay <= 0 {
+			return errors.New("swarm: happy eyeballs delay must be positive")
+		}
+		s.happyEyeballsDelay = delay
+		return nil
+	}
+}
+
 func WithResourceManager(m network.ResourceManager) Option {
 	return func(s *Swarm) error {
 		s.rcmgr = m
@@ -147,6 +173,11 @@ type Swarm struct {
 	limiter *dialLimiter
 	gater   connmgr.ConnectionGater
 
+	dialRanker         DialRanker
+	happyEyeballsDelay time.Duration
+	// nil if the peerstore doesn't track per-address dial outcomes
+	addrStats peerstore.AddrStatsBook
+
 	closeOnce sync.Once
 	ctx       context.Context // is canceled when Close is called
 	ctxCancel context.CancelFunc
@@ -165,6 +196,7 @@ func NewSwarm(local peer.ID, peers peerstore.Peerstore, opts ...Option) (*Swarm,
 		dialTimeout:      defaultDialTimeout,
 		dialTimeoutLocal: defaultDialTimeoutLocal,
 		maResolver:       madns.DefaultResolver,
+		dialRanker:       NoDelayDialRanker,
 	}
 
 	s.conns.m = make(map[peer.ID][]*Conn)
@@ -180,6 +212,7 @@ func NewSwarm(local peer.ID, peers peerstore.Peerstore, opts ...Option) (*Swarm,
 	if s.rcmgr == nil {
 		s.rcmgr = network.NullResourceManager
 	}
+	s.addrStats, _ = peerstore.GetAddrStatsBook(peers)
 
 	s.dsync = newDialSync(s.dialWorkerLoop)
 	s.limiter = newDialLimiter(s.dialAddr)
@@ -11,6 +11,7 @@ import (
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/transport"
 
 	ma "github.com/multiformats/go-multiaddr"
@@ -42,6 +43,7 @@ type Conn struct {
 }
 
 var _ network.Conn = &Conn{}
+var _ network.DatagramConn = &Conn{}
 
 func (c *Conn) ID() string {
 	// format: <first 10 chars of peer id>-<global conn ordinal>
@@ -251,3 +253,30 @@ func (c *Conn) GetStreams() []network.Stream {
 func (c *Conn) Scope() network.ConnScope {
 	return c.conn.Scope()
 }
+
+// NegotiateDatagrams negotiates sending datagrams for protocol p, if the underlying transport supports datagrams.
+func (c *Conn) NegotiateDatagrams(ctx context.Context, p protocol.ID) error {
+	dc, ok := c.conn.(network.DatagramConn)
+	if !ok {
+		return network.ErrDatagramsNotSupported
+	}
+	return dc.NegotiateDatagrams(ctx, p)
+}
+
+// SendDatagram sends a datagram for protocol p, if the underlying transport supports datagrams.
+func (c *Conn) SendDatagram(p protocol.ID, b []byte) error {
+	dc, ok := c.conn.(network.DatagramConn)
+	if !ok {
+		return network.ErrDatagramsNotSupported
+	}
+	return dc.SendDatagram(p, b)
+}
+
+// ReceiveDatagram receives a datagram for protocol p, if the underlying transport supports datagrams.
+func (c *Conn) ReceiveDatagram(ctx context.Context, p protocol.ID) ([]byte, error) {
+	dc, ok := c.conn.(network.DatagramConn)
+	if !ok {
+		return nil, network.ErrDatagramsNotSupported
+	}
+	return dc.ReceiveDatagram(ctx, p)
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/canonicallog"
@@ -480,8 +483,10 @@ func (s *Swarm) dialAddr(ctx context.Context, p peer.ID, addr ma.Multiaddr) (tra
 		return nil, ErrNoTransport
 	}
 
+	start := time.Now()
 	connC, err := tpt.Dial(ctx, addr, p)
 	if err != nil {
+		s.recordDialFailure(ctx, p, addr, err)
 		return nil, err
 	}
 	canonicallog.LogPeerStatus(100, connC.RemotePeer(), connC.RemoteMultiaddr(), "connection_status", "established", "dir", "outbound")
@@ -494,10 +499,69 @@ func (s *Swarm) dialAddr(ctx context.Context, p peer.ID, addr ma.Multiaddr) (tra
 		return nil, err
 	}
 
+	if s.addrStats != nil {
+		s.addrStats.RecordDialSuccess(p, addr, time.Since(start))
+	}
+
 	// success! we got one!
 	return connC, nil
 }
 
+// recordDialFailure records a failed dial in the peerstore's AddrStatsBook.
+// Dials that were canceled aren't recorded, as they say nothing about the address.
+func (s *Swarm) recordDialFailure(ctx context.Context, p peer.ID, addr ma.Multiaddr, err error) {
+	if s.addrStats == nil || errors.Is(err, context.Canceled) || ctx.Err() == context.Canceled {
+		return
+	}
+	s.addrStats.RecordDialFailure(p, addr, dialErrorClass(err))
+}
+
+// dialErrorClass classifies dial errors into a few coarse classes
+func dialErrorClass(err error) string {
+	var nerr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &nerr) && nerr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return "unreachable"
+	default:
+		return "other"
+	}
+}
+
+// preferKnownGoodAddrs moves the addresses whose last dial succeeded to the front of their
+// group of addresses with the same delay. The delays assigned by the dial ranker are kept, so
+// that e.g. a relay address that worked before isn't dialed alongside the direct addresses.
+func (s *Swarm) preferKnownGoodAddrs(p peer.ID, ads []AddrDelay) []AddrDelay {
+	if s.addrStats == nil {
+		return ads
+	}
+	good := make(map[string]struct{})
+	for _, st := range s.addrStats.AddrStats(p) {
+		if st.LastDialSucceeded() {
+			good[string(st.Addr.Bytes())] = struct{}{}
+		}
+	}
+	if len(good) == 0 {
+		return ads
+	}
+	isGood := func(ad AddrDelay) bool {
+		_, ok := good[string(ad.Addr.Bytes())]
+		return ok
+	}
+	res := make([]AddrDelay, len(ads))
+	copy(res, ads)
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Delay != res[j].Delay {
+			return res[i].Delay < res[j].Delay
+		}
+		return isGood(res[i]) && !isGood(res[j])
+	})
+	return res
+}
+
 // TODO We should have a `IsFdConsuming() bool` method on the `Transport` interface in go-libp2p/core/transport.
 // This function checks if any of the transport protocols in the address requires a file descriptor.
 // For now:
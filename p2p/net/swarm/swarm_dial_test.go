@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/core/transport"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
 	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
 	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
@@ -204,3 +206,216 @@ func TestRemoveWebTransportAddrs(t *testing.T) {
 	require.Equal(t, []ma.Multiaddr{quicAddr}, maybeRemoveWebTransportAddrs([]ma.Multiaddr{quicAddr, webtransportAddr}))
 	require.Equal(t, []ma.Multiaddr{webtransportAddr}, maybeRemoveWebTransportAddrs([]ma.Multiaddr{webtransportAddr}))
 }
+
+type fakeDial struct {
+	addr     ma.Multiaddr
+	start    time.Time
+	canceled bool
+}
+
+// fakeTransport wraps a TCP transport. Dials to addresses in redirect are sent to
+// the corresponding real address, all other dials block until they are canceled.
+type fakeTransport struct {
+	transport.Transport
+	redirect map[string]ma.Multiaddr
+
+	mu    sync.Mutex
+	dials []*fakeDial
+}
+
+func (t *fakeTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	d := &fakeDial{addr: raddr, start: time.Now()}
+	t.mu.Lock()
+	t.dials = append(t.dials, d)
+	t.mu.Unlock()
+
+	if real, ok := t.redirect[string(raddr.Bytes())]; ok {
+		return t.Transport.Dial(ctx, real, p)
+	}
+	<-ctx.Done()
+	t.mu.Lock()
+	d.canceled = true
+	t.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+func (t *fakeTransport) getDial(addr ma.Multiaddr) (fakeDial, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, d := range t.dials {
+		if d.addr.Equal(addr) {
+			return *d, true
+		}
+	}
+	return fakeDial{}, false
+}
+
+func makeFakeTransportSwarm(t *testing.T, redirect map[string]ma.Multiaddr, opts ...Option) (*Swarm, *fakeTransport) {
+	priv, id := newPeer(t)
+	ps, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	ps.AddPubKey(id, priv.GetPublic())
+	ps.AddPrivKey(id, priv)
+	t.Cleanup(func() { ps.Close() })
+
+	s, err := NewSwarm(id, ps, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	tcpTransport, err := tcp.NewTCPTransport(makeUpgrader(t, s), nil, tcp.DisableReuseport())
+	require.NoError(t, err)
+	tpt := &fakeTransport{Transport: tcpTransport, redirect: redirect}
+	require.NoError(t, s.AddTransport(tpt))
+	return s, tpt
+}
+
+func TestHappyEyeballsFallbackToIPv4(t *testing.T) {
+	s2 := makeSwarm(t)
+	defer s2.Close()
+	tcpAddr, _ := getAddrs(t, s2)
+
+	ip6Addr := ma.StringCast("/ip6/2001:db8::1/tcp/1234")
+	ip4Addr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+
+	const delay = 100 * time.Millisecond
+	s1, tpt := makeFakeTransportSwarm(t, map[string]ma.Multiaddr{string(ip4Addr.Bytes()): tcpAddr}, WithHappyEyeballs(delay))
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), []ma.Multiaddr{ip4Addr, ip6Addr}, peerstore.PermanentAddrTTL)
+
+	// use the dial worker directly, so that the request context is never canceled
+	reqch := make(chan dialRequest)
+	resch := make(chan dialResponse)
+	worker := newDialWorker(s1, s2.LocalPeer(), reqch)
+	go worker.loop()
+	defer worker.wg.Wait()
+	defer close(reqch)
+
+	reqch <- dialRequest{ctx: context.Background(), resch: resch}
+	res := <-resch
+	require.NoError(t, res.err)
+
+	ip6Dial, ok := tpt.getDial(ip6Addr)
+	require.True(t, ok)
+	ip4Dial, ok := tpt.getDial(ip4Addr)
+	require.True(t, ok)
+	require.GreaterOrEqual(t, ip4Dial.start.Sub(ip6Dial.start), delay)
+
+	// the IPv6 dial lost the race and is canceled
+	require.Eventually(t, func() bool {
+		d, _ := tpt.getDial(ip6Addr)
+		return d.canceled
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHappyEyeballsPreferIPv6(t *testing.T) {
+	s2 := makeSwarm(t)
+	defer s2.Close()
+	tcpAddr, _ := getAddrs(t, s2)
+
+	ip6Addr := ma.StringCast("/ip6/2001:db8::1/tcp/1234")
+	ip4Addr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+
+	s1, tpt := makeFakeTransportSwarm(t, map[string]ma.Multiaddr{string(ip6Addr.Bytes()): tcpAddr}, WithHappyEyeballs(time.Second))
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), []ma.Multiaddr{ip4Addr, ip6Addr}, peerstore.PermanentAddrTTL)
+
+	_, err := s1.DialPeer(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+
+	_, ok := tpt.getDial(ip6Addr)
+	require.True(t, ok)
+	_, ok = tpt.getDial(ip4Addr)
+	require.False(t, ok, "didn't expect the IPv4 address to be dialed")
+}
+
+func TestHappyEyeballsDisabled(t *testing.T) {
+	s2 := makeSwarm(t)
+	defer s2.Close()
+	tcpAddr, _ := getAddrs(t, s2)
+
+	ip6Addr := ma.StringCast("/ip6/2001:db8::1/tcp/1234")
+	ip4Addr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+
+	s1, tpt := makeFakeTransportSwarm(t, map[string]ma.Multiaddr{string(ip4Addr.Bytes()): tcpAddr})
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), []ma.Multiaddr{ip4Addr, ip6Addr}, peerstore.PermanentAddrTTL)
+
+	reqch := make(chan dialRequest)
+	resch := make(chan dialResponse)
+	worker := newDialWorker(s1, s2.LocalPeer(), reqch)
+	go worker.loop()
+	defer worker.wg.Wait()
+	defer close(reqch)
+
+	reqch <- dialRequest{ctx: context.Background(), resch: resch}
+	res := <-resch
+	require.NoError(t, res.err)
+
+	// both addresses are dialed at once, and the loser isn't canceled
+	_, ok := tpt.getDial(ip6Addr)
+	require.True(t, ok)
+	require.Never(t, func() bool {
+		d, _ := tpt.getDial(ip6Addr)
+		return d.canceled
+	}, 200*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestDialRecordsAddrStats(t *testing.T) {
+	s1 := makeSwarm(t)
+	s2 := makeSwarm(t)
+	defer s1.Close()
+	defer s2.Close()
+
+	tcpAddr, _ := getAddrs(t, s2)
+	// nothing listens on this port, so dials fail right away
+	badAddr := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), []ma.Multiaddr{tcpAddr, badAddr}, peerstore.PermanentAddrTTL)
+
+	_, err := s1.DialPeer(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+
+	sb, ok := peerstore.GetAddrStatsBook(s1.Peerstore())
+	require.True(t, ok)
+	require.Eventually(t, func() bool { return len(sb.AddrStats(s2.LocalPeer())) == 2 }, 5*time.Second, 10*time.Millisecond)
+	for _, st := range sb.AddrStats(s2.LocalPeer()) {
+		if st.Addr.Equal(tcpAddr) {
+			require.Equal(t, uint64(1), st.Successes)
+			require.True(t, st.LastDialSucceeded())
+			require.NotZero(t, st.LastRTT)
+		} else {
+			require.True(t, st.Addr.Equal(badAddr))
+			require.Equal(t, uint64(1), st.Failures)
+			require.Equal(t, "refused", st.LastErrorClass)
+		}
+	}
+}
+
+func TestPreferKnownGoodAddrs(t *testing.T) {
+	s := newTestSwarmWithResolver(t, madns.DefaultResolver)
+	p := test.RandPeerIDFatal(t)
+
+	quicAddr := ma.StringCast("/ip4/1.2.3.4/udp/1234/quic")
+	tcpAddr := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	tcpAddr2 := ma.StringCast("/ip4/1.2.3.4/tcp/1235")
+	relayAddr := ma.StringCast("/ip4/1.2.3.5/tcp/1234/p2p/QmbHVEEepCi7rn7VL7Exxpd2Ci9NNB6ifvqwhsrbRMgQFP/p2p-circuit")
+	ads := NewDelayDialRanker(time.Second, 2*time.Second)([]ma.Multiaddr{quicAddr, tcpAddr, tcpAddr2, relayAddr})
+	require.Equal(t, []AddrDelay{
+		{Addr: quicAddr, Delay: 0},
+		{Addr: tcpAddr, Delay: time.Second},
+		{Addr: tcpAddr2, Delay: time.Second},
+		{Addr: relayAddr, Delay: 2 * time.Second},
+	}, ads)
+
+	// no stats, nothing changes
+	require.Equal(t, ads, s.preferKnownGoodAddrs(p, ads))
+
+	sb, ok := peerstore.GetAddrStatsBook(s.Peerstore())
+	require.True(t, ok)
+	sb.RecordDialSuccess(p, tcpAddr2, time.Millisecond)
+	sb.RecordDialSuccess(p, relayAddr, time.Millisecond)
+	sb.RecordDialFailure(p, quicAddr, "timeout")
+	// known good addresses are only moved within their delay class
+	require.Equal(t, []AddrDelay{
+		{Addr: quicAddr, Delay: 0},
+		{Addr: tcpAddr2, Delay: time.Second},
+		{Addr: tcpAddr, Delay: time.Second},
+		{Addr: relayAddr, Delay: 2 * time.Second},
+	}, s.preferKnownGoodAddrs(p, ads))
+}
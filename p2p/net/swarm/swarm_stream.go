@@ -11,7 +11,10 @@ import (
 )
 
 // Validate Stream conforms to the go-libp2p-net Stream interface
-var _ network.Stream = &Stream{}
+var (
+	_ network.Stream            = &Stream{}
+	_ network.PrioritizedStream = &Stream{}
+)
 
 // Stream is the stream type used by swarm. In general, you won't use this type
 // directly.
@@ -142,6 +145,16 @@ func (s *Stream) SetWriteDeadline(t time.Time) error {
 	return s.stream.SetWriteDeadline(t)
 }
 
+// SetPriority sets the priority of this stream's writes, if the stream multiplexer
+// supports priorities.
+func (s *Stream) SetPriority(prio uint8) error {
+	ps, ok := s.stream.(network.PrioritizedStream)
+	if !ok {
+		return network.ErrStreamPriorityNotSupported
+	}
+	return ps.SetPriority(prio)
+}
+
 // Stat returns metadata information for this stream.
 func (s *Stream) Stat() network.Stats {
 	return s.stat
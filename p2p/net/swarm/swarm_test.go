@@ -559,3 +559,80 @@ func TestListenCloseCount(t *testing.T) {
 	remainingAddrs := s.ListenAddresses()
 	require.Equal(t, 0, len(remainingAddrs))
 }
+
+func TestDatagrams(t *testing.T) {
+	s1 := GenSwarm(t, OptDisableTCP)
+	s2 := GenSwarm(t, OptDisableTCP)
+	defer s1.Close()
+	defer s2.Close()
+	connectSwarms(t, context.Background(), []*swarm.Swarm{s1, s2})
+
+	c1 := s1.ConnsToPeer(s2.LocalPeer())[0].(network.DatagramConn)
+	require.Eventually(t, func() bool { return len(s2.ConnsToPeer(s1.LocalPeer())) > 0 }, 5*time.Second, 10*time.Millisecond)
+	c2 := s2.ConnsToPeer(s1.LocalPeer())[0].(network.DatagramConn)
+	received := make(chan []byte, 1)
+	go func() {
+		b, err := c2.ReceiveDatagram(context.Background(), "/foo")
+		if err != nil {
+			t.Error(err)
+		}
+		received <- b
+	}()
+	// the protocol can only be negotiated once the receiver called ReceiveDatagram
+	require.Eventually(t, func() bool {
+		return c1.NegotiateDatagrams(context.Background(), "/foo") == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.ErrorIs(t, c1.SendDatagram("/bar", []byte("foobar")), network.ErrDatagramProtocolNotNegotiated)
+	for {
+		require.NoError(t, c1.SendDatagram("/foo", []byte("foobar")))
+		select {
+		case b := <-received:
+			require.Equal(t, []byte("foobar"), b)
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestDatagramsNotSupported(t *testing.T) {
+	s1 := GenSwarm(t, OptDisableQUIC)
+	s2 := GenSwarm(t, OptDisableQUIC)
+	defer s1.Close()
+	defer s2.Close()
+	connectSwarms(t, context.Background(), []*swarm.Swarm{s1, s2})
+
+	c := s1.ConnsToPeer(s2.LocalPeer())[0].(network.DatagramConn)
+	require.ErrorIs(t, c.SendDatagram("/foo", []byte("foobar")), network.ErrDatagramsNotSupported)
+	require.ErrorIs(t, c.NegotiateDatagrams(context.Background(), "/foo"), network.ErrDatagramsNotSupported)
+	_, err := c.ReceiveDatagram(context.Background(), "/foo")
+	require.ErrorIs(t, err, network.ErrDatagramsNotSupported)
+}
+
+func TestStreamPriority(t *testing.T) {
+	t.Run("yamux", func(t *testing.T) {
+		s1 := GenSwarm(t, OptDisableQUIC)
+		s2 := GenSwarm(t, OptDisableQUIC)
+		defer s1.Close()
+		defer s2.Close()
+		connectSwarms(t, context.Background(), []*swarm.Swarm{s1, s2})
+
+		str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+		require.NoError(t, err)
+		defer str.Close()
+		// the test swarms use yamux.DefaultTransport, which doesn't schedule writes
+		require.ErrorIs(t, str.(network.PrioritizedStream).SetPriority(10), network.ErrStreamPriorityNotSupported)
+	})
+
+	t.Run("QUIC", func(t *testing.T) {
+		s1 := GenSwarm(t, OptDisableTCP)
+		s2 := GenSwarm(t, OptDisableTCP)
+		defer s1.Close()
+		defer s2.Close()
+		connectSwarms(t, context.Background(), []*swarm.Swarm{s1, s2})
+
+		str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+		require.NoError(t, err)
+		defer str.Close()
+		require.ErrorIs(t, str.(network.PrioritizedStream).SetPriority(10), network.ErrStreamPriorityNotSupported)
+	})
+}
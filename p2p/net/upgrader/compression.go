@@ -0,0 +1,83 @@
+package upgrader
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	msmux "github.com/libp2p/go-libp2p/p2p/muxer/muxer-multistream"
+
+	mss "github.com/multiformats/go-multistream"
+)
+
+// initCompression sets up the multistream negotiation of the compression algorithm.
+//
+// Peers that don't support compression expect the stream multiplexer to be negotiated right
+// after the security handshake. If the stream multiplexer is negotiated using multistream,
+// the stream multiplexers are therefore offered in the same negotiation, after the
+// compression algorithms. This costs a round trip per compression algorithm when connecting
+// to such a peer, but keeps us compatible with it.
+func (u *upgrader) initCompression() {
+	if len(u.compressionPreference) == 0 {
+		return
+	}
+	u.compressionMux = mss.NewMultistreamMuxer()
+	for _, id := range u.compressionPreference {
+		u.compressionMux.AddHandler(id, nil)
+	}
+	if mux, ok := u.muxer.(*msmux.Transport); ok {
+		for _, id := range mux.OrderPreference {
+			u.compressionMux.AddHandler(id, nil)
+			u.compressionPreference = append(u.compressionPreference, id)
+		}
+	}
+}
+
+// setupCompression negotiates the compression algorithm, if compression is enabled.
+// If the peer selected a stream multiplexer instead, the connection is not compressed, and
+// that stream multiplexer is returned. It must be used without negotiating again.
+func (u *upgrader) setupCompression(ctx context.Context, conn net.Conn, server bool) (net.Conn, network.Multiplexer, error) {
+	if u.compressionMux == nil {
+		return conn, nil, nil
+	}
+
+	var proto string
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if server {
+			proto, _, err = u.compressionMux.Negotiate(conn)
+		} else {
+			proto, err = mss.SelectOneOf(u.compressionPreference, conn)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// interrupt this process
+		conn.Close()
+		// wait to finish
+		<-done
+		return nil, nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c, ok := u.compressors[proto]; ok {
+		cconn, err := c.NewConn(conn)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cconn, nil, nil
+	}
+	if mux, ok := u.muxer.(*msmux.Transport); ok {
+		if tpt, ok := mux.GetTransportByKey(proto); ok {
+			return conn, tpt, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("selected unknown protocol: %s", proto)
+}
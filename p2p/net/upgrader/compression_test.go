@@ -0,0 +1,120 @@
+package upgrader_test
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/compression"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/compression/snappy"
+	"github.com/libp2p/go-libp2p/p2p/compression/zstd"
+	msmux "github.com/libp2p/go-libp2p/p2p/muxer/muxer-multistream"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/net/upgrader"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingCompressor counts how many connections it compressed.
+type countingCompressor struct {
+	compression.Compressor
+	count int32
+}
+
+func (c *countingCompressor) NewConn(nc net.Conn) (net.Conn, error) {
+	atomic.AddInt32(&c.count, 1)
+	return c.Compressor.NewConn(nc)
+}
+
+func (c *countingCompressor) Count() int {
+	return int(atomic.LoadInt32(&c.count))
+}
+
+func newMultistreamMuxer() network.Multiplexer {
+	m := msmux.NewBlankTransport()
+	m.AddTransport("/yamux/1.0.0", yamux.DefaultTransport)
+	return m
+}
+
+func TestCompression(t *testing.T) {
+	serverZstd := &countingCompressor{Compressor: zstd.DefaultCompressor}
+	serverSnappy := &countingCompressor{Compressor: snappy.DefaultCompressor}
+	clientZstd := &countingCompressor{Compressor: zstd.DefaultCompressor}
+	clientSnappy := &countingCompressor{Compressor: snappy.DefaultCompressor}
+
+	id, u := createUpgraderWithMuxer(t, newMultistreamMuxer(),
+		upgrader.WithCompression(zstd.ID, serverZstd),
+		upgrader.WithCompression(snappy.ID, serverSnappy),
+	)
+	ln := createListener(t, u)
+	defer ln.Close()
+
+	// the client's preference wins
+	_, cu := createUpgraderWithMuxer(t, newMultistreamMuxer(),
+		upgrader.WithCompression(snappy.ID, clientSnappy),
+		upgrader.WithCompression(zstd.ID, clientZstd),
+	)
+	cconn, err := dial(t, cu, ln.Multiaddr(), id, network.NullScope)
+	require.NoError(t, err)
+	defer cconn.Close()
+	sconn, err := ln.Accept()
+	require.NoError(t, err)
+	defer sconn.Close()
+
+	testConn(t, cconn, sconn)
+	require.Equal(t, 1, clientSnappy.Count())
+	require.Equal(t, 1, serverSnappy.Count())
+	require.Zero(t, clientZstd.Count())
+	require.Zero(t, serverZstd.Count())
+}
+
+func TestCompressionFallback(t *testing.T) {
+	testFallback := func(t *testing.T, serverOpts, clientOpts []upgrader.Option) {
+		t.Helper()
+		id, u := createUpgraderWithMuxer(t, newMultistreamMuxer(), serverOpts...)
+		ln := createListener(t, u)
+		defer ln.Close()
+
+		_, cu := createUpgraderWithMuxer(t, newMultistreamMuxer(), clientOpts...)
+		cconn, err := dial(t, cu, ln.Multiaddr(), id, network.NullScope)
+		require.NoError(t, err)
+		defer cconn.Close()
+		sconn, err := ln.Accept()
+		require.NoError(t, err)
+		defer sconn.Close()
+
+		testConn(t, cconn, sconn)
+	}
+
+	t.Run("server doesn't support compression", func(t *testing.T) {
+		c := &countingCompressor{Compressor: zstd.DefaultCompressor}
+		testFallback(t, nil, []upgrader.Option{upgrader.WithCompression(zstd.ID, c)})
+		require.Zero(t, c.Count())
+	})
+
+	t.Run("client doesn't support compression", func(t *testing.T) {
+		c := &countingCompressor{Compressor: zstd.DefaultCompressor}
+		testFallback(t, []upgrader.Option{upgrader.WithCompression(zstd.ID, c)}, nil)
+		require.Zero(t, c.Count())
+	})
+
+	t.Run("no common compression algorithm", func(t *testing.T) {
+		cz := &countingCompressor{Compressor: zstd.DefaultCompressor}
+		cs := &countingCompressor{Compressor: snappy.DefaultCompressor}
+		testFallback(t,
+			[]upgrader.Option{upgrader.WithCompression(zstd.ID, cz)},
+			[]upgrader.Option{upgrader.WithCompression(snappy.ID, cs)},
+		)
+		require.Zero(t, cz.Count())
+		require.Zero(t, cs.Count())
+	})
+}
+
+func TestCompressionDuplicate(t *testing.T) {
+	_, err := upgrader.New(nil, nil,
+		upgrader.WithCompression(zstd.ID, zstd.DefaultCompressor),
+		upgrader.WithCompression(zstd.ID, zstd.DefaultCompressor),
+	)
+	require.EqualError(t, err, "duplicate compression algorithm: /zstd/1.0.0")
+}
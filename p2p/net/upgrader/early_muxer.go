@@ -0,0 +1,46 @@
+package upgrader
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/sec"
+	msmux "github.com/libp2p/go-libp2p/p2p/muxer/muxer-multistream"
+)
+
+// initEarlyMuxers sets up the negotiation of the stream multiplexer during the security
+// handshake, see sec.WithStreamMuxers.
+//
+// This is only possible if the stream multiplexer is selected using multistream. It is
+// disabled if compression is enabled: the compression algorithm is negotiated after the
+// security handshake, and the stream multiplexer is only set up after that.
+func (u *upgrader) initEarlyMuxers() {
+	if u.compressionMux != nil {
+		return
+	}
+	if mux, ok := u.muxer.(*msmux.Transport); ok {
+		u.earlyMuxers = mux.OrderPreference
+	}
+}
+
+// getEarlyMuxer returns the stream multiplexer negotiated during the security handshake.
+// It returns nil if none was negotiated, for example because the security protocol or the
+// peer doesn't support this. The stream multiplexer then needs to be negotiated using
+// multistream.
+func (u *upgrader) getEarlyMuxer(sconn sec.SecureConn) (network.Multiplexer, error) {
+	if len(u.earlyMuxers) == 0 {
+		return nil, nil
+	}
+	c, ok := sconn.(sec.EarlyMuxerConn)
+	if !ok {
+		return nil, nil
+	}
+	proto := c.NegotiatedMuxer()
+	if proto == "" {
+		return nil, nil
+	}
+	if tpt, ok := u.muxer.(*msmux.Transport).GetTransportByKey(proto); ok {
+		return tpt, nil
+	}
+	return nil, fmt.Errorf("negotiated unknown stream multiplexer: %s", proto)
+}
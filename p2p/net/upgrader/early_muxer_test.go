@@ -0,0 +1,123 @@
+package upgrader_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/compression/zstd"
+	"github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	tls "github.com/libp2p/go-libp2p/p2p/security/tls"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingMuxAdapter records the secured connections.
+type recordingMuxAdapter struct {
+	MuxAdapter
+	conns chan sec.SecureConn
+}
+
+func (mux *recordingMuxAdapter) SecureInbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, bool, error) {
+	sconn, server, err := mux.MuxAdapter.SecureInbound(ctx, insecure, p)
+	if err == nil {
+		mux.conns <- sconn
+	}
+	return sconn, server, err
+}
+
+func (mux *recordingMuxAdapter) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, bool, error) {
+	sconn, server, err := mux.MuxAdapter.SecureOutbound(ctx, insecure, p)
+	if err == nil {
+		mux.conns <- sconn
+	}
+	return sconn, server, err
+}
+
+func createUpgraderWithSecurity(t *testing.T, newSecurity func(crypto.PrivKey) (sec.SecureTransport, error), muxer network.Multiplexer, opts ...upgrader.Option) (peer.ID, transport.Upgrader, <-chan sec.SecureConn) {
+	priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	tpt, err := newSecurity(priv)
+	require.NoError(t, err)
+	mux := &recordingMuxAdapter{MuxAdapter: MuxAdapter{tpt: tpt}, conns: make(chan sec.SecureConn, 1)}
+	u, err := upgrader.New(mux, muxer, opts...)
+	require.NoError(t, err)
+	return id, u, mux.conns
+}
+
+// noEarlyMuxer selects the stream multiplexer using multistream,
+// without negotiating it during the security handshake.
+type noEarlyMuxer struct {
+	network.Multiplexer
+}
+
+func TestEarlyMuxerNegotiation(t *testing.T) {
+	securities := []struct {
+		name string
+		new  func(crypto.PrivKey) (sec.SecureTransport, error)
+	}{
+		{name: "TLS", new: func(priv crypto.PrivKey) (sec.SecureTransport, error) { return tls.New(priv) }},
+		{name: "Noise", new: func(priv crypto.PrivKey) (sec.SecureTransport, error) { return noise.New(priv) }},
+	}
+
+	testCases := []struct {
+		name                     string
+		serverMuxer, clientMuxer func() network.Multiplexer
+		serverOpts, clientOpts   []upgrader.Option
+		expected                 string
+	}{
+		{
+			name:        "both support early muxer negotiation",
+			serverMuxer: newMultistreamMuxer,
+			clientMuxer: newMultistreamMuxer,
+			expected:    "/yamux/1.0.0",
+		},
+		{
+			name:        "server doesn't support early muxer negotiation",
+			serverMuxer: func() network.Multiplexer { return &noEarlyMuxer{newMultistreamMuxer()} },
+			clientMuxer: newMultistreamMuxer,
+		},
+		{
+			name:        "client doesn't support early muxer negotiation",
+			serverMuxer: newMultistreamMuxer,
+			clientMuxer: func() network.Multiplexer { return &noEarlyMuxer{newMultistreamMuxer()} },
+		},
+		{
+			name:        "client uses compression",
+			serverMuxer: newMultistreamMuxer,
+			clientMuxer: newMultistreamMuxer,
+			clientOpts:  []upgrader.Option{upgrader.WithCompression(zstd.ID, zstd.DefaultCompressor)},
+		},
+	}
+
+	for _, s := range securities {
+		for _, tc := range testCases {
+			t.Run(s.name+": "+tc.name, func(t *testing.T) {
+				id, u, serverSecConns := createUpgraderWithSecurity(t, s.new, tc.serverMuxer(), tc.serverOpts...)
+				ln := createListener(t, u)
+				defer ln.Close()
+
+				_, cu, clientSecConns := createUpgraderWithSecurity(t, s.new, tc.clientMuxer(), tc.clientOpts...)
+				cconn, err := dial(t, cu, ln.Multiaddr(), id, network.NullScope)
+				require.NoError(t, err)
+				defer cconn.Close()
+				sconn, err := ln.Accept()
+				require.NoError(t, err)
+				defer sconn.Close()
+
+				testConn(t, cconn, sconn)
+				require.Equal(t, tc.expected, (<-clientSecConns).(sec.EarlyMuxerConn).NegotiatedMuxer())
+				require.Equal(t, tc.expected, (<-serverSecConns).(sec.EarlyMuxerConn).NegotiatedMuxer())
+			})
+		}
+	}
+}
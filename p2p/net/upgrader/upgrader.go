@@ -7,6 +7,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/compression"
 	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -16,6 +17,7 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/net/pnet"
 
 	manet "github.com/multiformats/go-multiaddr/net"
+	mss "github.com/multiformats/go-multistream"
 )
 
 // ErrNilPeer is returned when attempting to upgrade an outbound connection
@@ -57,6 +59,23 @@ func WithResourceManager(m network.ResourceManager) Option {
 	}
 }
 
+// WithCompression enables compression using the given compression algorithm.
+// id is the protocol ID used to negotiate it. If multiple compression algorithms are
+// enabled, they are preferred in the order they were added.
+func WithCompression(id string, c compression.Compressor) Option {
+	return func(u *upgrader) error {
+		if _, ok := u.compressors[id]; ok {
+			return fmt.Errorf("duplicate compression algorithm: %s", id)
+		}
+		if u.compressors == nil {
+			u.compressors = make(map[string]compression.Compressor)
+		}
+		u.compressors[id] = c
+		u.compressionPreference = append(u.compressionPreference, id)
+		return nil
+	}
+}
+
 // Upgrader is a multistream upgrader that can upgrade an underlying connection
 // to a full transport connection (secure and multiplexed).
 type upgrader struct {
@@ -67,6 +86,14 @@ type upgrader struct {
 	connGater connmgr.ConnectionGater
 	rcmgr     network.ResourceManager
 
+	compressors           map[string]compression.Compressor
+	compressionPreference []string
+	compressionMux        *mss.MultistreamMuxer // nil if compression is disabled
+
+	// earlyMuxers are the stream multiplexers offered during the security handshake.
+	// Empty if the stream multiplexer can't be negotiated during the handshake.
+	earlyMuxers []string
+
 	// AcceptTimeout is the maximum duration an Accept is allowed to take.
 	// This includes the time between accepting the raw network connection,
 	// protocol selection as well as the handshake, if applicable.
@@ -91,6 +118,8 @@ func New(secureMuxer sec.SecureMuxer, muxer network.Multiplexer, opts ...Option)
 	if u.rcmgr == nil {
 		u.rcmgr = network.NullResourceManager
 	}
+	u.initCompression()
+	u.initEarlyMuxers()
 	return u, nil
 }
 
@@ -170,7 +199,24 @@ func (u *upgrader) upgrade(ctx context.Context, t transport.Transport, maconn ma
 		}
 	}
 
-	smconn, err := u.setupMuxer(ctx, sconn, server, connScope.PeerScope())
+	muxer, err := u.getEarlyMuxer(sconn)
+	if err != nil {
+		sconn.Close()
+		return nil, err
+	}
+	var cconn net.Conn = sconn
+	if muxer == nil {
+		cconn, muxer, err = u.setupCompression(ctx, sconn, server)
+		if err != nil {
+			sconn.Close()
+			return nil, fmt.Errorf("failed to negotiate compression: %s", err)
+		}
+	}
+
+	if muxer == nil {
+		muxer = u.muxer
+	}
+	smconn, err := u.setupMuxer(ctx, cconn, muxer, server, connScope.PeerScope())
 	if err != nil {
 		sconn.Close()
 		return nil, fmt.Errorf("failed to negotiate stream multiplexer: %s", err)
@@ -188,13 +234,16 @@ func (u *upgrader) upgrade(ctx context.Context, t transport.Transport, maconn ma
 }
 
 func (u *upgrader) setupSecurity(ctx context.Context, conn net.Conn, p peer.ID, dir network.Direction) (sec.SecureConn, bool, error) {
+	if len(u.earlyMuxers) > 0 {
+		ctx = sec.WithStreamMuxers(ctx, u.earlyMuxers)
+	}
 	if dir == network.DirInbound {
 		return u.secure.SecureInbound(ctx, conn, p)
 	}
 	return u.secure.SecureOutbound(ctx, conn, p)
 }
 
-func (u *upgrader) setupMuxer(ctx context.Context, conn net.Conn, server bool, scope network.PeerScope) (network.MuxedConn, error) {
+func (u *upgrader) setupMuxer(ctx context.Context, conn net.Conn, muxer network.Multiplexer, server bool, scope network.PeerScope) (network.MuxedConn, error) {
 	// TODO: The muxer should take a context.
 	done := make(chan struct{})
 
@@ -202,7 +251,7 @@ func (u *upgrader) setupMuxer(ctx context.Context, conn net.Conn, server bool, s
 	var err error
 	go func() {
 		defer close(done)
-		smconn, err = u.muxer.NewConn(conn, server, scope)
+		smconn, err = muxer.NewConn(conn, server, scope)
 	}()
 
 	select {
@@ -91,6 +91,7 @@ func (s *secureSession) runHandshake(ctx context.Context) (err error) {
 		if err != nil {
 			return err
 		}
+		s.negotiatedMuxer = matchMuxers(s.localMuxers, rcvdEd.GetStreamMuxers())
 		if s.initiatorEarlyDataHandler != nil {
 			if err := s.initiatorEarlyDataHandler.Received(ctx, s.insecureConn, rcvdEd); err != nil {
 				return err
@@ -103,7 +104,7 @@ func (s *secureSession) runHandshake(ctx context.Context) (err error) {
 		if s.initiatorEarlyDataHandler != nil {
 			ed = s.initiatorEarlyDataHandler.Send(ctx, s.insecureConn, s.remoteID)
 		}
-		payload, err := s.generateHandshakePayload(kp, ed)
+		payload, err := s.generateHandshakePayload(kp, s.addStreamMuxers(ed))
 		if err != nil {
 			return err
 		}
@@ -124,7 +125,7 @@ func (s *secureSession) runHandshake(ctx context.Context) (err error) {
 		if s.responderEarlyDataHandler != nil {
 			ed = s.responderEarlyDataHandler.Send(ctx, s.insecureConn, s.remoteID)
 		}
-		payload, err := s.generateHandshakePayload(kp, ed)
+		payload, err := s.generateHandshakePayload(kp, s.addStreamMuxers(ed))
 		if err != nil {
 			return err
 		}
@@ -141,6 +142,7 @@ func (s *secureSession) runHandshake(ctx context.Context) (err error) {
 		if err != nil {
 			return err
 		}
+		s.negotiatedMuxer = matchMuxers(rcvdEd.GetStreamMuxers(), s.localMuxers)
 		if s.responderEarlyDataHandler != nil {
 			if err := s.responderEarlyDataHandler.Received(ctx, s.insecureConn, rcvdEd); err != nil {
 				return err
@@ -150,6 +152,32 @@ func (s *secureSession) runHandshake(ctx context.Context) (err error) {
 	}
 }
 
+// addStreamMuxers adds our stream multiplexers to the extensions sent to the peer.
+// The extensions returned by the EarlyDataHandler are copied, not modified.
+func (s *secureSession) addStreamMuxers(ed *pb.NoiseExtensions) *pb.NoiseExtensions {
+	if len(s.localMuxers) == 0 {
+		return ed
+	}
+	ext := &pb.NoiseExtensions{StreamMuxers: s.localMuxers}
+	if ed != nil {
+		ext.WebtransportCerthashes = ed.WebtransportCerthashes
+	}
+	return ext
+}
+
+// matchMuxers returns the first of the initiator's stream multiplexers that is also
+// supported by the responder. It returns an empty string if there is none.
+func matchMuxers(initiatorMuxers, responderMuxers []string) string {
+	for _, m := range initiatorMuxers {
+		for _, rm := range responderMuxers {
+			if m == rm {
+				return m
+			}
+		}
+	}
+	return ""
+}
+
 // setCipherStates sets the initial cipher states that will be used to protect
 // traffic after the handshake.
 //
@@ -272,10 +300,10 @@ func (s *secureSession) handleRemoteHandshakePayload(payload []byte, remoteStati
 		return nil, err
 	}
 
-	// check the peer ID for:
-	// * all outbound connection
-	// * inbound connections, if we know which peer we want to connect to (SecureInbound called with a peer ID)
-	if (s.initiator && s.remoteID != id) || (!s.initiator && s.remoteID != "" && s.remoteID != id) {
+	// check the peer ID if:
+	// * we're initiating a connection, unless the peer ID check was disabled
+	// * we're accepting a connection and know which peer we want to connect to (SecureInbound called with a peer ID)
+	if s.checkPeerID && s.remoteID != id {
 		// use Pretty() as it produces the full b58-encoded string, rather than abbreviated forms.
 		return nil, fmt.Errorf("peer id mismatch: expected %s, but remote key matches %s", s.remoteID.Pretty(), id.Pretty())
 	}
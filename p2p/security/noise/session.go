@@ -11,6 +11,7 @@ import (
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
 )
 
 type secureSession struct {
@@ -38,12 +39,22 @@ type secureSession struct {
 	// noise prologue
 	prologue []byte
 
+	// checkPeerID is set if the remote's key must match remoteID
+	checkPeerID bool
+
 	initiatorEarlyDataHandler, responderEarlyDataHandler EarlyDataHandler
+
+	// localMuxers are the stream multiplexers we offer in the handshake, see sec.WithStreamMuxers.
+	localMuxers []string
+	// negotiatedMuxer is the stream multiplexer negotiated in the handshake, if any.
+	negotiatedMuxer string
 }
 
+var _ sec.EarlyMuxerConn = &secureSession{}
+
 // newSecureSession creates a Noise session over the given insecureConn Conn, using
 // the libp2p identity keypair from the given Transport.
-func newSecureSession(tpt *Transport, ctx context.Context, insecure net.Conn, remote peer.ID, prologue []byte, initiatorEDH, responderEDH EarlyDataHandler, initiator bool) (*secureSession, error) {
+func newSecureSession(tpt *Transport, ctx context.Context, insecure net.Conn, remote peer.ID, prologue []byte, initiatorEDH, responderEDH EarlyDataHandler, initiator, checkPeerID bool) (*secureSession, error) {
 	s := &secureSession{
 		insecureConn:              insecure,
 		insecureReader:            bufio.NewReader(insecure),
@@ -52,8 +63,10 @@ func newSecureSession(tpt *Transport, ctx context.Context, insecure net.Conn, re
 		localKey:                  tpt.privateKey,
 		remoteID:                  remote,
 		prologue:                  prologue,
+		checkPeerID:               checkPeerID,
 		initiatorEarlyDataHandler: initiatorEDH,
 		responderEarlyDataHandler: responderEDH,
+		localMuxers:               sec.GetStreamMuxers(ctx),
 	}
 
 	// the go-routine we create to run the handshake will
@@ -108,6 +121,10 @@ func (s *secureSession) RemotePublicKey() crypto.PubKey {
 	return s.remoteKey
 }
 
+func (s *secureSession) NegotiatedMuxer() string {
+	return s.negotiatedMuxer
+}
+
 func (s *secureSession) SetDeadline(t time.Time) error {
 	return s.insecureConn.SetDeadline(t)
 }
@@ -226,6 +226,26 @@ func TestPeerIDMismatchInboundFailsHandshake(t *testing.T) {
 	<-done
 }
 
+func TestPeerIDCheckDisabledOutbound(t *testing.T) {
+	initTransport := newTestTransport(t, crypto.Ed25519, 2048)
+	respTransport := newTestTransport(t, crypto.Ed25519, 2048)
+	init, resp := newConnPair(t)
+
+	st, err := initTransport.WithSessionOptions(DisablePeerIDCheck())
+	require.NoError(t, err)
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := respTransport.SecureInbound(context.Background(), resp, "")
+		errChan <- err
+	}()
+
+	conn, err := st.SecureOutbound(context.Background(), init, "")
+	require.NoError(t, err)
+	require.Equal(t, respTransport.localID, conn.RemotePeer())
+	require.NoError(t, <-errChan)
+}
+
 func makeLargePlaintext(size int) []byte {
 	buf := make([]byte, size)
 	rand.Read(buf)
@@ -586,3 +606,78 @@ func TestEarlyfffDataAcceptedWithNoHandler(t *testing.T) {
 		require.NoError(t, err)
 	}
 }
+
+func TestStreamMuxerNegotiation(t *testing.T) {
+	testCases := []struct {
+		name                   string
+		initMuxers, respMuxers []string
+		expected               string
+	}{
+		{name: "initiator preference", initMuxers: []string{"/yamux", "/mplex"}, respMuxers: []string{"/mplex", "/yamux"}, expected: "/yamux"},
+		{name: "one common muxer", initMuxers: []string{"/yamux", "/mplex"}, respMuxers: []string{"/foo", "/mplex"}, expected: "/mplex"},
+		{name: "no common muxer", initMuxers: []string{"/yamux"}, respMuxers: []string{"/mplex"}},
+		{name: "initiator doesn't offer muxers", respMuxers: []string{"/yamux"}},
+		{name: "responder doesn't offer muxers", initMuxers: []string{"/yamux"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			initTransport := newTestTransport(t, crypto.Ed25519, 2048)
+			respTransport := newTestTransport(t, crypto.Ed25519, 2048)
+			init, resp := newConnPair(t)
+
+			respConnChan := make(chan sec.SecureConn, 1)
+			go func() {
+				ctx := sec.WithStreamMuxers(context.Background(), tc.respMuxers)
+				respConn, err := respTransport.SecureInbound(ctx, resp, "")
+				require.NoError(t, err)
+				respConnChan <- respConn
+			}()
+
+			ctx := sec.WithStreamMuxers(context.Background(), tc.initMuxers)
+			initConn, err := initTransport.SecureOutbound(ctx, init, respTransport.localID)
+			require.NoError(t, err)
+			defer initConn.Close()
+			respConn := <-respConnChan
+			defer respConn.Close()
+
+			require.Equal(t, tc.expected, initConn.(sec.EarlyMuxerConn).NegotiatedMuxer())
+			require.Equal(t, tc.expected, respConn.(sec.EarlyMuxerConn).NegotiatedMuxer())
+		})
+	}
+}
+
+func TestStreamMuxerNegotiationWithEarlyData(t *testing.T) {
+	var received *pb.NoiseExtensions
+	initTransport, err := newTestTransport(t, crypto.Ed25519, 2048).WithSessionOptions(EarlyData(&earlyDataHandler{
+		received: func(_ context.Context, _ net.Conn, ext *pb.NoiseExtensions) error {
+			received = ext
+			return nil
+		},
+	}, nil))
+	require.NoError(t, err)
+	tpt := newTestTransport(t, crypto.Ed25519, 2048)
+	sent := &pb.NoiseExtensions{WebtransportCerthashes: [][]byte{[]byte("foobar")}}
+	respTransport, err := tpt.WithSessionOptions(EarlyData(nil, &earlyDataHandler{
+		send: func(context.Context, net.Conn, peer.ID) *pb.NoiseExtensions { return sent },
+	}))
+	require.NoError(t, err)
+	init, resp := newConnPair(t)
+
+	ctx := sec.WithStreamMuxers(context.Background(), []string{"/yamux"})
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := respTransport.SecureInbound(ctx, resp, "")
+		errChan <- err
+	}()
+	initConn, err := initTransport.SecureOutbound(ctx, init, tpt.localID)
+	require.NoError(t, err)
+	defer initConn.Close()
+	require.NoError(t, <-errChan)
+
+	require.Equal(t, "/yamux", initConn.(sec.EarlyMuxerConn).NegotiatedMuxer())
+	require.Equal(t, [][]byte{[]byte("foobar")}, received.WebtransportCerthashes)
+	require.Equal(t, []string{"/yamux"}, received.StreamMuxers)
+	// the extensions returned by the early data handler are not modified
+	require.Empty(t, sent.StreamMuxers)
+}
@@ -16,9 +16,14 @@ type conn struct {
 
 	remotePeer   peer.ID
 	remotePubKey ci.PubKey
+
+	negotiatedMuxer string
 }
 
-var _ sec.SecureConn = &conn{}
+var (
+	_ sec.SecureConn     = &conn{}
+	_ sec.EarlyMuxerConn = &conn{}
+)
 
 func (c *conn) LocalPeer() peer.ID {
 	return c.localPeer
@@ -35,3 +40,7 @@ func (c *conn) RemotePeer() peer.ID {
 func (c *conn) RemotePublicKey() ci.PubKey {
 	return c.remotePubKey
 }
+
+func (c *conn) NegotiatedMuxer() string {
+	return c.negotiatedMuxer
+}
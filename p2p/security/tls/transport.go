@@ -53,6 +53,7 @@ var _ sec.SecureTransport = &Transport{}
 // If p is empty, connections from any peer are accepted.
 func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
 	config, keyCh := t.identity.ConfigForPeer(p)
+	config.NextProtos = nextProtos(ctx)
 	cs, err := t.handshake(ctx, tls.Server(insecure, config), keyCh)
 	if err != nil {
 		addr, maErr := manet.FromNetAddr(insecure.RemoteAddr())
@@ -73,6 +74,7 @@ func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn, p peer
 // notice this after 1 RTT when calling Read.
 func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
 	config, keyCh := t.identity.ConfigForPeer(p)
+	config.NextProtos = nextProtos(ctx)
 	cs, err := t.handshake(ctx, tls.Client(insecure, config), keyCh)
 	if err != nil {
 		insecure.Close()
@@ -111,11 +113,28 @@ func (t *Transport) setupConn(tlsConn *tls.Conn, remotePubKey ci.PubKey) (sec.Se
 	if err != nil {
 		return nil, err
 	}
+	// If the peer didn't offer any of our stream multiplexers, the "libp2p" ALPN was negotiated.
+	var muxer string
+	if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto != alpn {
+		muxer = proto
+	}
 	return &conn{
-		Conn:         tlsConn,
-		localPeer:    t.localPeer,
-		privKey:      t.privKey,
-		remotePeer:   remotePeerID,
-		remotePubKey: remotePubKey,
+		Conn:            tlsConn,
+		localPeer:       t.localPeer,
+		privKey:         t.privKey,
+		remotePeer:      remotePeerID,
+		remotePubKey:    remotePubKey,
+		negotiatedMuxer: muxer,
 	}, nil
 }
+
+// nextProtos returns the ALPN values offered during the handshake.
+// The stream multiplexers set using sec.WithStreamMuxers are offered before the "libp2p"
+// ALPN, so that the stream multiplexer is negotiated with peers that support this. The
+// server's preference is used, as for any ALPN negotiation.
+func nextProtos(ctx context.Context) []string {
+	muxers := sec.GetStreamMuxers(ctx)
+	protos := make([]string, 0, len(muxers)+1)
+	protos = append(protos, muxers...)
+	return append(protos, alpn)
+}
@@ -595,3 +595,48 @@ func TestInvalidCerts(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamMuxerNegotiation(t *testing.T) {
+	clientID, clientKey := createPeer(t)
+	serverID, serverKey := createPeer(t)
+
+	testCases := []struct {
+		name                       string
+		clientMuxers, serverMuxers []string
+		expected                   string
+	}{
+		{name: "server preference", clientMuxers: []string{"/yamux", "/mplex"}, serverMuxers: []string{"/mplex", "/yamux"}, expected: "/mplex"},
+		{name: "one common muxer", clientMuxers: []string{"/yamux", "/mplex"}, serverMuxers: []string{"/foo", "/mplex"}, expected: "/mplex"},
+		{name: "no common muxer", clientMuxers: []string{"/yamux"}, serverMuxers: []string{"/mplex"}},
+		{name: "client doesn't offer muxers", serverMuxers: []string{"/yamux"}},
+		{name: "server doesn't offer muxers", clientMuxers: []string{"/yamux"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientTransport, err := New(clientKey)
+			require.NoError(t, err)
+			serverTransport, err := New(serverKey)
+			require.NoError(t, err)
+			clientInsecureConn, serverInsecureConn := connect(t)
+
+			serverConnChan := make(chan sec.SecureConn, 1)
+			go func() {
+				ctx := sec.WithStreamMuxers(context.Background(), tc.serverMuxers)
+				serverConn, err := serverTransport.SecureInbound(ctx, serverInsecureConn, clientID)
+				require.NoError(t, err)
+				serverConnChan <- serverConn
+			}()
+
+			ctx := sec.WithStreamMuxers(context.Background(), tc.clientMuxers)
+			clientConn, err := clientTransport.SecureOutbound(ctx, clientInsecureConn, serverID)
+			require.NoError(t, err)
+			defer clientConn.Close()
+			serverConn := <-serverConnChan
+			defer serverConn.Close()
+
+			require.Equal(t, tc.expected, clientConn.(sec.EarlyMuxerConn).NegotiatedMuxer())
+			require.Equal(t, tc.expected, serverConn.(sec.EarlyMuxerConn).NegotiatedMuxer())
+		})
+	}
+}
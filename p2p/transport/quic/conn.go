@@ -34,6 +34,8 @@ type conn struct {
 	remotePeerID    peer.ID
 	remotePubKey    ic.PubKey
 	remoteMultiaddr ma.Multiaddr
+
+	datagrams datagramMux
 }
 
 var _ tpt.CapableConn = &conn{}
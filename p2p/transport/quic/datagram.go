@@ -0,0 +1,265 @@
+package libp2pquic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// maxDatagramQueueLen is the number of received datagrams that are buffered per protocol.
+const maxDatagramQueueLen = 32
+
+// datagramProbeInterval is the interval at which NegotiateDatagrams resends its probe,
+// since the probe itself is sent as a datagram and might be lost.
+const datagramProbeInterval = 250 * time.Millisecond
+
+// Control messages are sent as datagrams with an empty protocol ID, followed by the message
+// type and the length-prefixed protocol ID the message refers to.
+const (
+	// datagramProbe asks the peer whether it's receiving datagrams for a protocol.
+	datagramProbe byte = iota + 1
+	// datagramAccept is the answer to a probe if the peer is receiving datagrams for the protocol.
+	datagramAccept
+	// datagramReject is the answer to a probe if the peer isn't receiving datagrams for the protocol.
+	datagramReject
+)
+
+var _ network.DatagramConn = &conn{}
+
+// datagramMux demultiplexes the datagrams received on a QUIC connection by protocol.
+// Every datagram starts with the length-prefixed protocol ID.
+// The read loop is started when the connection is established, so that probes are answered.
+type datagramMux struct {
+	startOnce sync.Once
+	closed    chan struct{}
+	closeErr  error // set before closed is closed
+
+	mx     sync.Mutex
+	queues map[protocol.ID]chan []byte
+	// negotiated holds the protocols the peer accepted datagrams for.
+	negotiated map[protocol.ID]struct{}
+	// probes holds the negotiations waiting for the peer's answer.
+	probes map[protocol.ID]*datagramNegotiation
+}
+
+type datagramNegotiation struct {
+	done     chan struct{}
+	accepted bool // set before done is closed
+}
+
+// startDatagrams starts reading datagrams, if the peer enabled datagram support.
+func (c *conn) startDatagrams() {
+	if !c.quicConn.ConnectionState().SupportsDatagrams {
+		return
+	}
+	d := &c.datagrams
+	d.startOnce.Do(func() {
+		d.closed = make(chan struct{})
+		go c.readDatagrams()
+	})
+}
+
+// NegotiateDatagrams asks the peer whether it's receiving datagrams for protocol p.
+func (c *conn) NegotiateDatagrams(ctx context.Context, p protocol.ID) error {
+	if !c.quicConn.ConnectionState().SupportsDatagrams {
+		return network.ErrDatagramsNotSupported
+	}
+	if p == "" {
+		return network.ErrDatagramProtocolNotNegotiated
+	}
+	c.startDatagrams()
+	d := &c.datagrams
+
+	d.mx.Lock()
+	if _, ok := d.negotiated[p]; ok {
+		d.mx.Unlock()
+		return nil
+	}
+	if d.probes == nil {
+		d.probes = make(map[protocol.ID]*datagramNegotiation)
+	}
+	n, ok := d.probes[p]
+	if !ok {
+		n = &datagramNegotiation{done: make(chan struct{})}
+		d.probes[p] = n
+	}
+	d.mx.Unlock()
+
+	ticker := time.NewTicker(datagramProbeInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.quicConn.SendMessage(controlDatagram(datagramProbe, p)); err != nil {
+			return err
+		}
+		select {
+		case <-n.done:
+			if !n.accepted {
+				return network.ErrDatagramProtocolNotNegotiated
+			}
+			return nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.closed:
+			return d.closeErr
+		}
+	}
+}
+
+// SendDatagram sends a datagram for protocol p.
+func (c *conn) SendDatagram(p protocol.ID, b []byte) error {
+	if !c.quicConn.ConnectionState().SupportsDatagrams {
+		return network.ErrDatagramsNotSupported
+	}
+	d := &c.datagrams
+	d.mx.Lock()
+	_, ok := d.negotiated[p]
+	d.mx.Unlock()
+	if !ok {
+		return network.ErrDatagramProtocolNotNegotiated
+	}
+	msg := make([]byte, binary.MaxVarintLen64+len(p)+len(b))
+	n := binary.PutUvarint(msg, uint64(len(p)))
+	n += copy(msg[n:], p)
+	n += copy(msg[n:], b)
+	return c.quicConn.SendMessage(msg[:n])
+}
+
+// ReceiveDatagram blocks until a datagram for protocol p is received.
+func (c *conn) ReceiveDatagram(ctx context.Context, p protocol.ID) ([]byte, error) {
+	if !c.quicConn.ConnectionState().SupportsDatagrams {
+		return nil, network.ErrDatagramsNotSupported
+	}
+	c.startDatagrams()
+	d := &c.datagrams
+
+	d.mx.Lock()
+	if d.queues == nil {
+		d.queues = make(map[protocol.ID]chan []byte)
+	}
+	q, ok := d.queues[p]
+	if !ok {
+		q = make(chan []byte, maxDatagramQueueLen)
+		d.queues[p] = q
+	}
+	d.mx.Unlock()
+
+	select {
+	case b := <-q:
+		c.scope.ReleaseMemory(len(b))
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-d.closed:
+		return nil, d.closeErr
+	}
+}
+
+func (c *conn) readDatagrams() {
+	d := &c.datagrams
+	for {
+		msg, err := c.quicConn.ReceiveMessage()
+		if err != nil {
+			d.closeErr = err
+			close(d.closed)
+			return
+		}
+		p, b, err := parseDatagram(msg)
+		if err != nil {
+			log.Debugw("received invalid datagram", "peer", c.remotePeerID, "error", err)
+			continue
+		}
+		if p == "" {
+			c.handleControlDatagram(b)
+			continue
+		}
+		d.mx.Lock()
+		q, ok := d.queues[p]
+		d.mx.Unlock()
+		if !ok {
+			// we're not receiving datagrams for this protocol
+			continue
+		}
+		if err := c.scope.ReserveMemory(len(b), network.ReservationPriorityLow); err != nil {
+			log.Debugw("dropping datagram", "peer", c.remotePeerID, "protocol", p, "error", err)
+			continue
+		}
+		select {
+		case q <- b:
+		default:
+			log.Debugw("datagram queue full, dropping datagram", "peer", c.remotePeerID, "protocol", p)
+			c.scope.ReleaseMemory(len(b))
+		}
+	}
+}
+
+func (c *conn) handleControlDatagram(b []byte) {
+	if len(b) == 0 {
+		log.Debugw("received invalid control datagram", "peer", c.remotePeerID)
+		return
+	}
+	typ := b[0]
+	p, _, err := parseDatagram(b[1:])
+	if err != nil || p == "" {
+		log.Debugw("received invalid control datagram", "peer", c.remotePeerID, "error", err)
+		return
+	}
+
+	d := &c.datagrams
+	switch typ {
+	case datagramProbe:
+		d.mx.Lock()
+		_, ok := d.queues[p]
+		d.mx.Unlock()
+		answer := datagramReject
+		if ok {
+			answer = datagramAccept
+		}
+		if err := c.quicConn.SendMessage(controlDatagram(answer, p)); err != nil {
+			log.Debugw("failed to answer datagram probe", "peer", c.remotePeerID, "protocol", p, "error", err)
+		}
+	case datagramAccept, datagramReject:
+		d.mx.Lock()
+		defer d.mx.Unlock()
+		n, ok := d.probes[p]
+		if !ok {
+			// duplicate answer, or no negotiation in progress
+			return
+		}
+		delete(d.probes, p)
+		if typ == datagramAccept {
+			if d.negotiated == nil {
+				d.negotiated = make(map[protocol.ID]struct{})
+			}
+			d.negotiated[p] = struct{}{}
+		}
+		n.accepted = typ == datagramAccept
+		close(n.done)
+	default:
+		log.Debugw("received unknown control datagram", "peer", c.remotePeerID, "type", typ)
+	}
+}
+
+// controlDatagram encodes a control message of type typ, referring to protocol p.
+func controlDatagram(typ byte, p protocol.ID) []byte {
+	msg := make([]byte, 2+binary.MaxVarintLen64+len(p))
+	// the empty protocol ID
+	msg[0] = 0
+	msg[1] = typ
+	n := 2 + binary.PutUvarint(msg[2:], uint64(len(p)))
+	n += copy(msg[n:], p)
+	return msg[:n]
+}
+
+func parseDatagram(msg []byte) (protocol.ID, []byte, error) {
+	l, n := binary.Uvarint(msg)
+	if n <= 0 || l > uint64(len(msg)-n) {
+		return "", nil, errors.New("invalid protocol length")
+	}
+	return protocol.ID(msg[n : n+int(l)]), msg[n+int(l):], nil
+}
@@ -0,0 +1,166 @@
+package libp2pquic
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	mocknetwork "github.com/libp2p/go-libp2p/core/network/mocks"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+
+	"github.com/golang/mock/gomock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// sendUntilReceived negotiates p, and sends datagrams until one of them is received.
+// This is needed since datagrams can be lost.
+func sendUntilReceived(t *testing.T, sender, receiver network.DatagramConn, p protocol.ID, msg []byte) []byte {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	received := make(chan []byte, 1)
+	go func() {
+		b, err := receiver.ReceiveDatagram(ctx, p)
+		if err != nil {
+			t.Error(err)
+		}
+		received <- b
+	}()
+	// the peer rejects p until it's receiving datagrams for p
+	require.Eventually(t, func() bool { return sender.NegotiateDatagrams(ctx, p) == nil }, 5*time.Second, 10*time.Millisecond)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		require.NoError(t, sender.SendDatagram(p, msg))
+		select {
+		case b := <-received:
+			return b
+		case <-ticker.C:
+		}
+	}
+}
+
+func TestDatagrams(t *testing.T) {
+	for _, tc := range connTestCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			testDatagrams(t, tc)
+		})
+	}
+}
+
+func testDatagrams(t *testing.T, tc *connTestCase) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, nil, nil, nil, tc.Options...)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, nil, nil, nil, tc.Options...)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+	conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer conn.Close()
+	serverConn, err := ln.Accept()
+	require.NoError(t, err)
+
+	client := conn.(network.DatagramConn)
+	server := serverConn.(network.DatagramConn)
+	require.Equal(t, []byte("foo"), sendUntilReceived(t, client, server, "/foo", []byte("foo")))
+	require.Equal(t, []byte("bar"), sendUntilReceived(t, server, client, "/bar", []byte("bar")))
+
+	// protocols the peer isn't receiving datagrams for can't be negotiated
+	require.ErrorIs(t, client.SendDatagram("/bar", []byte("bar")), network.ErrDatagramProtocolNotNegotiated)
+	require.ErrorIs(t, client.NegotiateDatagrams(context.Background(), "/bar"), network.ErrDatagramProtocolNotNegotiated)
+	require.ErrorIs(t, client.SendDatagram("/bar", []byte("bar")), network.ErrDatagramProtocolNotNegotiated)
+	require.Equal(t, []byte("foo"), sendUntilReceived(t, client, server, "/foo", []byte("foo")))
+
+	// closing the connection unblocks ReceiveDatagram
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := server.ReceiveDatagram(context.Background(), "/foo")
+		errChan <- err
+	}()
+	serverConn.Close()
+	select {
+	case err := <-errChan:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestDatagramsResourceManager(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	clientID, clientKey := createPeer(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	serverRcmgr := mocknetwork.NewMockResourceManager(ctrl)
+	serverTransport, err := NewTransport(serverKey, nil, nil, serverRcmgr)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln, err := serverTransport.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+
+	connChan := make(chan tpt.CapableConn)
+	serverConnScope := mocknetwork.NewMockConnManagementScope(ctrl)
+	serverRcmgr.EXPECT().OpenConnection(network.DirInbound, false, gomock.Any()).Return(serverConnScope, nil)
+	serverConnScope.EXPECT().SetPeer(clientID)
+	go func() {
+		serverConn, err := ln.Accept()
+		require.NoError(t, err)
+		connChan <- serverConn
+	}()
+
+	conn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer conn.Close()
+	serverConn := <-connChan
+
+	gomock.InOrder(
+		// the first datagram is dropped, since the memory can't be reserved
+		serverConnScope.EXPECT().ReserveMemory(6, network.ReservationPriorityLow).Return(errors.New("nope")),
+		serverConnScope.EXPECT().ReserveMemory(6, network.ReservationPriorityLow),
+		serverConnScope.EXPECT().ReleaseMemory(6),
+	)
+	// datagrams that are still in flight
+	serverConnScope.EXPECT().ReserveMemory(6, network.ReservationPriorityLow).AnyTimes()
+	b := sendUntilReceived(t, conn.(network.DatagramConn), serverConn.(network.DatagramConn), "/foo", []byte("foobar"))
+	require.Equal(t, []byte("foobar"), b)
+
+	serverConnScope.EXPECT().Done()
+	serverConn.Close()
+}
+
+func TestParseDatagram(t *testing.T) {
+	p, b, err := parseDatagram([]byte("\x04/foofoobar"))
+	require.NoError(t, err)
+	require.Equal(t, protocol.ID("/foo"), p)
+	require.Equal(t, []byte("foobar"), b)
+
+	_, _, err = parseDatagram([]byte("\x10/foo"))
+	require.Error(t, err)
+	_, _, err = parseDatagram(nil)
+	require.Error(t, err)
+
+	// control datagrams use the empty protocol ID
+	p, b, err = parseDatagram(controlDatagram(datagramProbe, "/foo"))
+	require.NoError(t, err)
+	require.Empty(t, p)
+	require.Equal(t, []byte("\x01\x04/foo"), b)
+}
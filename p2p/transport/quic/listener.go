@@ -77,6 +77,7 @@ func (l *listener) Accept() (tpt.CapableConn, error) {
 			continue
 		}
 		l.transport.addConn(qconn, c)
+		c.startDatagrams()
 
 		// return through active hole punching if any
 		key := holePunchKey{addr: qconn.RemoteAddr().String(), peer: c.remotePeerID}
@@ -49,6 +49,7 @@ var quicConfig = &quic.Config{
 	},
 	KeepAlivePeriod: 15 * time.Second,
 	Versions:        []quic.VersionNumber{quic.VersionDraft29, quic.Version1},
+	EnableDatagrams: true,
 }
 
 const statelessResetKeyInfo = "libp2p quic stateless reset key"
@@ -300,6 +301,7 @@ func (t *transport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (tp
 		return nil, fmt.Errorf("secured connection gated")
 	}
 	t.addConn(qconn, c)
+	c.startDatagrams()
 	return c, nil
 }
 
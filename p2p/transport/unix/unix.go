@@ -0,0 +1,210 @@
+// Package unix implements a transport for go-libp2p that runs over Unix domain sockets.
+// It is useful for connecting libp2p hosts running on the same machine.
+package unix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+
+	logging "github.com/ipfs/go-log/v2"
+	ma "github.com/multiformats/go-multiaddr"
+	mafmt "github.com/multiformats/go-multiaddr-fmt"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const defaultConnectTimeout = 5 * time.Second
+
+var log = logging.Logger("unix-tpt")
+
+var dialMatcher = mafmt.Base(ma.P_UNIX)
+
+type Option func(*UnixTransport) error
+
+func WithConnectionTimeout(d time.Duration) Option {
+	return func(tr *UnixTransport) error {
+		tr.connectTimeout = d
+		return nil
+	}
+}
+
+// UnixTransport is the Unix domain socket transport.
+type UnixTransport struct {
+	// Connection upgrader for upgrading insecure stream connections to
+	// secure multiplex connections.
+	upgrader transport.Upgrader
+
+	// connect timeout
+	connectTimeout time.Duration
+
+	rcmgr network.ResourceManager
+}
+
+var _ transport.Transport = &UnixTransport{}
+
+// NewUnixTransport creates a Unix domain socket transport.
+func NewUnixTransport(upgrader transport.Upgrader, rcmgr network.ResourceManager, opts ...Option) (*UnixTransport, error) {
+	if rcmgr == nil {
+		rcmgr = network.NullResourceManager
+	}
+	tr := &UnixTransport{
+		upgrader:       upgrader,
+		connectTimeout: defaultConnectTimeout, // can be set by using the WithConnectionTimeout option
+		rcmgr:          rcmgr,
+	}
+	for _, o := range opts {
+		if err := o(tr); err != nil {
+			return nil, err
+		}
+	}
+	return tr, nil
+}
+
+// CanDial returns true if this transport believes it can dial the given
+// multiaddr.
+func (t *UnixTransport) CanDial(addr ma.Multiaddr) bool {
+	return dialMatcher.Matches(addr)
+}
+
+func (t *UnixTransport) maDial(ctx context.Context, raddr ma.Multiaddr) (manet.Conn, error) {
+	// Apply the deadline iff applicable
+	if t.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.connectTimeout)
+		defer cancel()
+	}
+
+	var d manet.Dialer
+	conn, err := d.DialContext(ctx, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &unixConn{Conn: conn, addr: raddr}, nil
+}
+
+// Dial dials the peer at the remote address.
+func (t *UnixTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	if !t.CanDial(raddr) {
+		return nil, fmt.Errorf("can't dial %s", raddr)
+	}
+	connScope, err := t.rcmgr.OpenConnection(network.DirOutbound, true, raddr)
+	if err != nil {
+		log.Debugw("resource manager blocked outgoing connection", "peer", p, "addr", raddr, "error", err)
+		return nil, err
+	}
+	if err := connScope.SetPeer(p); err != nil {
+		log.Debugw("resource manager blocked outgoing connection for peer", "peer", p, "addr", raddr, "error", err)
+		connScope.Done()
+		return nil, err
+	}
+	conn, err := t.maDial(ctx, raddr)
+	if err != nil {
+		connScope.Done()
+		return nil, err
+	}
+	direction := network.DirOutbound
+	if ok, isClient, _ := network.GetSimultaneousConnect(ctx); ok && !isClient {
+		direction = network.DirInbound
+	}
+	return t.upgrader.Upgrade(ctx, t, conn, direction, p, connScope)
+}
+
+// Listen listens on the given multiaddr.
+// If a socket file is left over at the path from a listener that didn't shut down
+// cleanly, it is removed.
+func (t *UnixTransport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	if !dialMatcher.Matches(laddr) {
+		return nil, fmt.Errorf("can't listen on %s", laddr)
+	}
+	_, path, err := manet.DialArgs(laddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	list, err := manet.Listen(laddr)
+	if err != nil {
+		return nil, err
+	}
+	return t.upgrader.UpgradeListener(t, &unixListener{list}), nil
+}
+
+// Protocols returns the list of terminal protocols this transport can dial.
+func (t *UnixTransport) Protocols() []int {
+	return []int{ma.P_UNIX}
+}
+
+// Proxy always returns false for the Unix transport.
+func (t *UnixTransport) Proxy() bool {
+	return false
+}
+
+func (t *UnixTransport) String() string {
+	return "Unix"
+}
+
+// removeStaleSocket removes the socket file at path, unless a listener is still accepting
+// connections on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		// The socket is in use. Listen will fail with "address already in use".
+		conn.Close()
+		return nil
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	log.Debugw("removing stale socket file", "path", path)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+type unixListener struct {
+	manet.Listener
+}
+
+func (l *unixListener) Accept() (manet.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	// We're not calling OpenConnection in the resource manager here,
+	// since the manet.Conn doesn't allow us to save the scope.
+	// It's the caller's (usually the p2p/net/upgrader) responsibility
+	// to call the resource manager.
+	return &unixConn{Conn: c, addr: l.Multiaddr()}, nil
+}
+
+// unixConn sets the multiaddrs of a Unix domain socket connection.
+// Only the listening side of a connection is bound to a path, so manet leaves the dialer's
+// address empty. We use the listener's multiaddr as both the local and the remote multiaddr.
+type unixConn struct {
+	manet.Conn
+	addr ma.Multiaddr
+}
+
+func (c *unixConn) LocalMultiaddr() ma.Multiaddr  { return c.addr }
+func (c *unixConn) RemoteMultiaddr() ma.Multiaddr { return c.addr }
@@ -0,0 +1,191 @@
+package unix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	mocknetwork "github.com/libp2p/go-libp2p/core/network/mocks"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/core/sec/insecure"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	csms "github.com/libp2p/go-libp2p/p2p/net/conn-security-multistream"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	ttransport "github.com/libp2p/go-libp2p/p2p/transport/testsuite"
+
+	"github.com/golang/mock/gomock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// socketPath returns a path for a socket file.
+// The path is kept short, since socket paths are limited to about 100 characters.
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "libp2p-unix")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "sock")
+}
+
+func newTransport(t *testing.T, rcmgr network.ResourceManager) (peer.ID, *UnixTransport) {
+	t.Helper()
+	id, m := makeInsecureMuxer(t)
+	u, err := tptu.New(m, yamux.DefaultTransport)
+	require.NoError(t, err)
+	tr, err := NewUnixTransport(u, rcmgr)
+	require.NoError(t, err)
+	return id, tr
+}
+
+// uniquePathTransport listens on a new socket path on every call to Listen.
+// The test suite listens on the same multiaddr multiple times, at the same time.
+// That works for TCP port 0, but not for a socket path.
+type uniquePathTransport struct {
+	*UnixTransport
+	dir     string
+	counter int32
+}
+
+func (t *uniquePathTransport) Listen(ma.Multiaddr) (transport.Listener, error) {
+	path := filepath.Join(t.dir, fmt.Sprintf("%d", atomic.AddInt32(&t.counter, 1)))
+	return t.UnixTransport.Listen(ma.StringCast("/unix" + path))
+}
+
+func TestUnixTransport(t *testing.T) {
+	peerA, ta := newTransport(t, nil)
+	_, tb := newTransport(t, nil)
+	path := socketPath(t)
+	tpt := &uniquePathTransport{UnixTransport: ta, dir: filepath.Dir(path)}
+	ttransport.SubtestTransport(t, tpt, tb, "/unix"+path, peerA)
+}
+
+func TestResourceManager(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	peerA, ta := newTransport(t, nil)
+	ln, err := ta.Listen(ma.StringCast("/unix" + socketPath(t)))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	rcmgr := mocknetwork.NewMockResourceManager(ctrl)
+	_, tb := newTransport(t, rcmgr)
+
+	t.Run("success", func(t *testing.T) {
+		scope := mocknetwork.NewMockConnManagementScope(ctrl)
+		rcmgr.EXPECT().OpenConnection(network.DirOutbound, true, ln.Multiaddr()).Return(scope, nil)
+		scope.EXPECT().SetPeer(peerA)
+		scope.EXPECT().PeerScope().Return(network.NullScope).AnyTimes() // called by the upgrader
+		conn, err := tb.Dial(context.Background(), ln.Multiaddr(), peerA)
+		require.NoError(t, err)
+		scope.EXPECT().Done()
+		defer conn.Close()
+	})
+
+	t.Run("connection denied", func(t *testing.T) {
+		rerr := errors.New("nope")
+		rcmgr.EXPECT().OpenConnection(network.DirOutbound, true, ln.Multiaddr()).Return(nil, rerr)
+		_, err = tb.Dial(context.Background(), ln.Multiaddr(), peerA)
+		require.ErrorIs(t, err, rerr)
+	})
+
+	t.Run("peer denied", func(t *testing.T) {
+		scope := mocknetwork.NewMockConnManagementScope(ctrl)
+		rcmgr.EXPECT().OpenConnection(network.DirOutbound, true, ln.Multiaddr()).Return(scope, nil)
+		rerr := errors.New("nope")
+		scope.EXPECT().SetPeer(peerA).Return(rerr)
+		scope.EXPECT().Done()
+		_, err = tb.Dial(context.Background(), ln.Multiaddr(), peerA)
+		require.ErrorIs(t, err, rerr)
+	})
+}
+
+func TestConnMultiaddrs(t *testing.T) {
+	peerA, ta := newTransport(t, nil)
+	_, tb := newTransport(t, nil)
+	ln, err := ta.Listen(ma.StringCast("/unix" + socketPath(t)))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	conn, err := tb.Dial(context.Background(), ln.Multiaddr(), peerA)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, ln.Multiaddr(), conn.LocalMultiaddr())
+	require.Equal(t, ln.Multiaddr(), conn.RemoteMultiaddr())
+
+	sconn, err := ln.Accept()
+	require.NoError(t, err)
+	defer sconn.Close()
+	require.Equal(t, ln.Multiaddr(), sconn.LocalMultiaddr())
+	require.Equal(t, ln.Multiaddr(), sconn.RemoteMultiaddr())
+}
+
+func TestListenRemovesStaleSocket(t *testing.T) {
+	path := socketPath(t)
+	// leave a socket file behind, as a process that crashed would
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, l.Close())
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	_, tr := newTransport(t, nil)
+	ln, err := tr.Listen(ma.StringCast("/unix" + path))
+	require.NoError(t, err)
+	require.NoError(t, ln.Close())
+}
+
+func TestListenSocketInUse(t *testing.T) {
+	path := socketPath(t)
+	_, tr := newTransport(t, nil)
+	ln, err := tr.Listen(ma.StringCast("/unix" + path))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	_, err = tr.Listen(ma.StringCast("/unix" + path))
+	require.Error(t, err)
+}
+
+func TestListenNotASocket(t *testing.T) {
+	path := socketPath(t)
+	require.NoError(t, os.WriteFile(path, []byte("foobar"), 0o600))
+
+	_, tr := newTransport(t, nil)
+	_, err := tr.Listen(ma.StringCast("/unix" + path))
+	require.Error(t, err)
+	// the file must not have been removed
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foobar"), b)
+}
+
+func TestUnixTransportCantDialTCP(t *testing.T) {
+	var u transport.Upgrader
+	tpt, err := NewUnixTransport(u, nil)
+	require.NoError(t, err)
+	require.False(t, tpt.CanDial(ma.StringCast("/ip4/127.0.0.1/tcp/1234")))
+	_, err = tpt.Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0"))
+	require.Error(t, err)
+}
+
+func makeInsecureMuxer(t *testing.T) (peer.ID, sec.SecureMuxer) {
+	t.Helper()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	var secMuxer csms.SSMuxer
+	secMuxer.AddTransport(insecure.ID, insecure.NewWithIdentity(id, priv))
+	return id, &secMuxer
+}
@@ -0,0 +1,323 @@
+package libp2pwebrtc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/transport/webrtc/pb"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pion/datachannel"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// maxAcceptQueueLen is the number of incoming streams that can be waiting to be accepted.
+	maxAcceptQueueLen = 256
+	// maxOutgoingStreams is the number of streams we can have open at the same time.
+	// OpenStream blocks once this limit is reached. pion's SCTP implementation becomes
+	// very slow with a large number of concurrent streams.
+	maxOutgoingStreams = 256
+	// maxConcurrentOpens is the number of data channels that can be in the process of being opened.
+	// pion drops incoming SCTP streams when its accept queue (16 streams) is full, and they're only
+	// retransmitted after the SCTP retransmission timeout.
+	maxConcurrentOpens = 8
+	// maxDataChannelID is the highest SCTP stream identifier that can be used for a data channel.
+	maxDataChannelID = 65534
+	// closeFlushTimeout is the maximum time Close waits for the peer to acknowledge outstanding data.
+	closeFlushTimeout = 500 * time.Millisecond
+)
+
+var errConnClosed = errors.New("connection closed")
+
+type connMultiaddrs struct {
+	local, remote ma.Multiaddr
+}
+
+var _ network.ConnMultiaddrs = &connMultiaddrs{}
+
+func (c *connMultiaddrs) LocalMultiaddr() ma.Multiaddr  { return c.local }
+func (c *connMultiaddrs) RemoteMultiaddr() ma.Multiaddr { return c.remote }
+
+type connection struct {
+	pc        *webrtc.PeerConnection
+	transport *transport
+	scope     network.ConnManagementScope
+	onClose   func()
+
+	localPeer peer.ID
+	privKey   ic.PrivKey
+
+	// set once the handshake completed
+	remotePeer      peer.ID
+	remoteKey       ic.PubKey
+	localMultiaddr  ma.Multiaddr
+	remoteMultiaddr ma.Multiaddr
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+
+	acceptQueue chan *stream
+
+	mx sync.Mutex
+	// set once the handshake channel is open
+	handshake *stream
+
+	// Data channel IDs are allocated by us (instead of by pion), so that they can be reused once
+	// the data channel was closed. Dialers use even IDs, listeners use odd IDs.
+	streamSlots chan struct{}
+	openSlots   chan struct{}
+	idMx        sync.Mutex
+	nextID      int // int, so that it doesn't overflow when wrapping around
+	idsInUse    map[uint16]struct{}
+}
+
+var _ tpt.CapableConn = &connection{}
+
+// newConnection wraps a PeerConnection before it is connected, so that no data channels
+// opened by the peer are missed.
+func newConnection(dir network.Direction, pc *webrtc.PeerConnection, t *transport, scope network.ConnManagementScope, onClose func()) *connection {
+	ctx, cancel := context.WithCancel(context.Background())
+	// ID 0 is used by the handshake channel
+	firstID := 2
+	if dir == network.DirInbound {
+		firstID = 1
+	}
+	c := &connection{
+		pc:          pc,
+		transport:   t,
+		scope:       scope,
+		onClose:     onClose,
+		localPeer:   t.localPeer,
+		privKey:     t.privKey,
+		ctx:         ctx,
+		cancel:      cancel,
+		acceptQueue: make(chan *stream, maxAcceptQueueLen),
+		streamSlots: make(chan struct{}, maxOutgoingStreams),
+		openSlots:   make(chan struct{}, maxConcurrentOpens),
+		nextID:      firstID,
+		idsInUse:    make(map[uint16]struct{}),
+	}
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			c.Close()
+		}
+	})
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnOpen(func() {
+			rwc, err := dc.Detach()
+			if err != nil {
+				log.Debugw("failed to detach data channel", "error", err)
+				dc.Close()
+				return
+			}
+			str := newStream(dc, rwc, nil)
+			select {
+			case c.acceptQueue <- str:
+			default:
+				log.Debugw("accept queue full, resetting incoming stream", "peer", c.remotePeer)
+				str.Reset()
+			}
+		})
+	})
+	return c
+}
+
+// detachOnOpen returns a channel that receives the detached data channel once dc is open.
+func detachOnOpen(dc *webrtc.DataChannel) <-chan detachResult {
+	ch := make(chan detachResult, 1)
+	dc.OnOpen(func() {
+		rwc, err := dc.Detach()
+		ch <- detachResult{rwc: rwc, err: err}
+	})
+	return ch
+}
+
+type detachResult struct {
+	rwc datachannel.ReadWriteCloser
+	err error
+}
+
+func (c *connection) OpenStream(ctx context.Context) (network.MuxedStream, error) {
+	if c.IsClosed() {
+		return nil, errConnClosed
+	}
+	if err := c.acquire(ctx, c.streamSlots); err != nil {
+		return nil, err
+	}
+	return c.openStream(ctx, c.allocateID())
+}
+
+// openStream opens a data channel with the given ID. The ID is released once the stream is done,
+// or when opening the data channel fails.
+func (c *connection) openStream(ctx context.Context, id uint16) (*stream, error) {
+	if err := c.acquire(ctx, c.openSlots); err != nil {
+		c.releaseID(id)
+		return nil, err
+	}
+	defer func() { <-c.openSlots }()
+
+	dc, err := c.pc.CreateDataChannel("", &webrtc.DataChannelInit{ID: &id})
+	if err != nil {
+		c.releaseID(id)
+		return nil, err
+	}
+	var res detachResult
+	select {
+	case res = <-detachOnOpen(dc):
+	case <-ctx.Done():
+		res.err = ctx.Err()
+	case <-c.ctx.Done():
+		res.err = errConnClosed
+	}
+	if res.err != nil {
+		dc.Close()
+		c.releaseID(id)
+		return nil, res.err
+	}
+
+	// pion considers detached data channels open as soon as the DATA_CHANNEL_OPEN message was sent.
+	// Wait for the peer's DATA_CHANNEL_ACK, which is processed by the stream's read loop.
+	acked := make(chan struct{})
+	if rdc, ok := res.rwc.(*datachannel.DataChannel); ok {
+		rdc.OnOpen(func() { close(acked) })
+	} else {
+		close(acked)
+	}
+	str := newStream(dc, res.rwc, func() { c.releaseID(id) })
+	select {
+	case <-acked:
+		return str, nil
+	case <-ctx.Done():
+		str.Reset()
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		str.Reset()
+		return nil, errConnClosed
+	}
+}
+
+// acquire takes a slot from the semaphore sem.
+func (c *connection) acquire(ctx context.Context, sem chan struct{}) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		return errConnClosed
+	}
+}
+
+// allocateID returns an unused data channel ID. IDs are allocated round-robin, so that
+// an ID isn't reused right after it was released.
+// Since the number of outgoing streams is limited, there's always an ID available.
+func (c *connection) allocateID() uint16 {
+	c.idMx.Lock()
+	defer c.idMx.Unlock()
+
+	for {
+		id := uint16(c.nextID)
+		c.nextID += 2
+		if c.nextID > maxDataChannelID {
+			c.nextID = 2 - c.nextID%2
+		}
+		if _, ok := c.idsInUse[id]; !ok {
+			c.idsInUse[id] = struct{}{}
+			return id
+		}
+	}
+}
+
+func (c *connection) releaseID(id uint16) {
+	c.idMx.Lock()
+	delete(c.idsInUse, id)
+	c.idMx.Unlock()
+	<-c.streamSlots
+}
+
+func (c *connection) AcceptStream() (network.MuxedStream, error) {
+	select {
+	case <-c.ctx.Done():
+		return nil, errConnClosed
+	case str := <-c.acceptQueue:
+		return str, nil
+	}
+}
+
+func (c *connection) Close() error {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.flush()
+		c.scope.Done()
+		_ = c.pc.Close()
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+// flush waits until the peer acknowledged all data sent on the connection.
+// pion discards all SCTP data that is still queued when the association is closed. Without flushing,
+// DATA_CHANNEL_ACKs, FINs and stream resets sent right before closing the connection would be lost.
+func (c *connection) flush() {
+	c.mx.Lock()
+	hs := c.handshake
+	c.mx.Unlock()
+	if hs == nil {
+		return
+	}
+	// SCTP acknowledgements are cumulative. Once a message sent on the handshake channel
+	// is acknowledged, so is all data that was sent before it.
+	acked := make(chan struct{})
+	var once sync.Once
+	hs.dc.SetBufferedAmountLowThreshold(0)
+	hs.dc.OnBufferedAmountLow(func() { once.Do(func() { close(acked) }) })
+	if err := hs.writeMessage(&pb.Message{}); err != nil {
+		return
+	}
+	timer := time.NewTimer(closeFlushTimeout)
+	defer timer.Stop()
+	select {
+	case <-acked:
+	case <-timer.C:
+	}
+}
+
+func (c *connection) IsClosed() bool { return c.ctx.Err() != nil }
+
+func (c *connection) LocalPeer() peer.ID            { return c.localPeer }
+func (c *connection) LocalPrivateKey() ic.PrivKey   { return c.privKey }
+func (c *connection) RemotePeer() peer.ID           { return c.remotePeer }
+func (c *connection) RemotePublicKey() ic.PubKey    { return c.remoteKey }
+func (c *connection) LocalMultiaddr() ma.Multiaddr  { return c.localMultiaddr }
+func (c *connection) RemoteMultiaddr() ma.Multiaddr { return c.remoteMultiaddr }
+func (c *connection) Scope() network.ConnScope      { return c.scope }
+func (c *connection) Transport() tpt.Transport      { return c.transport }
+
+// selectedAddrs returns the addresses of the ICE candidate pair in use.
+// It must only be called once the connection is established.
+func (c *connection) selectedAddrs() (local, remote *net.UDPAddr, err error) {
+	pair, err := c.pc.SCTP().Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil {
+		return nil, nil, err
+	}
+	if pair == nil {
+		return nil, nil, errors.New("no ICE candidate pair selected")
+	}
+	local = &net.UDPAddr{IP: net.ParseIP(pair.Local.Address), Port: int(pair.Local.Port)}
+	remote = &net.UDPAddr{IP: net.ParseIP(pair.Remote.Address), Port: int(pair.Remote.Port)}
+	if local.IP == nil || remote.IP == nil {
+		return nil, nil, errors.New("invalid ICE candidate address")
+	}
+	return local, remote, nil
+}
@@ -0,0 +1,107 @@
+package libp2pwebrtc
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pion/dtls/v2/pkg/crypto/fingerprint"
+	"github.com/pion/webrtc/v3"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+)
+
+// sdpFingerprintHashes maps the multihash codes we support in /certhash components
+// to the hash function names used in SDP fingerprint attributes.
+var sdpFingerprintHashes = map[uint64]string{
+	multihash.SHA2_256: "sha-256",
+	multihash.SHA2_512: "sha-512",
+}
+
+// fingerprintToMultihash converts a DTLS fingerprint, as returned by pion, to a multihash.
+func fingerprintToMultihash(fp webrtc.DTLSFingerprint) ([]byte, error) {
+	var code uint64
+	for c, name := range sdpFingerprintHashes {
+		if name == fp.Algorithm {
+			code = c
+			break
+		}
+	}
+	if code == 0 {
+		return nil, fmt.Errorf("unsupported fingerprint algorithm: %s", fp.Algorithm)
+	}
+	digest, err := hex.DecodeString(strings.ReplaceAll(fp.Value, ":", ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode fingerprint: %w", err)
+	}
+	return multihash.Encode(digest, code)
+}
+
+// certificateMultihash returns the SHA-256 multihash of the DER-encoded certificate.
+func certificateMultihash(cert *x509.Certificate) ([]byte, error) {
+	fp, err := fingerprint.Fingerprint(cert, crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return fingerprintToMultihash(webrtc.DTLSFingerprint{Algorithm: "sha-256", Value: fp})
+}
+
+// sdpFingerprint formats a decoded certificate hash as the value of an SDP fingerprint attribute,
+// e.g. "sha-256 AB:CD:...".
+func sdpFingerprint(dh *multihash.DecodedMultihash) (string, error) {
+	name, ok := sdpFingerprintHashes[dh.Code]
+	if !ok {
+		return "", fmt.Errorf("unsupported certificate hash: %s", multihash.Codes[dh.Code])
+	}
+	var sb strings.Builder
+	sb.Grow(len(name) + 1 + 3*len(dh.Digest))
+	sb.WriteString(name)
+	sb.WriteByte(' ')
+	for i, b := range dh.Digest {
+		if i > 0 {
+			sb.WriteByte(':')
+		}
+		fmt.Fprintf(&sb, "%02X", b)
+	}
+	return sb.String(), nil
+}
+
+// extractCertHash returns the certificate hash contained in the /certhash component of addr.
+// WebRTC addresses carry exactly one certificate hash.
+func extractCertHash(addr ma.Multiaddr) (*multihash.DecodedMultihash, error) {
+	var hashes []string
+	ma.ForEach(addr, func(c ma.Component) bool {
+		if c.Protocol().Code == ma.P_CERTHASH {
+			hashes = append(hashes, c.Value())
+		}
+		return true
+	})
+	if len(hashes) != 1 {
+		return nil, errors.New("expected exactly one certificate hash")
+	}
+	_, ch, err := multibase.Decode(hashes[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to multibase-decode certificate hash: %w", err)
+	}
+	dh, err := multihash.Decode(ch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to multihash-decode certificate hash: %w", err)
+	}
+	if _, ok := sdpFingerprintHashes[dh.Code]; !ok {
+		return nil, fmt.Errorf("unsupported certificate hash: %s", multihash.Codes[dh.Code])
+	}
+	return dh, nil
+}
+
+func addrComponentForCert(mh []byte) (ma.Multiaddr, error) {
+	certStr, err := multibase.Encode(multibase.Base64url, mh)
+	if err != nil {
+		return nil, err
+	}
+	return ma.NewComponent(ma.ProtocolWithCode(ma.P_CERTHASH).Name, certStr)
+}
@@ -0,0 +1,312 @@
+package libp2pwebrtc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pion/ice/v2"
+	"github.com/pion/stun"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// maxInFlightHandshakes is the number of incoming connections that can be in the
+	// process of being established at the same time.
+	maxInFlightHandshakes = 64
+	// acceptQueueLen is the number of established connections that can be waiting to be accepted.
+	acceptQueueLen = 16
+)
+
+var errListenerClosed = errors.New("listener closed")
+
+type listener struct {
+	transport *transport
+	mux       ice.UDPMux
+
+	localAddr      net.Addr
+	localMultiaddr ma.Multiaddr // including the /certhash
+
+	ctx          context.Context
+	cancel       context.CancelFunc
+	closeOnce    sync.Once
+	muxCloseOnce sync.Once
+
+	acceptQueue chan tpt.CapableConn
+
+	mx sync.Mutex
+	// ICE ufrags of connections that are being established, or that are established
+	ufrags       map[string]struct{}
+	numInFlight  int
+	handshakesWg sync.WaitGroup
+}
+
+var _ tpt.Listener = &listener{}
+
+func newListener(t *transport, udpConn *net.UDPConn) (*listener, error) {
+	localMultiaddr, err := toWebRTCMultiaddr(udpConn.LocalAddr())
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	certComp, err := addrComponentForCert(t.localFingerprint)
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &listener{
+		transport:      t,
+		localAddr:      udpConn.LocalAddr(),
+		localMultiaddr: localMultiaddr.Encapsulate(certComp),
+		ctx:            ctx,
+		cancel:         cancel,
+		acceptQueue:    make(chan tpt.CapableConn, acceptQueueLen),
+		ufrags:         make(map[string]struct{}),
+	}
+	l.mux = ice.NewUDPMuxDefault(ice.UDPMuxParams{
+		UDPConn: &stunSniffingConn{PacketConn: udpConn, onBindingRequest: l.onBindingRequest},
+	})
+	return l, nil
+}
+
+// onBindingRequest is called for every STUN binding request received on the listener's socket.
+// Binding requests with an unknown ufrag are the first packets sent by a new dialer.
+func (l *listener) onBindingRequest(ufrag string, addr net.Addr) {
+	remote, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+	if !strings.HasPrefix(ufrag, ufragPrefix) {
+		return
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.ctx.Err() != nil {
+		return
+	}
+	if _, ok := l.ufrags[ufrag]; ok {
+		return
+	}
+	if l.numInFlight >= maxInFlightHandshakes {
+		log.Debugw("too many handshakes in flight, dropping incoming connection", "addr", remote)
+		return
+	}
+	l.ufrags[ufrag] = struct{}{}
+	l.numInFlight++
+	l.handshakesWg.Add(1)
+	go func() {
+		defer l.handshakesWg.Done()
+		l.handleCandidate(ufrag, remote)
+		l.mx.Lock()
+		l.numInFlight--
+		l.mx.Unlock()
+	}()
+}
+
+func (l *listener) removeUfrag(ufrag string) {
+	l.mx.Lock()
+	delete(l.ufrags, ufrag)
+	closeMux := l.ctx.Err() != nil && len(l.ufrags) == 0
+	l.mx.Unlock()
+	if closeMux {
+		l.closeMux()
+	}
+}
+
+// closeMux closes the ICE UDP mux, and with it the listener's socket.
+func (l *listener) closeMux() {
+	l.muxCloseOnce.Do(func() { l.mux.Close() })
+}
+
+func (l *listener) handleCandidate(ufrag string, remote *net.UDPAddr) {
+	ctx, cancel := context.WithTimeout(l.ctx, handshakeTimeout)
+	defer cancel()
+
+	c, err := l.setupConnection(ctx, ufrag, remote)
+	if err != nil {
+		log.Debugw("failed to set up connection", "addr", remote, "error", err)
+		l.removeUfrag(ufrag)
+		return
+	}
+
+	select {
+	case l.acceptQueue <- c:
+	default:
+		log.Debugw("accept queue full, dropping incoming connection", "peer", c.RemotePeer(), "addr", remote)
+		c.Close()
+	}
+}
+
+func (l *listener) setupConnection(ctx context.Context, ufrag string, remote *net.UDPAddr) (*connection, error) {
+	t := l.transport
+	remoteMultiaddr, err := toWebRTCMultiaddr(remote)
+	if err != nil {
+		return nil, err
+	}
+	if t.gater != nil && !t.gater.InterceptAccept(&connMultiaddrs{local: l.localMultiaddr, remote: remoteMultiaddr}) {
+		return nil, errors.New("connection gated")
+	}
+	scope, err := t.rcmgr.OpenConnection(network.DirInbound, false, remoteMultiaddr)
+	if err != nil {
+		log.Debugw("resource manager blocked incoming connection", "addr", remote, "error", err)
+		return nil, err
+	}
+
+	se := t.settingEngine(remote)
+	se.SetICECredentials(ufrag, ufrag)
+	se.SetLite(true)
+	se.SetICEUDPMux(l.mux)
+	// The dialer's certificate is verified as part of the Noise handshake.
+	se.DisableCertificateFingerprintVerification(true)
+	if err := se.SetAnsweringDTLSRole(webrtc.DTLSRoleServer); err != nil {
+		scope.Done()
+		return nil, err
+	}
+	pc, err := webrtc.NewAPI(webrtc.WithSettingEngine(se)).NewPeerConnection(t.webrtcConfig())
+	if err != nil {
+		scope.Done()
+		return nil, err
+	}
+	c := newConnection(network.DirInbound, pc, t, scope, func() { l.removeUfrag(ufrag) })
+	if err := l.connect(ctx, c, remote, ufrag); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if t.gater != nil && !t.gater.InterceptSecured(network.DirInbound, c.RemotePeer(), c) {
+		c.Close()
+		return nil, errors.New("secured connection gated")
+	}
+	if err := scope.SetPeer(c.RemotePeer()); err != nil {
+		log.Debugw("resource manager blocked incoming connection for peer", "peer", c.RemotePeer(), "addr", remote, "error", err)
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (l *listener) connect(ctx context.Context, c *connection, remote *net.UDPAddr, ufrag string) error {
+	t := l.transport
+	hsChannel, err := createHandshakeChannel(c.pc)
+	if err != nil {
+		return err
+	}
+	opened := detachOnOpen(hsChannel)
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: renderClientSDP(remote, ufrag)}
+	if err := c.pc.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+	answer, err := c.pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := c.pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	hs, err := c.awaitHandshakeChannel(ctx, hsChannel, opened)
+	if err != nil {
+		return err
+	}
+
+	remoteCert, err := parseCertificate(c.pc.SCTP().Transport().GetRemoteCertificate())
+	if err != nil {
+		return err
+	}
+	remoteFingerprint, err := certificateMultihash(remoteCert)
+	if err != nil {
+		return err
+	}
+	prologue := noisePrologue(remoteFingerprint, t.localFingerprint)
+	st, err := t.noise.WithSessionOptions(noise.Prologue(prologue), noise.DisablePeerIDCheck())
+	if err != nil {
+		return err
+	}
+	// The listener is the Noise initiator. It learns the dialer's peer ID during the handshake.
+	sconn, err := st.SecureOutbound(ctx, hs, "")
+	if err != nil {
+		return err
+	}
+	c.remotePeer = sconn.RemotePeer()
+	c.remoteKey = sconn.RemotePublicKey()
+	return nil
+}
+
+func (l *listener) Accept() (tpt.CapableConn, error) {
+	select {
+	case <-l.ctx.Done():
+		return nil, errListenerClosed
+	case c := <-l.acceptQueue:
+		return c, nil
+	}
+}
+
+// Close stops accepting new connections.
+// Connections that were already accepted share the listener's socket, and stay usable.
+// The socket is closed once the last of them is closed.
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		l.mx.Lock()
+		l.cancel()
+		l.mx.Unlock()
+		l.handshakesWg.Wait()
+	loop:
+		for {
+			select {
+			case c := <-l.acceptQueue:
+				c.Close()
+			default:
+				break loop
+			}
+		}
+		l.mx.Lock()
+		closeMux := len(l.ufrags) == 0
+		l.mx.Unlock()
+		if closeMux {
+			l.closeMux()
+		}
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.localAddr
+}
+
+func (l *listener) Multiaddr() ma.Multiaddr {
+	return l.localMultiaddr
+}
+
+// stunSniffingConn wraps the listener's UDP socket. It reports the ufrag of every STUN binding
+// request it receives, which allows the listener to detect new dialers before handing
+// the packet to the ICE UDP mux.
+type stunSniffingConn struct {
+	net.PacketConn
+	onBindingRequest func(ufrag string, addr net.Addr)
+}
+
+func (c *stunSniffingConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err != nil || !stun.IsMessage(b[:n]) {
+		return n, addr, err
+	}
+	msg := &stun.Message{Raw: append([]byte{}, b[:n]...)}
+	if err := msg.Decode(); err != nil || msg.Type != stun.BindingRequest {
+		return n, addr, nil
+	}
+	username, err := msg.Get(stun.AttrUsername)
+	if err != nil {
+		return n, addr, nil
+	}
+	// The USERNAME attribute is <local ufrag>:<remote ufrag>. Both are equal to the ufrag chosen by the dialer.
+	ufrag, _, _ := strings.Cut(string(username), ":")
+	c.onBindingRequest(ufrag, addr)
+	return n, addr, nil
+}
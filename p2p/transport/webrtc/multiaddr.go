@@ -0,0 +1,43 @@
+package libp2pwebrtc
+
+import (
+	"errors"
+	"net"
+
+	ma "github.com/multiformats/go-multiaddr"
+	mafmt "github.com/multiformats/go-multiaddr-fmt"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+var webrtcComponent = ma.StringCast("/webrtc")
+
+var dialMatcher = mafmt.And(mafmt.IP, mafmt.Base(ma.P_UDP), mafmt.Base(ma.P_WEBRTC), mafmt.Base(ma.P_CERTHASH))
+
+var listenMatcher = mafmt.And(mafmt.IP, mafmt.Base(ma.P_UDP), mafmt.Base(ma.P_WEBRTC))
+
+func toWebRTCMultiaddr(na net.Addr) (ma.Multiaddr, error) {
+	addr, err := manet.FromNetAddr(na)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := addr.ValueForProtocol(ma.P_UDP); err != nil {
+		return nil, errors.New("not a UDP address")
+	}
+	return addr.Encapsulate(webrtcComponent), nil
+}
+
+// udpAddrFromMultiaddr returns the UDP address of a /webrtc multiaddr.
+func udpAddrFromMultiaddr(addr ma.Multiaddr) (*net.UDPAddr, error) {
+	udpMA, _ := ma.SplitFunc(addr, func(c ma.Component) bool {
+		return c.Protocol().Code == ma.P_WEBRTC
+	})
+	na, err := manet.ToNetAddr(udpMA)
+	if err != nil {
+		return nil, err
+	}
+	udpAddr, ok := na.(*net.UDPAddr)
+	if !ok {
+		return nil, errors.New("not a UDP address")
+	}
+	return udpAddr, nil
+}
@@ -0,0 +1,97 @@
+package libp2pwebrtc
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/multiformats/go-multihash"
+)
+
+// Neither side of a connection exchanges SDP: the dialer derives the listener's answer from the
+// listener's multiaddr, and the listener derives the dialer's offer from the ICE ufrag of the dialer's
+// STUN binding requests. The DTLS certificates are authenticated in the Noise handshake.
+
+const (
+	// ufragPrefix is prepended to the random part of the ICE ufrag chosen by the dialer.
+	ufragPrefix = "libp2p+webrtc+v1/"
+	// ufragRandomLen is the number of random characters in the ICE ufrag.
+	ufragRandomLen = 32
+
+	// sctpPort is the port used for the SCTP association on both sides.
+	sctpPort = 5000
+)
+
+// clientSDP is the offer the listener pretends to have received from the dialer.
+// The fingerprint is a placeholder: the listener doesn't know the dialer's certificate
+// and doesn't verify it during the DTLS handshake.
+const clientSDP = `v=0
+o=- 0 0 IN %[1]s %[2]s
+s=-
+c=IN %[1]s %[2]s
+t=0 0
+m=application %[3]d UDP/DTLS/SCTP webrtc-datachannel
+a=mid:0
+a=ice-options:ice2
+a=ice-ufrag:%[4]s
+a=ice-pwd:%[4]s
+a=fingerprint:sha-256 %[7]s
+a=setup:actpass
+a=sctp-port:%[5]d
+a=max-message-size:%[6]d
+`
+
+// serverSDP is the answer the dialer pretends to have received from the listener.
+const serverSDP = `v=0
+o=- 0 0 IN %[1]s %[2]s
+s=-
+c=IN %[1]s %[2]s
+t=0 0
+a=ice-lite
+m=application %[3]d UDP/DTLS/SCTP webrtc-datachannel
+a=mid:0
+a=ice-options:ice2
+a=ice-ufrag:%[4]s
+a=ice-pwd:%[4]s
+a=fingerprint:%[5]s
+a=setup:passive
+a=sctp-port:%[6]d
+a=max-message-size:%[7]d
+a=candidate:1467250027 1 UDP 1467250027 %[2]s %[3]d typ host
+a=end-of-candidates
+`
+
+var placeholderFingerprint = strings.TrimSuffix(strings.Repeat("00:", 32), ":")
+
+func ipVersion(ip net.IP) string {
+	if ip.To4() != nil {
+		return "IP4"
+	}
+	return "IP6"
+}
+
+func renderClientSDP(addr *net.UDPAddr, ufrag string) string {
+	return fmt.Sprintf(clientSDP, ipVersion(addr.IP), addr.IP, addr.Port, ufrag, sctpPort, maxMessageSize, placeholderFingerprint)
+}
+
+func renderServerSDP(addr *net.UDPAddr, ufrag string, certHash *multihash.DecodedMultihash) (string, error) {
+	fp, err := sdpFingerprint(certHash)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(serverSDP, ipVersion(addr.IP), addr.IP, addr.Port, ufrag, fp, sctpPort, maxMessageSize), nil
+}
+
+// genUfrag generates a random ICE ufrag. The ufrag is also used as the ICE password.
+func genUfrag() string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, ufragRandomLen)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return ufragPrefix + string(b)
+}
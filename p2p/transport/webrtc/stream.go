@@ -0,0 +1,451 @@
+package libp2pwebrtc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/transport/webrtc/pb"
+
+	"github.com/pion/datachannel"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// maxMessageSize is the maximum size of a message sent on a data channel.
+	maxMessageSize = 16384
+	// protoOverhead is an upper bound for the length prefix and protobuf framing of a message.
+	protoOverhead = 16
+	// maxDataLen is the maximum amount of application data sent in a single message.
+	maxDataLen = maxMessageSize - protoOverhead
+
+	// maxBufferedAmount is the amount of data that may be queued on a data channel
+	// before Write blocks.
+	maxBufferedAmount = 4 * maxMessageSize
+	// bufferedAmountLowThreshold is the amount of queued data below which a blocked Write resumes.
+	bufferedAmountLowThreshold = maxBufferedAmount / 2
+	// maxReceiveBuffer is the amount of received data buffered per stream.
+	// Once it is exceeded, we stop reading from the data channel until the application catches up.
+	maxReceiveBuffer = 16 * maxMessageSize
+)
+
+var (
+	errWriteAfterClose = errors.New("write on closed stream")
+	errReadAfterClose  = errors.New("read on closed stream")
+)
+
+type receiveState uint8
+
+const (
+	receiveStateReceiving receiveState = iota
+	receiveStateDataRead               // received a FIN
+	receiveStateClosed                 // CloseRead was called
+	receiveStateReset
+)
+
+type sendState uint8
+
+const (
+	sendStateSending sendState = iota
+	sendStateClosed            // sent a FIN
+	sendStateStopped           // received a STOP_SENDING
+	sendStateReset
+)
+
+// stream is a libp2p stream on top of a detached data channel.
+//
+// Every message on the data channel is a varint-length-prefixed protobuf (see pb.Message),
+// carrying application data and flags to half-close and reset the stream.
+// The data channel is closed once both directions of the stream are done.
+type stream struct {
+	dc  *webrtc.DataChannel
+	rwc datachannel.ReadWriteCloser
+	// onDone is called once the data channel was closed in both directions
+	onDone func()
+	// number of directions of the data channel that are still open
+	openDirections int32
+
+	readMx  sync.Mutex // serializes calls to Read
+	writeMx sync.Mutex // serializes calls to Write
+
+	mx            sync.Mutex
+	receiveState  receiveState
+	sendState     sendState
+	readBuf       []byte
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readNotify  chan struct{} // signaled when data was received, or the receive state or deadline changed
+	writeNotify chan struct{} // signaled when the send buffer drained, or the send state or deadline changed
+	spaceNotify chan struct{} // signaled when data was consumed from readBuf
+
+	closeOnce sync.Once
+}
+
+var _ network.MuxedStream = &stream{}
+
+func newStream(dc *webrtc.DataChannel, rwc datachannel.ReadWriteCloser, onDone func()) *stream {
+	s := &stream{
+		dc:             dc,
+		rwc:            rwc,
+		onDone:         onDone,
+		openDirections: 2,
+		readNotify:     make(chan struct{}, 1),
+		writeNotify:    make(chan struct{}, 1),
+		spaceNotify:    make(chan struct{}, 1),
+	}
+	dc.SetBufferedAmountLowThreshold(bufferedAmountLowThreshold)
+	dc.OnBufferedAmountLow(func() { notify(s.writeNotify) })
+	go s.readLoop()
+	return s
+}
+
+// readLoop reads messages from the data channel until the peer closes it.
+func (s *stream) readLoop() {
+	defer s.directionClosed()
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := s.rwc.Read(buf)
+		if err != nil {
+			// The data channel was closed. Unless both sides already finished, the stream was reset.
+			s.mx.Lock()
+			if s.receiveState == receiveStateReceiving {
+				s.receiveState = receiveStateReset
+			}
+			if s.sendState == sendStateSending {
+				s.sendState = sendStateReset
+			}
+			s.notifyAll()
+			s.mx.Unlock()
+			s.close()
+			return
+		}
+		var msg pb.Message
+		if err := readMessage(buf[:n], &msg); err != nil {
+			log.Debugw("failed to read message", "error", err)
+			s.Reset()
+			return
+		}
+
+		s.mx.Lock()
+		if len(msg.Message) > 0 && s.receiveState == receiveStateReceiving {
+			s.readBuf = append(s.readBuf, msg.Message...)
+		}
+		if msg.Flag != nil {
+			switch *msg.Flag {
+			case pb.Message_FIN:
+				if s.receiveState == receiveStateReceiving {
+					s.receiveState = receiveStateDataRead
+				}
+			case pb.Message_STOP_SENDING:
+				if s.sendState == sendStateSending {
+					s.sendState = sendStateStopped
+				}
+			case pb.Message_RESET:
+				if s.receiveState == receiveStateReceiving || s.receiveState == receiveStateDataRead {
+					s.receiveState = receiveStateReset
+					s.readBuf = nil
+				}
+				if s.sendState == sendStateSending {
+					s.sendState = sendStateReset
+				}
+			}
+		}
+		s.notifyAll()
+		for s.receiveState == receiveStateReceiving && len(s.readBuf) >= maxReceiveBuffer {
+			s.mx.Unlock()
+			<-s.spaceNotify
+			s.mx.Lock()
+		}
+		done := s.isDone()
+		s.mx.Unlock()
+		if done {
+			s.close()
+		}
+	}
+}
+
+func (s *stream) Read(b []byte) (int, error) {
+	s.readMx.Lock()
+	defer s.readMx.Unlock()
+
+	for {
+		s.mx.Lock()
+		switch s.receiveState {
+		case receiveStateReset:
+			s.mx.Unlock()
+			return 0, network.ErrReset
+		case receiveStateClosed:
+			s.mx.Unlock()
+			return 0, errReadAfterClose
+		}
+		if len(s.readBuf) > 0 {
+			n := copy(b, s.readBuf)
+			s.readBuf = s.readBuf[n:]
+			if len(s.readBuf) == 0 {
+				s.readBuf = nil
+			}
+			s.mx.Unlock()
+			notify(s.spaceNotify)
+			return n, nil
+		}
+		if s.receiveState == receiveStateDataRead {
+			s.mx.Unlock()
+			return 0, io.EOF
+		}
+		deadline := s.readDeadline
+		s.mx.Unlock()
+
+		if !wait(s.readNotify, deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (s *stream) Write(b []byte) (int, error) {
+	s.writeMx.Lock()
+	defer s.writeMx.Unlock()
+
+	var n int
+	for len(b) > 0 {
+		s.mx.Lock()
+		switch s.sendState {
+		case sendStateClosed:
+			s.mx.Unlock()
+			return n, errWriteAfterClose
+		case sendStateStopped, sendStateReset:
+			s.mx.Unlock()
+			return n, network.ErrReset
+		}
+		deadline := s.writeDeadline
+		s.mx.Unlock()
+
+		if s.dc.BufferedAmount() >= maxBufferedAmount {
+			if !wait(s.writeNotify, deadline) {
+				return n, os.ErrDeadlineExceeded
+			}
+			continue
+		}
+		if !deadline.IsZero() && !deadline.After(time.Now()) {
+			return n, os.ErrDeadlineExceeded
+		}
+
+		chunk := b
+		if len(chunk) > maxDataLen {
+			chunk = chunk[:maxDataLen]
+		}
+		if err := s.writeMessage(&pb.Message{Message: chunk}); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+	return n, nil
+}
+
+// CloseWrite sends a FIN to the peer.
+func (s *stream) CloseWrite() error {
+	s.writeMx.Lock()
+	defer s.writeMx.Unlock()
+
+	s.mx.Lock()
+	if s.sendState != sendStateSending {
+		s.mx.Unlock()
+		return nil
+	}
+	s.sendState = sendStateClosed
+	s.mx.Unlock()
+	notify(s.writeNotify)
+
+	err := s.writeMessage(&pb.Message{Flag: pb.Message_FIN.Enum()})
+	s.maybeClose()
+	return err
+}
+
+// CloseRead discards all data that has not been read yet, and asks the peer to stop sending.
+func (s *stream) CloseRead() error {
+	s.mx.Lock()
+	if s.receiveState == receiveStateClosed || s.receiveState == receiveStateReset {
+		s.mx.Unlock()
+		return nil
+	}
+	sendStopSending := s.receiveState == receiveStateReceiving
+	s.receiveState = receiveStateClosed
+	s.readBuf = nil
+	s.notifyAll()
+	s.mx.Unlock()
+
+	var err error
+	if sendStopSending {
+		err = s.writeMessage(&pb.Message{Flag: pb.Message_STOP_SENDING.Enum()})
+	}
+	s.maybeClose()
+	return err
+}
+
+func (s *stream) Close() error {
+	werr := s.CloseWrite()
+	rerr := s.CloseRead()
+	if werr != nil {
+		return werr
+	}
+	return rerr
+}
+
+func (s *stream) Reset() error {
+	s.mx.Lock()
+	alreadyDone := s.isDone()
+	if s.receiveState != receiveStateClosed {
+		s.receiveState = receiveStateReset
+	}
+	if s.sendState != sendStateClosed {
+		s.sendState = sendStateReset
+	}
+	s.readBuf = nil
+	s.notifyAll()
+	s.mx.Unlock()
+
+	if !alreadyDone {
+		// best effort, the data channel is closed right after
+		_ = s.writeMessage(&pb.Message{Flag: pb.Message_RESET.Enum()})
+	}
+	s.close()
+	return nil
+}
+
+func (s *stream) SetDeadline(t time.Time) error {
+	s.mx.Lock()
+	s.readDeadline = t
+	s.writeDeadline = t
+	s.mx.Unlock()
+	notify(s.readNotify)
+	notify(s.writeNotify)
+	return nil
+}
+
+func (s *stream) SetReadDeadline(t time.Time) error {
+	s.mx.Lock()
+	s.readDeadline = t
+	s.mx.Unlock()
+	notify(s.readNotify)
+	return nil
+}
+
+func (s *stream) SetWriteDeadline(t time.Time) error {
+	s.mx.Lock()
+	s.writeDeadline = t
+	s.mx.Unlock()
+	notify(s.writeNotify)
+	return nil
+}
+
+func (s *stream) writeMessage(msg *pb.Message) error {
+	size := msg.Size()
+	buf := make([]byte, binary.MaxVarintLen64+size)
+	n := binary.PutUvarint(buf, uint64(size))
+	m, err := msg.MarshalTo(buf[n:])
+	if err != nil {
+		return err
+	}
+	_, err = s.rwc.Write(buf[:n+m])
+	return err
+}
+
+// isDone returns true if both directions of the stream are finished.
+// It must be called with s.mx held.
+func (s *stream) isDone() bool {
+	return s.receiveState != receiveStateReceiving && s.sendState != sendStateSending
+}
+
+func (s *stream) maybeClose() {
+	s.mx.Lock()
+	done := s.isDone()
+	s.mx.Unlock()
+	if done {
+		s.close()
+	}
+}
+
+// close closes the underlying data channel.
+// This resets the SCTP stream. pion only sends the reset after all data queued on the stream,
+// and the peer only processes it once it received that data, so no data is lost.
+func (s *stream) close() {
+	s.closeOnce.Do(func() {
+		notify(s.spaceNotify)
+		_ = s.dc.Close()
+		s.directionClosed()
+	})
+}
+
+// directionClosed is called when either we or the peer closed the data channel.
+func (s *stream) directionClosed() {
+	if atomic.AddInt32(&s.openDirections, -1) == 0 && s.onDone != nil {
+		s.onDone()
+	}
+}
+
+// notifyAll wakes up all goroutines waiting for a state change.
+// It must be called with s.mx held.
+func (s *stream) notifyAll() {
+	notify(s.readNotify)
+	notify(s.writeNotify)
+	notify(s.spaceNotify)
+}
+
+// readMessage decodes a varint-length-prefixed message.
+func readMessage(b []byte, msg *pb.Message) error {
+	size, n := binary.Uvarint(b)
+	if n <= 0 {
+		return errors.New("invalid message length")
+	}
+	if uint64(len(b)-n) != size {
+		return errors.New("message length mismatch")
+	}
+	return msg.Unmarshal(b[n:])
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks until c is signaled, or the deadline (if set) expires.
+// It returns false if the deadline expired.
+func wait(c <-chan struct{}, deadline time.Time) bool {
+	if deadline.IsZero() {
+		<-c
+		return true
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return false
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-c:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// streamConn adapts a stream to a net.Conn, for use in the Noise handshake.
+type streamConn struct {
+	*stream
+	laddr, raddr net.Addr
+}
+
+var _ net.Conn = &streamConn{}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.laddr }
+func (c *streamConn) RemoteAddr() net.Addr { return c.raddr }
@@ -0,0 +1,323 @@
+// Package libp2pwebrtc implements the WebRTC transport for go-libp2p, as described in
+// https://github.com/libp2p/specs/tree/master/webrtc.
+//
+// The transport allows browsers to connect to nodes that don't have a TLS certificate
+// signed by a certificate authority. Listeners advertise the hash of their (self-signed)
+// DTLS certificate in a /certhash multiaddr component, and the two peers authenticate each
+// other using a Noise handshake on a data channel. Streams are mapped to SCTP data channels.
+package libp2pwebrtc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+
+	logging "github.com/ipfs/go-log/v2"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/multiformats/go-multihash"
+	"github.com/pion/webrtc/v3"
+)
+
+var log = logging.Logger("webrtc-transport")
+
+const (
+	handshakeTimeout = 10 * time.Second
+
+	// ICE timeouts. The keepalive interval must be shorter than the disconnected timeout.
+	iceDisconnectedTimeout = 20 * time.Second
+	iceFailedTimeout       = 30 * time.Second
+	iceKeepaliveInterval   = 15 * time.Second
+
+	noiseProloguePrefix = "libp2p-webrtc-noise:"
+)
+
+type Option func(*transport) error
+
+// WithCertificate sets the certificate used for DTLS.
+// By default, a new certificate is generated when the transport is constructed. Reusing a
+// certificate keeps the /certhash of the listen addresses stable across restarts.
+func WithCertificate(cert webrtc.Certificate) Option {
+	return func(t *transport) error {
+		t.certificate = &cert
+		return nil
+	}
+}
+
+type transport struct {
+	privKey   ic.PrivKey
+	localPeer peer.ID
+
+	rcmgr network.ResourceManager
+	gater connmgr.ConnectionGater
+
+	certificate *webrtc.Certificate
+	// multihash of the certificate
+	localFingerprint []byte
+
+	noise *noise.Transport
+}
+
+var _ tpt.Transport = &transport{}
+
+func New(key ic.PrivKey, gater connmgr.ConnectionGater, rcmgr network.ResourceManager, opts ...Option) (tpt.Transport, error) {
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if rcmgr == nil {
+		rcmgr = network.NullResourceManager
+	}
+	t := &transport{
+		privKey:   key,
+		localPeer: id,
+		rcmgr:     rcmgr,
+		gater:     gater,
+	}
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, err
+		}
+	}
+	if t.certificate == nil {
+		// Browsers only accept ECDSA and RSA certificates for WebRTC.
+		pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate certificate key: %w", err)
+		}
+		t.certificate, err = webrtc.GenerateCertificate(pk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate certificate: %w", err)
+		}
+	}
+	fps, err := t.certificate.GetFingerprints()
+	if err != nil {
+		return nil, err
+	}
+	if len(fps) == 0 {
+		return nil, errors.New("certificate has no fingerprint")
+	}
+	t.localFingerprint, err = fingerprintToMultihash(fps[0])
+	if err != nil {
+		return nil, err
+	}
+	t.noise, err = noise.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *transport) CanDial(addr ma.Multiaddr) bool {
+	return dialMatcher.Matches(addr)
+}
+
+func (t *transport) Protocols() []int {
+	return []int{ma.P_WEBRTC}
+}
+
+func (t *transport) Proxy() bool {
+	return false
+}
+
+func (t *transport) Listen(laddr ma.Multiaddr) (tpt.Listener, error) {
+	if !listenMatcher.Matches(laddr) {
+		return nil, fmt.Errorf("cannot listen on non-WebRTC addr: %s", laddr)
+	}
+	udpMA, _ := ma.SplitFunc(laddr, func(c ma.Component) bool { return c.Protocol().Code == ma.P_WEBRTC })
+	nw, addr, err := manet.DialArgs(udpMA)
+	if err != nil {
+		return nil, err
+	}
+	udpAddr, err := net.ResolveUDPAddr(nw, addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP(nw, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return newListener(t, udpConn)
+}
+
+func (t *transport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
+	scope, err := t.rcmgr.OpenConnection(network.DirOutbound, false, raddr)
+	if err != nil {
+		log.Debugw("resource manager blocked outgoing connection", "peer", p, "addr", raddr, "error", err)
+		return nil, err
+	}
+	if err := scope.SetPeer(p); err != nil {
+		log.Debugw("resource manager blocked outgoing connection for peer", "peer", p, "addr", raddr, "error", err)
+		scope.Done()
+		return nil, err
+	}
+	// the connection takes ownership of the scope
+	return t.dial(ctx, scope, raddr, p)
+}
+
+func (t *transport) dial(ctx context.Context, scope network.ConnManagementScope, raddr ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
+	remoteAddr, err := udpAddrFromMultiaddr(raddr)
+	if err != nil {
+		scope.Done()
+		return nil, err
+	}
+	certHash, err := extractCertHash(raddr)
+	if err != nil {
+		scope.Done()
+		return nil, err
+	}
+	remoteFingerprint, err := multihash.Encode(certHash.Digest, certHash.Code)
+	if err != nil {
+		scope.Done()
+		return nil, err
+	}
+	ufrag := genUfrag()
+	answer, err := renderServerSDP(remoteAddr, ufrag, certHash)
+	if err != nil {
+		scope.Done()
+		return nil, err
+	}
+
+	se := t.settingEngine(remoteAddr)
+	se.SetICECredentials(ufrag, ufrag)
+	pc, err := webrtc.NewAPI(webrtc.WithSettingEngine(se)).NewPeerConnection(t.webrtcConfig())
+	if err != nil {
+		scope.Done()
+		return nil, err
+	}
+	c := newConnection(network.DirOutbound, pc, t, scope, nil)
+
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	if err := t.connect(ctx, c, p, remoteFingerprint, answer); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if t.gater != nil && !t.gater.InterceptSecured(network.DirOutbound, p, c) {
+		c.Close()
+		return nil, errors.New("secured connection gated")
+	}
+	return c, nil
+}
+
+func (t *transport) connect(ctx context.Context, c *connection, p peer.ID, remoteFingerprint []byte, answer string) error {
+	hsChannel, err := createHandshakeChannel(c.pc)
+	if err != nil {
+		return err
+	}
+	opened := detachOnOpen(hsChannel)
+	offer, err := c.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err := c.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	if err := c.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		return err
+	}
+	hs, err := c.awaitHandshakeChannel(ctx, hsChannel, opened)
+	if err != nil {
+		return err
+	}
+
+	prologue := noisePrologue(t.localFingerprint, remoteFingerprint)
+	st, err := t.noise.WithSessionOptions(noise.Prologue(prologue))
+	if err != nil {
+		return err
+	}
+	// The listener is the Noise initiator.
+	sconn, err := st.SecureInbound(ctx, hs, p)
+	if err != nil {
+		return err
+	}
+	c.remotePeer = sconn.RemotePeer()
+	c.remoteKey = sconn.RemotePublicKey()
+	return nil
+}
+
+// awaitHandshakeChannel waits for the handshake data channel to open, and sets the
+// connection's multiaddrs from the ICE candidate pair in use.
+func (c *connection) awaitHandshakeChannel(ctx context.Context, dc *webrtc.DataChannel, opened <-chan detachResult) (*streamConn, error) {
+	var res detachResult
+	select {
+	case res = <-opened:
+		if res.err != nil {
+			return nil, res.err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, errConnClosed
+	}
+	laddr, raddr, err := c.selectedAddrs()
+	if err != nil {
+		return nil, err
+	}
+	if c.localMultiaddr, err = toWebRTCMultiaddr(laddr); err != nil {
+		return nil, err
+	}
+	if c.remoteMultiaddr, err = toWebRTCMultiaddr(raddr); err != nil {
+		return nil, err
+	}
+	// The handshake channel is kept open for the lifetime of the connection, so that its ID is never reused.
+	// It is closed when the underlying SCTP association is closed, at which point we close the connection.
+	s := newStream(dc, res.rwc, func() { c.Close() })
+	c.mx.Lock()
+	c.handshake = s
+	c.mx.Unlock()
+	return &streamConn{stream: s, laddr: laddr, raddr: raddr}, nil
+}
+
+// createHandshakeChannel creates the negotiated data channel with ID 0 that is used for the Noise handshake.
+func createHandshakeChannel(pc *webrtc.PeerConnection) (*webrtc.DataChannel, error) {
+	negotiated, id := true, uint16(0)
+	return pc.CreateDataChannel("", &webrtc.DataChannelInit{Negotiated: &negotiated, ID: &id})
+}
+
+// noisePrologue binds the Noise handshake to the DTLS certificates of both sides.
+func noisePrologue(clientFingerprint, serverFingerprint []byte) []byte {
+	prologue := make([]byte, 0, len(noiseProloguePrefix)+len(clientFingerprint)+len(serverFingerprint))
+	prologue = append(prologue, noiseProloguePrefix...)
+	prologue = append(prologue, clientFingerprint...)
+	return append(prologue, serverFingerprint...)
+}
+
+func (t *transport) webrtcConfig() webrtc.Configuration {
+	return webrtc.Configuration{Certificates: []webrtc.Certificate{*t.certificate}}
+}
+
+// settingEngine returns the settings shared by dialers and listeners.
+// addr is the remote address when dialing, and the local address when listening.
+func (t *transport) settingEngine(addr *net.UDPAddr) webrtc.SettingEngine {
+	var se webrtc.SettingEngine
+	se.DetachDataChannels()
+	se.SetIncludeLoopbackCandidate(true)
+	se.SetICETimeouts(iceDisconnectedTimeout, iceFailedTimeout, iceKeepaliveInterval)
+	if addr.IP.To4() != nil {
+		se.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	} else {
+		se.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP6})
+	}
+	return se
+}
+
+func parseCertificate(der []byte) (*x509.Certificate, error) {
+	if len(der) == 0 {
+		return nil, errors.New("no remote certificate")
+	}
+	return x509.ParseCertificate(der)
+}
@@ -0,0 +1,199 @@
+package libp2pwebrtc
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+	ttransport "github.com/libp2p/go-libp2p/p2p/transport/testsuite"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func newIdentity(t *testing.T) (peer.ID, ic.PrivKey) {
+	key, _, err := ic.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	return id, key
+}
+
+func newTransport(t *testing.T) (tpt.Transport, peer.ID) {
+	id, key := newIdentity(t)
+	tr, err := New(key, nil, network.NullResourceManager)
+	require.NoError(t, err)
+	return tr, id
+}
+
+func TestTransport(t *testing.T) {
+	ta, idA := newTransport(t)
+	tb, _ := newTransport(t)
+	ttransport.SubtestTransport(t, ta, tb, "/ip4/127.0.0.1/udp/0/webrtc", idA)
+}
+
+func TestCanDial(t *testing.T) {
+	tr, _ := newTransport(t)
+	valid := []string{
+		"/ip4/1.2.3.4/udp/1234/webrtc/certhash/uEiAsGPzpiPGQzSlVHRXrUCT5EkTV7YFrV4VZ3hpEKTd_zg",
+		"/ip6/::1/udp/1234/webrtc/certhash/uEiAsGPzpiPGQzSlVHRXrUCT5EkTV7YFrV4VZ3hpEKTd_zg",
+	}
+	invalid := []string{
+		"/ip4/1.2.3.4/udp/1234/webrtc",
+		"/ip4/1.2.3.4/udp/1234/quic",
+		"/ip4/1.2.3.4/tcp/1234/webrtc/certhash/uEiAsGPzpiPGQzSlVHRXrUCT5EkTV7YFrV4VZ3hpEKTd_zg",
+		"/dns/example.com/udp/1234/webrtc/certhash/uEiAsGPzpiPGQzSlVHRXrUCT5EkTV7YFrV4VZ3hpEKTd_zg",
+	}
+	for _, s := range valid {
+		require.True(t, tr.CanDial(ma.StringCast(s)), s)
+	}
+	for _, s := range invalid {
+		require.False(t, tr.CanDial(ma.StringCast(s)), s)
+	}
+}
+
+func TestListenAddrCerthash(t *testing.T) {
+	tr, _ := newTransport(t)
+	ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	dh, err := extractCertHash(ln.Multiaddr())
+	require.NoError(t, err)
+	require.Equal(t, uint64(multihash.SHA2_256), dh.Code)
+	mh, err := multihash.Encode(dh.Digest, dh.Code)
+	require.NoError(t, err)
+	require.Equal(t, tr.(*transport).localFingerprint, []byte(mh))
+}
+
+func TestDialWrongCerthash(t *testing.T) {
+	ta, idA := newTransport(t)
+	tb, _ := newTransport(t)
+	ln, err := ta.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	h := make([]byte, 32)
+	rand.Read(h)
+	mh, err := multihash.Encode(h, multihash.SHA2_256)
+	require.NoError(t, err)
+	certhash, err := multibase.Encode(multibase.Base64url, mh)
+	require.NoError(t, err)
+	addr, _ := ma.SplitLast(ln.Multiaddr())
+	addr = addr.Encapsulate(ma.StringCast("/certhash/" + certhash))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = tb.Dial(ctx, addr, idA)
+	require.Error(t, err)
+}
+
+func TestDialWrongPeerID(t *testing.T) {
+	ta, _ := newTransport(t)
+	tb, _ := newTransport(t)
+	ln, err := ta.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	wrongID, _ := newIdentity(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = tb.Dial(ctx, ln.Multiaddr(), wrongID)
+	require.Error(t, err)
+}
+
+func TestConnectionPeers(t *testing.T) {
+	ta, idA := newTransport(t)
+	tb, idB := newTransport(t)
+	ln, err := ta.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	conn, err := tb.Dial(context.Background(), ln.Multiaddr(), idA)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, idB, conn.LocalPeer())
+	require.Equal(t, idA, conn.RemotePeer())
+
+	sconn, err := ln.Accept()
+	require.NoError(t, err)
+	defer sconn.Close()
+	require.Equal(t, idA, sconn.LocalPeer())
+	require.Equal(t, idB, sconn.RemotePeer())
+	require.True(t, sconn.RemotePublicKey().Equals(conn.(*connection).privKey.GetPublic()))
+	require.Equal(t, conn.LocalMultiaddr(), sconn.RemoteMultiaddr())
+	require.Equal(t, conn.RemoteMultiaddr(), sconn.LocalMultiaddr())
+
+	// closing the connection on one side closes it on the other side as well
+	require.NoError(t, conn.Close())
+	_, err = sconn.AcceptStream()
+	require.Error(t, err)
+}
+
+func TestStreamHalfClose(t *testing.T) {
+	ta, idA := newTransport(t)
+	tb, _ := newTransport(t)
+	ln, err := ta.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	conn, err := tb.Dial(context.Background(), ln.Multiaddr(), idA)
+	require.NoError(t, err)
+	defer conn.Close()
+	sconn, err := ln.Accept()
+	require.NoError(t, err)
+	defer sconn.Close()
+
+	str, err := conn.OpenStream(context.Background())
+	require.NoError(t, err)
+	_, err = str.Write([]byte("foobar"))
+	require.NoError(t, err)
+	require.NoError(t, str.CloseWrite())
+	_, err = str.Write([]byte("foobar"))
+	require.Error(t, err)
+
+	sstr, err := sconn.AcceptStream()
+	require.NoError(t, err)
+	data, err := io.ReadAll(sstr)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foobar"), data)
+
+	// the other direction is still open
+	_, err = sstr.Write([]byte("raboof"))
+	require.NoError(t, err)
+	require.NoError(t, sstr.Close())
+	data, err = io.ReadAll(str)
+	require.NoError(t, err)
+	require.Equal(t, []byte("raboof"), data)
+	require.NoError(t, str.Close())
+}
+
+func TestStreamReadDeadline(t *testing.T) {
+	ta, idA := newTransport(t)
+	tb, _ := newTransport(t)
+	ln, err := ta.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	conn, err := tb.Dial(context.Background(), ln.Multiaddr(), idA)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	str, err := conn.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer str.Reset()
+	require.NoError(t, str.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	start := time.Now()
+	_, err = str.Read([]byte{0})
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
@@ -24,6 +24,7 @@ var _ network.ConnMultiaddrs = &connMultiaddrs{}
 func (c *connMultiaddrs) LocalMultiaddr() ma.Multiaddr  { return c.local }
 func (c *connMultiaddrs) RemoteMultiaddr() ma.Multiaddr { return c.remote }
 
+// TODO: implement network.DatagramConn, once webtransport-go exposes datagrams on the session.
 type conn struct {
 	*connSecurityMultiaddrs
 